@@ -68,14 +68,49 @@ func StartApp() {
 		}
 	}
 
+	// Load JWT signing keys (fail fast on a broken key configuration)
+	if err := services.InitJWTKeys(); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
+	// Load provider token encryption keys
+	if err := services.InitTokenEncryption(); err != nil {
+		log.Fatalf("Failed to load token encryption keys: %v", err)
+	}
+
+	// SMS codes are hashed with OTP_HASH_KEY; without it phone login stays off
+	if os.Getenv("OTP_HASH_KEY") == "" {
+		log.Printf("⚠️  OTP_HASH_KEY is not set — SMS verification and phone login are disabled")
+	}
+
+	// Load the optional GeoIP database used for session locations
+	if err := services.InitGeoIP(); err != nil {
+		log.Printf("Warning: GeoIP disabled: %v", err)
+	}
+
 	// Migration çalıştır
 	migrations.RunMigrations()
 
+	// Encrypt provider tokens stored in plaintext and rewrap those of retired keys
+	if n, err := services.ReencryptAccountTokens(); err != nil {
+		log.Printf("Warning: Failed to re-encrypt provider tokens: %v", err)
+	} else if n > 0 {
+		log.Printf("Re-encrypted provider tokens of %d accounts", n)
+	}
+	if n, err := services.ReencryptTOTPSecrets(); err != nil {
+		log.Printf("Warning: Failed to re-encrypt TOTP secrets: %v", err)
+	} else if n > 0 {
+		log.Printf("Re-encrypted %d TOTP secrets", n)
+	}
+
 	// Initialize Casbin ABAC enforcer
 	if err := services.InitCasbin(); err != nil {
 		log.Fatalf("Failed to initialize Casbin: %v", err)
 	}
 
+	// Erase accounts whose deletion grace period is over
+	services.StartAccountDeletionWorker(context.Background())
+
 	// Initialize default policies
 	if err := services.InitializeDefaultPolicies(); err != nil {
 		log.Printf("Warning: Failed to initialize default policies: %v", err)
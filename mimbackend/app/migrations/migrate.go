@@ -2,7 +2,6 @@ package migrations
 
 import (
 	"crypto/sha256"
-	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"log"
@@ -12,7 +11,6 @@ import (
 	companymodels "mimbackend/internal/models/company"
 	systemmodels "mimbackend/internal/models/system"
 	"mimbackend/internal/services"
-	"strings"
 
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
@@ -24,10 +22,6 @@ func stringPtr(s string) *string {
 
 func boolPtr(b bool) *bool { return &b }
 
-type sessionTokenPayload struct {
-	Token string `json:"token"`
-}
-
 func RunMigrations() {
 	db, err := config.NewConnection()
 	if err != nil {
@@ -36,27 +30,35 @@ func RunMigrations() {
 
 	migrator := db.Migrator()
 
-	// Drop legacy index on the token column if it exists.
-	if migrator.HasIndex(&models.Session{}, "idx_sessions_token") {
-		_ = migrator.DropIndex(&models.Session{}, "idx_sessions_token")
-	}
-	if migrator.HasIndex(&models.Session{}, "token") {
-		_ = migrator.DropIndex(&models.Session{}, "token")
-	}
-
 	if err := migrator.AutoMigrate(
 		&models.User{},
 		&models.Account{},
-		&models.Session{},
 		&models.VerificationToken{},
 		&models.OTP{},
 		&models.PasswordResetRequest{},
-		&models.UserSession{},    // User session tracking
-		&models.UserPermission{}, // User-specific permissions
+		&models.PasswordHistory{},        // Recent password hashes (reuse check)
+		&models.UserSession{},            // User session tracking
+		&models.LoginAttempt{},           // Failed logins (security history)
+		&models.RefreshToken{},           // Refresh token families (rotation + reuse detection)
+		&models.PersonalAccessToken{},    // Scoped API tokens for scripts and CI
+		&models.OAuthClient{},            // Third-party apps of the OAuth2 authorization server
+		&models.OAuthAuthorizationCode{}, // Pending authorization codes (PKCE)
+		&models.OAuthConsent{},           // Scopes users granted to OAuth clients
+		&models.UserTOTP{},               // TOTP authenticators
+		&models.MFARecoveryCode{},        // Two-factor recovery codes
+		&models.MFAChallenge{},           // Pending second login step
+		&models.WebAuthnCredential{},     // Passkeys / security keys
+		&models.WebAuthnCeremony{},       // Pending WebAuthn ceremonies
+		&models.UserPermission{},         // User-specific permissions
+		&models.AccountDeletionRequest{}, // Scheduled self-service account deletions
+		&models.ImpersonationActivity{},  // Requests made by admins impersonating users
 		&basemodels.Role{},
 		&companymodels.Company{},
-		&companymodels.CompanyMember{},     // Multi-tenancy: User-Company relationship
-		&companymodels.CompanyInvitation{}, // Company invitations
+		&companymodels.CompanyMember{},          // Multi-tenancy: User-Company relationship
+		&companymodels.CompanyInvitation{},      // Company invitations
+		&companymodels.ServiceAccountSecret{},   // API secrets of company service accounts
+		&companymodels.ServiceAccountActivity{}, // Calls made by service accounts
+		&companymodels.CompanySAMLConfig{},      // SAML single sign-on of enterprise companies
 		&companymodels.Branch{},
 		&companymodels.Department{},
 	); err != nil {
@@ -92,78 +94,14 @@ func RunMigrations() {
 		log.Printf("⚠️  Warning: could not create unique index on user_permissions: %v", err)
 	}
 
-	// Ensure token_data and token_hash columns exist (AutoMigrate should add them, but double-check).
-	if !migrator.HasColumn(&models.Session{}, "token_data") {
-		if err := migrator.AddColumn(&models.Session{}, "token_data"); err != nil {
-			log.Fatalf("Failed to add token_data column: %v", err)
-		}
-	}
-	if !migrator.HasColumn(&models.Session{}, "token_hash") {
-		if err := migrator.AddColumn(&models.Session{}, "token_hash"); err != nil {
-			log.Fatalf("Failed to add token_hash column: %v", err)
-		}
+	// Refresh tokens of the legacy sessions table now live in user_sessions
+	if err := MigrateLegacySessions(db); err != nil {
+		log.Fatalf("Failed to migrate legacy sessions: %v", err)
 	}
-
-	// Backfill token JSON and hashes from legacy token column or existing JSON.
-	type legacySession struct {
-		ID        string
-		Token     sql.NullString
-		TokenData []byte
+	if err := ClearLocalAccountTokens(db); err != nil {
+		log.Printf("⚠️  Warning: could not clear session tokens of local accounts: %v", err)
 	}
 
-	var legacyRows []legacySession
-	if err := db.Table("sessions").Find(&legacyRows).Error; err != nil {
-		log.Fatalf("Failed to load legacy sessions for backfill: %v", err)
-	}
-
-	for _, row := range legacyRows {
-		var token string
-
-		if len(row.TokenData) > 0 {
-			var payload sessionTokenPayload
-			if err := json.Unmarshal(row.TokenData, &payload); err == nil {
-				token = strings.TrimSpace(payload.Token)
-			}
-		}
-
-		if token == "" && row.Token.Valid {
-			token = strings.TrimSpace(row.Token.String)
-		}
-
-		if token == "" {
-			continue
-		}
-
-		payload := sessionTokenPayload{Token: token}
-		payloadJSON, err := json.Marshal(payload)
-		if err != nil {
-			log.Fatalf("Failed to marshal token payload for session %s: %v", row.ID, err)
-		}
-
-		hash := sha256.Sum256([]byte(token))
-		tokenHash := hex.EncodeToString(hash[:])
-
-		updates := map[string]interface{}{
-			"token_data": payloadJSON,
-			"token_hash": tokenHash,
-		}
-
-		if err := db.Table("sessions").Where("id = ?", row.ID).Updates(updates).Error; err != nil {
-			log.Fatalf("Failed to backfill session %s: %v", row.ID, err)
-		}
-	}
-
-	// Nothing to do for token_hash deduplication anymore; we rely on token_data JSON.
-
-	// Drop legacy token column if it still exists.
-	if migrator.HasColumn(&models.Session{}, "token") {
-		if err := migrator.DropColumn(&models.Session{}, "token"); err != nil {
-			log.Printf("⚠️  Warning: could not drop legacy token column: %v", err)
-		}
-	}
-
-	// No token_hash index needed when storing token in JSON.
-
 	// Create default roles if they don't exist (roles created without
 	// model-derived permissions — permissions will be managed by admins).
 	createDefaultRoles(db)
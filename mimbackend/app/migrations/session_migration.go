@@ -1,13 +1,27 @@
 package migrations
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"log"
+	"strings"
+	"time"
 
 	models "mimbackend/internal/models/auth"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// LegacySessionLoginMethod marks user sessions moved over from the legacy sessions table
+const LegacySessionLoginMethod = "legacy"
+
+type sessionTokenPayload struct {
+	Token string `json:"token"`
+}
+
 // MigrateUserSessions creates or updates the user_sessions table
 func MigrateUserSessions(db *gorm.DB) error {
 	log.Println("🔄 Running user_sessions migration...")
@@ -34,3 +48,156 @@ func RollbackUserSessions(db *gorm.DB) error {
 	log.Println("✅ user_sessions table dropped successfully")
 	return nil
 }
+
+// MigrateLegacySessions moves the refresh tokens of the legacy sessions table
+// into user_sessions and drops the table. Every unexpired row becomes an
+// active user session (login method "legacy") that starts its own refresh
+// token family, so refresh, logout and revocation treat it like any other
+// session. Tokens that already belong to a user session are skipped and
+// expired rows are dropped with the table. The
+// table is kept when a row could not be moved; re-running is safe.
+func MigrateLegacySessions(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable("sessions") {
+		return nil
+	}
+	log.Println("🔄 Migrating legacy sessions into user_sessions...")
+
+	type legacySession struct {
+		UserID    string
+		Token     sql.NullString
+		TokenData []byte
+		TokenHash sql.NullString
+		ExpiresAt time.Time
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+
+	// Older schemas kept the raw token in a token column instead of token_data/token_hash
+	columns := []string{"user_id", "expires_at", "created_at", "updated_at"}
+	for _, column := range []string{"token", "token_data", "token_hash"} {
+		if migrator.HasColumn("sessions", column) {
+			columns = append(columns, column)
+		}
+	}
+	query := db.Table("sessions").Select(columns).Where("expires_at > ?", time.Now())
+	if migrator.HasColumn("sessions", "deleted_at") {
+		query = query.Where("deleted_at IS NULL")
+	}
+
+	var rows []legacySession
+	if err := query.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	moved, skipped, failed := 0, 0, 0
+	for _, row := range rows {
+		tokenHash := legacySessionTokenHash(row.Token, row.TokenData, row.TokenHash)
+		userID, err := uuid.Parse(row.UserID)
+		if tokenHash == "" || err != nil {
+			skipped++
+			continue
+		}
+
+		var existing int64
+		if err := db.Model(&models.RefreshToken{}).Where("token_hash = ?", tokenHash).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing == 0 {
+			if err := db.Model(&models.UserSession{}).Where("refresh_token = ?", tokenHash).Count(&existing).Error; err != nil {
+				return err
+			}
+		}
+		if existing > 0 {
+			skipped++
+			continue
+		}
+
+		lastActivity := row.UpdatedAt
+		if lastActivity.IsZero() {
+			lastActivity = row.CreatedAt
+		}
+		session := &models.UserSession{
+			UserID:       userID,
+			SessionID:    uuid.New().String(),
+			IsActive:     true,
+			LoginAt:      row.CreatedAt,
+			LastActivity: lastActivity,
+			ExpiresAt:    row.ExpiresAt,
+			LoginMethod:  LegacySessionLoginMethod,
+			RefreshToken: tokenHash,
+			TokenVersion: 1,
+			TrustScore:   100,
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(session).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.RefreshToken{
+				UserID:    userID,
+				FamilyID:  session.SessionID,
+				TokenHash: tokenHash,
+				ExpiresAt: row.ExpiresAt,
+			}).Error
+		})
+		if err != nil {
+			log.Printf("⚠️  Warning: could not migrate legacy session of user %s: %v", row.UserID, err)
+			failed++
+			continue
+		}
+		moved++
+	}
+
+	log.Printf("Legacy sessions: %d moved to user_sessions, %d skipped (no token or already tracked), %d failed", moved, skipped, failed)
+	if failed > 0 {
+		log.Println("⚠️  Warning: sessions table kept because some rows could not be migrated")
+		return nil
+	}
+
+	if err := migrator.DropTable("sessions"); err != nil {
+		return err
+	}
+	log.Println("✅ Legacy sessions table dropped")
+	return nil
+}
+
+// ClearLocalAccountTokens removes the session refresh tokens logins used to
+// copy onto the "local" account row. Sessions are tracked in user_sessions
+// only, and the copies outlived logout and revocation.
+func ClearLocalAccountTokens(db *gorm.DB) error {
+	result := db.Model(&models.Account{}).
+		Where("provider = ? AND (access_token <> '' OR refresh_token <> '')", "local").
+		Updates(map[string]interface{}{"access_token": "", "refresh_token": "", "expires_at": 0})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("✅ Cleared session tokens from %d local accounts", result.RowsAffected)
+	}
+	return nil
+}
+
+// legacySessionTokenHash returns the SHA-256 hex hash of a legacy session's
+// refresh token, the same hash user_sessions and refresh_tokens store
+func legacySessionTokenHash(token sql.NullString, tokenData []byte, tokenHash sql.NullString) string {
+	if tokenHash.Valid && strings.TrimSpace(tokenHash.String) != "" {
+		return strings.TrimSpace(tokenHash.String)
+	}
+
+	raw := ""
+	if len(tokenData) > 0 {
+		var payload sessionTokenPayload
+		if err := json.Unmarshal(tokenData, &payload); err == nil {
+			raw = strings.TrimSpace(payload.Token)
+		}
+	}
+	if raw == "" && token.Valid {
+		raw = strings.TrimSpace(token.String)
+	}
+	if raw == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,194 @@
+// Command samlidp is a local SAML identity provider for trying out company
+// single sign-on without a real IdP. Every login is answered for the user given
+// on the command line, so it must never be exposed outside a development setup.
+//
+//	go run ./cmd/samlidp -email ayse@acme.test -name "Ayşe Yılmaz" -groups engineering
+//
+// Upload http://localhost:8000/metadata as the company's IdP metadata, set the
+// attribute mapping to email / name / groups and open the company login URL.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8000", "listen address")
+	baseURL := flag.String("base-url", "http://localhost:8000", "public URL of this identity provider")
+	keyFile := flag.String("key-file", "", "PEM file keeping the signing key between runs (created if missing)")
+	email := flag.String("email", "user@example.com", "email of the signed-in user")
+	name := flag.String("name", "Test User", "full name of the signed-in user")
+	groups := flag.String("groups", "", "comma separated groups of the signed-in user")
+	flag.Parse()
+
+	base, err := url.Parse(strings.TrimRight(*baseURL, "/"))
+	if err != nil {
+		log.Fatalf("invalid -base-url: %v", err)
+	}
+	key, cert, err := loadOrCreateKey(*keyFile)
+	if err != nil {
+		log.Fatalf("signing key: %v", err)
+	}
+
+	user := &saml.Session{
+		NameID:         *email,
+		NameIDFormat:   "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress",
+		UserEmail:      *email,
+		UserCommonName: *name,
+		CustomAttributes: []saml.Attribute{
+			fixtureAttribute("email", *email),
+			fixtureAttribute("name", *name),
+		},
+	}
+	if *groups != "" {
+		user.Groups = strings.Split(*groups, ",")
+		user.CustomAttributes = append(user.CustomAttributes, fixtureAttribute("groups", user.Groups...))
+	}
+
+	idp := &saml.IdentityProvider{
+		Key:                     key,
+		Signer:                  key,
+		Logger:                  log.Default(),
+		Certificate:             cert,
+		MetadataURL:             *base.ResolveReference(&url.URL{Path: "/metadata"}),
+		SSOURL:                  *base.ResolveReference(&url.URL{Path: "/sso"}),
+		ServiceProviderProvider: &metadataFetcher{client: &http.Client{Timeout: 10 * time.Second}},
+		SessionProvider:         fixedSession{user: user},
+	}
+
+	log.Printf("SAML test IdP on %s — metadata %s, signing in %s", *addr, idp.MetadataURL.String(), *email)
+	log.Fatal(http.ListenAndServe(*addr, idp.Handler()))
+}
+
+// fixedSession signs every request in as the same user
+type fixedSession struct {
+	user *saml.Session
+}
+
+func (s fixedSession) GetSession(_ http.ResponseWriter, _ *http.Request, _ *saml.IdpAuthnRequest) *saml.Session {
+	session := *s.user
+	session.ID = fmt.Sprintf("%d", time.Now().UnixNano())
+	session.CreateTime = time.Now()
+	session.ExpireTime = time.Now().Add(time.Hour)
+	return &session
+}
+
+// metadataFetcher resolves service providers by downloading their metadata
+// from the entity ID, which is the metadata URL for mimbackend companies
+type metadataFetcher struct {
+	client *http.Client
+	mu     sync.Mutex
+	cache  map[string]*saml.EntityDescriptor
+}
+
+func (f *metadataFetcher) GetServiceProvider(_ *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if sp, ok := f.cache[serviceProviderID]; ok {
+		return sp, nil
+	}
+
+	resp, err := f.client.Get(serviceProviderID)
+	if err != nil {
+		log.Printf("fetch SP metadata %s: %v", serviceProviderID, err)
+		return nil, os.ErrNotExist
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		log.Printf("fetch SP metadata %s: status %d %v", serviceProviderID, resp.StatusCode, err)
+		return nil, os.ErrNotExist
+	}
+
+	var sp saml.EntityDescriptor
+	if err := xml.Unmarshal(body, &sp); err != nil {
+		log.Printf("parse SP metadata %s: %v", serviceProviderID, err)
+		return nil, os.ErrNotExist
+	}
+	if f.cache == nil {
+		f.cache = map[string]*saml.EntityDescriptor{}
+	}
+	f.cache[serviceProviderID] = &sp
+	return &sp, nil
+}
+
+func fixtureAttribute(name string, values ...string) saml.Attribute {
+	attr := saml.Attribute{
+		FriendlyName: name,
+		Name:         name,
+		NameFormat:   "urn:oasis:names:tc:SAML:2.0:attrname-format:basic",
+	}
+	for _, value := range values {
+		attr.Values = append(attr.Values, saml.AttributeValue{Type: "xs:string", Value: strings.TrimSpace(value)})
+	}
+	return attr
+}
+
+// loadOrCreateKey returns the signing key and a self-signed certificate. With a
+// key file the key survives restarts, so the uploaded metadata stays valid.
+func loadOrCreateKey(path string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	var key *rsa.PrivateKey
+	if path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			block, _ := pem.Decode(data)
+			if block == nil {
+				return nil, nil, errors.New("no PEM block in key file")
+			}
+			if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return nil, nil, err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+	if key == nil {
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			return nil, nil, err
+		}
+		if path != "" {
+			data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// The certificate is derived from the key with fixed fields, so it is the same on every run
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mimbackend-test-idp"},
+		NotBefore:             time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2044, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, cert, nil
+}
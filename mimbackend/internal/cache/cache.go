@@ -239,3 +239,43 @@ func DeleteUserPermissionCache(ctx context.Context, cacheKey string) error {
 	}
 	return cli.Del(ctx, cacheKey).Err()
 }
+
+// Session token state cache (checked on every authenticated request)
+func SessionStateKey(sessionID string) string {
+	return fmt.Sprintf("session:state:%s", sessionID)
+}
+
+func GetSessionStateCache(ctx context.Context, sessionID string) ([]byte, error) {
+	cli := config.GetRedisClient()
+	if cli == nil {
+		return nil, nil
+	}
+	val, err := cli.Get(ctx, SessionStateKey(sessionID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+func SetSessionStateCache(ctx context.Context, sessionID string, data []byte, ttl time.Duration) error {
+	cli := config.GetRedisClient()
+	if cli == nil {
+		return nil
+	}
+	return cli.Set(ctx, SessionStateKey(sessionID), data, ttl).Err()
+}
+
+func InvalidateSessionStateCache(ctx context.Context, sessionIDs ...string) error {
+	cli := config.GetRedisClient()
+	if cli == nil || len(sessionIDs) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(sessionIDs))
+	for _, id := range sessionIDs {
+		keys = append(keys, SessionStateKey(id))
+	}
+	return cli.Del(ctx, keys...).Err()
+}
@@ -2,15 +2,17 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"mimbackend/config"
 	auth "mimbackend/internal/models/auth"
 	basemodels "mimbackend/internal/models/basemodels"
 	"mimbackend/internal/services"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,7 +22,7 @@ import (
 
 type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 	FullName string `json:"full_name"`
 }
 
@@ -29,17 +31,19 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// RefreshRequest and LogoutRequest may be empty in cookie mode; the refresh
+// token is then read from the refresh_token cookie
 type RefreshRequest struct {
-	RefreshToken string `json:"refresh_token" binding:"required"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 type LogoutRequest struct {
-	RefreshToken string `json:"refresh_token" binding:"required"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 type AuthResponse struct {
-	AccessToken  string       `json:"access_token"`
-	RefreshToken string       `json:"refresh_token"`
+	AccessToken  string       `json:"access_token,omitempty"`
+	RefreshToken string       `json:"refresh_token,omitempty"`
 	User         UserResponse `json:"user"`
 	Message      string       `json:"message,omitempty"`
 }
@@ -74,6 +78,12 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
+	// Şifre politikası (kayıtta henüz şirket yok, genel politika geçerli)
+	if err := services.DefaultPasswordPolicy().Validate(req.Password, req.Email, req.FullName); err != nil {
+		writePasswordPolicyError(c, err)
+		return
+	}
+
 	// Kullanıcı oluştur (sadece email ve role)
 	// Find the "user" role from database
 	db, err := config.NewConnection()
@@ -117,6 +127,10 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
+	if err := services.RecordPasswordHistory(user.ID, user.PasswordHash); err != nil {
+		fmt.Printf("RecordPasswordHistory error (register): %v\n", err)
+	}
+
 	// Send verification email
 	code, err := services.CreateVerificationToken(user.Email, 24*time.Hour)
 	if err != nil {
@@ -137,8 +151,16 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
-	// Tokens oluştur (access + refresh)
-	accessTok, refreshTok, err := services.GenerateTokens(user.ID, user.Email, user.Role)
+	// Create user session and its tokens (access + refresh)
+	sessionService, err := services.NewSessionService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session service"})
+		return
+	}
+	securityInfo := sessionService.ExtractSecurityInfo(c)
+	securityInfo.LoginMethod = "password"
+
+	accessTok, refreshTok, _, err := sessionService.IssueSessionTokens(user, securityInfo, nil, 30*24*time.Hour)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Token oluşturulamadı",
@@ -158,51 +180,28 @@ func RegisterHandler(c *gin.Context) {
 		Message: "Registration successful. Please check your email for verification code.",
 	}
 
-	// Create session record for refresh token
-	refreshExp := time.Now().Add(30 * 24 * time.Hour)
-	if err := services.CreateSession(user.ID, refreshTok, refreshExp); err != nil {
-		// log but don't fail the registration
-		fmt.Printf("CreateSession error (register): %v\n", err)
-	}
-
-	// Create a local account entry for this user
-	if err := services.CreateAccount(user.ID, "local", user.ID.String(), "", refreshTok, refreshExp.Unix()); err != nil {
+	// Create a local account entry for this user; session tokens live in user_sessions
+	if err := services.CreateAccount(user.ID, "local", user.ID.String(), "", "", 0); err != nil {
 		fmt.Printf("CreateAccount error (register): %v\n", err)
 	}
 
-	// Set cookies for browser
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "access_token",
-		Value:    response.AccessToken,
-		Path:     "/",
-		HttpOnly: false,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   3600,
-	})
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    response.RefreshToken,
-		Path:     "/",
-		HttpOnly: false,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   30 * 24 * 3600,
-	})
-
-	c.JSON(http.StatusCreated, response)
+	writeAuthResponse(c, http.StatusCreated, response)
 }
 
 // LoginHandler kullanıcı giriş işlemi
 // @Summary Login user
-// @Description Authenticate user and return access token
+// @Description Authenticate user and return access token. Users with two-factor authentication get an MFA challenge token to complete at /auth/mfa/verify
 // @Tags Auth
 // @Accept json
 // @Produce json
 // @Param payload body LoginRequest true "Login payload"
-// @Success 200 {object} AuthResponse
+// @Success 200 {object} AuthResponse "Tokens, or an MFAChallengeResponse when two-factor authentication is enabled"
+// @Success 202 {object} LoginConfirmationResponse "Risky login, confirm with the emailed link"
 // @Failure 400 {object} map[string]interface{}
 // @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{} "Password login refused because a company of the user enforces SSO (code sso_required), or blocked by the risk engine (code login_risk_blocked)"
+// @Failure 423 {object} map[string]interface{} "Account locked after too many failed logins"
+// @Failure 429 {object} map[string]interface{} "Too soon after a failed login, or client IP blocked"
 // @Failure 500 {object} map[string]interface{}
 // @Router /auth/login [post]
 func LoginHandler(c *gin.Context) {
@@ -215,9 +214,26 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	// Kullanıcıyı email ile bul
-	user, err := services.GetUserByEmail(req.Email)
+	guard, err := services.NewLoginGuard()
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize login guard"})
+		return
+	}
+	clientIP := c.ClientIP()
+
+	// Kullanıcıyı email ile bul; bulunamazsa user nil kalır
+	user, _ := services.GetUserByEmail(req.Email)
+
+	// Kilitli hesap, engellenen IP veya bekleme süresi dolmadıysa şifreye bakma
+	var blocked *services.LoginBlockedError
+	if err := guard.Check(req.Email, clientIP); errors.As(err, &blocked) {
+		guard.RecordFailure(user, req.Email, clientIP, c.Request.UserAgent(), loginFailureReason(blocked))
+		writeLoginBlocked(c, blocked)
+		return
+	}
+
+	if user == nil {
+		guard.RecordFailure(nil, req.Email, clientIP, c.Request.UserAgent(), auth.LoginFailureUnknownUser)
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Geçersiz email veya şifre",
 		})
@@ -225,21 +241,152 @@ func LoginHandler(c *gin.Context) {
 	}
 
 	// Şifreyi doğrula
-	if !services.CheckPassword(req.Password, user.PasswordHash) {
+	passwordOK, needsRehash := services.CheckPassword(req.Password, user.PasswordHash)
+	if !passwordOK {
+		guard.RecordFailure(user, req.Email, clientIP, c.Request.UserAgent(), auth.LoginFailureInvalidPassword)
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Geçersiz email veya şifre",
 		})
 		return
 	}
 
-	// Tokens oluştur (access + refresh)
-	accessTok, refreshTok, err := services.GenerateTokens(user.ID, user.Email, user.Role)
+	// Eski algoritma veya parametrelerle yapılmış hash'i güncelle
+	if needsRehash {
+		if err := services.RehashPassword(user.ID, req.Password, user.PasswordHash); err != nil {
+			fmt.Printf("RehashPassword error (login): %v\n", err)
+		}
+	}
+
+	beginLogin(c, user, "password")
+}
+
+// loginFailureReason maps a refused login to the reason kept in the security history
+func loginFailureReason(blocked *services.LoginBlockedError) string {
+	switch {
+	case errors.Is(blocked, services.ErrAccountLocked):
+		return auth.LoginFailureLocked
+	case errors.Is(blocked, services.ErrLoginIPBlocked):
+		return auth.LoginFailureIPBlocked
+	default:
+		return auth.LoginFailureThrottled
+	}
+}
+
+// writeLoginBlocked answers a refused login with Retry-After. Locked accounts
+// get 423, throttled attempts and blocked addresses 429.
+func writeLoginBlocked(c *gin.Context, blocked *services.LoginBlockedError) {
+	retryAfter := int(math.Ceil(blocked.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+
+	switch {
+	case errors.Is(blocked, services.ErrAccountLocked):
+		c.JSON(http.StatusLocked, gin.H{
+			"error":       "Çok fazla hatalı deneme nedeniyle hesap geçici olarak kilitlendi. Kilidi kaldırma bağlantısı email adresinize gönderildi",
+			"retry_after": retryAfter,
+		})
+	case errors.Is(blocked, services.ErrLoginIPBlocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "Bu adresten çok fazla hatalı giriş denemesi yapıldı",
+			"retry_after": retryAfter,
+		})
+	default:
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "Lütfen tekrar denemeden önce bekleyin",
+			"retry_after": retryAfter,
+		})
+	}
+}
+
+// beginLogin finishes the first login factor. Members of a company that
+// enforces SSO are sent to SAML, risky logins are blocked or wait for email
+// confirmation; users with a second factor get an MFA challenge, everyone else
+// gets a session via completeLogin.
+func beginLogin(c *gin.Context, user *auth.User, loginMethod string) {
+	// SSO zorunlu kılan bir şirketin üyesi başka yöntemle giremez (sahipler hariç)
+	if !enforceSSO(c, user, loginMethod) {
+		return
+	}
+
+	// İki adımlı doğrulama açıksa oturum yerine MFA challenge döndür
+	mfaService, err := services.NewMFAService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize MFA service"})
+		return
+	}
+	mfaMethods, err := mfaService.Methods(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor status"})
+		return
+	}
+
+	// Riskli girişler engellenir ya da email onayı bekler
+	risk, ok := enforceLoginRisk(c, user, loginMethod, len(mfaMethods) > 0)
+	if !ok {
+		return
+	}
+
+	if len(mfaMethods) > 0 {
+		challengeToken, expiresAt, err := mfaService.CreateChallenge(user.ID, loginMethod, risk)
+		if errors.Is(err, services.ErrMFAChallengeLimit) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "Çok fazla bekleyen doğrulama var, lütfen birkaç dakika sonra tekrar deneyin",
+				"code":  "mfa_challenge_limit",
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create MFA challenge"})
+			return
+		}
+		c.JSON(http.StatusOK, MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    challengeToken,
+			ExpiresAt:   expiresAt,
+			Methods:     mfaMethods,
+		})
+		return
+	}
+
+	completeLogin(c, user, loginMethod, risk)
+}
+
+// completeLogin creates the user session for an authenticated user, sets the
+// auth cookies and writes the AuthResponse. loginMethod and the risk
+// assessment the login was checked with are recorded on the session. The
+// failed login counters are reset here, once every factor was verified.
+func completeLogin(c *gin.Context, user *auth.User, loginMethod string, risk *services.LoginRiskAssessment) {
+	// Service accounts only authenticate with their API secrets
+	if user.IsServiceAccount {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrServiceAccountLoginNotAllowed.Error()})
+		return
+	}
+	if !enforceSSO(c, user, loginMethod) {
+		return
+	}
+
+	// Create user session with security tracking; the tokens are bound to it
+	sessionService, err := services.NewSessionService()
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session service"})
+		return
+	}
+	securityInfo := sessionService.ExtractSecurityInfo(c)
+	securityInfo.LoginMethod = loginMethod
+
+	accessTok, refreshTok, userSession, err := sessionService.IssueSessionTokens(user, securityInfo, risk, 30*24*time.Hour)
+	if err != nil {
+		fmt.Printf("IssueSessionTokens error (login): %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Token oluşturulamadı",
 		})
 		return
 	}
+	fmt.Printf("✅ User session created: %s (IP: %s, Device: %s)\n",
+		userSession.SessionID, userSession.IPAddress, userSession.DeviceType)
+
+	if guard, err := services.NewLoginGuard(); err == nil {
+		guard.RecordSuccess(user.Email)
+	}
 
 	response := AuthResponse{
 		AccessToken:  accessTok,
@@ -253,59 +400,27 @@ func LoginHandler(c *gin.Context) {
 		},
 	}
 
-	// Create session record for refresh token
-	refreshExp := time.Now().Add(30 * 24 * time.Hour)
-	if err := services.CreateSession(user.ID, refreshTok, refreshExp); err != nil {
-		fmt.Printf("CreateSession error (login): %v\n", err)
-	}
-
-	// Create user session with security tracking
-	sessionService, err := services.NewSessionService()
-	if err != nil {
-		fmt.Printf("NewSessionService error: %v\n", err)
-	} else {
-		securityInfo := sessionService.ExtractSecurityInfo(c)
-		securityInfo.LoginMethod = "password" // Set login method
+	writeAuthResponse(c, http.StatusOK, response)
+}
 
-		userSession, err := sessionService.CreateSession(user.ID, refreshTok, securityInfo, 30*24*time.Hour)
-		if err != nil {
-			fmt.Printf("CreateUserSession error (login): %v\n", err)
-		} else {
-			fmt.Printf("✅ User session created: %s (IP: %s, Device: %s)\n",
-				userSession.SessionID, userSession.IPAddress, userSession.DeviceType)
-		}
+// writeAuthResponse sets the auth cookies of a new session and writes the
+// AuthResponse. With AUTH_COOKIE_ONLY the tokens only go to the HttpOnly
+// cookies, so scripts on the page never see them.
+func writeAuthResponse(c *gin.Context, status int, response AuthResponse) {
+	// The csrf_token cookie guards cookie-authenticated writes
+	if err := services.SetAuthCookies(c, response.AccessToken, response.RefreshToken); err != nil {
+		fmt.Printf("SetAuthCookies error: %v\n", err)
 	}
-
-	// Ensure local account exists (upsert handled by service)
-	if err := services.CreateAccount(user.ID, "local", user.ID.String(), "", refreshTok, refreshExp.Unix()); err != nil {
-		fmt.Printf("CreateAccount error (login): %v\n", err)
+	if services.AuthCookieConfig().CookieOnly {
+		response.AccessToken = ""
+		response.RefreshToken = ""
 	}
-
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "access_token",
-		Value:    response.AccessToken,
-		Path:     "/",
-		HttpOnly: false,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   3600,
-	})
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    response.RefreshToken,
-		Path:     "/",
-		HttpOnly: false,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   30 * 24 * 3600,
-	})
-
-	c.JSON(http.StatusOK, response)
+	c.JSON(status, response)
 }
 
 // RefreshHandler exchanges a refresh token for new tokens
 // @Summary Refresh tokens
-// @Description Exchange refresh token for new access and refresh tokens
+// @Description Exchange refresh token for new access and refresh tokens. Cookie clients may send an empty body; the refresh_token cookie is used together with the X-CSRF-Token header and the new tokens are only returned as cookies
 // @Tags Auth
 // @Accept json
 // @Produce json
@@ -315,122 +430,86 @@ func LoginHandler(c *gin.Context) {
 // @Failure 401 {object} map[string]interface{}
 // @Router /auth/refresh [post]
 func RefreshHandler(c *gin.Context) {
-	// Log raw body for debugging
+	// Cookie clients may send no body at all
 	bodyBytes, _ := c.GetRawData()
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes)) // Reset body for binding
-	fmt.Printf("RefreshHandler: raw body length=%d content='%s'\n", len(bodyBytes), string(bodyBytes))
 
 	var req RefreshRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		fmt.Printf("RefreshHandler: ShouldBindJSON error: %v\n", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
-		return
+	if len(bytes.TrimSpace(bodyBytes)) > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			fmt.Printf("RefreshHandler: ShouldBindJSON error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+			return
+		}
 	}
-	// diagnostic log: show incoming refresh token length to help debug issues
 	tokenToUse := req.RefreshToken
-	fmt.Printf("RefreshHandler: Incoming request - token length=%d\n", len(tokenToUse))
-	if tokenToUse == "" {
+	fromCookie := tokenToUse == ""
+	if fromCookie {
 		// try cookie fallback
-		cookieVal, cookieErr := c.Cookie("refresh_token")
-		if cookieErr == nil && cookieVal != "" {
-			tokenToUse = cookieVal
-			fmt.Printf("RefreshHandler: using refresh token from cookie length=%d\n", len(tokenToUse))
-		} else {
-			fmt.Printf("RefreshHandler: no refresh token provided in request body or cookie\n")
+		cookieVal, ok := refreshTokenFromCookie(c)
+		if !ok {
+			return
 		}
-	} else {
-		fmt.Printf("RefreshHandler: received refresh token from body length=%d\n", len(tokenToUse))
+		tokenToUse = cookieVal
+	}
+
+	sessionService, err := services.NewSessionService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session service"})
+		return
 	}
 
-	accessTok, refreshTok, err := services.RefreshTokens(tokenToUse)
+	// Rotate within the same session; a rotated token presented again revokes its whole family
+	accessTok, refreshTok, userSession, err := sessionService.RefreshSession(tokenToUse, 30*24*time.Hour)
+	if errors.Is(err, services.ErrRefreshTokenReused) || errors.Is(err, services.ErrRefreshTokenRevoked) {
+		fmt.Printf("RefreshHandler: rejected refresh token: %v\n", err)
+		services.ClearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh_token_revoked", "message": "This session has been revoked. Please login again."})
+		return
+	}
 	if err != nil {
 		// log error for debugging
-		fmt.Printf("RefreshHandler: services.RefreshTokens error: %v\n", err)
+		fmt.Printf("RefreshHandler: RefreshSession error: %v\n", err)
 		// clear cookies on invalid/expired refresh token so client state is cleaned
-		http.SetCookie(c.Writer, &http.Cookie{Name: "access_token", Value: "", Path: "/", Expires: time.Unix(0, 0)})
-		http.SetCookie(c.Writer, &http.Cookie{Name: "refresh_token", Value: "", Path: "/", Expires: time.Unix(0, 0)})
+		services.ClearAuthCookies(c)
 
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh_token_invalid_or_expired", "message": "Refresh token expired or invalid. Please login again."})
 		return
 	}
-
-	// Update session activity when token is refreshed
-	sessionService, svcErr := services.NewSessionService()
-	if svcErr == nil {
-		// Get the old session by token
-		oldSession, err := sessionService.GetSessionByToken(tokenToUse)
-		if err == nil && oldSession != nil {
-			// Update activity timestamp
-			if err := sessionService.UpdateSessionActivity(oldSession.SessionID); err != nil {
-				fmt.Printf("UpdateSessionActivity error: %v\n", err)
-			} else {
-				fmt.Printf("✅ Session activity updated: %s\n", oldSession.SessionID)
-			}
-
-			// Create new session with new refresh token
-			securityInfo := sessionService.ExtractSecurityInfo(c)
-			securityInfo.LoginMethod = "refresh"
-
-			newSession, err := sessionService.CreateSession(oldSession.UserID, refreshTok, securityInfo, 30*24*time.Hour)
-			if err != nil {
-				fmt.Printf("CreateSession error (refresh): %v\n", err)
-			} else {
-				fmt.Printf("✅ New session created on refresh: %s\n", newSession.SessionID)
-			}
-
-			// Mark old session as logged out
-			if err := oldSession.MarkAsLogout(sessionService.GetDB()); err != nil {
-				fmt.Printf("MarkAsLogout error: %v\n", err)
-			}
-		}
-	}
+	fmt.Printf("✅ Refresh token rotated for session: %s\n", userSession.SessionID)
 
 	// set refreshed tokens as HttpOnly cookies (safer) and return JSON
-	secure := false
-	if os.Getenv("ENV") == "production" || os.Getenv("NODE_ENV") == "production" {
-		secure = true
-	}
-
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "access_token",
-		Value:    accessTok,
-		Path:     "/",
-		HttpOnly: false, // Frontend'in erişebilmesi için false yapıyoruz
-		Secure:   secure,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   3600,
-	})
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshTok,
-		Path:     "/",
-		HttpOnly: false, // Frontend'in erişebilmesi için false yapıyoruz
-		Secure:   secure,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   30 * 24 * 3600,
-	})
+	if err := services.SetAuthCookies(c, accessTok, refreshTok); err != nil {
+		fmt.Printf("SetAuthCookies error: %v\n", err)
+	}
 
-	// Debug: log new token lengths
-	fmt.Printf("RefreshHandler: SUCCESS - new access_token len=%d, new refresh_token len=%d\n", len(accessTok), len(refreshTok))
+	// A cookie-authenticated client gets the new tokens only as cookies; the
+	// readable csrf_token must not be enough to read them from the response
+	if fromCookie {
+		c.JSON(http.StatusOK, gin.H{"message": "Tokens refreshed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"access_token": accessTok, "refresh_token": refreshTok})
+}
 
-	// Log safe summaries (head/tail) for the issued tokens to help correlation
-	if len(accessTok) >= 16 {
-		fmt.Printf("RefreshHandler: new access_token head_tail=%s...%s length=%d\n", accessTok[:8], accessTok[len(accessTok)-8:], len(accessTok))
-	} else {
-		fmt.Printf("RefreshHandler: new access_token (short)=%s length=%d\n", accessTok, len(accessTok))
+// refreshTokenFromCookie reads the refresh token of a cookie-mode client. The
+// request must carry the CSRF header; otherwise the error is written and ok is false.
+func refreshTokenFromCookie(c *gin.Context) (string, bool) {
+	token, err := c.Cookie(services.RefreshTokenCookie)
+	if err != nil || token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token required"})
+		return "", false
 	}
-	if len(refreshTok) >= 16 {
-		fmt.Printf("RefreshHandler: new refresh_token head_tail=%s...%s length=%d\n", refreshTok[:8], refreshTok[len(refreshTok)-8:], len(refreshTok))
-	} else {
-		fmt.Printf("RefreshHandler: new refresh_token (short)=%s length=%d\n", refreshTok, len(refreshTok))
+	if !services.ValidCSRFToken(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+		return "", false
 	}
-
-	c.JSON(http.StatusOK, gin.H{"access_token": accessTok, "refresh_token": refreshTok})
+	return token, true
 }
 
 // LogoutHandler refresh token ile oturumu sonlandırır
 // @Summary Logout user
-// @Description Invalidate refresh token and end user session
+// @Description Invalidate refresh token and end user session. Cookie clients may send an empty body with the X-CSRF-Token header
 // @Tags Auth
 // @Accept json
 // @Produce json
@@ -441,30 +520,34 @@ func RefreshHandler(c *gin.Context) {
 // @Router /auth/logout [post]
 func LogoutHandler(c *gin.Context) {
 	var req LogoutRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
-		return
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+			return
+		}
 	}
-
-	// Mark user session as logged out
-	sessionService, err := services.NewSessionService()
-	if err == nil {
-		if err := sessionService.LogoutSession(req.RefreshToken); err != nil {
-			fmt.Printf("LogoutSession error: %v\n", err)
-		} else {
-			fmt.Printf("✅ User session logged out successfully\n")
+	if req.RefreshToken == "" {
+		token, ok := refreshTokenFromCookie(c)
+		if !ok {
+			return
 		}
+		req.RefreshToken = token
 	}
 
-	// Session'ı sil (legacy)
-	if err := services.DeleteSession(req.RefreshToken); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Geçersiz oturum"})
+	// Mark user session as logged out and revoke its refresh tokens
+	sessionService, err := services.NewSessionService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session service"})
+		return
+	}
+	if err := sessionService.LogoutSession(req.RefreshToken); err != nil {
+		fmt.Printf("LogoutSession error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Oturum sonlandırılamadı"})
 		return
 	}
 
 	// Clear cookies
-	http.SetCookie(c.Writer, &http.Cookie{Name: "access_token", Value: "", Path: "/", Expires: time.Unix(0, 0)})
-	http.SetCookie(c.Writer, &http.Cookie{Name: "refresh_token", Value: "", Path: "/", Expires: time.Unix(0, 0)})
+	services.ClearAuthCookies(c)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Oturum başarıyla sonlandırıldı"})
 }
@@ -494,23 +577,18 @@ func LogoutAllHandler(c *gin.Context) {
 
 	// Logout all user sessions
 	sessionService, err := services.NewSessionService()
-	if err == nil {
-		if err := sessionService.LogoutAllUserSessions(uid); err != nil {
-			fmt.Printf("LogoutAllUserSessions error: %v\n", err)
-		} else {
-			fmt.Printf("✅ All user sessions logged out successfully\n")
-		}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session service"})
+		return
 	}
-
-	// Tüm session'ları sil (legacy)
-	if err := services.DeleteAllSessionsForUser(uid); err != nil {
+	if err := sessionService.LogoutAllUserSessions(uid); err != nil {
+		fmt.Printf("LogoutAllUserSessions error: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout from all devices"})
 		return
 	}
 
 	// Clear cookies
-	http.SetCookie(c.Writer, &http.Cookie{Name: "access_token", Value: "", Path: "/", Expires: time.Unix(0, 0)})
-	http.SetCookie(c.Writer, &http.Cookie{Name: "refresh_token", Value: "", Path: "/", Expires: time.Unix(0, 0)})
+	services.ClearAuthCookies(c)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Tüm oturumlar başarıyla sonlandırıldı"})
 }
@@ -554,89 +632,6 @@ func VerifyEmailHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Email başarıyla doğrulandı"})
 }
 
-// DebugSessionsHandler sessions tablosundan son 5 kaydı gösterir
-// @Summary Debug Sessions
-// @Description Shows last 5 sessions for debugging
-// @Tags Debug
-// @Produce json
-// @Success 200 {object} map[string]interface{}
-// @Router /debug/sessions [get]
-func DebugSessionsHandler(c *gin.Context) {
-	sessions, err := services.GetLastSessions(5)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	var debugSessions []map[string]interface{}
-	for _, session := range sessions {
-		debugSession := map[string]interface{}{
-			"id":                session.ID.String(),
-			"user_id":           session.UserID.String(),
-			"token_data_length": len(session.TokenData),
-			"expires_at":        session.ExpiresAt,
-			"created_at":        session.CreatedAt,
-		}
-
-		// TokenData'dan token'ı parse etmeye çalış
-		if len(session.TokenData) > 0 {
-			tokenPayload, parseErr := services.ParseTokenFromJSON(session.TokenData)
-			if parseErr == nil {
-				debugSession["parsed_token_length"] = len(tokenPayload.Token)
-				debugSession["parsed_token_value_head_tail"] = fmt.Sprintf("%s...%s", tokenPayload.Token[:8], tokenPayload.Token[len(tokenPayload.Token)-8:])
-			} else {
-				debugSession["parse_error"] = parseErr.Error()
-			}
-		}
-
-		debugSessions = append(debugSessions, debugSession)
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"sessions": debugSessions,
-		"count":    len(debugSessions),
-	})
-}
-
-// CheckTokenHandler accepts { token } and returns a safe summary of the matching session
-// Dev-only helper to correlate an incoming refresh token with DB sessions without
-// returning the full token value.
-func CheckTokenHandler(c *gin.Context) {
-	var body struct {
-		Token string `json:"token" binding:"required"`
-	}
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "token required"})
-		return
-	}
-
-	sess, err := services.GetSessionByToken(body.Token)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
-		return
-	}
-
-	// parse token head/tail for response
-	tokenPayload, parseErr := services.ParseTokenFromJSON(sess.TokenData)
-	tokenSummary := map[string]interface{}{
-		"id":         sess.ID.String(),
-		"user_id":    sess.UserID.String(),
-		"expires_at": sess.ExpiresAt,
-	}
-	if parseErr == nil && tokenPayload != nil {
-		t := tokenPayload.Token
-		if len(t) >= 16 {
-			tokenSummary["token_head_tail"] = fmt.Sprintf("%s...%s", t[:8], t[len(t)-8:])
-			tokenSummary["token_length"] = len(t)
-		} else {
-			tokenSummary["token_head_tail"] = t
-			tokenSummary["token_length"] = len(t)
-		}
-	}
-
-	c.JSON(http.StatusOK, tokenSummary)
-}
-
 // UserMeHandler returns current user info (used by /user/me and /api/v1/user/me)
 func UserMeHandler(c *gin.Context) {
 	userIDVal, _ := c.Get("user_id")
@@ -759,11 +754,19 @@ func UpdateUserHandler(c *gin.Context) {
 		FirstName *string `json:"first_name"`
 		LastName  *string `json:"last_name"`
 		IsActive  *bool   `json:"is_active"`
+		Password  *string `json:"password"`
+		Email     *string `json:"email"`
 	}
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	// Invitations and provider accounts are keyed by email, so it only changes
+	// through the verified flow (/user/email-change)
+	if payload.Email != nil {
+		c.JSON(400, gin.H{"error": "Email can only be changed by the user through the verified email change flow"})
+		return
+	}
 
 	db, err := config.NewConnection()
 	if err != nil {
@@ -778,6 +781,19 @@ func UpdateUserHandler(c *gin.Context) {
 		return
 	}
 
+	// Admin password change: same policy and history as a reset; applied first so a
+	// rejected password leaves the user unchanged
+	if payload.Password != nil {
+		if err := services.SetUserPassword(db, &user, *payload.Password); err != nil {
+			if writePasswordPolicyError(c, err) {
+				return
+			}
+			c.JSON(500, gin.H{"error": "Failed to update password"})
+			return
+		}
+		revokeAllUserSessions(uid)
+	}
+
 	if payload.FirstName != nil {
 		full := *payload.FirstName
 		if payload.LastName != nil && *payload.LastName != "" {
@@ -799,6 +815,7 @@ func UpdateUserHandler(c *gin.Context) {
 				c.JSON(500, gin.H{"error": "Failed to deactivate user"})
 				return
 			}
+			revokeAllUserSessions(uid)
 		}
 	}
 
@@ -874,11 +891,25 @@ func DeleteUserHandler(c *gin.Context) {
 		return
 	}
 
+	revokeAllUserSessions(uid)
+
 	log.Printf("User deleted: %s", uid)
 
 	c.JSON(200, gin.H{"message": "User deleted"})
 }
 
+// revokeAllUserSessions ends every session of a user so their access tokens stop working immediately
+func revokeAllUserSessions(userID uuid.UUID) {
+	sessionService, err := services.NewSessionService()
+	if err != nil {
+		log.Printf("revokeAllUserSessions: failed to initialize session service: %v", err)
+		return
+	}
+	if err := sessionService.LogoutAllUserSessions(userID); err != nil {
+		log.Printf("LogoutAllUserSessions error for user %s: %v", userID, err)
+	}
+}
+
 // GetUserPermissionsHandler kullanıcının izinlerini getirir
 // @Summary Get user permissions
 // @Description Get permissions for a specific user based on their role
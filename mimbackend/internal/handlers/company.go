@@ -643,26 +643,9 @@ func RequestExportBackgroundHandler(c *gin.Context) {
 		req.Models = []string{}
 	}
 
-	// Parse access_token cookie as fallback if Authorization header is not present
-	var userID uuid.UUID
-	if v, exists := c.Get("user_id"); exists {
-		if uid, ok := v.(uuid.UUID); ok {
-			userID = uid
-		}
-	}
-	// If middleware didn't set user, try cookie
-	if userID == uuid.Nil {
-		cookie, err := c.Cookie("access_token")
-		if err != nil || cookie == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
-		claims, err := services.ValidateJWT(cookie)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			return
-		}
-		userID = claims.UserID
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
 	}
 
 	company, err := services.GetCompanyByID(companyID)
@@ -702,25 +685,9 @@ func DownloadExportHandler(c *gin.Context) {
 	}
 
 	// Validate requester is the same user who initiated export
-	var userID uuid.UUID
-	if v, exists := c.Get("user_id"); exists {
-		if uid, ok := v.(uuid.UUID); ok {
-			userID = uid
-		}
-	}
-	if userID == uuid.Nil {
-		// try cookie
-		cookie, err := c.Cookie("access_token")
-		if err != nil || cookie == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
-		claims, err := services.ValidateJWT(cookie)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			return
-		}
-		userID = claims.UserID
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
 	}
 
 	if userID != rec.UserID {
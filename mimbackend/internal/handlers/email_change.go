@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmailChangeRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email,max=200"`
+	Password string `json:"password"` // Required for accounts with a password
+	Code     string `json:"code"`     // TOTP or recovery code, alternative to the password
+}
+
+type EmailChangeTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// RequestEmailChangeHandler starts changing the current user's email
+// @Summary Request email change
+// @Description Re-authenticates the user and emails a confirmation link to the new address and a cancel link to the current one. The email changes only after the new address is confirmed
+// @Tags User
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body EmailChangeRequest true "New email"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 423 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /user/email-change [post]
+func RequestEmailChangeHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req EmailChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	user, err := services.GetUserByID(uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !verifyReauthentication(c, user, req.Password, req.Code) {
+		return
+	}
+
+	if err := services.RequestEmailChange(user, req.NewEmail); err != nil {
+		writeEmailChangeError(c, err, "Email değişikliği başlatılamadı")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Onay bağlantısı yeni email adresinize gönderildi"})
+}
+
+// ConfirmEmailChangeHandler applies an email change from the confirmation link
+// @Summary Confirm email change
+// @Description Consume the link sent to the new address. The account, its email-keyed provider accounts and pending company invitations move to the new address together
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body EmailChangeTokenRequest true "Link token"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/email-change/confirm [post]
+func ConfirmEmailChangeHandler(c *gin.Context) {
+	var req EmailChangeTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	user, err := services.ConfirmEmailChange(req.Token)
+	if err != nil {
+		writeEmailChangeError(c, err, "Failed to confirm email change")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email adresiniz güncellendi", "email": user.Email})
+}
+
+// CancelEmailChangeHandler cancels or reverts an email change from the link
+// sent to the old address
+// @Summary Cancel email change
+// @Description Consume the link sent to the old address. A pending change is dropped; a confirmed one is reverted and all sessions of the user are ended
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body EmailChangeTokenRequest true "Link token"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/email-change/cancel [post]
+func CancelEmailChangeHandler(c *gin.Context) {
+	var req EmailChangeTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	user, reverted, err := services.CancelEmailChange(req.Token)
+	if err != nil {
+		writeEmailChangeError(c, err, "Failed to cancel email change")
+		return
+	}
+
+	// Whoever confirmed the change may still be signed in
+	if reverted {
+		revokeAllUserSessions(user.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email değişikliği iptal edildi", "email": user.Email, "reverted": reverted})
+}
+
+func writeEmailChangeError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrEmailUnchanged):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Yeni email adresi mevcut adresinizle aynı"})
+	case errors.Is(err, services.ErrEmailInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": "Bu email adresi başka bir hesap tarafından kullanılıyor"})
+	case errors.Is(err, services.ErrEmailChangeInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bağlantı geçersiz veya süresi dolmuş"})
+	default:
+		log.Printf("email change: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
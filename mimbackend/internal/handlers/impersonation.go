@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type StartImpersonationRequest struct {
+	// Reason is shown to the user in their session history, e.g. a support ticket
+	Reason string `json:"reason" binding:"required,max=500"`
+	// DurationMinutes defaults to 30, at most 60
+	DurationMinutes int    `json:"duration_minutes" binding:"omitempty,min=1,max=60"`
+	Password        string `json:"password"` // Required for admins with a password
+	Code            string `json:"code"`     // TOTP or recovery code, alternative to the password
+}
+
+// StartImpersonationHandler opens a session of a user for the calling super admin
+// @Summary Impersonate a user
+// @Description Starts a time-boxed session as the user (super admin only). The access token carries the admin in its "act" claim, cannot be refreshed and cannot change credentials, the email address, delete the account or purge companies. Every request made with it is recorded for the admin and the session appears in the user's session history. Only an interactive session of the admin can start it, after re-authenticating with the password or an MFA code; personal access tokens, service account secrets, OAuth2 application tokens and impersonation tokens are refused
+// @Tags Sessions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param userId path string true "User ID"
+// @Param payload body StartImpersonationRequest true "Impersonation payload"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /users/{userId}/impersonate [post]
+func StartImpersonationHandler(c *gin.Context) {
+	adminID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	targetID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req StartImpersonationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	admin, err := services.GetUserByID(adminID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if !verifyReauthentication(c, admin, req.Password, req.Code) {
+		return
+	}
+
+	sessionService, err := services.NewSessionService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session service"})
+		return
+	}
+
+	duration := time.Duration(req.DurationMinutes) * time.Minute
+	accessToken, session, err := services.StartImpersonation(admin, targetID, req.Reason, duration, sessionService.ExtractSecurityInfo(c))
+	if err != nil {
+		writeImpersonationError(c, err, "Failed to start impersonation")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(session.ExpiresAt).Seconds()),
+		"expires_at":   session.ExpiresAt,
+		"session_id":   session.SessionID,
+	})
+}
+
+// EndImpersonationHandler ends the impersonation session the request was made with
+// @Summary End impersonation
+// @Tags Sessions
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /auth/impersonation/end [post]
+func EndImpersonationHandler(c *gin.Context) {
+	if _, ok := c.Get("impersonator_id"); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not an impersonation session"})
+		return
+	}
+
+	if err := services.EndImpersonation(c.GetString("session_id")); err != nil {
+		writeImpersonationError(c, err, "Failed to end impersonation")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended"})
+}
+
+// GetImpersonationActivityHandler returns the requests made while a user was impersonated
+// @Summary Impersonation activity of a user
+// @Description Latest requests made by super admins impersonating the user, each with the admin's ID (admin only)
+// @Tags Sessions
+// @Produce json
+// @Security BearerAuth
+// @Param userId path string true "User ID"
+// @Param session_id query string false "Only this impersonation session"
+// @Param limit query int false "Number of entries (default 50, max 500)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /users/{userId}/impersonation-activity [get]
+func GetImpersonationActivityHandler(c *gin.Context) {
+	uid, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	limit := 50
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, 500)
+	}
+
+	activity, err := services.ListImpersonationActivity(uid, c.Query("session_id"), limit)
+	if err != nil {
+		writeImpersonationError(c, err, "Failed to get impersonation activity")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"activity": activity, "count": len(activity)})
+}
+
+func writeImpersonationError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrImpersonationNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can impersonate users"})
+	case errors.Is(err, services.ErrImpersonationTargetInvalid):
+		c.JSON(http.StatusForbidden, gin.H{"error": "This user cannot be impersonated"})
+	case errors.Is(err, services.ErrImpersonationTargetMissing):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrImpersonationReason):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to impersonate a user"})
+	case errors.Is(err, services.ErrImpersonationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Impersonation session not found or already ended"})
+	default:
+		log.Printf("impersonation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"log"
+	"mimbackend/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKSHandler publishes the public keys used to sign access tokens
+// @Summary JSON Web Key Set
+// @Description Public keys for verifying access tokens offline. Symmetric (HS256) secrets are never listed.
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} services.JWKS
+// @Failure 500 {object} map[string]interface{}
+// @Router /.well-known/jwks.json [get]
+func JWKSHandler(c *gin.Context) {
+	jwks, err := services.GetJWKS()
+	if err != nil {
+		log.Printf("JWKSHandler: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Signing keys unavailable"})
+		return
+	}
+
+	// Verifiers may cache the set; keep it short so rotations propagate quickly
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
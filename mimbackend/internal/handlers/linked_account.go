@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	auth "mimbackend/internal/models/auth"
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type LinkAccountRequest struct {
+	Provider   string `json:"provider" binding:"required"`
+	Password   string `json:"password"`    // Required for accounts with a password
+	Code       string `json:"code"`        // TOTP or recovery code, alternative to the password
+	RedirectTo string `json:"redirect_to"` // Frontend path to return to, defaults to /settings/accounts
+}
+
+// ListLinkedAccountsHandler lists the external login providers of the current user
+// @Summary List linked login providers
+// @Description External providers (Google, GitHub, OpenID Connect, ...) the current user can sign in with
+// @Tags User
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /user/accounts [get]
+func ListLinkedAccountsHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	accounts, err := services.ListLinkedAccounts(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list linked accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+}
+
+// StartAccountLinkHandler starts linking an external provider to the current user
+// @Summary Link a login provider
+// @Description Re-authenticates the user (password, TOTP/recovery code, or a sign-in from the last 10 minutes for accounts with neither) and returns the provider URL to continue in the browser. The provider callback attaches the identity and redirects to the frontend. A company's SAML identity provider is linked with provider saml:<company ID>.
+// @Tags User
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body LinkAccountRequest true "Link payload"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 423 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Failure 502 {object} map[string]interface{}
+// @Router /user/accounts/link [post]
+func StartAccountLinkHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req LinkAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+	if !services.IsLinkableProvider(req.Provider) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
+	user, err := services.GetUserByID(uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !verifyReauthentication(c, user, req.Password, req.Code) {
+		return
+	}
+
+	// A company's SAML identity provider answers with a browser POST to the ACS
+	if companyID, ok := services.SAMLProviderCompany(req.Provider); ok {
+		authURL, ok := beginSAMLFlow(c, companyID, req.RedirectTo, &uid)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+		return
+	}
+
+	flowProvider := req.Provider
+	oidcProvider, oidcErr := services.GetOIDCProvider(req.Provider)
+	if oidcErr == nil {
+		flowProvider = "oidc:" + oidcProvider.Name()
+	}
+
+	state, flow, ok := beginOAuthFlow(c, flowProvider, req.RedirectTo, &uid)
+	if !ok {
+		return
+	}
+
+	var authURL string
+	if oidcErr == nil {
+		if authURL, err = oidcProvider.AuthCodeURL(state, flow.Nonce, flow.CodeChallenge()); err != nil {
+			log.Printf("OIDC %s: %v", oidcProvider.Name(), err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider is unavailable"})
+			return
+		}
+	} else {
+		authURL = oauthAuthorizationURL(req.Provider, state, flow.CodeChallenge())
+	}
+
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// UnlinkAccountHandler removes a linked login provider from the current user
+// @Summary Unlink a login provider
+// @Description Refused when the provider is the last way to sign in (password, other providers, passkeys and a verified phone count; magic links do not)
+// @Tags User
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Linked account ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /user/accounts/{id} [delete]
+func UnlinkAccountHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	accountID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return
+	}
+
+	err = services.UnlinkAccount(uid, accountID)
+	switch {
+	case errors.Is(err, services.ErrLinkedAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Linked account not found"})
+		return
+	case errors.Is(err, services.ErrLastLoginMethod):
+		c.JSON(http.StatusConflict, gin.H{"error": "Hesabınızda başka bir giriş yöntemi kalmayacağı için bu bağlantı kaldırılamaz", "code": "last_login_method"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlinked"})
+}
+
+// completeOAuthLink attaches the provider identity from a callback to the user
+// who started the link flow and sends the browser back to the frontend
+func completeOAuthLink(c *gin.Context, flow *services.OAuthState, provider, providerID string, token *services.OAuthToken) {
+	userID := *flow.LinkUserID
+	query := url.Values{"provider": {provider}}
+	err := services.LinkAccount(userID, provider, providerID, token.AccessToken, token.RefreshToken, providerTokenExpiry(token))
+	switch {
+	case err == nil:
+		query.Set("linked", "true")
+	case errors.Is(err, services.ErrAccountLinkedToOtherUser):
+		query.Set("error", "account_linked_to_other_user")
+	case errors.Is(err, services.ErrProviderAlreadyLinked):
+		query.Set("error", "provider_already_linked")
+	default:
+		log.Printf("LinkAccount %s for user %s: %v", provider, userID, err)
+		query.Set("error", "link_failed")
+	}
+
+	redirectTo := flow.RedirectTo
+	if redirectTo == "" {
+		redirectTo = "/settings/accounts"
+	}
+	c.Redirect(http.StatusFound, oauthFrontendURL()+appendQuery(redirectTo, query))
+}
+
+// appendQuery adds query parameters to a frontend path that may already have some
+func appendQuery(path string, query url.Values) string {
+	fragment := ""
+	if i := strings.Index(path, "#"); i >= 0 {
+		path, fragment = path[:i], path[i:]
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + query.Encode() + fragment
+}
+
+// providerTokenExpiry converts the provider's expires_in to the Unix time kept on Account
+func providerTokenExpiry(token *services.OAuthToken) int64 {
+	if token.ExpiresIn <= 0 {
+		return 0
+	}
+	return time.Now().Add(time.Duration(token.ExpiresIn) * time.Second).Unix()
+}
+
+// verifyReauthentication checks the password or code a sensitive change was
+// confirmed with and writes the error response when it is refused. Failures
+// count against the login lockout of the account.
+func verifyReauthentication(c *gin.Context, user *auth.User, password, code string) bool {
+	err := services.VerifyReauthentication(user, password, code, c.GetString("session_id"), c.ClientIP(), c.Request.UserAgent())
+
+	var blocked *services.LoginBlockedError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &blocked):
+		writeLoginBlocked(c, blocked)
+	case errors.Is(err, services.ErrReauthenticationRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Bu işlem için kimliğinizi yeniden doğrulamanız gerekiyor", "code": "reauthentication_required"})
+	case errors.Is(err, services.ErrReauthenticationFailed):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Şifre veya doğrulama kodu hatalı", "code": "reauthentication_failed"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify identity"})
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mimbackend/internal/services"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type UnlockAccountRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// UnlockAccountHandler lifts a login lockout with the emailed unlock link
+// @Summary Unlock account
+// @Description Exchange the token from the lockout email to allow password login again before the lockout expires
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body UnlockAccountRequest true "Unlock token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/unlock [post]
+func UnlockAccountHandler(c *gin.Context) {
+	var req UnlockAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	guard, err := services.NewLoginGuard()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize login guard"})
+		return
+	}
+
+	if err := guard.UnlockWithToken(req.Token); err != nil {
+		if errors.Is(err, services.ErrUnlockTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Kilit kaldırma bağlantısı geçersiz veya süresi dolmuş"})
+			return
+		}
+		fmt.Printf("UnlockWithToken error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Hesap kilidi kaldırıldı"})
+}
+
+// AdminGetUserLockoutHandler returns the login lockout state of a user (admin only)
+// @Summary Get login lockout of a user
+// @Description Lockout state, recent failure count and the latest failed logins of a user (admin only)
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param userId path string true "User ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /users/{userId}/lockout [get]
+func AdminGetUserLockoutHandler(c *gin.Context) {
+	uid, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := services.GetUserByID(uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	guard, err := services.NewLoginGuard()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize login guard"})
+		return
+	}
+
+	status, err := guard.Status(user.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lockout status"})
+		return
+	}
+	attempts, err := guard.FailedAttempts(user.ID, 20)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get failed logins"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"lockout":       status,
+		"failed_logins": attempts,
+	})
+}
+
+// AdminUnlockUserHandler lifts the login lockout of a user (admin only)
+// @Summary Unlock a user
+// @Description Lift the login lockout and reset the failure counters of a user (admin only)
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param userId path string true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /users/{userId}/lockout [delete]
+func AdminUnlockUserHandler(c *gin.Context) {
+	uid, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := services.GetUserByID(uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	guard, err := services.NewLoginGuard()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize login guard"})
+		return
+	}
+
+	if err := guard.UnlockAccount(user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
+// AdminUnblockIPHandler lifts the login block of a client IP (admin only)
+// @Summary Unblock an IP address
+// @Description Lift the login block and reset the failure counter of a client IP (admin only)
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param ip path string true "IP address"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /login-blocks/ips/{ip} [delete]
+func AdminUnblockIPHandler(c *gin.Context) {
+	ip := net.ParseIP(c.Param("ip"))
+	if ip == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+		return
+	}
+
+	guard, err := services.NewLoginGuard()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize login guard"})
+		return
+	}
+
+	if err := guard.UnblockIP(ip.String()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock IP address"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "IP address unblocked successfully"})
+}
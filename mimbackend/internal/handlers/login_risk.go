@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	auth "mimbackend/internal/models/auth"
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// LoginConfirmationResponse is returned instead of tokens when a risky login
+// has to be confirmed with the link emailed to the user
+type LoginConfirmationResponse struct {
+	EmailConfirmationRequired bool   `json:"email_confirmation_required"`
+	Message                   string `json:"message"`
+}
+
+// checkLoginRisk scores a login whose first factor succeeded and returns the
+// assessment together with the action still required for it (see
+// LoginRiskAssessment.RequiredAction). While a confirmation link is cooling
+// down it returns services.ErrLoginConfirmationPending.
+func checkLoginRisk(c *gin.Context, user *auth.User, loginMethod string, hasMFA bool) (*services.LoginRiskAssessment, string, error) {
+	sessionService, err := services.NewSessionService()
+	if err != nil {
+		return nil, "", err
+	}
+	securityInfo := sessionService.ExtractSecurityInfo(c)
+
+	risk := sessionService.AssessLoginRisk(user.ID, securityInfo)
+	action := risk.RequiredAction(loginMethod, hasMFA)
+
+	switch action {
+	case services.RiskActionBlock:
+		log.Printf("🚫 Login of %s blocked: risk score %d (%v)", user.Email, risk.Score, risk.Signals)
+		if guard, err := services.NewLoginGuard(); err == nil {
+			guard.RecordFailure(user, user.Email, c.ClientIP(), c.Request.UserAgent(), auth.LoginFailureRiskBlocked)
+		}
+	case services.RiskActionEmailConfirmation:
+		log.Printf("✉️ Login of %s needs email confirmation: risk score %d (%v)", user.Email, risk.Score, risk.Signals)
+		binding, err := newMagicLinkBinding()
+		if err != nil {
+			return nil, "", err
+		}
+		// The binding cookie of the still-valid previous link is kept on cooldown
+		if err := services.SendLoginConfirmation(user, binding, securityInfo); err != nil {
+			return nil, "", err
+		}
+		setMagicLinkBindingCookie(c, binding)
+	}
+
+	return risk, action, nil
+}
+
+// enforceLoginRisk answers blocked logins and logins waiting for email
+// confirmation. It returns the assessment to record on the session and false
+// when the login must not go on.
+func enforceLoginRisk(c *gin.Context, user *auth.User, loginMethod string, hasMFA bool) (*services.LoginRiskAssessment, bool) {
+	risk, action, err := checkLoginRisk(c, user, loginMethod, hasMFA)
+	if errors.Is(err, services.ErrLoginConfirmationPending) {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "Onay bağlantısı az önce gönderildi; email adresinizi kontrol edin veya biraz sonra tekrar deneyin",
+			"code":  "login_confirmation_pending",
+		})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("checkLoginRisk error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login risk"})
+		return nil, false
+	}
+
+	switch action {
+	case services.RiskActionBlock:
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Bu giriş güvenlik nedeniyle engellendi",
+			"code":  "login_risk_blocked",
+		})
+		return nil, false
+	case services.RiskActionEmailConfirmation:
+		c.JSON(http.StatusAccepted, LoginConfirmationResponse{
+			EmailConfirmationRequired: true,
+			Message:                   "Girişi tamamlamak için email adresinize gönderilen bağlantıyı kullanın",
+		})
+		return nil, false
+	}
+	return risk, true
+}
+
+// AdminGetUserLoginRiskHandler returns the risk breakdown of a user's logins (admin only)
+// @Summary Get login risk of a user
+// @Description Risk score, fired signals and resulting action of the user's latest logins, the logins blocked by the risk engine and the weights and thresholds in effect (admin only)
+// @Tags Users
+// @Produce json
+// @Security BearerAuth
+// @Param userId path string true "User ID"
+// @Param limit query int false "Number of sessions (default 20, max 100)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /users/{userId}/login-risk [get]
+func AdminGetUserLoginRiskHandler(c *gin.Context) {
+	uid, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	limit := 20
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, 100)
+	}
+
+	sessionService, err := services.NewSessionService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session service"})
+		return
+	}
+	logins, err := sessionService.LoginRiskHistory(uid, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get login risk"})
+		return
+	}
+
+	blocked, err := sessionService.RiskBlockedLogins(uid, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blocked logins"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"logins":         logins,
+		"blocked_logins": blocked,
+		"config":         services.CurrentLoginRiskConfig(),
+	})
+}
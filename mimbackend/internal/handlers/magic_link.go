@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mimbackend/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// magicLinkBindingCookie holds the browser nonce a bound magic link is tied to
+const magicLinkBindingCookie = "magic_link_binding"
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type MagicLinkConsumeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// MagicLinkRequestHandler emails a passwordless login link
+// @Summary Request magic link
+// @Description Email a single-use login link. The response is the same for unregistered addresses
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body MagicLinkRequest true "Email"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/magic-link [post]
+func MagicLinkRequestHandler(c *gin.Context) {
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	binding, err := newMagicLinkBinding()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create magic link"})
+		return
+	}
+
+	if err := services.SendMagicLink(req.Email, binding); err != nil {
+		fmt.Printf("SendMagicLink error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Giriş bağlantısı gönderilemedi"})
+		return
+	}
+
+	setMagicLinkBindingCookie(c, binding)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email kayıtlıysa giriş bağlantısı gönderildi"})
+}
+
+// MagicLinkConsumeHandler logs in with a magic link token
+// @Summary Consume magic link
+// @Description Exchange the token from the emailed link for a session. Users with two-factor authentication get an MFA challenge like /auth/login
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body MagicLinkConsumeRequest true "Link token"
+// @Success 200 {object} AuthResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/magic-link/consume [post]
+func MagicLinkConsumeHandler(c *gin.Context) {
+	var req MagicLinkConsumeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	binding, _ := c.Cookie(magicLinkBindingCookie)
+
+	user, err := services.ConsumeMagicLink(req.Token, binding)
+	if errors.Is(err, services.ErrMagicLinkInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Giriş bağlantısı geçersiz veya süresi dolmuş"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("ConsumeMagicLink error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to consume magic link"})
+		return
+	}
+
+	if binding != "" {
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     magicLinkBindingCookie,
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			MaxAge:   -1,
+		})
+	}
+
+	beginLogin(c, user, "magic_link")
+}
+
+// newMagicLinkBinding returns a browser nonce for a new magic link, or ""
+// when links are not bound to the requesting browser
+func newMagicLinkBinding() (string, error) {
+	if !services.MagicLinkBindBrowser() {
+		return "", nil
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func setMagicLinkBindingCookie(c *gin.Context, binding string) {
+	if binding == "" {
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     magicLinkBindingCookie,
+		Value:    binding,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(services.MagicLinkTTL.Seconds()),
+	})
+}
@@ -68,17 +68,18 @@ func GetCompanyMembersHandler(c *gin.Context) {
 		}
 
 		resp = append(resp, gin.H{
-			"id":          m.ID,
-			"user_id":     m.UserID,
-			"company_id":  m.CompanyID,
-			"role_id":     m.RoleID,
-			"is_owner":    m.IsOwner,
-			"is_active":   m.IsActive,
-			"joined_at":   m.JoinedAt,
-			"created_at":  m.CreatedAt,
-			"user":        userObj,
-			"role":        roleObj,
-			"user_exists": m.User != nil,
+			"id":                 m.ID,
+			"user_id":            m.UserID,
+			"company_id":         m.CompanyID,
+			"role_id":            m.RoleID,
+			"is_owner":           m.IsOwner,
+			"is_active":          m.IsActive,
+			"is_service_account": m.IsServiceAccount,
+			"joined_at":          m.JoinedAt,
+			"created_at":         m.CreatedAt,
+			"user":               userObj,
+			"role":               roleObj,
+			"user_exists":        m.User != nil,
 		})
 	}
 
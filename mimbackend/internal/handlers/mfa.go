@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	auth "mimbackend/internal/models/auth"
+	"mimbackend/internal/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mfaTokenCookie carries the MFA challenge of a browser login (OAuth, SAML)
+// that redirected to the frontend for the second factor
+const mfaTokenCookie = "mfa_token"
+
+// MFAChallengeResponse is returned by LoginHandler instead of tokens when the
+// user has two-factor authentication enabled
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Methods     []string  `json:"methods"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token"`               // Falls back to the mfa_token cookie of redirected logins
+	Code     string `json:"code" binding:"required"` // TOTP code or recovery code
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFADisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// MFAVerifyHandler completes a two-step login
+// @Summary Verify MFA challenge
+// @Description Exchange the MFA challenge token from /auth/login (or the mfa_token cookie set by OAuth and SAML logins) and a TOTP or recovery code for a session
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body MFAVerifyRequest true "MFA verify payload"
+// @Success 200 {object} AuthResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/mfa/verify [post]
+func MFAVerifyHandler(c *gin.Context) {
+	var req MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	var ok bool
+	if req.MFAToken, ok = mfaChallengeToken(c, req.MFAToken); !ok {
+		return
+	}
+
+	mfaService, err := services.NewMFAService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize MFA service"})
+		return
+	}
+
+	user, guard, ok := guardMFAChallenge(c, mfaService, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	_, method, err := mfaService.VerifyChallenge(req.MFAToken, req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrMFAChallengeInvalid):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "mfa_challenge_invalid", "message": "Doğrulama süresi doldu, lütfen tekrar giriş yapın"})
+		case errors.Is(err, services.ErrInvalidMFACode):
+			// Yanlış kodlar hesabın hatalı giriş sayacına işlenir
+			guard.RecordFailure(user, user.Email, c.ClientIP(), c.Request.UserAgent(), auth.LoginFailureInvalidCode)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "mfa_code_invalid", "message": "Doğrulama kodu geçersiz"})
+		case errors.Is(err, services.ErrMFANotEnabled):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "mfa_code_invalid", "message": "Doğrulama kodu geçersiz"})
+		default:
+			fmt.Printf("VerifyChallenge error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify MFA challenge"})
+		}
+		return
+	}
+
+	firstFactor, risk := mfaService.ChallengeLogin(req.MFAToken)
+	clearMFATokenCookie(c)
+	completeLogin(c, user, secondFactorLoginMethod(firstFactor, method), risk)
+}
+
+// mfaChallengeToken returns the challenge token sent in the body or, for OAuth
+// and SAML logins that redirected to the frontend, the mfa_token cookie. It
+// writes the error response when there is neither.
+func mfaChallengeToken(c *gin.Context, bodyToken string) (string, bool) {
+	if bodyToken != "" {
+		return bodyToken, true
+	}
+	if token, err := c.Cookie(mfaTokenCookie); err == nil && token != "" {
+		return token, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+	return "", false
+}
+
+// setMFATokenCookie hands the MFA challenge of a redirected login to the
+// frontend in an HttpOnly cookie that lives as long as the challenge
+func setMFATokenCookie(c *gin.Context, token string, expiresAt time.Time) {
+	settings := services.AuthCookieConfig()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     mfaTokenCookie,
+		Value:    token,
+		Path:     "/",
+		Domain:   settings.Domain,
+		Expires:  expiresAt,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
+		HttpOnly: true,
+		Secure:   settings.Secure,
+		SameSite: settings.SameSite,
+	})
+}
+
+// clearMFATokenCookie removes the MFA challenge cookie once the login is completed
+func clearMFATokenCookie(c *gin.Context) {
+	settings := services.AuthCookieConfig()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     mfaTokenCookie,
+		Value:    "",
+		Path:     "/",
+		Domain:   settings.Domain,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   settings.Secure,
+		SameSite: settings.SameSite,
+	})
+}
+
+// secondFactorLoginMethod is the login method recorded on a session completed
+// with a second factor, e.g. "password+totp" or "saml+webauthn_2fa". Keeping
+// the first factor lets the SSO policy see logins that started with SAML.
+func secondFactorLoginMethod(firstFactor, secondFactor string) string {
+	if firstFactor == "" {
+		return secondFactor // challenge created before the first factor was stored
+	}
+	return firstFactor + "+" + secondFactor
+}
+
+// guardMFAChallenge loads the user of a pending login challenge and refuses
+// the second step while the account is locked or throttled or the client IP
+// is blocked, like LoginHandler does for passwords. It returns false when the
+// response was written.
+func guardMFAChallenge(c *gin.Context, mfaService *services.MFAService, token string) (*auth.User, *services.LoginGuard, bool) {
+	userID, err := mfaService.ChallengeUser(token)
+	if errors.Is(err, services.ErrMFAChallengeInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "mfa_challenge_invalid", "message": "Doğrulama süresi doldu, lütfen tekrar giriş yapın"})
+		return nil, nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load MFA challenge"})
+		return nil, nil, false
+	}
+
+	user, err := services.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, nil, false
+	}
+
+	guard, err := services.NewLoginGuard()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize login guard"})
+		return nil, nil, false
+	}
+	var blocked *services.LoginBlockedError
+	if err := guard.Check(user.Email, c.ClientIP()); errors.As(err, &blocked) {
+		guard.RecordFailure(user, user.Email, c.ClientIP(), c.Request.UserAgent(), loginFailureReason(blocked))
+		writeLoginBlocked(c, blocked)
+		return nil, nil, false
+	}
+
+	return user, guard, true
+}
+
+// MFAStatusHandler returns the two-factor status of the current user
+// @Summary Get MFA status
+// @Description Whether TOTP is enabled and how many recovery codes are left
+// @Tags Auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/mfa/status [get]
+func MFAStatusHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	mfaService, err := services.NewMFAService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize MFA service"})
+		return
+	}
+
+	enabled, err := mfaService.IsEnabled(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get MFA status"})
+		return
+	}
+	remaining, err := mfaService.RemainingRecoveryCodes(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get MFA status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"totp_enabled":             enabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// MFASetupHandler starts TOTP enrollment
+// @Summary Start TOTP enrollment
+// @Description Create a TOTP secret and provisioning URI (render as QR code). Enrollment is active after /auth/mfa/totp/confirm
+// @Tags Auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} services.TOTPEnrollment
+// @Failure 401 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /auth/mfa/totp/setup [post]
+func MFASetupHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := services.GetUserByID(uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	mfaService, err := services.NewMFAService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize MFA service"})
+		return
+	}
+
+	enrollment, err := mfaService.BeginTOTPEnrollment(user)
+	if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+		c.JSON(http.StatusConflict, gin.H{"error": "İki adımlı doğrulama zaten etkin"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start TOTP enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// MFAConfirmHandler confirms TOTP enrollment
+// @Summary Confirm TOTP enrollment
+// @Description Activate TOTP with a code from the authenticator app. Returns the recovery codes once
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body MFACodeRequest true "TOTP code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /auth/mfa/totp/confirm [post]
+func MFAConfirmHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	mfaService, err := services.NewMFAService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize MFA service"})
+		return
+	}
+
+	codes, err := mfaService.ConfirmTOTPEnrollment(uid, req.Code)
+	switch {
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "İki adımlı doğrulama zaten etkin"})
+		return
+	case errors.Is(err, services.ErrMFAEnrollmentNotStarted):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Önce TOTP kurulumunu başlatın"})
+		return
+	case errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Doğrulama kodu geçersiz"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm TOTP enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "İki adımlı doğrulama etkinleştirildi",
+		"recovery_codes": codes,
+	})
+}
+
+// MFADisableHandler disables TOTP
+// @Summary Disable TOTP
+// @Description Disable two-factor authentication. Requires the password (for password accounts) and a TOTP or recovery code; wrong passwords and codes count against the login lockout
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body MFADisableRequest true "Disable payload"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 423 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Router /auth/mfa/totp/disable [post]
+func MFADisableHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req MFADisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	user, err := services.GetUserByID(uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	guard, err := services.NewLoginGuard()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize login guard"})
+		return
+	}
+	// The password goes through the login lockout like any re-authentication;
+	// accounts without one are still held back while locked
+	if user.PasswordHash != "" {
+		if !verifyReauthentication(c, user, req.Password, "") {
+			return
+		}
+	} else if err := guard.Check(user.Email, c.ClientIP()); err != nil {
+		var blocked *services.LoginBlockedError
+		if errors.As(err, &blocked) {
+			writeLoginBlocked(c, blocked)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify identity"})
+		return
+	}
+
+	mfaService, err := services.NewMFAService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize MFA service"})
+		return
+	}
+
+	err = mfaService.DisableTOTP(uid, req.Code)
+	switch {
+	case errors.Is(err, services.ErrMFANotEnabled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "İki adımlı doğrulama etkin değil"})
+		return
+	case errors.Is(err, services.ErrInvalidMFACode):
+		guard.RecordFailure(user, user.Email, c.ClientIP(), c.Request.UserAgent(), auth.LoginFailureInvalidCode)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Doğrulama kodu geçersiz"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable TOTP"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "İki adımlı doğrulama devre dışı bırakıldı"})
+}
+
+// MFARecoveryCodesHandler regenerates recovery codes
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes. Requires a TOTP or recovery code
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/mfa/recovery-codes [post]
+func MFARecoveryCodesHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	mfaService, err := services.NewMFAService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize MFA service"})
+		return
+	}
+
+	codes, err := mfaService.RegenerateRecoveryCodes(uid, req.Code)
+	switch {
+	case errors.Is(err, services.ErrMFANotEnabled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "İki adımlı doğrulama etkin değil"})
+		return
+	case errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Doğrulama kodu geçersiz"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
@@ -2,11 +2,11 @@ package handlers
 
 import (
 	"bytes"
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	auth "mimbackend/internal/models/auth"
 	"mimbackend/internal/services"
 	"net/http"
 	"net/url"
@@ -16,61 +16,70 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
-// OAuth state için basit in-memory store (production'da Redis kullanın)
-var oauthStates = make(map[string]string)
+// oauthStateCookie binds a pending provider redirect to the browser that started it
+const oauthStateCookie = "oauth_state"
 
-// generateState rastgele state string oluşturur
-func generateState() string {
-	b := make([]byte, 32)
-	rand.Read(b)
-	return base64.URLEncoding.EncodeToString(b)
-}
-
-// GoogleOAuthHandler Google OAuth başlatır
-// @Summary Start Google OAuth
-// @Description Redirects user to Google's OAuth consent screen
-// @Tags Auth
-// @Produce json
-// @Router /auth/google [get]
-func GoogleOAuthHandler(c *gin.Context) {
-	state := generateState()
-	oauthStates[state] = "google"
+// beginOAuthFlow stores the state of a provider redirect (PKCE verifier,
+// nonce, return path and optional link target) and sets the cookie that ties
+// it to this browser. It writes the error response itself when it fails.
+func beginOAuthFlow(c *gin.Context, provider, redirectTo string, linkUserID *uuid.UUID) (string, *services.OAuthState, bool) {
+	state, flow, err := services.NewOAuthState(provider, redirectTo, linkUserID)
+	if errors.Is(err, services.ErrInvalidRedirectTo) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid redirect_to"})
+		return "", nil, false
+	}
+	if err != nil {
+		log.Printf("NewOAuthState %s: %v", provider, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", nil, false
+	}
 
-	// store state in cookie for client validation
 	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "oauth_state",
+		Name:     oauthStateCookie,
 		Value:    state,
 		Path:     "/",
 		HttpOnly: true,
-		Expires:  time.Now().Add(5 * time.Minute),
+		Secure:   services.AuthCookieConfig().Secure,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(services.OAuthStateTTL),
 	})
+	return state, flow, true
+}
 
-	clientID := os.Getenv("GOOGLE_CLIENT_ID")
-	redirect := os.Getenv("GOOGLE_REDIRECT_URI")
-	if redirect == "" {
-		base := os.Getenv("BASE_URL")
-		if base == "" {
-			base = "http://localhost:3333"
-		}
-		apiPrefix := os.Getenv("API_PREFIX")
-		if apiPrefix == "" {
-			apiPrefix = "/api/v1"
-		}
-		redirect = strings.TrimRight(base, "/") + apiPrefix + "/auth/google/callback"
+// consumeOAuthFlow validates the state of a provider callback: it must match
+// the cookie of this browser and a stored, unused state of the same provider.
+// The state is deleted either way. It writes the error response itself.
+func consumeOAuthFlow(c *gin.Context, provider string) (*services.OAuthState, bool) {
+	state := c.Query("state")
+	cookie, err := c.Request.Cookie(oauthStateCookie)
+	http.SetCookie(c.Writer, &http.Cookie{Name: oauthStateCookie, Value: "", Path: "/", Expires: time.Unix(0, 0)})
+	if err != nil || cookie.Value == "" || cookie.Value != state {
+		log.Printf("OAuth callback for %s: state does not match the oauth_state cookie", provider)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+		return nil, false
 	}
 
-	// Google OAuth URL'i oluştur
-	authURL := fmt.Sprintf(
-		"https://accounts.google.com/o/oauth2/v2/auth?client_id=%s&redirect_uri=%s&scope=%s&response_type=code&state=%s",
-		clientID,
-		url.QueryEscape(redirect),
-		"openid email profile",
-		state,
-	)
+	flow, err := services.ConsumeOAuthState(state, provider)
+	if err != nil {
+		log.Printf("OAuth callback for %s: %v", provider, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+		return nil, false
+	}
+	return flow, true
+}
 
-	c.Redirect(http.StatusTemporaryRedirect, authURL)
+// GoogleOAuthHandler Google OAuth başlatır
+// @Summary Start Google OAuth
+// @Description Redirects user to Google's OAuth consent screen
+// @Tags Auth
+// @Produce json
+// @Param redirect_to query string false "Frontend path to return to after login"
+// @Router /auth/google [get]
+func GoogleOAuthHandler(c *gin.Context) {
+	startOIDCLogin(c, "google")
 }
 
 // FacebookOAuthHandler Facebook OAuth başlatır
@@ -78,42 +87,10 @@ func GoogleOAuthHandler(c *gin.Context) {
 // @Description Redirects user to Facebook's OAuth consent screen
 // @Tags Auth
 // @Produce json
+// @Param redirect_to query string false "Frontend path to return to after login"
 // @Router /auth/facebook [get]
 func FacebookOAuthHandler(c *gin.Context) {
-	state := generateState()
-	oauthStates[state] = "facebook"
-
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "oauth_state",
-		Value:    state,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  time.Now().Add(5 * time.Minute),
-	})
-
-	clientID := os.Getenv("FACEBOOK_CLIENT_ID")
-	fbRedirect := os.Getenv("FACEBOOK_REDIRECT_URI")
-	if fbRedirect == "" {
-		base := os.Getenv("BASE_URL")
-		if base == "" {
-			base = "http://localhost:3333"
-		}
-		apiPrefix := os.Getenv("API_PREFIX")
-		if apiPrefix == "" {
-			apiPrefix = "/api/v1"
-		}
-		fbRedirect = strings.TrimRight(base, "/") + apiPrefix + "/auth/facebook/callback"
-	}
-
-	authURL := fmt.Sprintf(
-		"https://www.facebook.com/v18.0/dialog/oauth?client_id=%s&redirect_uri=%s&scope=%s&response_type=code&state=%s",
-		clientID,
-		url.QueryEscape(fbRedirect),
-		"email,public_profile",
-		state,
-	)
-
-	c.Redirect(http.StatusTemporaryRedirect, authURL)
+	startOAuthLogin(c, "facebook")
 }
 
 // GithubOAuthHandler Github OAuth başlatır
@@ -121,202 +98,88 @@ func FacebookOAuthHandler(c *gin.Context) {
 // @Description Redirects user to Github's OAuth consent screen
 // @Tags Auth
 // @Produce json
+// @Param redirect_to query string false "Frontend path to return to after login"
 // @Router /auth/github [get]
 func GithubOAuthHandler(c *gin.Context) {
-	state := generateState()
-	oauthStates[state] = "github"
-
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "oauth_state",
-		Value:    state,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  time.Now().Add(5 * time.Minute),
-	})
-
-	clientID := os.Getenv("GITHUB_CLIENT_ID")
-	ghRedirect := os.Getenv("GITHUB_REDIRECT_URI")
-	if ghRedirect == "" {
-		base := os.Getenv("BASE_URL")
-		if base == "" {
-			base = "http://localhost:3333"
-		}
-		apiPrefix := os.Getenv("API_PREFIX")
-		if apiPrefix == "" {
-			apiPrefix = "/api/v1"
-		}
-		ghRedirect = strings.TrimRight(base, "/") + apiPrefix + "/auth/github/callback"
-	}
-
-	authURL := fmt.Sprintf(
-		"https://github.com/login/oauth/authorize?client_id=%s&redirect_uri=%s&scope=%s&state=%s",
-		clientID,
-		url.QueryEscape(ghRedirect),
-		"user:email",
-		state,
-	)
-
-	c.Redirect(http.StatusTemporaryRedirect, authURL)
+	startOAuthLogin(c, "github")
 }
 
-// GoogleCallbackHandler Google OAuth callback
-// @Summary Google OAuth callback
-// @Description Handles OAuth callback from Google and redirects to frontend
-// @Tags Auth
-// @Produce html
-// @Param state query string true "State"
-// @Param code query string true "Code"
-// @Router /auth/google/callback [get]
-func GoogleCallbackHandler(c *gin.Context) {
-	code := c.Query("code")
-	state := c.Query("state")
-
-	log.Printf("OAuth callback received for google: state=%q", state)
-
-	// State doğrula: prefer cookie stored state (one per client)
-	cookie, err := c.Request.Cookie("oauth_state")
-	if err == nil {
-		log.Printf("Found oauth_state cookie: %q", cookie.Value)
-		if cookie.Value != state {
-			log.Printf("Cookie/state mismatch: cookie=%q state=%q", cookie.Value, state)
-			// mismatch
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state (cookie mismatch)"})
-			return
-		}
-		// remove cookie
-		http.SetCookie(c.Writer, &http.Cookie{Name: "oauth_state", Value: "", Path: "/", Expires: time.Unix(0, 0)})
-	} else {
-		present := oauthStates[state]
-		log.Printf("No cookie; oauthStates[%q]=%q", state, present)
-		// fallback to in-memory map
-		if oauthStates[state] != "google" {
-			log.Printf("oauthStates check failed for state=%q, val=%q", state, oauthStates[state])
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
-			return
-		}
-		delete(oauthStates, state)
-	}
-
-	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Authorization code not provided"})
-		return
-	}
-
-	// Google'dan access token al
-	token, err := services.ExchangeGoogleCode(code)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange code"})
-		return
-	}
-
-	// Google'dan user info al
-	userInfo, err := services.GetGoogleUserInfo(token.AccessToken)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info"})
-		return
-	}
-
-	log.Printf("Google userInfo: ID=%s, Email=%s, Name=%s, Picture=%s", userInfo.ID, userInfo.Email, userInfo.Name, userInfo.Picture)
-
-	// Kullanıcıyı bul veya oluştur
-	user, err := services.FindOrCreateOAuthUser("google", userInfo.ID, userInfo.Email, userInfo.Name, userInfo.Picture)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+// startOAuthLogin redirects to a built-in provider's consent screen
+func startOAuthLogin(c *gin.Context, provider string) {
+	state, flow, ok := beginOAuthFlow(c, provider, c.Query("redirect_to"), nil)
+	if !ok {
 		return
 	}
+	c.Redirect(http.StatusTemporaryRedirect, oauthAuthorizationURL(provider, state, flow.CodeChallenge()))
+}
 
-	// Generate access + refresh tokens
-	accessTok, refreshTok, err := services.GenerateTokens(user.ID, user.Email, user.Role)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
+// oauthAuthorizationURL builds the consent screen URL of a built-in provider
+func oauthAuthorizationURL(provider, state, codeChallenge string) string {
+	params := url.Values{}
+	params.Set("client_id", os.Getenv(strings.ToUpper(provider)+"_CLIENT_ID"))
+	params.Set("redirect_uri", oauthRedirectURI(provider))
+	params.Set("state", state)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", services.PKCEMethodS256)
+
+	switch provider {
+	case "facebook":
+		params.Set("scope", "email,public_profile")
+		params.Set("response_type", "code")
+		return "https://www.facebook.com/v18.0/dialog/oauth?" + params.Encode()
+	default:
+		params.Set("scope", "user:email")
+		return "https://github.com/login/oauth/authorize?" + params.Encode()
 	}
+}
 
-	// Create session with security tracking
-	sessionService, err := services.NewSessionService()
-	if err != nil {
-		log.Printf("Failed to create session service: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session"})
-		return
-	}
-
-	securityInfo := sessionService.ExtractSecurityInfo(c)
-	securityInfo.LoginMethod = "oauth_google"
-
-	refreshExp := time.Now().Add(30 * 24 * time.Hour)
-	_, err = sessionService.CreateSession(user.ID, refreshTok, securityInfo, time.Until(refreshExp))
-	if err != nil {
-		log.Printf("CreateUserSession error (oauth google): %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
-		return
+// oauthRedirectURI returns <PROVIDER>_REDIRECT_URI or the callback under BASE_URL
+func oauthRedirectURI(provider string) string {
+	if redirect := os.Getenv(strings.ToUpper(provider) + "_REDIRECT_URI"); redirect != "" {
+		return redirect
 	}
-
-	// Persist session & account (legacy)
-	if err := services.CreateSession(user.ID, refreshTok, refreshExp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
-		return
+	base := os.Getenv("BASE_URL")
+	if base == "" {
+		base = "http://localhost:3333"
 	}
-	if err := services.CreateAccount(user.ID, "google", userInfo.ID, accessTok, refreshTok, refreshExp.Unix()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
-		return
+	apiPrefix := os.Getenv("API_PREFIX")
+	if apiPrefix == "" {
+		apiPrefix = "/api/v1"
 	}
+	return strings.TrimRight(base, "/") + apiPrefix + "/auth/" + provider + "/callback"
+}
 
-	// Token'ları cookie olarak set et
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "access_token",
-		Value:    accessTok,
-		Path:     "/",
-		HttpOnly: true,  // Frontend'ten erişilemesin, sadece server-side
-		Secure:   false, // Development için false
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   3600, // 1 saat
-	})
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshTok,
-		Path:     "/",
-		HttpOnly: true, // Frontend'ten erişilemesin, sadece server-side
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   30 * 24 * 3600, // 30 gün
-	})
-
-	// Send full payload to frontend URL (so frontend can process without tokens in URL)
+// oauthFrontendURL returns FRONTEND_URL, where provider callbacks end up
+func oauthFrontendURL() string {
 	frontendURL := os.Getenv("FRONTEND_URL")
 	if frontendURL == "" {
 		frontendURL = "http://localhost:3000"
 	}
+	return strings.TrimRight(frontendURL, "/")
+}
 
-	payload := map[string]interface{}{
-		"access_token":  accessTok,
-		"refresh_token": refreshTok,
-		"user": map[string]interface{}{
-			"email":       user.Email,
-			"full_name":   user.FullName,
-			"role":        user.Role,
-			"is_verified": user.IsVerified,
-			"image_url":   user.ImageURL,
-		},
-	}
-
-	if b, err := json.Marshal(payload); err == nil {
-		// Best-effort server->server POST JSON to frontend API
-		go func(data []byte) {
-			client := &http.Client{Timeout: 5 * time.Second}
-			req, _ := http.NewRequest("POST", strings.TrimRight(frontendURL, "/")+"/api/auth/callback", bytes.NewBuffer(data))
-			req.Header.Set("Content-Type", "application/json")
-			if resp, err := client.Do(req); err != nil {
-				log.Printf("POST to frontend API failed: %v", err)
-			} else {
-				resp.Body.Close()
-				log.Printf("Posted auth payload to frontend API: %s", frontendURL+"/api/auth/callback")
-			}
-		}(b)
-	} else {
-		log.Printf("Failed to marshal auth payload: %v", err)
+// redirectOAuthLoginError sends the browser back to the frontend login page
+// when a provider login cannot be completed, e.g. because the email belongs
+// to an existing account that has not linked the provider yet
+func redirectOAuthLoginError(c *gin.Context, provider string, err error) {
+	if errors.Is(err, services.ErrOAuthAccountLinkRequired) {
+		query := url.Values{"error": {"account_link_required"}, "provider": {provider}}
+		c.Redirect(http.StatusFound, oauthFrontendURL()+"/auth/login?"+query.Encode())
+		return
 	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+}
 
-	c.Redirect(http.StatusFound, frontendURL+"/")
+// GoogleCallbackHandler Google OAuth callback
+// @Summary Google OAuth callback
+// @Description Handles OAuth callback from Google and redirects to frontend
+// @Tags Auth
+// @Produce html
+// @Param state query string true "State"
+// @Param code query string true "Code"
+// @Router /auth/google/callback [get]
+func GoogleCallbackHandler(c *gin.Context) {
+	completeOIDCLogin(c, "google")
 }
 
 // FacebookCallbackHandler Facebook OAuth callback
@@ -328,37 +191,22 @@ func GoogleCallbackHandler(c *gin.Context) {
 // @Param code query string true "Code"
 // @Router /auth/facebook/callback [get]
 func FacebookCallbackHandler(c *gin.Context) {
-	code := c.Query("code")
-	state := c.Query("state")
+	log.Printf("OAuth callback received for facebook")
 
-	log.Printf("OAuth callback received for facebook: state=%q", state)
-
-	cookie, err := c.Request.Cookie("oauth_state")
-	if err == nil {
-		log.Printf("Found oauth_state cookie: %q", cookie.Value)
-		if cookie.Value != state {
-			log.Printf("Cookie/state mismatch: cookie=%q state=%q", cookie.Value, state)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state (cookie mismatch)"})
-			return
-		}
-		http.SetCookie(c.Writer, &http.Cookie{Name: "oauth_state", Value: "", Path: "/", Expires: time.Unix(0, 0)})
-	} else {
-		log.Printf("No cookie; oauthStates[%q]=%q", state, oauthStates[state])
-		if oauthStates[state] != "facebook" {
-			log.Printf("oauthStates check failed for state=%q, val=%q", state, oauthStates[state])
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
-			return
-		}
-		delete(oauthStates, state)
+	flow, ok := consumeOAuthFlow(c, "facebook")
+	if !ok {
+		return
 	}
 
+	code := c.Query("code")
+
 	if code == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Authorization code not provided"})
 		return
 	}
 
 	// Facebook'dan access token al
-	token, err := services.ExchangeFacebookCode(code)
+	token, err := services.ExchangeFacebookCode(code, flow.CodeVerifier)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange code"})
 		return
@@ -373,103 +221,22 @@ func FacebookCallbackHandler(c *gin.Context) {
 
 	log.Printf("Facebook userInfo: ID=%s, Email=%s, Name=%s, Picture=%s", userInfo.ID, userInfo.Email, userInfo.Name, userInfo.Picture.Data.URL)
 
-	// Kullanıcıyı bul veya oluştur
-	user, err := services.FindOrCreateOAuthUser("facebook", userInfo.ID, userInfo.Email, userInfo.Name, userInfo.Picture.Data.URL)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+	// Giriş yapmış bir kullanıcı provider bağlıyorsa hesabına ekle
+	if flow.LinkUserID != nil {
+		completeOAuthLink(c, flow, "facebook", userInfo.ID, token)
 		return
 	}
 
-	// Generate access + refresh tokens
-	accessTok, refreshTok, err := services.GenerateTokens(user.ID, user.Email, user.Role)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
-	}
-
-	// Create session with security tracking
-	sessionService, err := services.NewSessionService()
-	if err != nil {
-		log.Printf("Failed to create session service: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session"})
-		return
-	}
-
-	securityInfo := sessionService.ExtractSecurityInfo(c)
-	securityInfo.LoginMethod = "oauth_facebook"
-
-	refreshExp := time.Now().Add(30 * 24 * time.Hour)
-	_, err = sessionService.CreateSession(user.ID, refreshTok, securityInfo, time.Until(refreshExp))
+	// Kullanıcıyı bul veya oluştur
+	user, err := services.FindOrCreateOAuthUser("facebook", userInfo.ID, userInfo.Email, userInfo.Name, userInfo.Picture.Data.URL)
 	if err != nil {
-		log.Printf("CreateUserSession error (oauth facebook): %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
-		return
-	}
-
-	if err := services.CreateSession(user.ID, refreshTok, refreshExp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
-		return
-	}
-	if err := services.CreateAccount(user.ID, "facebook", userInfo.ID, accessTok, refreshTok, refreshExp.Unix()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+		redirectOAuthLoginError(c, "facebook", err)
 		return
 	}
 
-	// Token'ları cookie olarak set et
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "access_token",
-		Value:    accessTok,
-		Path:     "/",
-		HttpOnly: true,  // Frontend'ten erişilemesin, sadece server-side
-		Secure:   false, // Development için false
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   3600,
-	})
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshTok,
-		Path:     "/",
-		HttpOnly: true, // Frontend'ten erişilemesin, sadece server-side
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   30 * 24 * 3600,
+	completeOAuthLogin(c, user, "facebook", flow.RedirectTo, func() error {
+		return services.CreateAccount(user.ID, "facebook", userInfo.ID, token.AccessToken, token.RefreshToken, providerTokenExpiry(token))
 	})
-
-	// Send full payload to frontend URL (best-effort)
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:3000"
-	}
-
-	payload := map[string]interface{}{
-		"access_token":  accessTok,
-		"refresh_token": refreshTok,
-		"user": map[string]interface{}{
-			"email":       user.Email,
-			"full_name":   user.FullName,
-			"role":        user.Role,
-			"is_verified": user.IsVerified,
-			"image_url":   user.ImageURL,
-		},
-	}
-
-	if b, err := json.Marshal(payload); err == nil {
-		go func(data []byte) {
-			client := &http.Client{Timeout: 5 * time.Second}
-			req, _ := http.NewRequest("POST", strings.TrimRight(frontendURL, "/")+"/api/auth/callback", bytes.NewBuffer(data))
-			req.Header.Set("Content-Type", "application/json")
-			if resp, err := client.Do(req); err != nil {
-				log.Printf("POST to frontend API failed: %v", err)
-			} else {
-				resp.Body.Close()
-				log.Printf("Posted auth payload to frontend API: %s", frontendURL+"/api/auth/callback")
-			}
-		}(b)
-	} else {
-		log.Printf("Failed to marshal auth payload: %v", err)
-	}
-
-	c.Redirect(http.StatusFound, frontendURL+"/")
 }
 
 // GithubCallbackHandler Github OAuth callback
@@ -481,37 +248,22 @@ func FacebookCallbackHandler(c *gin.Context) {
 // @Param code query string true "Code"
 // @Router /auth/github/callback [get]
 func GithubCallbackHandler(c *gin.Context) {
-	code := c.Query("code")
-	state := c.Query("state")
-
-	log.Printf("OAuth callback received for github: state=%q", state)
+	log.Printf("OAuth callback received for github")
 
-	cookie, err := c.Request.Cookie("oauth_state")
-	if err == nil {
-		log.Printf("Found oauth_state cookie: %q", cookie.Value)
-		if cookie.Value != state {
-			log.Printf("Cookie/state mismatch: cookie=%q state=%q", cookie.Value, state)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state (cookie mismatch)"})
-			return
-		}
-		http.SetCookie(c.Writer, &http.Cookie{Name: "oauth_state", Value: "", Path: "/", Expires: time.Unix(0, 0)})
-	} else {
-		log.Printf("No cookie; oauthStates[%q]=%q", state, oauthStates[state])
-		if oauthStates[state] != "github" {
-			log.Printf("oauthStates check failed for state=%q, val=%q", state, oauthStates[state])
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
-			return
-		}
-		delete(oauthStates, state)
+	flow, ok := consumeOAuthFlow(c, "github")
+	if !ok {
+		return
 	}
 
+	code := c.Query("code")
+
 	if code == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Authorization code not provided"})
 		return
 	}
 
 	// Github'dan access token al
-	token, err := services.ExchangeGithubCode(code)
+	token, err := services.ExchangeGithubCode(code, flow.CodeVerifier)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange code"})
 		return
@@ -526,21 +278,124 @@ func GithubCallbackHandler(c *gin.Context) {
 
 	log.Printf("Github userInfo: ID=%d, Email=%s, Name=%s, AvatarURL=%s", userInfo.ID, userInfo.Email, userInfo.Name, userInfo.AvatarURL)
 
+	// Giriş yapmış bir kullanıcı provider bağlıyorsa hesabına ekle
+	if flow.LinkUserID != nil {
+		completeOAuthLink(c, flow, "github", strconv.Itoa(userInfo.ID), token)
+		return
+	}
+
 	// Kullanıcıyı bul veya oluştur
 	user, err := services.FindOrCreateOAuthUser("github", strconv.Itoa(userInfo.ID), userInfo.Email, userInfo.Name, userInfo.AvatarURL)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		redirectOAuthLoginError(c, "github", err)
 		return
 	}
 
-	// Generate access + refresh tokens
-	accessTok, refreshTok, err := services.GenerateTokens(user.ID, user.Email, user.Role)
+	completeOAuthLogin(c, user, "github", flow.RedirectTo, func() error {
+		return services.CreateAccount(user.ID, "github", strconv.Itoa(userInfo.ID), token.AccessToken, token.RefreshToken, providerTokenExpiry(token))
+	})
+}
+
+// completeOAuthLogin starts a session for a user authenticated by an external
+// provider: it issues the session-bound tokens, lets saveAccount persist the
+// provider account, sets the auth cookies and sends the browser to redirectTo on
+// the frontend.
+func completeOAuthLogin(c *gin.Context, user *auth.User, provider, redirectTo string, saveAccount func() error) {
+	completeExternalLogin(c, user, "oauth_"+provider, redirectTo, saveAccount)
+}
+
+// completeExternalLogin is completeOAuthLogin with the login method recorded
+// on the session given explicitly, e.g. for SAML single sign-on. Users with a
+// second factor are sent to the frontend with an MFA challenge instead of
+// tokens; the provider's own MFA does not replace it.
+func completeExternalLogin(c *gin.Context, user *auth.User, loginMethod, redirectTo string, saveAccount func() error) {
+	// Service accounts only authenticate with their API secrets, also when a
+	// provider account is linked to them
+	if user.IsServiceAccount {
+		c.Redirect(http.StatusFound, oauthFrontendURL()+"/auth/login?error=service_account_login_not_allowed")
+		return
+	}
+
+	// Members of a company that enforces SSO may only sign in with SAML
+	ssoCompanyID, err := ssoRequiredCompany(user, loginMethod)
+	if err != nil {
+		log.Printf("SSORequiredCompany (%s): %v", loginMethod, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check single sign-on policy"})
+		return
+	}
+	if ssoCompanyID != uuid.Nil {
+		query := url.Values{"error": {"sso_required"}, "company_id": {ssoCompanyID.String()}, "sso_url": {services.SAMLLoginURL(ssoCompanyID)}}
+		c.Redirect(http.StatusFound, oauthFrontendURL()+"/auth/login?"+query.Encode())
+		return
+	}
+
+	mfaService, err := services.NewMFAService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize MFA service"})
+		return
+	}
+	mfaMethods, err := mfaService.Methods(user.ID)
+	if err != nil {
+		log.Printf("MFA methods error (%s): %v", loginMethod, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor status"})
+		return
+	}
+
+	// Riskli girişler engellenir ya da email onayı bekler; tarayıcı hata ile frontend'e döner.
+	// mfa aksiyonu aşağıdaki MFA challenge'ı ile karşılanır
+	risk, action, err := checkLoginRisk(c, user, loginMethod, len(mfaMethods) > 0)
+	if errors.Is(err, services.ErrLoginConfirmationPending) {
+		c.Redirect(http.StatusFound, oauthFrontendURL()+"/auth/login?error=login_confirmation_pending")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		log.Printf("checkLoginRisk error (%s): %v", loginMethod, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login risk"})
+		return
+	}
+	switch action {
+	case services.RiskActionBlock:
+		c.Redirect(http.StatusFound, oauthFrontendURL()+"/auth/login?error=login_risk_blocked")
+		return
+	case services.RiskActionEmailConfirmation:
+		c.Redirect(http.StatusFound, oauthFrontendURL()+"/auth/login?error=email_confirmation_required")
+		return
+	}
+
+	// Persist the provider account
+	if err := saveAccount(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+		return
+	}
+
+	// İki adımlı doğrulama açıksa frontend girişi /auth/mfa/verify ile tamamlar
+	if len(mfaMethods) > 0 {
+		challengeToken, expiresAt, err := mfaService.CreateChallenge(user.ID, loginMethod, risk)
+		if errors.Is(err, services.ErrMFAChallengeLimit) {
+			c.Redirect(http.StatusFound, oauthFrontendURL()+"/auth/login?error=mfa_challenge_limit")
+			return
+		}
+		if err != nil {
+			log.Printf("CreateChallenge error (%s): %v", loginMethod, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create MFA challenge"})
+			return
+		}
+		// The challenge stays out of the URL, where it would end up in the
+		// browser history, logs and Referer headers
+		setMFATokenCookie(c, challengeToken, expiresAt)
+		query := url.Values{
+			"mfa_required": {"true"},
+			"expires_at":   {expiresAt.UTC().Format(time.RFC3339)},
+			"methods":      {strings.Join(mfaMethods, ",")},
+		}
+		if redirectTo != "" {
+			query.Set("redirect_to", redirectTo)
+		}
+		c.Redirect(http.StatusFound, oauthFrontendURL()+"/auth/login?"+query.Encode())
 		return
 	}
 
-	// Create session with security tracking
+	// Create session with security tracking; the access + refresh tokens are bound to it
 	sessionService, err := services.NewSessionService()
 	if err != nil {
 		log.Printf("Failed to create session service: %v", err)
@@ -549,55 +404,29 @@ func GithubCallbackHandler(c *gin.Context) {
 	}
 
 	securityInfo := sessionService.ExtractSecurityInfo(c)
-	securityInfo.LoginMethod = "oauth_github"
+	securityInfo.LoginMethod = loginMethod
 
 	refreshExp := time.Now().Add(30 * 24 * time.Hour)
-	_, err = sessionService.CreateSession(user.ID, refreshTok, securityInfo, time.Until(refreshExp))
+	accessTok, refreshTok, _, err := sessionService.IssueSessionTokens(user, securityInfo, risk, time.Until(refreshExp))
 	if err != nil {
-		log.Printf("CreateUserSession error (oauth github): %v", err)
+		log.Printf("CreateUserSession error (%s): %v", loginMethod, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
-	if err := services.CreateSession(user.ID, refreshTok, refreshExp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
-		return
-	}
-	if err := services.CreateAccount(user.ID, "github", strconv.Itoa(userInfo.ID), accessTok, refreshTok, refreshExp.Unix()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
-		return
+	if guard, err := services.NewLoginGuard(); err == nil {
+		guard.RecordSuccess(user.Email)
 	}
 
 	// Token'ları cookie olarak set et
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "access_token",
-		Value:    accessTok,
-		Path:     "/",
-		HttpOnly: true,  // Frontend'ten erişilemesin, sadece server-side
-		Secure:   false, // Development için false
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   3600,
-	})
-
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshTok,
-		Path:     "/",
-		HttpOnly: true, // Frontend'ten erişilemesin, sadece server-side
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   30 * 24 * 3600,
-	})
-
-	// Send full payload to frontend URL (best-effort)
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:3000"
+	if err := services.SetAuthCookies(c, accessTok, refreshTok); err != nil {
+		fmt.Printf("SetAuthCookies error: %v\n", err)
 	}
 
+	// Send full payload to frontend URL (so frontend can process without tokens in URL)
+	frontendURL := oauthFrontendURL()
+
 	payload := map[string]interface{}{
-		"access_token":  accessTok,
-		"refresh_token": refreshTok,
 		"user": map[string]interface{}{
 			"email":       user.Email,
 			"full_name":   user.FullName,
@@ -606,11 +435,17 @@ func GithubCallbackHandler(c *gin.Context) {
 			"image_url":   user.ImageURL,
 		},
 	}
+	// With AUTH_COOKIE_ONLY the tokens stay in the cookies set above
+	if !services.AuthCookieConfig().CookieOnly {
+		payload["access_token"] = accessTok
+		payload["refresh_token"] = refreshTok
+	}
 
 	if b, err := json.Marshal(payload); err == nil {
+		// Best-effort server->server POST JSON to frontend API
 		go func(data []byte) {
 			client := &http.Client{Timeout: 5 * time.Second}
-			req, _ := http.NewRequest("POST", strings.TrimRight(frontendURL, "/")+"/api/auth/callback", bytes.NewBuffer(data))
+			req, _ := http.NewRequest("POST", frontendURL+"/api/auth/callback", bytes.NewBuffer(data))
 			req.Header.Set("Content-Type", "application/json")
 			if resp, err := client.Do(req); err != nil {
 				log.Printf("POST to frontend API failed: %v", err)
@@ -623,5 +458,8 @@ func GithubCallbackHandler(c *gin.Context) {
 		log.Printf("Failed to marshal auth payload: %v", err)
 	}
 
-	c.Redirect(http.StatusFound, frontendURL+"/")
+	if redirectTo == "" {
+		redirectTo = "/"
+	}
+	c.Redirect(http.StatusFound, frontendURL+redirectTo)
 }
@@ -0,0 +1,607 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	auth "mimbackend/internal/models/auth"
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type OAuthClientRequest struct {
+	Name         string   `json:"name" binding:"required,max=100"`
+	Description  string   `json:"description" binding:"max=1000"`
+	HomepageURL  string   `json:"homepage_url" binding:"omitempty,url,max=500"`
+	RedirectURIs []string `json:"redirect_uris" binding:"required,min=1,max=10"`
+	Scopes       []string `json:"scopes" binding:"required,min=1"`
+	IsPublic     bool     `json:"is_public"` // SPA / mobile apps without a secret; ignored on update
+}
+
+// OAuthAuthorizeDecisionRequest is the user's answer on the consent screen.
+// It repeats the parameters of the authorization request.
+type OAuthAuthorizeDecisionRequest struct {
+	ResponseType        string `json:"response_type" binding:"required"`
+	ClientID            string `json:"client_id" binding:"required"`
+	RedirectURI         string `json:"redirect_uri" binding:"required"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+// oauthClientOwnerScope parses the company ID and ensures the current user
+// owns the company. It writes the error response and returns ok=false otherwise.
+func oauthClientOwnerScope(c *gin.Context) (companyID, userID uuid.UUID, ok bool) {
+	companyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid company ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	userID, ok = currentUserID(c)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	membership, err := services.GetUserCompanyMembership(userID, companyID)
+	if err != nil || !membership.IsOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the company owner can manage OAuth clients"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return companyID, userID, true
+}
+
+// writeOAuthClientError maps client registration errors to responses
+func writeOAuthClientError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrOAuthClientNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "OAuth client not found"})
+	case errors.Is(err, services.ErrOAuthClientPublic),
+		errors.Is(err, services.ErrInvalidOAuthClientURI),
+		errors.Is(err, services.ErrInvalidTokenScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		fmt.Printf("%s: %v\n", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// writeOAuthProtocolError writes an RFC 6749 error response
+func writeOAuthProtocolError(c *gin.Context, err error) {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		fmt.Printf("OAuth server error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	c.JSON(status, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+}
+
+// ListOAuthClientsHandler lists the OAuth clients of a company
+// @Summary List OAuth clients
+// @Description Third-party apps registered by the company. Secrets are never returned (owner only)
+// @Tags Company OAuth Clients
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/oauth-clients [get]
+func ListOAuthClientsHandler(c *gin.Context) {
+	companyID, _, ok := oauthClientOwnerScope(c)
+	if !ok {
+		return
+	}
+
+	clients, err := services.ListOAuthClients(companyID)
+	if err != nil {
+		writeOAuthClientError(c, err, "Failed to list OAuth clients")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"clients": clients, "count": len(clients)})
+}
+
+// CreateOAuthClientHandler registers a third-party app
+// @Summary Create OAuth client
+// @Description Register a third-party app that can act for company members via the authorization code flow with PKCE. Scopes are resource:action pairs and limit what the app may request. The client secret of confidential clients is returned only once (owner only)
+// @Tags Company OAuth Clients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param payload body OAuthClientRequest true "Client registration"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/oauth-clients [post]
+func CreateOAuthClientHandler(c *gin.Context) {
+	companyID, userID, ok := oauthClientOwnerScope(c)
+	if !ok {
+		return
+	}
+
+	var req OAuthClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	secret, client, err := services.CreateOAuthClient(companyID, userID, services.OAuthClientInput{
+		Name:         req.Name,
+		Description:  req.Description,
+		HomepageURL:  req.HomepageURL,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		IsPublic:     req.IsPublic,
+	})
+	if err != nil {
+		writeOAuthClientError(c, err, "Failed to create OAuth client")
+		return
+	}
+
+	resp := gin.H{"client": client}
+	if secret != "" {
+		resp["client_secret"] = secret
+		resp["message"] = "Client secret yalnızca bir kez gösterilir, güvenli bir yerde saklayın"
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// UpdateOAuthClientHandler changes the registration of an OAuth client
+// @Summary Update OAuth client
+// @Description Replace name, description, redirect URIs and allowed scopes. Narrowed scopes apply to existing grants on their next refresh (owner only)
+// @Tags Company OAuth Clients
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param clientId path string true "OAuth client ID"
+// @Param payload body OAuthClientRequest true "Client registration"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/oauth-clients/{clientId} [put]
+func UpdateOAuthClientHandler(c *gin.Context) {
+	companyID, _, ok := oauthClientOwnerScope(c)
+	if !ok {
+		return
+	}
+	clientID, err := uuid.Parse(c.Param("clientId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+
+	var req OAuthClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	client, err := services.UpdateOAuthClient(companyID, clientID, services.OAuthClientInput{
+		Name:         req.Name,
+		Description:  req.Description,
+		HomepageURL:  req.HomepageURL,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+	})
+	if err != nil {
+		writeOAuthClientError(c, err, "Failed to update OAuth client")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"client": client})
+}
+
+// RotateOAuthClientSecretHandler issues a new client secret
+// @Summary Rotate OAuth client secret
+// @Description Replace the secret of a confidential client. The old secret stops working immediately; issued tokens stay valid (owner only)
+// @Tags Company OAuth Clients
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param clientId path string true "OAuth client ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/oauth-clients/{clientId}/secret [post]
+func RotateOAuthClientSecretHandler(c *gin.Context) {
+	companyID, _, ok := oauthClientOwnerScope(c)
+	if !ok {
+		return
+	}
+	clientID, err := uuid.Parse(c.Param("clientId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+
+	secret, client, err := services.RotateOAuthClientSecret(companyID, clientID)
+	if err != nil {
+		writeOAuthClientError(c, err, "Failed to rotate client secret")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"client":        client,
+		"client_secret": secret,
+		"message":       "Client secret yalnızca bir kez gösterilir, güvenli bir yerde saklayın",
+	})
+}
+
+// DeleteOAuthClientHandler revokes an OAuth client
+// @Summary Delete OAuth client
+// @Description Revoke a client; every user authorization and token of the app stops working immediately (owner only)
+// @Tags Company OAuth Clients
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param clientId path string true "OAuth client ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/oauth-clients/{clientId} [delete]
+func DeleteOAuthClientHandler(c *gin.Context) {
+	companyID, _, ok := oauthClientOwnerScope(c)
+	if !ok {
+		return
+	}
+	clientID, err := uuid.Parse(c.Param("clientId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+
+	if err := services.DeleteOAuthClient(companyID, clientID); err != nil {
+		writeOAuthClientError(c, err, "Failed to delete OAuth client")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "OAuth client deleted successfully"})
+}
+
+// OAuthAuthorizeHandler validates an authorization request for the consent screen
+// @Summary Get authorization request details
+// @Description Validate an authorization code request (PKCE S256 required) and return what the consent screen shows. previously_granted is true when the user already approved these scopes
+// @Tags OAuth Server
+// @Produce json
+// @Security BearerAuth
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string true "Registered redirect URI"
+// @Param scope query string false "Space separated scopes; defaults to all scopes of the client"
+// @Param state query string false "Opaque client state"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "Must be S256"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /oauth/authorize [get]
+func OAuthAuthorizeHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	authorization, err := services.ValidateAuthorizeRequest(uid, services.OAuthAuthorizeRequest{
+		ResponseType:        c.Query("response_type"),
+		ClientID:            c.Query("client_id"),
+		RedirectURI:         c.Query("redirect_uri"),
+		Scope:               c.Query("scope"),
+		State:               c.Query("state"),
+		CodeChallenge:       c.Query("code_challenge"),
+		CodeChallengeMethod: c.Query("code_challenge_method"),
+	})
+	if err != nil {
+		writeAuthorizeError(c, authorization, err)
+		return
+	}
+
+	granted, err := services.HasOAuthConsent(uid, authorization)
+	if err != nil {
+		writeOAuthProtocolError(c, err)
+		return
+	}
+
+	client := authorization.Client
+	c.JSON(http.StatusOK, gin.H{
+		"client": gin.H{
+			"client_id":    client.ClientID,
+			"name":         client.Name,
+			"description":  client.Description,
+			"homepage_url": client.HomepageURL,
+			"company_id":   client.CompanyID,
+		},
+		"scopes":             authorization.Scopes,
+		"redirect_uri":       authorization.RedirectURI,
+		"state":              authorization.State,
+		"previously_granted": granted,
+	})
+}
+
+// OAuthAuthorizeDecisionHandler records the user's consent decision
+// @Summary Approve or deny an authorization request
+// @Description Approve issues a single-use authorization code; the response holds the URL to send the browser to, carrying either the code or error=access_denied
+// @Tags OAuth Server
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body OAuthAuthorizeDecisionRequest true "Authorization request and decision"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /oauth/authorize [post]
+func OAuthAuthorizeDecisionHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req OAuthAuthorizeDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "Geçersiz istek verisi"})
+		return
+	}
+
+	authorization, err := services.ValidateAuthorizeRequest(uid, services.OAuthAuthorizeRequest{
+		ResponseType:        req.ResponseType,
+		ClientID:            req.ClientID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		State:               req.State,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+	})
+	if err != nil {
+		writeAuthorizeError(c, authorization, err)
+		return
+	}
+
+	if !req.Approve {
+		c.JSON(http.StatusOK, gin.H{
+			"redirect_to": services.OAuthRedirectURL(authorization.RedirectURI, url.Values{
+				"error":             {"access_denied"},
+				"error_description": {"the user denied the request"},
+				"state":             {authorization.State},
+			}),
+		})
+		return
+	}
+
+	code, err := services.ApproveOAuthAuthorization(uid, authorization)
+	if err != nil {
+		writeOAuthProtocolError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"redirect_to": services.OAuthRedirectURL(authorization.RedirectURI, url.Values{
+			"code":  {code},
+			"state": {authorization.State},
+		}),
+	})
+}
+
+// writeAuthorizeError answers an invalid authorization request. Once the
+// client and redirect URI are verified, the response also carries the URL
+// that reports the error back to the client.
+func writeAuthorizeError(c *gin.Context, authorization *services.OAuthAuthorization, err error) {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		writeOAuthProtocolError(c, err)
+		return
+	}
+
+	resp := gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description}
+	if authorization != nil {
+		resp["redirect_to"] = services.OAuthRedirectURL(authorization.RedirectURI, url.Values{
+			"error":             {oauthErr.Code},
+			"error_description": {oauthErr.Description},
+			"state":             {authorization.State},
+		})
+	}
+	c.JSON(http.StatusBadRequest, resp)
+}
+
+// OAuthTokenHandler is the token endpoint of the authorization server
+// @Summary OAuth token endpoint
+// @Description Exchange an authorization code (grant_type=authorization_code with code, redirect_uri, code_verifier) or a refresh token (grant_type=refresh_token, optional narrower scope). Clients authenticate with HTTP Basic or client_id/client_secret form fields; public clients send only client_id
+// @Tags OAuth Server
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Success 200 {object} services.OAuthTokenResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /oauth/token [post]
+func OAuthTokenHandler(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	client, ok := authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	var (
+		resp *services.OAuthTokenResponse
+		err  error
+	)
+	switch c.PostForm("grant_type") {
+	case "authorization_code":
+		sessionService, sErr := services.NewSessionService()
+		if sErr != nil {
+			writeOAuthProtocolError(c, sErr)
+			return
+		}
+		resp, err = services.ExchangeAuthorizationCode(client, c.PostForm("code"), c.PostForm("redirect_uri"),
+			c.PostForm("code_verifier"), sessionService.ExtractSecurityInfo(c))
+	case "refresh_token":
+		resp, err = services.RefreshOAuthToken(client, c.PostForm("refresh_token"), c.PostForm("scope"))
+	default:
+		err = &services.OAuthError{Code: "unsupported_grant_type", Description: "supported grant types: authorization_code, refresh_token"}
+	}
+	if err != nil {
+		writeOAuthProtocolError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// OAuthIntrospectHandler implements RFC 7662 token introspection
+// @Summary OAuth token introspection
+// @Description Report whether an access or refresh token issued to the calling client is active (RFC 7662). Only confidential clients may introspect
+// @Tags OAuth Server
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Success 200 {object} services.OAuthIntrospection
+// @Failure 401 {object} map[string]interface{}
+// @Router /oauth/introspect [post]
+func OAuthIntrospectHandler(c *gin.Context) {
+	client, ok := authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+	if client.IsPublic {
+		writeOAuthProtocolError(c, &services.OAuthError{Code: "invalid_client", Description: "public clients cannot introspect tokens"})
+		return
+	}
+
+	resp, err := services.IntrospectOAuthToken(client, c.PostForm("token"))
+	if err != nil {
+		writeOAuthProtocolError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// OAuthRevokeHandler implements RFC 7009 token revocation
+// @Summary OAuth token revocation
+// @Description Revoke an access or refresh token of the calling client; the whole grant ends. Unknown tokens are ignored (RFC 7009)
+// @Tags OAuth Server
+// @Accept x-www-form-urlencoded
+// @Success 200
+// @Failure 401 {object} map[string]interface{}
+// @Router /oauth/revoke [post]
+func OAuthRevokeHandler(c *gin.Context) {
+	client, ok := authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	if err := services.RevokeOAuthToken(client, c.PostForm("token")); err != nil {
+		writeOAuthProtocolError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// authenticateOAuthClient reads client credentials from HTTP Basic auth or the
+// form body. It writes the error response and returns false when they are invalid.
+func authenticateOAuthClient(c *gin.Context) (*auth.OAuthClient, bool) {
+	clientID, clientSecret, basic := c.Request.BasicAuth()
+	if basic {
+		// RFC 6749 2.3.1: Basic credentials are form-urlencoded
+		if id, err := url.QueryUnescape(clientID); err == nil {
+			clientID = id
+		}
+		if secret, err := url.QueryUnescape(clientSecret); err == nil {
+			clientSecret = secret
+		}
+	} else {
+		clientID = c.PostForm("client_id")
+		clientSecret = c.PostForm("client_secret")
+	}
+
+	client, err := services.AuthenticateOAuthClient(clientID, clientSecret)
+	if err != nil {
+		writeOAuthProtocolError(c, err)
+		return nil, false
+	}
+	return client, true
+}
+
+// ListOAuthAuthorizationsHandler lists the apps the current user authorized
+// @Summary List authorized apps
+// @Description Third-party apps the current user granted access to, with the granted scopes
+// @Tags OAuth Server
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /oauth/authorizations [get]
+func ListOAuthAuthorizationsHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	consents, err := services.ListOAuthConsents(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list authorizations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"authorizations": consents, "count": len(consents)})
+}
+
+// RevokeOAuthAuthorizationHandler withdraws the current user's authorization of an app
+// @Summary Revoke app authorization
+// @Description Withdraw access of a third-party app; its tokens for the current user stop working immediately
+// @Tags OAuth Server
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Authorization ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /oauth/authorizations/{id} [delete]
+func RevokeOAuthAuthorizationHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	consentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid authorization ID"})
+		return
+	}
+
+	if err := services.RevokeOAuthConsent(uid, consentID); err != nil {
+		if errors.Is(err, services.ErrOAuthConsentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Authorization not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke authorization"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Authorization revoked successfully"})
+}
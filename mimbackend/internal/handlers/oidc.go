@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListOIDCProvidersHandler lists the configured OpenID Connect providers
+// @Summary List OpenID Connect providers
+// @Description Providers configured with OIDC_PROVIDERS, for rendering login buttons
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /auth/oidc [get]
+func ListOIDCProvidersHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": services.ListOIDCProviders()})
+}
+
+// OIDCLoginHandler starts a login with a configured OpenID Connect provider
+// @Summary Start OpenID Connect login
+// @Description Redirects the user to the provider's authorization endpoint found via OIDC discovery
+// @Tags Auth
+// @Param provider path string true "Provider name"
+// @Param redirect_to query string false "Frontend path to return to after login"
+// @Success 307
+// @Failure 404 {object} map[string]interface{}
+// @Failure 502 {object} map[string]interface{}
+// @Router /auth/oidc/{provider} [get]
+func OIDCLoginHandler(c *gin.Context) {
+	startOIDCLogin(c, c.Param("provider"))
+}
+
+// OIDCCallbackHandler completes a login with an OpenID Connect provider
+// @Summary OpenID Connect callback
+// @Description Exchanges the code, validates the ID token against the provider JWKS and signs the user in
+// @Tags Auth
+// @Produce html
+// @Param provider path string true "Provider name"
+// @Param state query string true "State"
+// @Param code query string true "Code"
+// @Router /auth/oidc/{provider}/callback [get]
+func OIDCCallbackHandler(c *gin.Context) {
+	completeOIDCLogin(c, c.Param("provider"))
+}
+
+// startOIDCLogin redirects to the authorization endpoint of a registered provider
+func startOIDCLogin(c *gin.Context, name string) {
+	provider, err := services.GetOIDCProvider(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
+	state, flow, ok := beginOAuthFlow(c, "oidc:"+provider.Name(), c.Query("redirect_to"), nil)
+	if !ok {
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, flow.Nonce, flow.CodeChallenge())
+	if err != nil {
+		log.Printf("OIDC %s: %v", provider.Name(), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Login provider is unavailable"})
+		return
+	}
+
+	c.Redirect(http.StatusTemporaryRedirect, authURL)
+}
+
+// completeOIDCLogin handles the callback of a registered provider: it signs
+// the user in or links the provider to the signed-in user who started the flow
+func completeOIDCLogin(c *gin.Context, name string) {
+	provider, err := services.GetOIDCProvider(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
+	if errCode := c.Query("error"); errCode != "" {
+		log.Printf("OIDC %s: provider returned error %q: %s", provider.Name(), errCode, c.Query("error_description"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login was not completed", "provider_error": errCode})
+		return
+	}
+
+	// The state must match the cookie of this browser; it also carries the PKCE verifier and the nonce
+	flow, ok := consumeOAuthFlow(c, "oidc:"+provider.Name())
+	if !ok {
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Authorization code not provided"})
+		return
+	}
+
+	identity, token, err := provider.Authenticate(code, flow.Nonce, flow.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC %s: authentication failed: %v", provider.Name(), err)
+		switch {
+		case errors.Is(err, services.ErrOIDCEmailMissing), errors.Is(err, services.ErrOIDCEmailNotVerified):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Provider did not return a verified email address"})
+		case errors.Is(err, services.ErrOIDCInvalidIDToken):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token"})
+		default:
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to exchange code"})
+		}
+		return
+	}
+
+	// A signed-in user linking the provider gets the identity attached instead
+	if flow.LinkUserID != nil {
+		completeOAuthLink(c, flow, provider.Name(), identity.Subject, token)
+		return
+	}
+
+	user, err := services.FindOrCreateOAuthUser(provider.Name(), identity.Subject, identity.Email, identity.Name, identity.Picture)
+	if err != nil {
+		redirectOAuthLoginError(c, provider.Name(), err)
+		return
+	}
+
+	// The account keeps the provider's own tokens, not ours
+	completeOAuthLogin(c, user, provider.Name(), flow.RedirectTo, func() error {
+		return services.CreateAccount(user.ID, provider.Name(), identity.Subject, token.AccessToken, token.RefreshToken, providerTokenExpiry(token))
+	})
+}
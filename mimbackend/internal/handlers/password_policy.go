@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"mimbackend/config"
+	companymodels "mimbackend/internal/models/company"
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// writePasswordPolicyError answers with the violated password rules. It returns
+// false, without writing, when err is not a policy error.
+func writePasswordPolicyError(c *gin.Context, err error) bool {
+	var policyErr *services.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":      "Şifre, şifre politikasına uymuyor",
+		"violations": policyErr.Violations,
+	})
+	return true
+}
+
+// GetPasswordPolicyHandler returns the password policy that applies to the current user
+// @Summary Get password policy
+// @Description Global password policy tightened by the companies of the current user
+// @Tags Auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} services.PasswordPolicy
+// @Failure 401 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/password-policy [get]
+func GetPasswordPolicyHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection failed"})
+		return
+	}
+
+	policy, err := services.EffectivePasswordPolicy(db, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load password policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// UpdateCompanyPasswordPolicyHandler sets the stricter password rules of a company
+// @Summary Update company password policy
+// @Description Tighten the global password policy for members of the company (owner only). Rules can only be stricter than the global policy
+// @Tags Company
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param payload body companymodels.PasswordPolicy true "Password policy"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/password-policy [put]
+func UpdateCompanyPasswordPolicyHandler(c *gin.Context) {
+	companyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid company ID"})
+		return
+	}
+
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	membership, err := services.GetUserCompanyMembership(uid, companyID)
+	if err != nil || !membership.IsOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var policy companymodels.PasswordPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil || policy.MinLength < 0 || policy.HistorySize < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password policy"})
+		return
+	}
+
+	if err := services.UpdateCompanyPasswordPolicy(companyID, &policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Password policy updated successfully",
+		"password_policy": policy,
+		"effective":       services.DefaultPasswordPolicy().Tighten(&policy),
+	})
+}
@@ -41,7 +41,7 @@ type ForgotPasswordRequest struct {
 // ResetPasswordRequest represents the request payload for reset password
 type ResetPasswordRequest struct {
 	Token    string `json:"token" binding:"required"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 }
 
 // ForgotPassword handles POST /auth/forgot-password
@@ -104,6 +104,9 @@ func (h *PasswordResetHandler) ResetPassword(c *gin.Context) {
 
 	// Validate token and reset password
 	if err := services.ResetPassword(req.Token, req.Password); err != nil {
+		if writePasswordPolicyError(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": capitalizeFirst(err.Error())})
 		return
 	}
@@ -204,6 +207,9 @@ func ResetPasswordHandler(c *gin.Context) {
 	}
 
 	if err := services.ResetPassword(tokenStr, passStr); err != nil {
+		if writePasswordPolicyError(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CreatePersonalAccessTokenRequest struct {
+	Name          string     `json:"name" binding:"required,max=100"`
+	Scopes        []string   `json:"scopes" binding:"required,min=1"`
+	CompanyID     *uuid.UUID `json:"company_id"`
+	ExpiresInDays int        `json:"expires_in_days" binding:"min=0,max=365"` // 0 means 30 days
+}
+
+// CreatePersonalAccessTokenHandler creates a personal access token for the current user
+// @Summary Create personal access token
+// @Description Create a named, scoped API token for scripts and CI. Scopes are resource:action pairs (read, create, update, delete, write or *), e.g. companies:read. The token is returned only once
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body CreatePersonalAccessTokenRequest true "Token details"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/tokens [post]
+func CreatePersonalAccessTokenHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req CreatePersonalAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	raw, token, err := services.CreatePersonalAccessToken(uid, services.CreatePersonalAccessTokenInput{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		CompanyID: req.CompanyID,
+		ExpiresIn: time.Duration(req.ExpiresInDays) * 24 * time.Hour,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidTokenScope) || errors.Is(err, services.ErrTokenCompanyNotAllowed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		fmt.Printf("CreatePersonalAccessToken error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":   raw,
+		"details": token,
+		"message": "Token yalnızca bir kez gösterilir, güvenli bir yerde saklayın",
+	})
+}
+
+// ListPersonalAccessTokensHandler lists the personal access tokens of the current user
+// @Summary List personal access tokens
+// @Description Tokens of the current user including revoked and expired ones. Token values are never returned
+// @Tags Auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/tokens [get]
+func ListPersonalAccessTokensHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := services.ListPersonalAccessTokens(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// RevokePersonalAccessTokenHandler revokes a personal access token of the current user
+// @Summary Revoke personal access token
+// @Description Revoke a token immediately; requests using it are rejected afterwards
+// @Tags Auth
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Token ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/tokens/{id} [delete]
+func RevokePersonalAccessTokenHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	tokenID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	if err := services.RevokePersonalAccessToken(uid, tokenID); err != nil {
+		if errors.Is(err, services.ErrPersonalAccessTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
+}
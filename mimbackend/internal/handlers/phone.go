@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mimbackend/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PhoneSendCodeRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+type PhoneVerifyRequest struct {
+	Phone string `json:"phone" binding:"required"`
+	Code  string `json:"code" binding:"required,len=6"`
+}
+
+// PhoneSendVerificationHandler sends a verification code to a new phone number
+// @Summary Send phone verification code
+// @Description Send an SMS code to confirm the current user's phone number
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body PhoneSendCodeRequest true "Phone number"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Router /auth/phone/verify/send [post]
+func PhoneSendVerificationHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req PhoneSendCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	otpService, err := services.NewPhoneOTPService()
+	if err != nil {
+		fmt.Printf("NewPhoneOTPService error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize SMS service"})
+		return
+	}
+
+	expiresAt, err := otpService.SendVerificationCode(uid, req.Phone, c.ClientIP())
+	if err != nil {
+		writePhoneOTPError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Doğrulama kodu gönderildi",
+		"expires_at": expiresAt,
+	})
+}
+
+// PhoneVerifyHandler confirms the phone number with the SMS code
+// @Summary Verify phone number
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body PhoneVerifyRequest true "Phone and code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/phone/verify [post]
+func PhoneVerifyHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req PhoneVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	otpService, err := services.NewPhoneOTPService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize SMS service"})
+		return
+	}
+
+	if err := otpService.VerifyPhone(uid, req.Phone, req.Code); err != nil {
+		writePhoneOTPError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Telefon numarası doğrulandı"})
+}
+
+// PhoneLoginSendHandler sends a login code to a verified phone number
+// @Summary Send phone login code
+// @Description Send an SMS login code. The response is the same for unregistered numbers
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body PhoneSendCodeRequest true "Phone number"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Router /auth/phone/login/send [post]
+func PhoneLoginSendHandler(c *gin.Context) {
+	var req PhoneSendCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	otpService, err := services.NewPhoneOTPService()
+	if err != nil {
+		fmt.Printf("NewPhoneOTPService error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize SMS service"})
+		return
+	}
+
+	expiresAt, err := otpService.SendLoginCode(req.Phone, c.ClientIP())
+	if err != nil {
+		writePhoneOTPError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Numara kayıtlıysa giriş kodu gönderildi",
+		"expires_at": expiresAt,
+	})
+}
+
+// PhoneLoginHandler logs in with phone number and SMS code
+// @Summary Login with phone code
+// @Description Exchange an SMS login code for a session. Users with two-factor authentication get an MFA challenge like /auth/login
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body PhoneVerifyRequest true "Phone and code"
+// @Success 200 {object} AuthResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/phone/login [post]
+func PhoneLoginHandler(c *gin.Context) {
+	var req PhoneVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	otpService, err := services.NewPhoneOTPService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize SMS service"})
+		return
+	}
+
+	user, err := otpService.VerifyLoginCode(req.Phone, req.Code)
+	if err != nil {
+		writePhoneOTPError(c, err)
+		return
+	}
+
+	beginLogin(c, user, "sms_otp")
+}
+
+// writePhoneOTPError maps PhoneOTPService errors to responses
+func writePhoneOTPError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidPhoneNumber):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz telefon numarası"})
+	case errors.Is(err, services.ErrPhoneInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": "Bu telefon numarası başka bir hesapta kayıtlı"})
+	case errors.Is(err, services.ErrOTPRateLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Çok fazla kod istendi, lütfen daha sonra tekrar deneyin"})
+	case errors.Is(err, services.ErrOTPInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Kod geçersiz veya süresi dolmuş"})
+	default:
+		fmt.Printf("Phone OTP error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "SMS doğrulama işlemi başarısız"})
+	}
+}
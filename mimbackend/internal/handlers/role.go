@@ -1622,6 +1622,13 @@ func AssignRoleToUser(c *gin.Context) {
 
 	fmt.Printf("AssignRoleToUser: After commit, user.Role=%s, user.RoleID=%s\n", user.Role, user.RoleID.String())
 
+	// Access tokens carry the role; force active sessions to refresh
+	if sessionService, err := services.NewSessionService(); err == nil {
+		if err := sessionService.BumpTokenVersion(user.ID); err != nil {
+			fmt.Printf("AssignRoleToUser: BumpTokenVersion error: %v\n", err)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Role assigned successfully",
 		"user": gin.H{
@@ -1704,6 +1711,13 @@ func RemoveRoleFromUser(c *gin.Context) {
 		return
 	}
 
+	// Access tokens carry the role; force active sessions to refresh
+	if sessionService, err := services.NewSessionService(); err == nil {
+		if err := sessionService.BumpTokenVersion(user.ID); err != nil {
+			fmt.Printf("RemoveRoleFromUser: BumpTokenVersion error: %v\n", err)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Role removed from user successfully",
 		"user": gin.H{
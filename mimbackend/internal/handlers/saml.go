@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	auth "mimbackend/internal/models/auth"
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// samlStateCookie binds a pending SAML login to the browser that started it
+const samlStateCookie = "saml_state"
+
+type SAMLConfigRequest struct {
+	IDPMetadata    *string           `json:"idp_metadata"` // IdP metadata XML
+	Enabled        *bool             `json:"enabled"`
+	EnforceSSO     *bool             `json:"enforce_sso"`
+	EmailAttribute *string           `json:"email_attribute" binding:"omitempty,max=255"`
+	NameAttribute  *string           `json:"name_attribute" binding:"omitempty,max=255"`
+	RoleAttribute  *string           `json:"role_attribute" binding:"omitempty,max=255"`
+	RoleMapping    map[string]string `json:"role_mapping"` // IdP value -> company role name
+	DefaultRole    *string           `json:"default_role" binding:"omitempty,max=100"`
+	AllowedDomains []string          `json:"allowed_domains"`
+}
+
+// samlOwnerScope parses the company ID and ensures the current user owns the
+// company. It writes the error response and returns ok=false otherwise.
+func samlOwnerScope(c *gin.Context) (uuid.UUID, bool) {
+	companyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid company ID"})
+		return uuid.Nil, false
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	membership, err := services.GetUserCompanyMembership(userID, companyID)
+	if err != nil || !membership.IsOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the company owner can manage single sign-on"})
+		return uuid.Nil, false
+	}
+	return companyID, true
+}
+
+// samlConfigResponse adds the service provider URLs the IdP administrator needs
+func samlConfigResponse(companyID uuid.UUID, cfg interface{}) gin.H {
+	return gin.H{
+		"config":       cfg,
+		"entity_id":    services.SAMLMetadataURL(companyID),
+		"metadata_url": services.SAMLMetadataURL(companyID),
+		"acs_url":      services.SAMLACSURL(companyID),
+		"login_url":    services.SAMLLoginURL(companyID),
+	}
+}
+
+// GetSAMLConfigHandler returns the SAML configuration of a company
+// @Summary Get SAML configuration
+// @Description Single sign-on settings of the company and the service provider URLs to register at the identity provider (owner only)
+// @Tags Company SSO
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /company/{id}/saml [get]
+func GetSAMLConfigHandler(c *gin.Context) {
+	companyID, ok := samlOwnerScope(c)
+	if !ok {
+		return
+	}
+
+	cfg, err := services.GetSAMLConfig(companyID)
+	if err != nil {
+		writeSAMLConfigError(c, err, "Failed to load SAML configuration")
+		return
+	}
+
+	c.JSON(http.StatusOK, samlConfigResponse(companyID, cfg))
+}
+
+// UpdateSAMLConfigHandler creates or updates the SAML configuration of a company
+// @Summary Configure SAML single sign-on
+// @Description Uploads the IdP metadata and sets the attribute mapping, role mapping, allowed email domains and enforcement. Enterprise plan only (owner only)
+// @Tags Company SSO
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param payload body SAMLConfigRequest true "SAML configuration"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 402 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /company/{id}/saml [put]
+func UpdateSAMLConfigHandler(c *gin.Context) {
+	companyID, ok := samlOwnerScope(c)
+	if !ok {
+		return
+	}
+
+	var req SAMLConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	cfg, err := services.SaveSAMLConfig(companyID, services.SAMLConfigUpdate{
+		IDPMetadata:    req.IDPMetadata,
+		Enabled:        req.Enabled,
+		EnforceSSO:     req.EnforceSSO,
+		EmailAttribute: req.EmailAttribute,
+		NameAttribute:  req.NameAttribute,
+		RoleAttribute:  req.RoleAttribute,
+		RoleMapping:    req.RoleMapping,
+		DefaultRole:    req.DefaultRole,
+		AllowedDomains: req.AllowedDomains,
+	})
+	if err != nil {
+		writeSAMLConfigError(c, err, "Failed to save SAML configuration")
+		return
+	}
+
+	c.JSON(http.StatusOK, samlConfigResponse(companyID, cfg))
+}
+
+// DeleteSAMLConfigHandler removes the SAML configuration of a company
+// @Summary Remove SAML configuration
+// @Description Members signed up through SAML keep their accounts but cannot sign in with it anymore (owner only)
+// @Tags Company SSO
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /company/{id}/saml [delete]
+func DeleteSAMLConfigHandler(c *gin.Context) {
+	companyID, ok := samlOwnerScope(c)
+	if !ok {
+		return
+	}
+
+	if err := services.DeleteSAMLConfig(companyID); err != nil {
+		writeSAMLConfigError(c, err, "Failed to remove SAML configuration")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "SAML configuration removed"})
+}
+
+// writeSAMLConfigError maps SAML configuration errors to responses
+func writeSAMLConfigError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrSAMLNotConfigured):
+		c.JSON(http.StatusNotFound, gin.H{"error": "SAML is not configured for this company"})
+	case errors.Is(err, services.ErrSAMLPlanRequired):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "SAML single sign-on is available on the enterprise plan"})
+	case errors.Is(err, services.ErrSAMLInvalidMetadata),
+		errors.Is(err, services.ErrSAMLDomainsRequired),
+		errors.Is(err, services.ErrSAMLInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("%s: %v", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// SAMLMetadataHandler serves the service provider metadata of a company
+// @Summary SAML service provider metadata
+// @Description Metadata to upload at the identity provider; the URL is also the service provider entity ID
+// @Tags Auth
+// @Produce xml
+// @Param company path string true "Company ID"
+// @Success 200 {string} string
+// @Failure 404 {object} map[string]interface{}
+// @Router /auth/saml/{company}/metadata [get]
+func SAMLMetadataHandler(c *gin.Context) {
+	companyID, err := uuid.Parse(c.Param("company"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SAML is not configured for this company"})
+		return
+	}
+
+	metadata, err := services.SAMLMetadata(companyID)
+	if err != nil {
+		writeSAMLConfigError(c, err, "Failed to build SAML metadata")
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// SAMLLoginHandler starts a SAML login
+// @Summary Start SAML single sign-on
+// @Description Redirects the user to the company's identity provider with an AuthnRequest
+// @Tags Auth
+// @Param company path string true "Company ID"
+// @Param redirect_to query string false "Frontend path to return to after login"
+// @Success 307
+// @Failure 404 {object} map[string]interface{}
+// @Router /auth/saml/{company}/login [get]
+func SAMLLoginHandler(c *gin.Context) {
+	companyID, err := uuid.Parse(c.Param("company"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SAML is not configured for this company"})
+		return
+	}
+
+	authURL, ok := beginSAMLFlow(c, companyID, c.Query("redirect_to"), nil)
+	if !ok {
+		return
+	}
+	c.Redirect(http.StatusTemporaryRedirect, authURL)
+}
+
+// beginSAMLFlow stores the state of a SAML login or link, sets the saml_state
+// cookie and returns the identity provider URL. It writes the error response
+// itself.
+func beginSAMLFlow(c *gin.Context, companyID uuid.UUID, redirectTo string, linkUserID *uuid.UUID) (string, bool) {
+	state, flow, err := services.NewOAuthState(services.SAMLProvider(companyID), redirectTo, linkUserID)
+	if errors.Is(err, services.ErrInvalidRedirectTo) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid redirect_to"})
+		return "", false
+	}
+	if err != nil {
+		log.Printf("NewOAuthState saml %s: %v", companyID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return "", false
+	}
+
+	authURL, err := services.SAMLAuthnRequestURL(companyID, samlRequestID(flow), state)
+	if err != nil {
+		writeSAMLConfigError(c, err, "Failed to start SAML login")
+		return "", false
+	}
+
+	// The identity provider posts back cross-site, which SameSite=Lax cookies
+	// do not survive; None requires Secure, so plain HTTP setups keep Lax
+	cookieSettings := services.AuthCookieConfig()
+	sameSite := http.SameSiteLaxMode
+	if cookieSettings.Secure {
+		sameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     samlStateCookie,
+		Value:    state,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cookieSettings.Secure,
+		SameSite: sameSite,
+		Expires:  time.Now().Add(services.OAuthStateTTL),
+	})
+	return authURL, true
+}
+
+// SAMLACSHandler is the assertion consumer service of a company
+// @Summary SAML assertion consumer service
+// @Description Verifies the SAMLResponse posted by the identity provider, creates the user and company membership just in time and signs the user in, or links the identity provider to the user who started the flow from /user/accounts/link
+// @Tags Auth
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Param company path string true "Company ID"
+// @Param SAMLResponse formData string true "Base64 encoded SAML response"
+// @Param RelayState formData string true "State returned by the identity provider"
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /auth/saml/{company}/acs [post]
+func SAMLACSHandler(c *gin.Context) {
+	companyID, err := uuid.Parse(c.Param("company"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SAML is not configured for this company"})
+		return
+	}
+	provider := services.SAMLProvider(companyID)
+
+	// Logins must be started here; the RelayState has to match this browser's cookie
+	state := c.PostForm("RelayState")
+	cookie, err := c.Request.Cookie(samlStateCookie)
+	http.SetCookie(c.Writer, &http.Cookie{Name: samlStateCookie, Value: "", Path: "/", Expires: time.Unix(0, 0)})
+	if err != nil || cookie.Value == "" || cookie.Value != state {
+		log.Printf("SAML ACS for %s: RelayState does not match the saml_state cookie", companyID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+		return
+	}
+	flow, err := services.ConsumeOAuthState(state, provider)
+	if err != nil {
+		log.Printf("SAML ACS for %s: %v", companyID, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+		return
+	}
+
+	cfg, identity, err := services.AuthenticateSAMLResponse(companyID, c.Request, samlRequestID(flow))
+	if err != nil {
+		log.Printf("SAML ACS for %s: %v", companyID, err)
+		switch {
+		case errors.Is(err, services.ErrSAMLInvalidResponse):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid SAML response"})
+		case errors.Is(err, services.ErrSAMLEmailMissing):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Identity provider did not return an email address"})
+		case errors.Is(err, services.ErrSAMLDomainNotAllowed):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email domain is not allowed for this company"})
+		default:
+			writeSAMLConfigError(c, err, "Failed to complete SAML login")
+		}
+		return
+	}
+
+	// A signed-in user linking the company's identity provider
+	if flow.LinkUserID != nil {
+		completeOAuthLink(c, flow, provider, identity.NameID, &services.OAuthToken{})
+		return
+	}
+
+	user, err := services.FindOrCreateSAMLUser(cfg, identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSAMLAccountExists):
+			query := url.Values{"error": {"account_link_required"}, "provider": {provider}}
+			c.Redirect(http.StatusFound, oauthFrontendURL()+"/auth/login?"+query.Encode())
+		case errors.Is(err, services.ErrSAMLServiceAccountUser):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			log.Printf("FindOrCreateSAMLUser %s: %v", companyID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		}
+		return
+	}
+
+	// The SAML account was written by FindOrCreateSAMLUser. The session records
+	// the company's provider name so enforce_sso knows which company it satisfies.
+	completeExternalLogin(c, user, provider, flow.RedirectTo, func() error { return nil })
+}
+
+// ssoRequiredCompany returns the company whose enforce_sso setting refuses a
+// login made with loginMethod, or uuid.Nil. A login whose first factor was
+// SAML only satisfies the company whose identity provider answered it.
+func ssoRequiredCompany(user *auth.User, loginMethod string) (uuid.UUID, error) {
+	firstFactor, _, _ := strings.Cut(loginMethod, "+")
+	samlCompanyID, _ := services.SAMLProviderCompany(firstFactor)
+	return services.SSORequiredCompany(user.ID, samlCompanyID)
+}
+
+// enforceSSO answers logins of users whose company requires single sign-on.
+// It returns false when the login must not go on.
+func enforceSSO(c *gin.Context, user *auth.User, loginMethod string) bool {
+	companyID, err := ssoRequiredCompany(user, loginMethod)
+	if err != nil {
+		log.Printf("SSORequiredCompany %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check single sign-on policy"})
+		return false
+	}
+	if companyID != uuid.Nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      "Şirketiniz tek oturum açma (SSO) kullanılmasını zorunlu kılıyor",
+			"code":       "sso_required",
+			"company_id": companyID,
+			"sso_url":    services.SAMLLoginURL(companyID),
+		})
+		return false
+	}
+	return true
+}
+
+// samlRequestID derives the AuthnRequest ID from the stored state, so the
+// response can be matched to the request without keeping the ID separately
+func samlRequestID(flow *services.OAuthState) string {
+	return "id-" + flow.Nonce
+}
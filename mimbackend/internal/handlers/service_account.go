@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	companymodels "mimbackend/internal/models/company"
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CreateServiceAccountRequest struct {
+	Name   string    `json:"name" binding:"required,max=100"`
+	RoleID uuid.UUID `json:"role_id" binding:"required"`
+}
+
+type UpdateServiceAccountRequest struct {
+	Name     *string    `json:"name" binding:"omitempty,max=100"`
+	RoleID   *uuid.UUID `json:"role_id"`
+	IsActive *bool      `json:"is_active"`
+}
+
+type CreateServiceAccountSecretRequest struct {
+	Name string `json:"name" binding:"max=100"`
+}
+
+type RotateServiceAccountSecretRequest struct {
+	Name       string `json:"name" binding:"max=100"`
+	GraceHours *int   `json:"grace_hours" binding:"omitempty,min=0,max=720"` // Default 24
+}
+
+// serviceAccountOwnerScope parses the company ID and ensures the current user
+// owns the company. It writes the error response and returns ok=false otherwise.
+func serviceAccountOwnerScope(c *gin.Context) (companyID, userID uuid.UUID, ok bool) {
+	companyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid company ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	userID, ok = currentUserID(c)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	membership, err := services.GetUserCompanyMembership(userID, companyID)
+	if err != nil || !membership.IsOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the company owner can manage service accounts"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return companyID, userID, true
+}
+
+// writeServiceAccountError maps service account errors to responses
+func writeServiceAccountError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrServiceAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+	case errors.Is(err, services.ErrServiceAccountSecretNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+	case errors.Is(err, services.ErrServiceAccountRoleNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		fmt.Printf("%s: %v\n", fallback, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+func serviceAccountResponse(m *companymodels.CompanyMember) gin.H {
+	resp := gin.H{
+		"id":         m.ID,
+		"user_id":    m.UserID,
+		"company_id": m.CompanyID,
+		"role_id":    m.RoleID,
+		"is_active":  m.IsActive,
+		"status":     m.Status,
+		"created_at": m.CreatedAt,
+	}
+	if m.User != nil {
+		resp["name"] = m.User.FullName
+	}
+	if m.Role != nil {
+		resp["role"] = gin.H{"id": m.Role.ID, "name": m.Role.Name}
+	}
+	return resp
+}
+
+// ListServiceAccountsHandler lists the service accounts of a company
+// @Summary List service accounts
+// @Description Non-human company members used by integrations (owner only)
+// @Tags Company Service Accounts
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/service-accounts [get]
+func ListServiceAccountsHandler(c *gin.Context) {
+	companyID, _, ok := serviceAccountOwnerScope(c)
+	if !ok {
+		return
+	}
+
+	accounts, err := services.ListServiceAccounts(companyID)
+	if err != nil {
+		writeServiceAccountError(c, err, "Failed to list service accounts")
+		return
+	}
+
+	resp := make([]gin.H, 0, len(accounts))
+	for i := range accounts {
+		resp = append(resp, serviceAccountResponse(&accounts[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"service_accounts": resp, "count": len(resp)})
+}
+
+// CreateServiceAccountHandler creates a service account with a company role
+// @Summary Create service account
+// @Description Create a non-login company member for an integration. Give it a secret afterwards with POST /company/{id}/service-accounts/{serviceAccountId}/secrets (owner only)
+// @Tags Company Service Accounts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param payload body CreateServiceAccountRequest true "Service account"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/service-accounts [post]
+func CreateServiceAccountHandler(c *gin.Context) {
+	companyID, userID, ok := serviceAccountOwnerScope(c)
+	if !ok {
+		return
+	}
+
+	var req CreateServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	account, err := services.CreateServiceAccount(companyID, userID, req.Name, req.RoleID)
+	if err != nil {
+		writeServiceAccountError(c, err, "Failed to create service account")
+		return
+	}
+
+	c.JSON(http.StatusCreated, serviceAccountResponse(account))
+}
+
+// UpdateServiceAccountHandler renames, re-roles or (de)activates a service account
+// @Summary Update service account
+// @Description Change the name, company role or active state of a service account (owner only)
+// @Tags Company Service Accounts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param serviceAccountId path string true "Service account ID"
+// @Param payload body UpdateServiceAccountRequest true "Changes"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/service-accounts/{serviceAccountId} [put]
+func UpdateServiceAccountHandler(c *gin.Context) {
+	companyID, _, ok := serviceAccountOwnerScope(c)
+	if !ok {
+		return
+	}
+	accountID, err := uuid.Parse(c.Param("serviceAccountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return
+	}
+
+	var req UpdateServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	account, err := services.UpdateServiceAccount(companyID, accountID, services.ServiceAccountUpdate{
+		Name:     req.Name,
+		RoleID:   req.RoleID,
+		IsActive: req.IsActive,
+	})
+	if err != nil {
+		writeServiceAccountError(c, err, "Failed to update service account")
+		return
+	}
+
+	c.JSON(http.StatusOK, serviceAccountResponse(account))
+}
+
+// DeleteServiceAccountHandler deletes a service account and revokes its secrets
+// @Summary Delete service account
+// @Description Remove a service account from the company; all of its secrets stop working immediately (owner only)
+// @Tags Company Service Accounts
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param serviceAccountId path string true "Service account ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/service-accounts/{serviceAccountId} [delete]
+func DeleteServiceAccountHandler(c *gin.Context) {
+	companyID, userID, ok := serviceAccountOwnerScope(c)
+	if !ok {
+		return
+	}
+	accountID, err := uuid.Parse(c.Param("serviceAccountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return
+	}
+
+	if err := services.DeleteServiceAccount(companyID, accountID, userID); err != nil {
+		writeServiceAccountError(c, err, "Failed to delete service account")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Service account deleted successfully"})
+}
+
+// ListServiceAccountSecretsHandler lists the secrets of a service account
+// @Summary List service account secrets
+// @Description Secrets of a service account including expired and revoked ones. Secret values are never returned (owner only)
+// @Tags Company Service Accounts
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param serviceAccountId path string true "Service account ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/service-accounts/{serviceAccountId}/secrets [get]
+func ListServiceAccountSecretsHandler(c *gin.Context) {
+	companyID, _, ok := serviceAccountOwnerScope(c)
+	if !ok {
+		return
+	}
+	accountID, err := uuid.Parse(c.Param("serviceAccountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return
+	}
+
+	secrets, err := services.ListServiceAccountSecrets(companyID, accountID)
+	if err != nil {
+		writeServiceAccountError(c, err, "Failed to list secrets")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"secrets": secrets})
+}
+
+// CreateServiceAccountSecretHandler issues an additional secret
+// @Summary Create service account secret
+// @Description Issue a new API secret; existing secrets keep working. The secret is returned only once (owner only)
+// @Tags Company Service Accounts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param serviceAccountId path string true "Service account ID"
+// @Param payload body CreateServiceAccountSecretRequest false "Secret label"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/service-accounts/{serviceAccountId}/secrets [post]
+func CreateServiceAccountSecretHandler(c *gin.Context) {
+	companyID, userID, ok := serviceAccountOwnerScope(c)
+	if !ok {
+		return
+	}
+	accountID, err := uuid.Parse(c.Param("serviceAccountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return
+	}
+
+	var req CreateServiceAccountSecretRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+			return
+		}
+	}
+
+	raw, secret, err := services.CreateServiceAccountSecret(companyID, accountID, userID, req.Name)
+	if err != nil {
+		writeServiceAccountError(c, err, "Failed to create secret")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"secret":  raw,
+		"details": secret,
+		"message": "Secret yalnızca bir kez gösterilir, güvenli bir yerde saklayın",
+	})
+}
+
+// RotateServiceAccountSecretHandler issues a new secret and phases out the old ones
+// @Summary Rotate service account secret
+// @Description Issue a new secret; every other active secret expires after grace_hours (default 24, 0 revokes them at once). The secret is returned only once (owner only)
+// @Tags Company Service Accounts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param serviceAccountId path string true "Service account ID"
+// @Param payload body RotateServiceAccountSecretRequest false "Rotation options"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/service-accounts/{serviceAccountId}/secrets/rotate [post]
+func RotateServiceAccountSecretHandler(c *gin.Context) {
+	companyID, userID, ok := serviceAccountOwnerScope(c)
+	if !ok {
+		return
+	}
+	accountID, err := uuid.Parse(c.Param("serviceAccountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return
+	}
+
+	var req RotateServiceAccountSecretRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+			return
+		}
+	}
+	grace := services.DefaultSecretRotationGrace
+	if req.GraceHours != nil {
+		grace = time.Duration(*req.GraceHours) * time.Hour
+	}
+
+	raw, secret, err := services.RotateServiceAccountSecret(companyID, accountID, userID, req.Name, grace)
+	if err != nil {
+		writeServiceAccountError(c, err, "Failed to rotate secret")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"secret":            raw,
+		"details":           secret,
+		"old_secrets_until": time.Now().Add(grace),
+		"message":           "Secret yalnızca bir kez gösterilir, güvenli bir yerde saklayın",
+	})
+}
+
+// RevokeServiceAccountSecretHandler revokes one secret
+// @Summary Revoke service account secret
+// @Description Revoke a secret immediately (owner only)
+// @Tags Company Service Accounts
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param serviceAccountId path string true "Service account ID"
+// @Param secretId path string true "Secret ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/service-accounts/{serviceAccountId}/secrets/{secretId} [delete]
+func RevokeServiceAccountSecretHandler(c *gin.Context) {
+	companyID, _, ok := serviceAccountOwnerScope(c)
+	if !ok {
+		return
+	}
+	accountID, err := uuid.Parse(c.Param("serviceAccountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return
+	}
+	secretID, err := uuid.Parse(c.Param("secretId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret ID"})
+		return
+	}
+
+	if err := services.RevokeServiceAccountSecret(companyID, accountID, secretID); err != nil {
+		writeServiceAccountError(c, err, "Failed to revoke secret")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Secret revoked successfully"})
+}
+
+// GetServiceAccountActivityHandler returns the latest API calls of a service account
+// @Summary Service account activity
+// @Description Latest API calls made with the secrets of a service account, kept apart from user sessions (owner only)
+// @Tags Company Service Accounts
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Company ID"
+// @Param serviceAccountId path string true "Service account ID"
+// @Param limit query int false "Number of entries (default 50, max 500)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /company/{id}/service-accounts/{serviceAccountId}/activity [get]
+func GetServiceAccountActivityHandler(c *gin.Context) {
+	companyID, _, ok := serviceAccountOwnerScope(c)
+	if !ok {
+		return
+	}
+	accountID, err := uuid.Parse(c.Param("serviceAccountId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service account ID"})
+		return
+	}
+
+	limit := 50
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, 500)
+	}
+
+	activity, err := services.ListServiceAccountActivity(companyID, accountID, limit)
+	if err != nil {
+		writeServiceAccountError(c, err, "Failed to get service account activity")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"activity": activity, "count": len(activity)})
+}
@@ -54,7 +54,7 @@ func GetUserSessionsHandler(c *gin.Context) {
 
 // GetUserSessionHistoryHandler returns session history for the authenticated user
 // @Summary Get user session history
-// @Description Get session history for the current user
+// @Description Get session history and failed login attempts for the current user
 // @Tags Sessions
 // @Accept json
 // @Produce json
@@ -96,9 +96,21 @@ func GetUserSessionHistoryHandler(c *gin.Context) {
 		return
 	}
 
+	guard, err := services.NewLoginGuard()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize login guard"})
+		return
+	}
+	failedLogins, err := guard.FailedAttempts(uid, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get failed logins"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"sessions": sessions,
-		"count":    len(sessions),
+		"sessions":      sessions,
+		"count":         len(sessions),
+		"failed_logins": failedLogins,
 	})
 }
 
@@ -239,3 +251,84 @@ func MarkSessionSuspiciousHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Session marked as suspicious"})
 }
+
+// AdminRevokeUserSessionsHandler revokes every session of a user (admin only)
+// @Summary Revoke all sessions of a user
+// @Description Log a user out everywhere; their access tokens stop working on the next request (admin only)
+// @Tags Sessions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param userId path string true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /users/{userId}/sessions [delete]
+func AdminRevokeUserSessionsHandler(c *gin.Context) {
+	uid, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	sessionService, err := services.NewSessionService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session service"})
+		return
+	}
+
+	if err := sessionService.LogoutAllUserSessions(uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	fmt.Printf("🔒 All sessions of user %s revoked by admin\n", uid)
+
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions revoked successfully"})
+}
+
+// AdminRevokeUserSessionHandler revokes a specific session of a user (admin only)
+// @Summary Revoke a session of a user
+// @Description Revoke a specific session of a user by session ID (admin only)
+// @Tags Sessions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param userId path string true "User ID"
+// @Param session_id path string true "Session ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /users/{userId}/sessions/{session_id} [delete]
+func AdminRevokeUserSessionHandler(c *gin.Context) {
+	uid, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	sessionID := c.Param("session_id")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Session ID is required"})
+		return
+	}
+
+	sessionService, err := services.NewSessionService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize session service"})
+		return
+	}
+
+	if err := sessionService.RevokeSession(sessionID, uid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	fmt.Printf("🔒 Session %s of user %s revoked by admin\n", sessionID, uid)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"` // Required for accounts with a password
+	Code     string `json:"code"`     // TOTP or recovery code, alternative to the password
+	// Mode is "anonymize" (default) or "delete"
+	Mode string `json:"mode" binding:"omitempty,oneof=anonymize delete"`
+	// OwnershipTransfers hands over companies the user is the sole owner of
+	OwnershipTransfers []services.OwnershipTransfer `json:"ownership_transfers" binding:"omitempty,dive"`
+}
+
+// RequestUserDataExportHandler starts a personal data export of the current user
+// @Summary Export my data
+// @Description Builds a zip archive of everything tied to the current user (profile, linked accounts, session history, memberships, invitations, permissions) and emails a download link valid for 24 hours
+// @Tags User
+// @Produce json
+// @Security BearerAuth
+// @Success 202 {object} map[string]string
+// @Failure 401 {object} map[string]interface{}
+// @Router /user/data-export [post]
+func RequestUserDataExportHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	go func() {
+		if _, err := services.StartUserDataExport(uid); err != nil {
+			log.Printf("user data export failed for %s: %v", uid, err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Dışa aktarma başlatıldı; hazır olduğunda indirme bağlantısı email adresinize gönderilecek"})
+}
+
+// DownloadUserDataExportHandler returns a personal data export by token
+// @Summary Download my data export
+// @Description Returns the zip archive from the emailed link. Only the user who requested it can download it
+// @Tags User
+// @Produce application/zip
+// @Security BearerAuth
+// @Param token query string true "Export token"
+// @Success 200 {file} file
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /user/data-export/download [get]
+func DownloadUserDataExportHandler(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	rec, ok := services.GetExport(token)
+	if !ok || rec.CompanyID != uuid.Nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "export not found or expired"})
+		return
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if userID != rec.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", rec.Filename))
+	c.Data(http.StatusOK, "application/zip", rec.Data)
+}
+
+// GetAccountDeletionHandler returns the scheduled deletion of the current user
+// @Summary Get scheduled account deletion
+// @Tags User
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /user/delete-account [get]
+func GetAccountDeletionHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	request, err := services.GetAccountDeletion(uid)
+	if err != nil {
+		writeAccountDeletionError(c, err, "Failed to load account deletion")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deletion": request})
+}
+
+// RequestAccountDeletionHandler schedules the deletion of the current user's account
+// @Summary Delete my account
+// @Description Re-authenticates the user and schedules the account for deletion after the grace period (ACCOUNT_DELETION_GRACE_DAYS, default 30). All sessions are ended; signing in again and cancelling stops the deletion. Companies the user is the sole owner of must be handed over with ownership_transfers, otherwise the request is refused with 409 and the list of companies
+// @Tags User
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body DeleteAccountRequest true "Deletion payload"
+// @Success 202 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 423 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /user/delete-account [post]
+func RequestAccountDeletionHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	user, err := services.GetUserByID(uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.IsServiceAccount {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Service accounts are removed by the company owner"})
+		return
+	}
+
+	if !verifyReauthentication(c, user, req.Password, req.Code) {
+		return
+	}
+
+	request, err := services.RequestAccountDeletion(user, req.Mode, req.OwnershipTransfers)
+	if err != nil {
+		writeAccountDeletionError(c, err, "Hesap silme talebi oluşturulamadı")
+		return
+	}
+
+	revokeAllUserSessions(uid)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Hesabınız silinmek üzere planlandı",
+		"deletion": request,
+	})
+}
+
+// CancelAccountDeletionHandler cancels the scheduled deletion of the current user
+// @Summary Cancel account deletion
+// @Tags User
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /user/delete-account [delete]
+func CancelAccountDeletionHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := services.CancelAccountDeletion(uid); err != nil {
+		writeAccountDeletionError(c, err, "Failed to cancel account deletion")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Hesap silme talebi iptal edildi"})
+}
+
+func writeAccountDeletionError(c *gin.Context, err error, fallback string) {
+	var soleOwner *services.SoleOwnershipError
+	switch {
+	case errors.As(err, &soleOwner):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "Tek sahibi olduğunuz şirketlerin sahipliğini devretmeniz gerekiyor",
+			"code":      "ownership_transfer_required",
+			"companies": soleOwner.Companies,
+		})
+	case errors.Is(err, services.ErrInvalidOwnershipTransfer):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Yeni sahip şirketin aktif bir üyesi olmalı"})
+	case errors.Is(err, services.ErrInvalidDeletionMode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz silme modu"})
+	case errors.Is(err, services.ErrAccountDeletionPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "Hesap silme talebi zaten mevcut"})
+	case errors.Is(err, services.ErrAccountDeletionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Planlanmış bir hesap silme talebi yok"})
+	default:
+		log.Printf("account deletion: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
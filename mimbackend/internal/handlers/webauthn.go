@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mimbackend/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// WebAuthnReauthRequest confirms passkey changes. Accounts with neither a
+// password nor two-factor authentication send an empty body and need a
+// session started in the last 10 minutes.
+type WebAuthnReauthRequest struct {
+	Password string `json:"password"` // Required for accounts with a password
+	Code     string `json:"code"`     // TOTP or recovery code, alternative to the password
+}
+
+type WebAuthnFinishRequest struct {
+	CeremonyID string          `json:"ceremony_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"` // PublicKeyCredential from navigator.credentials
+}
+
+type WebAuthnRegisterFinishRequest struct {
+	CeremonyID string          `json:"ceremony_id" binding:"required"`
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+type WebAuthnMFABeginRequest struct {
+	MFAToken string `json:"mfa_token"` // Falls back to the mfa_token cookie of redirected logins
+}
+
+type WebAuthnMFAFinishRequest struct {
+	MFAToken   string          `json:"mfa_token"` // Falls back to the mfa_token cookie of redirected logins
+	CeremonyID string          `json:"ceremony_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+// WebAuthnRegisterBeginHandler starts passkey registration
+// @Summary Begin passkey registration
+// @Description Re-authenticates the user (password, TOTP/recovery code, or a sign-in from the last 10 minutes for accounts with neither) and returns the options for navigator.credentials.create() and a ceremony ID for /auth/webauthn/register/finish
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body WebAuthnReauthRequest false "Re-authentication"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 423 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Router /auth/webauthn/register/begin [post]
+func WebAuthnRegisterBeginHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	req, ok := bindWebAuthnReauth(c)
+	if !ok {
+		return
+	}
+
+	user, err := services.GetUserByID(uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !verifyReauthentication(c, user, req.Password, req.Code) {
+		return
+	}
+
+	webauthnService, err := services.NewWebAuthnService()
+	if err != nil {
+		fmt.Printf("NewWebAuthnService error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize WebAuthn service"})
+		return
+	}
+
+	ceremonyID, options, err := webauthnService.BeginRegistration(user)
+	if err != nil {
+		fmt.Printf("BeginRegistration error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+// WebAuthnRegisterFinishHandler stores a new passkey
+// @Summary Finish passkey registration
+// @Description Verify the attestation from navigator.credentials.create() and store the passkey. The ceremony only exists after /auth/webauthn/register/begin re-authenticated the same user.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param payload body WebAuthnRegisterFinishRequest true "Registration response"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/webauthn/register/finish [post]
+func WebAuthnRegisterFinishHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req WebAuthnRegisterFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	user, err := services.GetUserByID(uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	webauthnService, err := services.NewWebAuthnService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize WebAuthn service"})
+		return
+	}
+
+	credential, err := webauthnService.FinishRegistration(user, req.CeremonyID, req.Name, req.Credential)
+	switch {
+	case errors.Is(err, services.ErrWebAuthnCeremonyInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "webauthn_ceremony_invalid", "message": "Kayıt süresi doldu, lütfen tekrar deneyin"})
+		return
+	case errors.Is(err, services.ErrWebAuthnVerificationFailed):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "webauthn_verification_failed", "message": "Passkey doğrulanamadı"})
+		return
+	case err != nil:
+		fmt.Printf("FinishRegistration error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register passkey"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Passkey eklendi",
+		"credential": credential,
+	})
+}
+
+// WebAuthnLoginBeginHandler starts a passwordless login
+// @Summary Begin passkey login
+// @Description Returns the options for navigator.credentials.get() and a ceremony ID for /auth/webauthn/login/finish
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/webauthn/login/begin [post]
+func WebAuthnLoginBeginHandler(c *gin.Context) {
+	webauthnService, err := services.NewWebAuthnService()
+	if err != nil {
+		fmt.Printf("NewWebAuthnService error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize WebAuthn service"})
+		return
+	}
+
+	ceremonyID, options, err := webauthnService.BeginLogin()
+	if err != nil {
+		fmt.Printf("BeginLogin error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+// WebAuthnLoginFinishHandler completes a passwordless login
+// @Summary Finish passkey login
+// @Description Verify the assertion from navigator.credentials.get() and create a session
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body WebAuthnFinishRequest true "Assertion response"
+// @Success 200 {object} AuthResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{} "Blocked by the risk engine (code login_risk_blocked)"
+// @Router /auth/webauthn/login/finish [post]
+func WebAuthnLoginFinishHandler(c *gin.Context) {
+	var req WebAuthnFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+
+	webauthnService, err := services.NewWebAuthnService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize WebAuthn service"})
+		return
+	}
+
+	user, err := webauthnService.FinishLogin(req.CeremonyID, req.Credential)
+	switch {
+	case errors.Is(err, services.ErrWebAuthnCeremonyInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "webauthn_ceremony_invalid", "message": "Doğrulama süresi doldu, lütfen tekrar deneyin"})
+		return
+	case errors.Is(err, services.ErrWebAuthnVerificationFailed), errors.Is(err, services.ErrWebAuthnCredentialNotFound):
+		fmt.Printf("WebAuthn login failed: %v\n", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "webauthn_verification_failed", "message": "Passkey doğrulanamadı"})
+		return
+	case err != nil:
+		fmt.Printf("FinishLogin error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete passkey login"})
+		return
+	}
+
+	// A passkey satisfies every step-up; only a blocked login stops here
+	risk, ok := enforceLoginRisk(c, user, "webauthn", true)
+	if !ok {
+		return
+	}
+
+	completeLogin(c, user, "webauthn", risk)
+}
+
+// WebAuthnMFABeginHandler starts a passkey assertion as the second login step
+// @Summary Begin passkey second factor
+// @Description Returns assertion options for the user of an MFA challenge token from /auth/login (or the mfa_token cookie set by OAuth and SAML logins)
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body WebAuthnMFABeginRequest false "MFA challenge token"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/webauthn/mfa/begin [post]
+func WebAuthnMFABeginHandler(c *gin.Context) {
+	var req WebAuthnMFABeginRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+	token, ok := mfaChallengeToken(c, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	mfaService, err := services.NewMFAService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize MFA service"})
+		return
+	}
+
+	userID, err := mfaService.ChallengeUser(token)
+	if errors.Is(err, services.ErrMFAChallengeInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "mfa_challenge_invalid", "message": "Doğrulama süresi doldu, lütfen tekrar giriş yapın"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load MFA challenge"})
+		return
+	}
+
+	webauthnService, err := services.NewWebAuthnService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize WebAuthn service"})
+		return
+	}
+
+	ceremonyID, options, err := webauthnService.BeginSecondFactor(userID)
+	if errors.Is(err, services.ErrWebAuthnCredentialNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Kayıtlı passkey bulunamadı"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("BeginSecondFactor error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey verification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+// WebAuthnMFAFinishHandler completes a two-step login with a passkey
+// @Summary Finish passkey second factor
+// @Description Exchange the MFA challenge token (or the mfa_token cookie set by OAuth and SAML logins) and a passkey assertion for a session
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body WebAuthnMFAFinishRequest true "Assertion response"
+// @Success 200 {object} AuthResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/webauthn/mfa/finish [post]
+func WebAuthnMFAFinishHandler(c *gin.Context) {
+	var req WebAuthnMFAFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return
+	}
+	token, ok := mfaChallengeToken(c, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	mfaService, err := services.NewMFAService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize MFA service"})
+		return
+	}
+	webauthnService, err := services.NewWebAuthnService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize WebAuthn service"})
+		return
+	}
+
+	user, _, ok := guardMFAChallenge(c, mfaService, token)
+	if !ok {
+		return
+	}
+
+	_, err = mfaService.CompleteChallenge(token, func(userID uuid.UUID) error {
+		return webauthnService.FinishSecondFactor(userID, req.CeremonyID, req.Credential)
+	})
+	switch {
+	case errors.Is(err, services.ErrMFAChallengeInvalid), errors.Is(err, services.ErrWebAuthnCeremonyInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "mfa_challenge_invalid", "message": "Doğrulama süresi doldu, lütfen tekrar giriş yapın"})
+		return
+	case errors.Is(err, services.ErrWebAuthnVerificationFailed):
+		fmt.Printf("WebAuthn second factor failed: %v\n", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "webauthn_verification_failed", "message": "Passkey doğrulanamadı"})
+		return
+	case err != nil:
+		fmt.Printf("WebAuthn CompleteChallenge error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify MFA challenge"})
+		return
+	}
+
+	firstFactor, risk := mfaService.ChallengeLogin(token)
+	clearMFATokenCookie(c)
+	completeLogin(c, user, secondFactorLoginMethod(firstFactor, "webauthn_2fa"), risk)
+}
+
+// WebAuthnListCredentialsHandler lists the current user's passkeys
+// @Summary List passkeys
+// @Tags Auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/webauthn/credentials [get]
+func WebAuthnListCredentialsHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	webauthnService, err := services.NewWebAuthnService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize WebAuthn service"})
+		return
+	}
+
+	credentials, err := webauthnService.ListCredentials(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get passkeys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"credentials": credentials,
+		"total":       len(credentials),
+	})
+}
+
+// WebAuthnDeleteCredentialHandler removes one of the current user's passkeys
+// @Summary Delete passkey
+// @Description Re-authenticates the user (password, TOTP/recovery code, or a sign-in from the last 10 minutes for accounts with neither) and deletes the passkey
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Credential ID"
+// @Param payload body WebAuthnReauthRequest false "Re-authentication"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 423 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Router /auth/webauthn/credentials/{id} [delete]
+func WebAuthnDeleteCredentialHandler(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential ID"})
+		return
+	}
+
+	req, ok := bindWebAuthnReauth(c)
+	if !ok {
+		return
+	}
+
+	user, err := services.GetUserByID(uid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !verifyReauthentication(c, user, req.Password, req.Code) {
+		return
+	}
+
+	webauthnService, err := services.NewWebAuthnService()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize WebAuthn service"})
+		return
+	}
+
+	err = webauthnService.DeleteCredential(uid, id)
+	if errors.Is(err, services.ErrWebAuthnCredentialNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Passkey bulunamadı"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete passkey"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey silindi"})
+}
+
+// bindWebAuthnReauth reads the optional re-authentication body of passkey
+// changes; a request without a body is left to the recent sign-in check.
+func bindWebAuthnReauth(c *gin.Context) (WebAuthnReauthRequest, bool) {
+	var req WebAuthnReauthRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Geçersiz istek verisi"})
+		return req, false
+	}
+	return req, true
+}
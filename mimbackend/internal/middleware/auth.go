@@ -1,10 +1,12 @@
 package middleware
 
 import (
+	"errors"
 	"mimbackend/internal/services"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,7 +20,7 @@ func CORSMiddleware() gin.HandlerFunc {
 		}
 		c.Header("Access-Control-Allow-Origin", origin)
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, "+services.CSRFHeader)
 		c.Header("Access-Control-Allow-Credentials", "true")
 
 		if c.Request.Method == "OPTIONS" {
@@ -30,11 +32,53 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-// JWTMiddleware JWT token doğrular
+// JWTMiddleware JWT token doğrular. Token Authorization header'ından ya da
+// access_token cookie'sinden okunur; cookie ile gelen değiştirici isteklerde
+// X-CSRF-Token header'ı zorunludur.
 func JWTMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		var tokenString string
+		if authHeader != "" {
+			// Bearer token kontrolü
+			tokenParts := strings.Split(authHeader, " ")
+			if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Invalid authorization format. Use: Bearer <token>",
+				})
+				c.Abort()
+				return
+			}
+			tokenString = tokenParts[1]
+
+			// Personal access token (scriptler ve CI için)
+			if services.IsPersonalAccessToken(tokenString) {
+				if authenticatePersonalAccessToken(c, tokenString) {
+					c.Next()
+				}
+				return
+			}
+
+			// Şirket servis hesabı (entegrasyonlar); çağrılar ayrı kaydedilir
+			if services.IsServiceAccountSecret(tokenString) {
+				started := time.Now()
+				if secret, member, ok := authenticateServiceAccount(c, tokenString); ok {
+					c.Next()
+					recordServiceAccountCall(c, secret, member, started)
+				}
+				return
+			}
+		} else if cookie, err := c.Cookie(services.AccessTokenCookie); err == nil && cookie != "" {
+			// Cookie ile gelen isteklerde CSRF header zorunlu
+			if !services.ValidCSRFToken(c) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": "Invalid CSRF token",
+				})
+				c.Abort()
+				return
+			}
+			tokenString = cookie
+		} else {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Authorization header required",
 			})
@@ -42,20 +86,16 @@ func JWTMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Bearer token kontrolü
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		// Token doğrula (imza, süre ve oturumun hâlâ geçerli olması)
+		started := time.Now()
+		claims, err := services.ValidateAccessToken(tokenString)
+		if errors.Is(err, services.ErrSessionRevoked) {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorization format. Use: Bearer <token>",
+				"error": "Session has been revoked",
 			})
 			c.Abort()
 			return
 		}
-
-		tokenString := tokenParts[1]
-
-		// Token doğrula
-		claims, err := services.ValidateJWT(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "Invalid or expired token",
@@ -64,11 +104,33 @@ func JWTMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Üçüncü parti uygulama token'ı: izin verilen scope'lar ile sınırlı
+		if claims.IsDelegated() && !authorizeDelegatedToken(c, claims) {
+			return
+		}
+
 		// Claims'i context'e ekle
 		c.Set("userID", claims.UserID)  // camelCase for consistency
 		c.Set("user_id", claims.UserID) // snake_case for backward compatibility
 		c.Set("user_email", claims.Email)
 		c.Set("user_role", claims.Role)
+		c.Set("session_id", claims.SessionID)
+
+		// Kullanıcı adına oturum açmış super admin: her istek admin adına kaydedilir
+		if claims.IsImpersonated() {
+			impersonatorID, ok := claims.ImpersonatorID()
+			if !ok {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Invalid or expired token",
+				})
+				c.Abort()
+				return
+			}
+			c.Set("impersonator_id", impersonatorID)
+			c.Next()
+			recordImpersonatedCall(c, claims, impersonatorID, started)
+			return
+		}
 
 		c.Next()
 	}
@@ -77,22 +139,25 @@ func JWTMiddleware() gin.HandlerFunc {
 // OptionalJWTMiddleware JWT token varsa doğrular ama zorunlu değil
 func OptionalJWTMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		var tokenString string
+		if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+			tokenParts := strings.Split(authHeader, " ")
+			if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+				c.Next()
+				return
+			}
+			tokenString = tokenParts[1]
+		} else if cookie, err := c.Cookie(services.AccessTokenCookie); err == nil && cookie != "" && services.ValidCSRFToken(c) {
+			tokenString = cookie
+		} else {
 			c.Next()
 			return
 		}
 
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			c.Next()
-			return
-		}
-
-		tokenString := tokenParts[1]
-
-		claims, err := services.ValidateJWT(tokenString)
-		if err != nil {
+		// Üçüncü parti uygulama token'ları scope kontrolü, impersonation token'ları
+		// kayıt gerektirir; burada yok sayılır
+		claims, err := services.ValidateAccessToken(tokenString)
+		if err != nil || claims.IsDelegated() || claims.IsImpersonated() {
 			c.Next()
 			return
 		}
@@ -101,6 +166,7 @@ func OptionalJWTMiddleware() gin.HandlerFunc {
 		c.Set("user_id", claims.UserID) // snake_case for backward compatibility
 		c.Set("user_email", claims.Email)
 		c.Set("user_role", claims.Role)
+		c.Set("session_id", claims.SessionID)
 
 		c.Next()
 	}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	auth "mimbackend/internal/models/auth"
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// DenyImpersonation blocks sensitive endpoints (credentials, email, account
+// deletion, company purge) for super admins impersonating a user
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("impersonator_id"); ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Bu işlem kullanıcı adına oturum açılmışken yapılamaz",
+				"code":  "impersonation_forbidden",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// InteractiveSession reports whether the request was made with the access token
+// of a session the user signed in to, rather than a personal access token, a
+// service account secret, an OAuth2 application token or an impersonation token
+func InteractiveSession(c *gin.Context) bool {
+	for _, key := range []string{"token_id", "service_account_id", "oauth_client_id", "impersonator_id"} {
+		if _, ok := c.Get(key); ok {
+			return false
+		}
+	}
+	return c.GetString("session_id") != ""
+}
+
+// RequireInteractiveSession blocks endpoints that mint or rotate long-lived
+// credentials (service account secrets, OAuth client secrets) or start an
+// impersonation for anything but an interactive session, so a leaked or
+// delegated token cannot turn itself into permanent access
+func RequireInteractiveSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !InteractiveSession(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Bu işlem yalnızca etkileşimli bir oturumdan yapılabilir",
+				"code":  "interactive_session_required",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// recordImpersonatedCall stores a finished request made with an impersonation
+// token, attributed to the super admin behind it
+func recordImpersonatedCall(c *gin.Context, claims *services.Claims, impersonatorID uuid.UUID, started time.Time) {
+	activity := &auth.ImpersonationActivity{
+		SessionID:      claims.SessionID,
+		ImpersonatorID: impersonatorID,
+		UserID:         claims.UserID,
+		Method:         c.Request.Method,
+		Path:           c.Request.URL.Path,
+		StatusCode:     c.Writer.Status(),
+		IPAddress:      c.ClientIP(),
+		UserAgent:      c.Request.UserAgent(),
+		DurationMs:     time.Since(started).Milliseconds(),
+	}
+	if err := services.RecordImpersonationActivity(activity); err != nil {
+		log.Printf("failed to record impersonation activity: %v", err)
+	}
+}
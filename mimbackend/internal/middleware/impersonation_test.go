@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveInteractiveOnly runs a request through RequireInteractiveSession after
+// setting the context keys JWTMiddleware would set for the credential
+func serveInteractiveOnly(t *testing.T, keys map[string]any) int {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/company/:id/service-accounts/:serviceAccountId/secrets",
+		func(c *gin.Context) {
+			for key, value := range keys {
+				c.Set(key, value)
+			}
+		},
+		RequireInteractiveSession(),
+		func(c *gin.Context) { c.Status(http.StatusCreated) },
+	)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/company/c1/service-accounts/sa1/secrets", nil))
+	return w.Code
+}
+
+func TestRequireInteractiveSession(t *testing.T) {
+	cases := []struct {
+		name string
+		keys map[string]any
+		want int
+	}{
+		{"interactive session", map[string]any{"session_id": "s1"}, http.StatusCreated},
+		{"personal access token", map[string]any{"token_id": "t1"}, http.StatusForbidden},
+		{"service account secret", map[string]any{"service_account_id": "sa1"}, http.StatusForbidden},
+		{"oauth application token", map[string]any{"session_id": "s1", "oauth_client_id": "app"}, http.StatusForbidden},
+		{"impersonation token", map[string]any{"session_id": "s1", "impersonator_id": "admin"}, http.StatusForbidden},
+		{"no session", map[string]any{}, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := serveInteractiveOnly(t, tc.keys); got != tc.want {
+				t.Fatalf("status = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// authorizeDelegatedToken enforces the scopes of an access token issued to a
+// third-party app by the OAuth2 authorization server. It writes the error
+// response and returns false when the request is rejected.
+func authorizeDelegatedToken(c *gin.Context, claims *services.Claims) bool {
+	if claims.TokenUse == services.OAuthTokenUseRefresh {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return false
+	}
+
+	resource, action, ok := requestScope(c)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "OAuth access tokens cannot be used for this endpoint"})
+		return false
+	}
+
+	// Apps act only inside the company that registered them
+	companyID, err := uuid.Parse(claims.CompanyID)
+	if err != nil || !requestTargetsCompany(c, companyID) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token is restricted to another company"})
+		return false
+	}
+
+	allowed, err := services.DelegatedTokenAllows(claims, resource, action, c.ClientIP())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Permission check failed"})
+		return false
+	}
+	if !allowed {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":          "Insufficient token scope",
+			"required_scope": resource + ":" + action,
+		})
+		return false
+	}
+
+	c.Set("oauth_client_id", claims.ClientID)
+	return true
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestRequestTargetsCompany(t *testing.T) {
+	companyID := uuid.New()
+	other := uuid.New()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	var got bool
+	handler := func(c *gin.Context) { got = requestTargetsCompany(c, companyID) }
+	router.GET("/company/:id", handler)
+	router.GET("/company/:id/members", handler)
+	router.POST("/company", handler)
+	router.POST("/company/switch", handler)
+	router.GET("/company/by-slug/:slug", handler)
+	router.POST("/invitations/:token/accept", handler)
+	router.GET("/users", handler)
+	router.GET("/users/:id", handler)
+
+	cases := []struct {
+		method, target string
+		want           bool
+	}{
+		{http.MethodGet, "/company/" + companyID.String(), true},
+		{http.MethodGet, "/company/" + companyID.String() + "/members", true},
+		{http.MethodGet, "/company/" + other.String() + "/members", false},
+		{http.MethodGet, "/company/" + companyID.String() + "/members?company_id=" + other.String(), false},
+		{http.MethodGet, "/users?company_id=" + companyID.String(), true},
+		{http.MethodGet, "/users?company_id=not-a-uuid", false},
+		// Routes that name no company are refused
+		{http.MethodPost, "/company", false},
+		{http.MethodPost, "/company/switch", false},
+		{http.MethodGet, "/company/by-slug/acme", false},
+		{http.MethodPost, "/invitations/abc/accept", false},
+		{http.MethodGet, "/users", false},
+		// The :id of other resources is not a company
+		{http.MethodGet, "/users/" + companyID.String(), false},
+	}
+	for _, tc := range cases {
+		got = !tc.want
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.target, nil))
+		if got != tc.want {
+			t.Errorf("%s %s: targets company = %v, want %v", tc.method, tc.target, got, tc.want)
+		}
+	}
+}
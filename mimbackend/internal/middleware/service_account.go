@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	companymodels "mimbackend/internal/models/company"
+	"mimbackend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authenticateServiceAccount handles a bearer service account secret. The
+// account acts only inside its own company and every permission goes through
+// CheckUserCompanyPermissionWithContext with the company role of the account.
+// It writes the error response and returns ok=false when the request is rejected.
+func authenticateServiceAccount(c *gin.Context, raw string) (*companymodels.ServiceAccountSecret, *companymodels.CompanyMember, bool) {
+	clientIP := c.ClientIP()
+	secret, member, err := services.AuthenticateServiceAccount(raw, clientIP)
+	if err != nil {
+		if !errors.Is(err, services.ErrServiceAccountSecretInvalid) {
+			log.Printf("service account lookup failed: %v", err)
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return nil, nil, false
+	}
+
+	resource, action, ok := requestScope(c)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Service accounts cannot be used for this endpoint"})
+		return nil, nil, false
+	}
+	if !requestTargetsCompany(c, member.CompanyID) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Service account belongs to another company"})
+		return nil, nil, false
+	}
+
+	allowed, err := services.CheckUserCompanyPermissionWithContext(member.UserID, resource, action, &member.CompanyID, clientIP, time.Now())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Permission check failed"})
+		return nil, nil, false
+	}
+	if !allowed {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return nil, nil, false
+	}
+
+	c.Set("userID", member.UserID)
+	c.Set("user_id", member.UserID)
+	c.Set("user_email", member.User.Email)
+	c.Set("user_role", member.User.Role)
+	c.Set("service_account_id", member.ID)
+	c.Set("service_account_company_id", member.CompanyID)
+	return secret, member, true
+}
+
+// recordServiceAccountCall stores a finished request in the service account
+// activity log, separate from user sessions
+func recordServiceAccountCall(c *gin.Context, secret *companymodels.ServiceAccountSecret, member *companymodels.CompanyMember, started time.Time) {
+	activity := &companymodels.ServiceAccountActivity{
+		MemberID:   member.ID,
+		CompanyID:  member.CompanyID,
+		SecretID:   secret.ID,
+		Method:     c.Request.Method,
+		Path:       c.Request.URL.Path,
+		StatusCode: c.Writer.Status(),
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		DurationMs: time.Since(started).Milliseconds(),
+	}
+	if err := services.RecordServiceAccountActivity(activity); err != nil {
+		log.Printf("failed to record service account activity: %v", err)
+	}
+}
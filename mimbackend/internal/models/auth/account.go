@@ -8,9 +8,9 @@ type Account struct {
 	UserID       uuid.UUID `gorm:"type:varchar(36);not null;index"`
 	Provider     string    `gorm:"not null"` // google, github, facebook
 	ProviderID   string    `gorm:"not null;index;type:varchar(255)"`
-	AccessToken  string
-	RefreshToken string
-	ExpiresAt    int64 // Unix timestamp
+	AccessToken  string    // Provider token, encrypted at rest (services.sealToken)
+	RefreshToken string    // Provider token, encrypted at rest (services.sealToken)
+	ExpiresAt    int64     // Unix timestamp
 
 	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
 }
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Account deletion modes
+const (
+	AccountDeletionAnonymize  = "anonymize" // Personal data is erased, the user row stays as an anonymous tombstone
+	AccountDeletionHardDelete = "delete"    // The user row is removed as well
+)
+
+// Account deletion statuses
+const (
+	AccountDeletionPending   = "pending"
+	AccountDeletionRunning   = "running"
+	AccountDeletionCompleted = "completed"
+)
+
+// AccountDeletionRequest is a self-service account deletion waiting for its
+// grace period. Cancelled requests are removed; completed ones are kept
+// without personal data as a record of the erasure.
+type AccountDeletionRequest struct {
+	BaseModel
+
+	UserID       uuid.UUID  `gorm:"type:varchar(36);not null;uniqueIndex" json:"user_id"`
+	Mode         string     `gorm:"type:varchar(20);not null" json:"mode"`
+	Status       string     `gorm:"type:varchar(20);not null;default:'pending';index" json:"status"`
+	ScheduledFor time.Time  `gorm:"not null;index" json:"scheduled_for"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	// LastError explains why a due deletion could not run yet, e.g. the user became a sole owner again
+	LastError string `gorm:"type:varchar(500)" json:"last_error,omitempty"`
+}
+
+// TableName specifies the table name for AccountDeletionRequest
+func (AccountDeletionRequest) TableName() string {
+	return "account_deletion_requests"
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// ImpersonationActivity is one request made by a super admin while
+// impersonating a user. The session itself is a UserSession of the user with
+// ImpersonatorID set; this log ties every call to the real admin.
+type ImpersonationActivity struct {
+	BaseModel
+
+	SessionID      string    `gorm:"type:varchar(255);not null;index" json:"session_id"`
+	ImpersonatorID uuid.UUID `gorm:"type:varchar(36);not null;index" json:"impersonator_id"`
+	UserID         uuid.UUID `gorm:"type:varchar(36);not null;index" json:"user_id"`
+	Method         string    `gorm:"type:varchar(10)" json:"method"`
+	Path           string    `gorm:"type:varchar(500)" json:"path"`
+	StatusCode     int       `json:"status_code"`
+	IPAddress      string    `gorm:"type:varchar(45)" json:"ip_address"`
+	UserAgent      string    `gorm:"type:text" json:"user_agent"`
+	DurationMs     int64     `json:"duration_ms"`
+}
+
+// TableName specifies the table name for ImpersonationActivity
+func (ImpersonationActivity) TableName() string {
+	return "impersonation_activities"
+}
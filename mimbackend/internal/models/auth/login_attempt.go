@@ -0,0 +1,35 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// Failed login reasons recorded on LoginAttempt
+const (
+	LoginFailureInvalidPassword = "invalid_password"
+	LoginFailureUnknownUser     = "unknown_user"
+	LoginFailureLocked          = "locked"
+	LoginFailureIPBlocked       = "ip_blocked"
+	LoginFailureThrottled       = "throttled"
+	LoginFailureRiskBlocked     = "risk_blocked"
+	// A wrong TOTP or recovery code at the second login step or while
+	// re-authenticating for a sensitive change
+	LoginFailureInvalidCode = "invalid_code"
+)
+
+// LoginAttempt is a failed login kept in the user's security history. UserID is
+// empty when the email does not belong to an account.
+type LoginAttempt struct {
+	BaseModel
+
+	UserID    *uuid.UUID `gorm:"type:varchar(36);index" json:"user_id,omitempty"`
+	Email     string     `gorm:"type:varchar(255);index;not null" json:"email"`
+	IPAddress string     `gorm:"type:varchar(45);index" json:"ip_address"`
+	UserAgent string     `gorm:"type:text" json:"user_agent"`
+	Reason    string     `gorm:"type:varchar(50);not null" json:"reason"`
+}
+
+// TableName specifies the table name for LoginAttempt
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// UserTOTP is a user's TOTP authenticator. It only acts as a second factor
+// once the enrollment was confirmed with a valid code (ConfirmedAt set).
+type UserTOTP struct {
+	BaseModel
+
+	UserID       uuid.UUID  `gorm:"type:varchar(36);uniqueIndex;not null" json:"user_id"`
+	Secret       string     `gorm:"type:text;not null" json:"-"` // Base32 shared secret, encrypted at rest (services.sealToken)
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty"`
+	LastUsedStep int64      `gorm:"default:0" json:"-"` // Last accepted time step, rejects replayed codes
+}
+
+// TableName specifies the table name for UserTOTP
+func (UserTOTP) TableName() string {
+	return "user_totps"
+}
+
+// MFARecoveryCode is a single-use code that replaces a TOTP code when the
+// authenticator is lost. Only the SHA-256 hash is stored.
+type MFARecoveryCode struct {
+	BaseModel
+
+	UserID   uuid.UUID  `gorm:"type:varchar(36);not null;index" json:"user_id"`
+	CodeHash string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	UsedAt   *time.Time `json:"used_at,omitempty"`
+}
+
+// TableName specifies the table name for MFARecoveryCode
+func (MFARecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+// MFAChallenge is the pending second step of a login. The first step
+// (password, phone code, magic link, external provider) hands out the token;
+// /auth/mfa/verify exchanges it plus a code for a session.
+type MFAChallenge struct {
+	BaseModel
+
+	UserID     uuid.UUID  `gorm:"type:varchar(36);not null;index" json:"user_id"`
+	TokenHash  string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	Attempts   int        `gorm:"default:0" json:"attempts"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	ConsumedAt *time.Time `json:"consumed_at,omitempty"`
+	// LoginMethod is the first factor, e.g. password, oauth_github or saml:<company ID>
+	LoginMethod string `gorm:"type:varchar(64)" json:"login_method"`
+	// LoginRisk is the risk assessment of the first step, recorded on the session
+	LoginRisk datatypes.JSON `gorm:"type:json" json:"-"`
+}
+
+// TableName specifies the table name for MFAChallenge
+func (MFAChallenge) TableName() string {
+	return "mfa_challenges"
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// OAuthClient is a third-party application registered by a company to act on
+// behalf of its users through the built-in OAuth2 authorization server.
+// Confidential clients authenticate with a secret whose SHA-256 is stored;
+// public clients (SPAs, mobile apps) have none and rely on PKCE alone.
+type OAuthClient struct {
+	BaseModel
+
+	CompanyID        uuid.UUID                   `gorm:"type:varchar(36);not null;index" json:"company_id"`
+	ClientID         string                      `gorm:"type:varchar(64);uniqueIndex;not null" json:"client_id"`
+	ClientSecretHash string                      `gorm:"type:varchar(64)" json:"-"`
+	Name             string                      `gorm:"type:varchar(100);not null" json:"name"`
+	Description      string                      `gorm:"type:text" json:"description,omitempty"`
+	HomepageURL      string                      `gorm:"type:varchar(500)" json:"homepage_url,omitempty"`
+	RedirectURIs     datatypes.JSONSlice[string] `gorm:"type:json;not null" json:"redirect_uris"` // Exact match only
+	Scopes           datatypes.JSONSlice[string] `gorm:"type:json;not null" json:"scopes"`        // Upper bound of what the client may request
+	IsPublic         bool                        `gorm:"default:false" json:"is_public"`
+	CreatedBy        uuid.UUID                   `gorm:"type:varchar(36)" json:"created_by"`
+	RevokedAt        *time.Time                  `gorm:"index" json:"revoked_at,omitempty"`
+}
+
+// TableName specifies the table name for OAuthClient
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// IsActive reports whether the client can still obtain tokens
+func (c *OAuthClient) IsActive() bool {
+	return c.RevokedAt == nil
+}
+
+// AllowsRedirectURI reports whether uri is one of the registered redirect URIs
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthAuthorizationCode is a short-lived, single-use code handed to a client
+// after the user approved its request. It can only be exchanged together with
+// the PKCE verifier matching CodeChallenge.
+type OAuthAuthorizationCode struct {
+	BaseModel
+
+	CodeHash            string                      `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	ClientID            uuid.UUID                   `gorm:"type:varchar(36);not null;index" json:"client_id"`
+	UserID              uuid.UUID                   `gorm:"type:varchar(36);not null;index" json:"user_id"`
+	RedirectURI         string                      `gorm:"type:varchar(500);not null" json:"redirect_uri"`
+	Scopes              datatypes.JSONSlice[string] `gorm:"type:json;not null" json:"scopes"`
+	CodeChallenge       string                      `gorm:"type:varchar(128);not null" json:"-"`
+	CodeChallengeMethod string                      `gorm:"type:varchar(10);not null" json:"-"`
+	ExpiresAt           time.Time                   `gorm:"not null;index" json:"expires_at"`
+	UsedAt              *time.Time                  `json:"used_at,omitempty"`
+	SessionID           string                      `gorm:"type:varchar(255)" json:"-"` // Session created by the exchange; revoked if the code is replayed
+}
+
+// TableName specifies the table name for OAuthAuthorizationCode
+func (OAuthAuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
+
+// OAuthConsent records the scopes a user granted to a client, so the consent
+// screen can be skipped for requests it already covers and the user can list
+// and withdraw access later.
+type OAuthConsent struct {
+	BaseModel
+
+	UserID    uuid.UUID                   `gorm:"type:varchar(36);not null;index" json:"user_id"`
+	ClientID  uuid.UUID                   `gorm:"type:varchar(36);not null;index" json:"client_id"`
+	Client    *OAuthClient                `gorm:"foreignKey:ClientID;constraint:OnDelete:CASCADE" json:"client,omitempty"`
+	Scopes    datatypes.JSONSlice[string] `gorm:"type:json;not null" json:"scopes"`
+	RevokedAt *time.Time                  `gorm:"index" json:"revoked_at,omitempty"`
+}
+
+// TableName specifies the table name for OAuthConsent
+func (OAuthConsent) TableName() string {
+	return "oauth_consents"
+}
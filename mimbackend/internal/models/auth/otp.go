@@ -1,12 +1,27 @@
 package models
 
-import "time"
+import (
+	"time"
 
+	"github.com/google/uuid"
+)
+
+// OTP purposes
+const (
+	OTPPurposeVerifyPhone = "verify_phone"
+	OTPPurposeLogin       = "login"
+)
+
+// OTP is a one-time code sent by SMS. Only a keyed hash of the code is stored.
 type OTP struct {
 	BaseModel
 
-	Phone     string    `gorm:"index;not null;type:varchar(20)"`
-	Code      string    `gorm:"not null;type:varchar(10)"`
-	ExpiresAt time.Time `gorm:"not null"`
-	IsUsed    bool      `gorm:"default:false"`
+	Phone     string     `gorm:"index;not null;type:varchar(20)"`                 // E.164, e.g. +905321234567
+	Code      string     `gorm:"not null;type:varchar(64)"`                       // HMAC-SHA256 of the code
+	Purpose   string     `gorm:"type:varchar(20);not null;default:'login';index"` // verify_phone, login
+	UserID    *uuid.UUID `gorm:"type:varchar(36);index"`                          // Set for verify_phone
+	IPAddress string     `gorm:"type:varchar(45);index"`                          // Requesting client, used for rate limiting
+	Attempts  int        `gorm:"default:0"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	IsUsed    bool       `gorm:"default:false"`
 }
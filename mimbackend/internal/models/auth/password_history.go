@@ -0,0 +1,19 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// PasswordHistory keeps the hashes of a user's recent passwords so the
+// password policy can refuse reusing them
+type PasswordHistory struct {
+	BaseModel
+
+	UserID       uuid.UUID `gorm:"type:varchar(36);not null;index" json:"user_id"`
+	PasswordHash string    `gorm:"not null" json:"-"`
+}
+
+// TableName specifies the table name for PasswordHistory
+func (PasswordHistory) TableName() string {
+	return "password_histories"
+}
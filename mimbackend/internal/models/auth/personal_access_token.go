@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// PersonalAccessToken is a long-lived API credential created by a user for
+// scripts and CI. Only the SHA-256 of the token is stored; the plain value is
+// shown once at creation.
+type PersonalAccessToken struct {
+	BaseModel
+
+	UserID     uuid.UUID                   `gorm:"type:varchar(36);not null;index" json:"user_id"`
+	User       *User                       `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	Name       string                      `gorm:"type:varchar(100);not null" json:"name"`
+	TokenHash  string                      `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	Prefix     string                      `gorm:"type:varchar(20);not null" json:"prefix"`            // First characters, to recognise the token in lists
+	Scopes     datatypes.JSONSlice[string] `gorm:"type:json;not null" json:"scopes"`                   // resource:action, e.g. companies:read
+	CompanyID  *uuid.UUID                  `gorm:"type:varchar(36);index" json:"company_id,omitempty"` // Token only works inside this company
+	ExpiresAt  *time.Time                  `gorm:"index" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time                  `json:"last_used_at,omitempty"`
+	LastUsedIP string                      `gorm:"type:varchar(45)" json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time                  `gorm:"index" json:"revoked_at,omitempty"`
+}
+
+// TableName specifies the table name for PersonalAccessToken
+func (PersonalAccessToken) TableName() string {
+	return "personal_access_tokens"
+}
+
+// IsActive reports whether the token can still authenticate requests
+func (t *PersonalAccessToken) IsActive() bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || time.Now().Before(*t.ExpiresAt)
+}
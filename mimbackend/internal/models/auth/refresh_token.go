@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is one link in a refresh token family. Each UserSession owns a
+// family (FamilyID = UserSession.SessionID); every refresh marks the presented
+// token as used and appends its successor. Presenting a used token again means
+// the token was copied, so the whole family gets revoked.
+type RefreshToken struct {
+	BaseModel
+
+	UserID    uuid.UUID  `gorm:"type:varchar(36);not null;index" json:"user_id"`
+	FamilyID  string     `gorm:"type:varchar(255);not null;index" json:"family_id"`
+	TokenHash string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"` // SHA-256 of the refresh token
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`    // Set when the token was exchanged for a successor
+	RevokedAt *time.Time `json:"revoked_at,omitempty"` // Set when the family was revoked (logout or reuse)
+}
+
+// TableName specifies the table name for RefreshToken
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
@@ -17,6 +17,10 @@ type User struct {
 	FullName     *string
 	PasswordHash string
 	IsVerified   bool `gorm:"default:false"`
+	// Phone was confirmed with an SMS code; only verified numbers can log in
+	PhoneVerified bool `gorm:"default:false"`
+	// Non-login principal created for a company integration; see company service accounts
+	IsServiceAccount bool `gorm:"default:false;index"`
 
 	// Role relationship (global role)
 	RoleID *uuid.UUID `gorm:"column:role_id;type:varchar(36);index"`
@@ -30,6 +34,5 @@ type User struct {
 	ResetTokenExpires *time.Time
 
 	Accounts  []Account
-	Sessions  []Session
 	RoleModel *basemodels.Role `gorm:"foreignKey:RoleID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
 }
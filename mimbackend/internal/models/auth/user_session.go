@@ -17,13 +17,18 @@ type UserSession struct {
 	SessionID string    `gorm:"type:varchar(255);uniqueIndex;not null" json:"session_id"` // Unique session identifier
 
 	// Security Information
-	IPAddress  string `gorm:"type:varchar(45);not null" json:"ip_address"` // IPv4 or IPv6
-	UserAgent  string `gorm:"type:text" json:"user_agent"`                 // Browser/client info
-	DeviceType string `gorm:"type:varchar(50)" json:"device_type"`         // mobile, desktop, tablet, etc.
-	OS         string `gorm:"type:varchar(100)" json:"os"`                 // Operating system
-	Browser    string `gorm:"type:varchar(100)" json:"browser"`            // Browser name and version
-	DeviceID   string `gorm:"type:varchar(255);index" json:"device_id"`    // Device fingerprint
-	Location   string `gorm:"type:varchar(255)" json:"location,omitempty"` // Approximate location (city, country)
+	IPAddress  string `gorm:"type:varchar(45);not null" json:"ip_address"`    // IPv4 or IPv6
+	UserAgent  string `gorm:"type:text" json:"user_agent"`                    // Browser/client info
+	DeviceType string `gorm:"type:varchar(50)" json:"device_type"`            // mobile, desktop, tablet, etc.
+	OS         string `gorm:"type:varchar(100)" json:"os"`                    // Operating system
+	Browser    string `gorm:"type:varchar(100)" json:"browser"`               // Browser name and version
+	DeviceID   string `gorm:"type:varchar(255);index" json:"device_id"`       // Device fingerprint
+	Location   string `gorm:"type:varchar(255)" json:"location,omitempty"`    // Approximate location (city, country)
+	Country    string `gorm:"type:varchar(2);index" json:"country,omitempty"` // ISO 3166-1 alpha-2 country code
+
+	// Coordinates of the IP address from the GeoIP database, used to detect impossible travel
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
 
 	// Session Status
 	IsActive     bool       `gorm:"default:true;not null" json:"is_active"`
@@ -35,7 +40,14 @@ type UserSession struct {
 	// Security Flags
 	IsSuspicious bool   `gorm:"default:false" json:"is_suspicious"`   // Marked if suspicious activity detected
 	TrustScore   int    `gorm:"default:100" json:"trust_score"`       // 0-100, trust level of this session
-	LoginMethod  string `gorm:"type:varchar(50)" json:"login_method"` // password, oauth, 2fa, etc.
+	LoginMethod  string `gorm:"type:varchar(64)" json:"login_method"` // password, oauth, 2fa, etc.
+
+	// OAuthClientID is set on sessions created for a third-party app by the
+	// OAuth2 authorization server; the app's tokens live and die with it
+	OAuthClientID *uuid.UUID `gorm:"column:oauth_client_id;type:char(36);index" json:"oauth_client_id,omitempty"`
+	// ImpersonatorID is the super admin who opened this session to act as the
+	// user; it shows up in the user's session history
+	ImpersonatorID *uuid.UUID `gorm:"type:char(36);index" json:"impersonator_id,omitempty"`
 
 	// Metadata
 	RefreshToken string         `gorm:"type:text" json:"-"`             // Associated refresh token (hashed)
@@ -101,11 +113,13 @@ func (s *UserSession) MarkAsSuspicious(db *gorm.DB, reason string) error {
 		}
 	}
 
-	// Create metadata map
-	metadata := map[string]interface{}{
-		"suspicious_reason": reason,
-		"marked_at":         time.Now().Format(time.RFC3339),
+	// Keep what is already in the metadata (new_device, location, ...)
+	metadata := make(map[string]interface{})
+	if len(s.Metadata) > 0 {
+		_ = json.Unmarshal(s.Metadata, &metadata)
 	}
+	metadata["suspicious_reason"] = reason
+	metadata["marked_at"] = time.Now().Format(time.RFC3339)
 
 	// Convert to JSON
 	metadataJSON, err := json.Marshal(metadata)
@@ -130,6 +144,9 @@ type SessionSecurityInfo struct {
 	Browser     string                 `json:"browser,omitempty"`
 	DeviceID    string                 `json:"device_id,omitempty"`
 	Location    string                 `json:"location,omitempty"`
+	Country     string                 `json:"country,omitempty"`
+	Latitude    *float64               `json:"latitude,omitempty"`
+	Longitude   *float64               `json:"longitude,omitempty"`
 	LoginMethod string                 `json:"login_method,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
 }
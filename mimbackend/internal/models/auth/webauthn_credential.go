@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user. The
+// full credential record (public key, sign counter, flags) is kept as JSON so
+// it can be handed back to the WebAuthn library unchanged.
+type WebAuthnCredential struct {
+	BaseModel
+
+	UserID       uuid.UUID      `gorm:"type:varchar(36);not null;index" json:"user_id"`
+	User         *User          `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	CredentialID string         `gorm:"type:varchar(255);uniqueIndex;not null" json:"credential_id"` // Base64url credential ID
+	Name         string         `gorm:"type:varchar(100)" json:"name"`                               // User supplied label, e.g. "MacBook"
+	Credential   datatypes.JSON `gorm:"type:json;not null" json:"-"`                                 // webauthn.Credential
+	LastUsedAt   *time.Time     `json:"last_used_at,omitempty"`
+}
+
+// TableName specifies the table name for WebAuthnCredential
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
+
+// WebAuthnCeremony holds the server side state of a registration or login
+// ceremony between its begin and finish requests. Rows are deleted when the
+// ceremony finishes.
+type WebAuthnCeremony struct {
+	BaseModel
+
+	UserID    *uuid.UUID     `gorm:"type:varchar(36);index" json:"user_id,omitempty"` // Empty for discoverable (passwordless) logins
+	Kind      string         `gorm:"type:varchar(20);not null" json:"kind"`           // registration, login, mfa
+	Data      datatypes.JSON `gorm:"type:json;not null" json:"-"`                     // webauthn.SessionData
+	ExpiresAt time.Time      `gorm:"not null;index" json:"expires_at"`
+}
+
+// TableName specifies the table name for WebAuthnCeremony
+func (WebAuthnCeremony) TableName() string {
+	return "webauthn_ceremonies"
+}
@@ -85,6 +85,9 @@ type Company struct {
 	// Company Modules - SaaS için aktif modeller
 	Modules *CompanyModules `gorm:"column:modules;type:json" json:"modules,omitempty"`
 
+	// Stricter password rules for members; see PasswordPolicy
+	PasswordPolicy *PasswordPolicy `gorm:"column:password_policy;type:json" json:"password_policy,omitempty"`
+
 	// Relations
 	Branches    []Branch        `gorm:"foreignKey:CompanyID" json:"branches,omitempty"`
 	Departments []Department    `gorm:"foreignKey:CompanyID" json:"departments,omitempty"`
@@ -24,6 +24,8 @@ type CompanyMember struct {
 	IsActive bool       `gorm:"default:true" json:"is_active"`                   // Active member
 	Status   string     `gorm:"type:varchar(20);default:'active'" json:"status"` // active, pending, suspended
 	JoinedAt *time.Time `gorm:"type:datetime" json:"joined_at,omitempty"`        // When user joined company
+	// Service accounts are non-human members authenticated by API secrets
+	IsServiceAccount bool `gorm:"default:false;index" json:"is_service_account"`
 
 	// Relations
 	User    *authmodels.User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"user,omitempty"`
@@ -0,0 +1,60 @@
+package company
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ServiceAccountSecret is an API secret of a company service account. A
+// service account can hold several secrets so they can be rotated without
+// downtime; only the SHA-256 of each secret is stored.
+type ServiceAccountSecret struct {
+	BaseModel
+
+	MemberID   uuid.UUID      `gorm:"type:varchar(36);not null;index" json:"service_account_id"`
+	Member     *CompanyMember `gorm:"foreignKey:MemberID;constraint:OnDelete:CASCADE" json:"-"`
+	CompanyID  uuid.UUID      `gorm:"type:varchar(36);not null;index" json:"company_id"`
+	Name       string         `gorm:"type:varchar(100)" json:"name"`
+	SecretHash string         `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	Prefix     string         `gorm:"type:varchar(20);not null" json:"prefix"` // First characters, to recognise the secret in lists
+	CreatedBy  uuid.UUID      `gorm:"type:varchar(36)" json:"created_by"`
+	ExpiresAt  *time.Time     `gorm:"index" json:"expires_at,omitempty"` // Set on the old secrets when a rotation grace period ends
+	LastUsedAt *time.Time     `json:"last_used_at,omitempty"`
+	LastUsedIP string         `gorm:"type:varchar(45)" json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time     `gorm:"index" json:"revoked_at,omitempty"`
+}
+
+// TableName specifies the table name for ServiceAccountSecret
+func (ServiceAccountSecret) TableName() string {
+	return "service_account_secrets"
+}
+
+// IsActive reports whether the secret can still authenticate requests
+func (s *ServiceAccountSecret) IsActive() bool {
+	if s.RevokedAt != nil {
+		return false
+	}
+	return s.ExpiresAt == nil || time.Now().Before(*s.ExpiresAt)
+}
+
+// ServiceAccountActivity is one API call made by a service account. It is kept
+// apart from user sessions so integration traffic does not mix with human activity.
+type ServiceAccountActivity struct {
+	BaseModel
+
+	MemberID   uuid.UUID `gorm:"type:varchar(36);not null;index" json:"service_account_id"`
+	CompanyID  uuid.UUID `gorm:"type:varchar(36);not null;index" json:"company_id"`
+	SecretID   uuid.UUID `gorm:"type:varchar(36);index" json:"secret_id"`
+	Method     string    `gorm:"type:varchar(10)" json:"method"`
+	Path       string    `gorm:"type:varchar(500)" json:"path"`
+	StatusCode int       `json:"status_code"`
+	IPAddress  string    `gorm:"type:varchar(45)" json:"ip_address"`
+	UserAgent  string    `gorm:"type:text" json:"user_agent"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// TableName specifies the table name for ServiceAccountActivity
+func (ServiceAccountActivity) TableName() string {
+	return "service_account_activities"
+}
@@ -84,3 +84,31 @@ func (w *WorkingHours) Scan(value interface{}) error {
 func (w WorkingHours) Value() (driver.Value, error) {
 	return json.Marshal(w)
 }
+
+// PasswordPolicy lets a company tighten the global password policy for its
+// members. Zero values keep the global setting; nothing here can loosen it.
+type PasswordPolicy struct {
+	MinLength     int  `json:"min_length,omitempty"`
+	RequireUpper  bool `json:"require_upper,omitempty"`
+	RequireLower  bool `json:"require_lower,omitempty"`
+	RequireDigit  bool `json:"require_digit,omitempty"`
+	RequireSymbol bool `json:"require_symbol,omitempty"`
+	HistorySize   int  `json:"history_size,omitempty"`
+}
+
+// Scan implements sql.Scanner interface for GORM
+func (p *PasswordPolicy) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, p)
+}
+
+// Value implements driver.Valuer interface for GORM
+func (p PasswordPolicy) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
@@ -20,13 +20,24 @@ func SetupAPIRoutes(router gin.IRouter) {
 
 	// User management routes - require admin permissions
 	userGroup := router.Group("/users")
-	userGroup.Use(middleware.JWTMiddleware(), middleware.AdminMiddleware())
+	userGroup.Use(middleware.JWTMiddleware(), middleware.DenyImpersonation(), middleware.AdminMiddleware())
 	{
 		userGroup.GET("", getUsersHandler)
 		// Admin operations for user management
 		userGroup.GET("/paginated", handlers.GetUsersPaginatedHandler)
 		userGroup.PUT("/:userId", handlers.UpdateUserHandler)
 		userGroup.DELETE("/:userId", handlers.DeleteUserHandler)
+		// Session revocation takes effect on the user's next request
+		userGroup.DELETE("/:userId/sessions", handlers.AdminRevokeUserSessionsHandler)
+		userGroup.DELETE("/:userId/sessions/:session_id", handlers.AdminRevokeUserSessionHandler)
+		// Login lockout after repeated failed passwords
+		userGroup.GET("/:userId/lockout", handlers.AdminGetUserLockoutHandler)
+		userGroup.DELETE("/:userId/lockout", handlers.AdminUnlockUserHandler)
+		// Risk score breakdown of logins and the logins the risk engine blocked
+		userGroup.GET("/:userId/login-risk", handlers.AdminGetUserLoginRiskHandler)
+		// Super admin impersonation and the requests made with it
+		userGroup.POST("/:userId/impersonate", middleware.RequireInteractiveSession(), handlers.StartImpersonationHandler)
+		userGroup.GET("/:userId/impersonation-activity", handlers.GetImpersonationActivityHandler)
 		// userGroup.GET("/:userId/permissions", handlers.GetUserPermissionsHandler) // Removed - moved to auth.go
 
 		// User custom permissions management - moved to auth.go routes
@@ -35,6 +46,13 @@ func SetupAPIRoutes(router gin.IRouter) {
 		// userGroup.PUT("/:userId/custom-permissions/:permissionId", handlers.UpdateUserCustomPermission)
 		// userGroup.DELETE("/:userId/custom-permissions/:permissionId", handlers.DeleteUserCustomPermission)
 	}
+
+	// Client IPs blocked after too many failed logins - admin only
+	loginBlockGroup := router.Group("/login-blocks")
+	loginBlockGroup.Use(middleware.JWTMiddleware(), middleware.AdminMiddleware())
+	{
+		loginBlockGroup.DELETE("/ips/:ip", handlers.AdminUnblockIPHandler)
+	}
 }
 
 // getUsersHandler tüm kullanıcıları listeler (admin için)
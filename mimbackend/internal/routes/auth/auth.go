@@ -15,13 +15,67 @@ func SetupAuthRoutes(router gin.IRouter) {
 		auth.POST("/login", handlers.LoginHandler)
 		auth.POST("/refresh", handlers.RefreshHandler)
 		auth.POST("/logout", handlers.LogoutHandler)
-		auth.POST("/logout-all", middleware.JWTMiddleware(), handlers.LogoutAllHandler)
+		auth.POST("/logout-all", middleware.JWTMiddleware(), middleware.DenyImpersonation(), handlers.LogoutAllHandler)
 		auth.POST("/send-verification", handlers.SendVerificationCode)
 		auth.POST("/verify-email", handlers.VerifyEmail)
 		auth.POST("/resend-verification", handlers.ResendVerificationCode)
 		auth.POST("/forgot-password", handlers.ForgotPasswordHandler)
 		auth.POST("/resend-password", handlers.ResendPasswordHandler)
 		auth.POST("/reset-password", handlers.ResetPasswordHandler)
+		auth.POST("/unlock", handlers.UnlockAccountHandler)
+		auth.GET("/password-policy", middleware.JWTMiddleware(), handlers.GetPasswordPolicyHandler)
+
+		// Two-factor authentication (TOTP + recovery codes)
+		auth.POST("/mfa/verify", handlers.MFAVerifyHandler)
+		mfa := auth.Group("/mfa")
+		mfa.Use(middleware.JWTMiddleware(), middleware.DenyImpersonation())
+		{
+			mfa.GET("/status", handlers.MFAStatusHandler)
+			mfa.POST("/totp/setup", handlers.MFASetupHandler)
+			mfa.POST("/totp/confirm", handlers.MFAConfirmHandler)
+			mfa.POST("/totp/disable", handlers.MFADisableHandler)
+			mfa.POST("/recovery-codes", handlers.MFARecoveryCodesHandler)
+		}
+
+		// Personal access tokens for scripts and CI
+		tokens := auth.Group("/tokens")
+		tokens.Use(middleware.JWTMiddleware(), middleware.DenyImpersonation())
+		{
+			tokens.GET("", handlers.ListPersonalAccessTokensHandler)
+			tokens.POST("", handlers.CreatePersonalAccessTokenHandler)
+			tokens.DELETE("/:id", handlers.RevokePersonalAccessTokenHandler)
+		}
+
+		// Passwordless email login
+		auth.POST("/magic-link", handlers.MagicLinkRequestHandler)
+		auth.POST("/magic-link/consume", handlers.MagicLinkConsumeHandler)
+
+		// Links of a requested email change, sent to the new and the old address
+		auth.POST("/email-change/confirm", handlers.ConfirmEmailChangeHandler)
+		auth.POST("/email-change/cancel", handlers.CancelEmailChangeHandler)
+
+		// Ends the super admin impersonation session the request was made with
+		auth.POST("/impersonation/end", middleware.JWTMiddleware(), handlers.EndImpersonationHandler)
+
+		// SMS one-time codes: phone verification and phone login
+		auth.POST("/phone/login/send", handlers.PhoneLoginSendHandler)
+		auth.POST("/phone/login", handlers.PhoneLoginHandler)
+		auth.POST("/phone/verify/send", middleware.JWTMiddleware(), middleware.DenyImpersonation(), handlers.PhoneSendVerificationHandler)
+		auth.POST("/phone/verify", middleware.JWTMiddleware(), middleware.DenyImpersonation(), handlers.PhoneVerifyHandler)
+
+		// Passkeys (WebAuthn) as first factor or as second factor after /auth/login
+		webauthn := auth.Group("/webauthn")
+		{
+			webauthn.POST("/login/begin", handlers.WebAuthnLoginBeginHandler)
+			webauthn.POST("/login/finish", handlers.WebAuthnLoginFinishHandler)
+			webauthn.POST("/mfa/begin", handlers.WebAuthnMFABeginHandler)
+			webauthn.POST("/mfa/finish", handlers.WebAuthnMFAFinishHandler)
+
+			webauthn.POST("/register/begin", middleware.JWTMiddleware(), middleware.DenyImpersonation(), handlers.WebAuthnRegisterBeginHandler)
+			webauthn.POST("/register/finish", middleware.JWTMiddleware(), middleware.DenyImpersonation(), handlers.WebAuthnRegisterFinishHandler)
+			webauthn.GET("/credentials", middleware.JWTMiddleware(), handlers.WebAuthnListCredentialsHandler)
+			webauthn.DELETE("/credentials/:id", middleware.JWTMiddleware(), middleware.DenyImpersonation(), handlers.WebAuthnDeleteCredentialHandler)
+		}
 	}
 
 	// Casbin admin endpoints removed: policy management is no longer exposed.
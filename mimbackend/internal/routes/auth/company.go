@@ -9,9 +9,10 @@ import (
 
 // SetupCompanyRoutes company ile ilgili route'ları kurar
 func SetupCompanyRoutes(router gin.IRouter) {
-	// Background export endpoint (auth via cookie or token handled inside handler)
-	router.POST("/company/:id/export/background", handlers.RequestExportBackgroundHandler)
-	router.GET("/company/export/download", handlers.DownloadExportHandler)
+	// Background export endpoints; download links are opened in the browser, so
+	// they are usually authenticated by the access_token cookie
+	router.POST("/company/:id/export/background", middleware.JWTMiddleware(), handlers.RequestExportBackgroundHandler)
+	router.GET("/company/export/download", middleware.JWTMiddleware(), handlers.DownloadExportHandler)
 
 	// E-Fatura verification endpoint (no auth required for now)
 	router.POST("/company/verify-tax", handlers.VerifyCompanyTaxHandler)
@@ -35,7 +36,8 @@ func SetupCompanyRoutes(router gin.IRouter) {
 		{
 			idGroup.PUT("", handlers.UpdateCompanyHandler)
 			idGroup.DELETE("", handlers.DeleteCompanyHandler)
-			idGroup.DELETE("/permanent", handlers.DeleteCompanyPermanentHandler)
+			idGroup.DELETE("/permanent", middleware.DenyImpersonation(), handlers.DeleteCompanyPermanentHandler)
+			idGroup.PUT("/password-policy", middleware.DenyImpersonation(), handlers.UpdateCompanyPasswordPolicyHandler)
 
 			// Invitation routes (ID-based)
 			idGroup.POST("/invitations", handlers.CreateCompanyInvitationHandler)
@@ -56,6 +58,31 @@ func SetupCompanyRoutes(router gin.IRouter) {
 			idGroup.PATCH("/roles/:roleId/permissions/:permissionId", handlers.UpdateCompanyRolePermission)
 			idGroup.PUT("/roles/:roleId/permissions/:permissionId", handlers.UpdateCompanyRolePermissionByID)
 			idGroup.DELETE("/roles/:roleId", handlers.DeleteCompanyRoleHandler)
+
+			// Service accounts: non-human members for integrations (owner only).
+			// Secrets are only minted from an interactive session, never by an API credential
+			idGroup.GET("/service-accounts", handlers.ListServiceAccountsHandler)
+			idGroup.POST("/service-accounts", middleware.RequireInteractiveSession(), handlers.CreateServiceAccountHandler)
+			idGroup.PUT("/service-accounts/:serviceAccountId", handlers.UpdateServiceAccountHandler)
+			idGroup.DELETE("/service-accounts/:serviceAccountId", handlers.DeleteServiceAccountHandler)
+			idGroup.GET("/service-accounts/:serviceAccountId/secrets", handlers.ListServiceAccountSecretsHandler)
+			idGroup.POST("/service-accounts/:serviceAccountId/secrets", middleware.RequireInteractiveSession(), handlers.CreateServiceAccountSecretHandler)
+			idGroup.POST("/service-accounts/:serviceAccountId/secrets/rotate", middleware.RequireInteractiveSession(), handlers.RotateServiceAccountSecretHandler)
+			idGroup.DELETE("/service-accounts/:serviceAccountId/secrets/:secretId", handlers.RevokeServiceAccountSecretHandler)
+			idGroup.GET("/service-accounts/:serviceAccountId/activity", handlers.GetServiceAccountActivityHandler)
+
+			// OAuth clients: third-party apps acting for members (owner only).
+			// Client secrets are only issued from an interactive session
+			idGroup.GET("/oauth-clients", handlers.ListOAuthClientsHandler)
+			idGroup.POST("/oauth-clients", middleware.RequireInteractiveSession(), handlers.CreateOAuthClientHandler)
+			idGroup.PUT("/oauth-clients/:clientId", handlers.UpdateOAuthClientHandler)
+			idGroup.DELETE("/oauth-clients/:clientId", handlers.DeleteOAuthClientHandler)
+			idGroup.POST("/oauth-clients/:clientId/secret", middleware.RequireInteractiveSession(), handlers.RotateOAuthClientSecretHandler)
+
+			// SAML single sign-on (enterprise plan, owner only)
+			idGroup.GET("/saml", handlers.GetSAMLConfigHandler)
+			idGroup.PUT("/saml", middleware.DenyImpersonation(), handlers.UpdateSAMLConfigHandler)
+			idGroup.DELETE("/saml", middleware.DenyImpersonation(), handlers.DeleteSAMLConfigHandler)
 		}
 	}
 
@@ -17,5 +17,15 @@ func SetupOAuthRoutes(router gin.IRouter) {
 		oauth.GET("/google/callback", handlers.GoogleCallbackHandler)
 		oauth.GET("/facebook/callback", handlers.FacebookCallbackHandler)
 		oauth.GET("/github/callback", handlers.GithubCallbackHandler)
+
+		// OpenID Connect providers configured with OIDC_PROVIDERS
+		oauth.GET("/oidc", handlers.ListOIDCProvidersHandler)
+		oauth.GET("/oidc/:provider", handlers.OIDCLoginHandler)
+		oauth.GET("/oidc/:provider/callback", handlers.OIDCCallbackHandler)
+
+		// SAML single sign-on of enterprise companies
+		oauth.GET("/saml/:company/metadata", handlers.SAMLMetadataHandler)
+		oauth.GET("/saml/:company/login", handlers.SAMLLoginHandler)
+		oauth.POST("/saml/:company/acs", handlers.SAMLACSHandler)
 	}
 }
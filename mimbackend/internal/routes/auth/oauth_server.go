@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"mimbackend/internal/handlers"
+	"mimbackend/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupOAuthServerRoutes kurulu OAuth2 yetkilendirme sunucusunun (üçüncü parti
+// uygulamalar için) route'larını kurar
+func SetupOAuthServerRoutes(router gin.IRouter) {
+	oauth := router.Group("/oauth")
+	{
+		// Consent screen API for the signed-in user
+		oauth.GET("/authorize", middleware.JWTMiddleware(), handlers.OAuthAuthorizeHandler)
+		oauth.POST("/authorize", middleware.JWTMiddleware(), middleware.DenyImpersonation(), handlers.OAuthAuthorizeDecisionHandler)
+
+		// Client-authenticated endpoints (form-encoded)
+		oauth.POST("/token", handlers.OAuthTokenHandler)
+		oauth.POST("/introspect", handlers.OAuthIntrospectHandler)
+		oauth.POST("/revoke", handlers.OAuthRevokeHandler)
+
+		// Apps the user has authorized
+		oauth.GET("/authorizations", middleware.JWTMiddleware(), handlers.ListOAuthAuthorizationsHandler)
+		oauth.DELETE("/authorizations/:id", middleware.JWTMiddleware(), handlers.RevokeOAuthAuthorizationHandler)
+	}
+}
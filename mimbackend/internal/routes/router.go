@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"log"
 	"mimbackend/internal/handlers"
 	"mimbackend/internal/middleware"
 	authRoutes "mimbackend/internal/routes/auth"
@@ -8,6 +9,7 @@ import (
 	"mimbackend/internal/services"
 	"net/http"
 	"os"
+	"strings"
 
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -24,12 +26,19 @@ func NewRouter() *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 
+	// X-Forwarded-For / X-Real-IP are only believed from the reverse proxies in
+	// TRUSTED_PROXIES; otherwise c.ClientIP() is the connecting address
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// Global middleware
 	r.Use(middleware.CORSMiddleware())
 
 	// Basic routes
 	r.GET("/", handlers.HomeHandler)
 	r.GET("/health", handlers.HealthHandler)
+	r.GET("/.well-known/jwks.json", handlers.JWKSHandler)
 	r.GET("/user/me", middleware.JWTMiddleware(), func(c *gin.Context) {
 		userIDVal, _ := c.Get("user_id")
 		userID, ok := userIDVal.(uuid.UUID)
@@ -61,7 +70,23 @@ func NewRouter() *gin.Engine {
 		userGroup.GET("/sessions", handlers.GetUserSessionsHandler)
 		userGroup.GET("/sessions/history", handlers.GetUserSessionHistoryHandler)
 		userGroup.GET("/sessions/stats", handlers.GetUserSessionStatsHandler)
-		userGroup.DELETE("/sessions/:session_id", handlers.RevokeUserSessionHandler)
+		userGroup.DELETE("/sessions/:session_id", middleware.DenyImpersonation(), handlers.RevokeUserSessionHandler)
+
+		// Linked external login providers
+		userGroup.GET("/accounts", handlers.ListLinkedAccountsHandler)
+		userGroup.POST("/accounts/link", middleware.DenyImpersonation(), handlers.StartAccountLinkHandler)
+		userGroup.DELETE("/accounts/:id", middleware.DenyImpersonation(), handlers.UnlinkAccountHandler)
+
+		// Verified email change
+		userGroup.POST("/email-change", middleware.DenyImpersonation(), handlers.RequestEmailChangeHandler)
+
+		// Personal data export and account deletion (KVKK/GDPR)
+		// (not available while a super admin impersonates the user)
+		userGroup.POST("/data-export", middleware.DenyImpersonation(), handlers.RequestUserDataExportHandler)
+		userGroup.GET("/data-export/download", middleware.DenyImpersonation(), handlers.DownloadUserDataExportHandler)
+		userGroup.GET("/delete-account", handlers.GetAccountDeletionHandler)
+		userGroup.POST("/delete-account", middleware.DenyImpersonation(), handlers.RequestAccountDeletionHandler)
+		userGroup.DELETE("/delete-account", middleware.DenyImpersonation(), handlers.CancelAccountDeletionHandler)
 	}
 
 	// Swagger docs
@@ -84,17 +109,12 @@ func NewRouter() *gin.Engine {
 	{
 		authRoutes.SetupAuthRoutes(apiGroup)
 		authRoutes.SetupOAuthRoutes(apiGroup)
+		authRoutes.SetupOAuthServerRoutes(apiGroup)
 		authRoutes.SetupAPIRoutes(apiGroup)
 		authRoutes.SetupCompanyRoutes(apiGroup)
 		systemRoutes.SetupSystemRoutes(apiGroup)
 	}
 
-	// Debug endpoint
-	debugGroup := r.Group("/debug")
-	{
-		debugGroup.GET("/sessions", handlers.DebugSessionsHandler)
-	}
-
 	// Backwards-compatible alias: redirect /auth/* to {apiPrefix}/auth/*
 	r.Any("/auth/*any", func(c *gin.Context) {
 		path := c.Param("any")
@@ -115,3 +135,14 @@ func NewRouter() *gin.Engine {
 
 	return r
 }
+
+// trustedProxies reads the comma separated IPs or CIDRs of TRUSTED_PROXIES
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
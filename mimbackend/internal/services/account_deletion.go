@@ -0,0 +1,469 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"mimbackend/config"
+	"mimbackend/internal/cache"
+	auth "mimbackend/internal/models/auth"
+	basemodels "mimbackend/internal/models/basemodels"
+	companymodels "mimbackend/internal/models/company"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAccountDeletionGrace = 30 * 24 * time.Hour
+	accountDeletionInterval     = time.Hour
+	// anonymizedEmailDomain is a reserved TLD, so tombstone addresses can never receive mail
+	anonymizedEmailDomain = "deleted.invalid"
+)
+
+var (
+	ErrAccountDeletionPending   = errors.New("account deletion is already scheduled")
+	ErrAccountDeletionNotFound  = errors.New("no account deletion is scheduled")
+	ErrInvalidDeletionMode      = errors.New("invalid account deletion mode")
+	ErrSoleCompanyOwner         = errors.New("user is the sole owner of a company")
+	ErrInvalidOwnershipTransfer = errors.New("new owner must be an active member of the company")
+)
+
+// OwnershipTransfer names the member who takes over a company from a user
+// deleting their account
+type OwnershipTransfer struct {
+	CompanyID  uuid.UUID `json:"company_id" binding:"required"`
+	NewOwnerID uuid.UUID `json:"new_owner_id" binding:"required"`
+}
+
+// SoleOwnedCompany is a company that would be left without an owner
+type SoleOwnedCompany struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+// SoleOwnershipError lists the companies blocking an account deletion
+type SoleOwnershipError struct {
+	Companies []SoleOwnedCompany
+}
+
+func (e *SoleOwnershipError) Error() string {
+	return fmt.Sprintf("%s (%d companies)", ErrSoleCompanyOwner, len(e.Companies))
+}
+
+func (e *SoleOwnershipError) Unwrap() error {
+	return ErrSoleCompanyOwner
+}
+
+// AccountDeletionGrace is how long a requested deletion waits before it runs
+// (ACCOUNT_DELETION_GRACE_DAYS, default 30). The user can cancel until then.
+func AccountDeletionGrace() time.Duration {
+	if days, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS")); err == nil && days >= 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return defaultAccountDeletionGrace
+}
+
+// RequestAccountDeletion schedules the user's account for deletion after the
+// grace period. Companies the user is the sole owner of must be handed over:
+// each transfer makes the named member an owner right away, and any sole-owned
+// company left without one refuses the request with a *SoleOwnershipError.
+func RequestAccountDeletion(user *auth.User, mode string, transfers []OwnershipTransfer) (*auth.AccountDeletionRequest, error) {
+	if mode == "" {
+		mode = auth.AccountDeletionAnonymize
+	}
+	if mode != auth.AccountDeletionAnonymize && mode != auth.AccountDeletionHardDelete {
+		return nil, ErrInvalidDeletionMode
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var existing int64
+	if err := db.Model(&auth.AccountDeletionRequest{}).
+		Where("user_id = ? AND status <> ?", user.ID, auth.AccountDeletionCompleted).
+		Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, ErrAccountDeletionPending
+	}
+
+	soleOwned, err := soleOwnedCompanies(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	transferTo := map[uuid.UUID]uuid.UUID{}
+	for _, t := range transfers {
+		transferTo[t.CompanyID] = t.NewOwnerID
+	}
+	var blocking []SoleOwnedCompany
+	for _, company := range soleOwned {
+		if _, ok := transferTo[company.ID]; !ok {
+			blocking = append(blocking, company)
+		}
+	}
+	if len(blocking) > 0 {
+		return nil, &SoleOwnershipError{Companies: blocking}
+	}
+
+	request := &auth.AccountDeletionRequest{
+		UserID:       user.ID,
+		Mode:         mode,
+		Status:       auth.AccountDeletionPending,
+		ScheduledFor: time.Now().Add(AccountDeletionGrace()),
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, company := range soleOwned {
+			if err := transferCompanyOwnership(tx, company.ID, user.ID, transferTo[company.ID]); err != nil {
+				return err
+			}
+		}
+		// A completed request of an earlier, restored account must not block the unique index
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&auth.AccountDeletionRequest{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(request).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, company := range soleOwned {
+		_ = cache.InvalidateCompanyMembersCache(context.Background(), company.ID)
+	}
+
+	emailService := NewEmailService()
+	if err := emailService.SendAccountDeletionEmail(user.Email, user.FullName, request.ScheduledFor, emailService.frontendURL+"/auth/login"); err != nil {
+		log.Printf("RequestAccountDeletion: failed to notify %s: %v", user.Email, err)
+	}
+
+	return request, nil
+}
+
+// GetAccountDeletion returns the scheduled deletion of the user
+func GetAccountDeletion(userID uuid.UUID) (*auth.AccountDeletionRequest, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var request auth.AccountDeletionRequest
+	if err := db.Where("user_id = ? AND status = ?", userID, auth.AccountDeletionPending).First(&request).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAccountDeletionNotFound
+		}
+		return nil, err
+	}
+	return &request, nil
+}
+
+// CancelAccountDeletion drops a scheduled deletion that has not started yet.
+// Ownership handed over with the request stays with the new owners.
+func CancelAccountDeletion(userID uuid.UUID) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	result := db.Unscoped().Where("user_id = ? AND status = ?", userID, auth.AccountDeletionPending).
+		Delete(&auth.AccountDeletionRequest{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccountDeletionNotFound
+	}
+	return nil
+}
+
+// StartAccountDeletionWorker runs due account deletions now and then hourly
+// until ctx is done
+func StartAccountDeletionWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(accountDeletionInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := ProcessDueAccountDeletions(); err != nil {
+				log.Printf("Account deletion worker: %v", err)
+			} else if n > 0 {
+				log.Printf("Account deletion worker: erased %d accounts", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ProcessDueAccountDeletions erases the accounts whose grace period is over
+// and returns how many were erased. Each request is claimed with a
+// conditional update, so several instances can run the worker side by side.
+func ProcessDueAccountDeletions() (int, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return 0, err
+	}
+
+	// A run that died midway left its request running; it is taken over after a while
+	stale := time.Now().Add(-accountDeletionInterval)
+	claimable := db.Where("status = ? OR (status = ? AND updated_at < ?)", auth.AccountDeletionPending, auth.AccountDeletionRunning, stale)
+
+	var due []auth.AccountDeletionRequest
+	if err := db.Where(claimable).Where("scheduled_for <= ?", time.Now()).
+		Order("scheduled_for ASC").
+		Limit(100).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	erased := 0
+	for _, request := range due {
+		claim := db.Model(&auth.AccountDeletionRequest{}).
+			Where("id = ? AND status = ? AND updated_at = ?", request.ID, request.Status, request.UpdatedAt).
+			Updates(map[string]interface{}{"status": auth.AccountDeletionRunning, "updated_at": time.Now()})
+		if claim.Error != nil {
+			return erased, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		if err := eraseUser(db, request.UserID, request.Mode); err != nil {
+			// Leave it for the next run; a sole owner has to transfer ownership first
+			log.Printf("Account deletion of user %s failed: %v", request.UserID, err)
+			reason := err.Error()
+			if len(reason) > 500 {
+				reason = reason[:500]
+			}
+			db.Model(&auth.AccountDeletionRequest{}).Where("id = ?", request.ID).Updates(map[string]interface{}{
+				"status":     auth.AccountDeletionPending,
+				"last_error": reason,
+			})
+			continue
+		}
+
+		now := time.Now()
+		if err := db.Model(&auth.AccountDeletionRequest{}).Where("id = ?", request.ID).Updates(map[string]interface{}{
+			"status":       auth.AccountDeletionCompleted,
+			"completed_at": now,
+			"last_error":   "",
+		}).Error; err != nil {
+			log.Printf("Account deletion of user %s: failed to mark completed: %v", request.UserID, err)
+		}
+		log.Printf("🗑️  Account erased: user_id=%s, mode=%s", request.UserID, request.Mode)
+		erased++
+	}
+	return erased, nil
+}
+
+// eraseUser removes the personal data of a user. Memberships, sessions,
+// credentials and security history are deleted in both modes; anonymize keeps
+// the user row as a soft-deleted tombstone so references from invitations and
+// audit fields stay valid, delete removes it as well.
+func eraseUser(db *gorm.DB, userID uuid.UUID, mode string) error {
+	var user auth.User
+	if err := db.Unscoped().First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	soleOwned, err := soleOwnedCompanies(db, userID)
+	if err != nil {
+		return err
+	}
+	if len(soleOwned) > 0 {
+		return &SoleOwnershipError{Companies: soleOwned}
+	}
+
+	var members []companymodels.CompanyMember
+	if err := db.Unscoped().Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		return err
+	}
+	var sessionIDs []string
+	if err := db.Model(&auth.UserSession{}).Where("user_id = ?", userID).Pluck("session_id", &sessionIDs).Error; err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		userScoped := []interface{}{
+			&companymodels.CompanyMember{},
+			&auth.Account{},
+			&auth.UserSession{},
+			&auth.RefreshToken{},
+			&auth.PersonalAccessToken{},
+			&auth.OAuthAuthorizationCode{},
+			&auth.OAuthConsent{},
+			&auth.UserTOTP{},
+			&auth.MFARecoveryCode{},
+			&auth.MFAChallenge{},
+			&auth.WebAuthnCredential{},
+			&auth.WebAuthnCeremony{},
+			&auth.PasswordHistory{},
+			&auth.PasswordResetRequest{},
+			&auth.UserPermission{},
+			&auth.OTP{},
+			&auth.ImpersonationActivity{},
+		}
+		for _, model := range userScoped {
+			if err := tx.Unscoped().Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Unscoped().Where("user_id = ? OR email = ?", userID, user.Email).Delete(&auth.LoginAttempt{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().
+			Where("identifier IN ? OR identifier LIKE ? OR identifier LIKE ?",
+				[]string{user.Email, magicLinkPrefix + user.Email, loginUnlockPrefix + user.Email},
+				emailChangePrefix+userID.String()+":%",
+				emailChangeCancelPrefix+userID.String()+":%").
+			Delete(&auth.VerificationToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("email = ?", user.Email).Delete(&companymodels.CompanyInvitation{}).Error; err != nil {
+			return err
+		}
+		if user.Phone != nil && *user.Phone != "" {
+			if err := tx.Unscoped().Where("phone = ?", *user.Phone).Delete(&auth.OTP{}).Error; err != nil {
+				return err
+			}
+		}
+
+		// Responsibilities and the legacy owner column point at nobody afterwards
+		for _, model := range []interface{}{&companymodels.Branch{}, &companymodels.Department{}} {
+			if err := tx.Unscoped().Model(model).Where("authorized_user_id = ?", userID).
+				Update("authorized_user_id", nil).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Model(&companymodels.Company{}).Where("user_id = ?", userID).
+			Update("user_id", nil).Error; err != nil {
+			return err
+		}
+
+		if mode == auth.AccountDeletionHardDelete {
+			// Invitations the user sent cascade with the user row
+			return tx.Unscoped().Delete(&auth.User{}, "id = ?", userID).Error
+		}
+
+		now := time.Now()
+		return tx.Unscoped().Model(&auth.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"email":               fmt.Sprintf("deleted-%s@%s", userID.String(), anonymizedEmailDomain),
+			"phone":               nil,
+			"image_url":           nil,
+			"full_name":           nil,
+			"password_hash":       "",
+			"is_verified":         false,
+			"phone_verified":      false,
+			"role_id":             nil,
+			"role":                "user",
+			"active_company_id":   nil,
+			"reset_token":         nil,
+			"reset_token_expires": nil,
+			"deleted_at":          now,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// Access tokens of the deleted sessions stop working on their next request
+	invalidateSessionTokenState(sessionIDs...)
+
+	userSubject := fmt.Sprintf("user:%s", userID.String())
+	for _, member := range members {
+		domain := BuildDomainID(&member.CompanyID)
+		if _, err := DeleteRoleForUser(userSubject, fmt.Sprintf("role:%s", member.RoleID.String()), domain); err != nil {
+			log.Printf("warning: failed to remove role assignment for user %s: %v", userSubject, err)
+		}
+		_ = cache.InvalidateCompanyMembersCache(context.Background(), member.CompanyID)
+	}
+	return nil
+}
+
+// soleOwnedCompanies returns the active companies in which the user is the
+// only owner
+func soleOwnedCompanies(db *gorm.DB, userID uuid.UUID) ([]SoleOwnedCompany, error) {
+	var companies []companymodels.Company
+	if err := db.Model(&companymodels.Company{}).
+		Joins("JOIN company_members cm ON cm.company_id = companies.id AND cm.deleted_at IS NULL").
+		Where("cm.user_id = ? AND cm.is_owner = ?", userID, true).
+		Where("NOT EXISTS (SELECT 1 FROM company_members other WHERE other.company_id = companies.id AND other.user_id <> ? AND other.is_owner = ? AND other.deleted_at IS NULL)", userID, true).
+		Find(&companies).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]SoleOwnedCompany, 0, len(companies))
+	for _, company := range companies {
+		name := ""
+		if company.Name != nil {
+			name = *company.Name
+		} else if company.Title != nil {
+			name = *company.Title
+		}
+		result = append(result, SoleOwnedCompany{ID: company.ID, Name: name})
+	}
+	return result, nil
+}
+
+// transferCompanyOwnership makes newOwnerID an owner of the company with the
+// company_owner role. The previous owner keeps their membership until their
+// account is erased.
+func transferCompanyOwnership(tx *gorm.DB, companyID, previousOwnerID, newOwnerID uuid.UUID) error {
+	if newOwnerID == previousOwnerID {
+		return ErrInvalidOwnershipTransfer
+	}
+
+	var member companymodels.CompanyMember
+	if err := tx.Where("company_id = ? AND user_id = ? AND is_active = ? AND status = ? AND is_service_account = ?",
+		companyID, newOwnerID, true, "active", false).
+		First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidOwnershipTransfer
+		}
+		return err
+	}
+
+	var ownerRole basemodels.Role
+	if err := tx.Where("name = ? AND company_id = ?", "company_owner", companyID).First(&ownerRole).Error; err != nil {
+		if err := tx.Where("name = ? AND company_id IS NULL", "company_owner").First(&ownerRole).Error; err != nil {
+			return fmt.Errorf("role not found: %w", err)
+		}
+	}
+
+	oldRoleID := member.RoleID
+	if err := tx.Model(&member).Updates(map[string]interface{}{"is_owner": true, "role_id": ownerRole.ID}).Error; err != nil {
+		return fmt.Errorf("failed to transfer ownership: %w", err)
+	}
+	if err := tx.Model(&companymodels.Company{}).Where("id = ? AND (user_id = ? OR user_id IS NULL)", companyID, previousOwnerID).
+		Update("user_id", newOwnerID).Error; err != nil {
+		return err
+	}
+
+	domain := BuildDomainID(&companyID)
+	userSubject := fmt.Sprintf("user:%s", newOwnerID.String())
+	if oldRoleID != ownerRole.ID {
+		if _, err := DeleteRoleForUser(userSubject, fmt.Sprintf("role:%s", oldRoleID.String()), domain); err != nil {
+			log.Printf("warning: failed to remove old role assignment for user %s: %v", userSubject, err)
+		}
+	}
+	if _, err := AddRoleForUser(userSubject, fmt.Sprintf("role:%s", ownerRole.ID.String()), domain); err != nil {
+		log.Printf("warning: failed to add owner role assignment for user %s: %v", userSubject, err)
+	}
+
+	log.Printf("👑 Company ownership transferred: company_id=%s, from=%s, to=%s", companyID, previousOwnerID, newOwnerID)
+	return nil
+}
@@ -3,15 +3,12 @@ package services
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
 	"mimbackend/config"
@@ -19,17 +16,49 @@ import (
 	basemodels "mimbackend/internal/models/basemodels"
 )
 
-var jwtSecret = []byte("your-secret-key-change-this-in-production")
-
 type Claims struct {
 	UserID uuid.UUID `json:"-"`
 	Email  string    `json:"email"`
 	Role   string    `json:"role"`
+	// SessionID and TokenVersion bind the token to a UserSession so it can be
+	// revoked before it expires
+	SessionID    string `json:"sid,omitempty"`
+	TokenVersion int    `json:"ver,omitempty"`
+	// Set on tokens the OAuth2 authorization server issues to third-party
+	// apps: the client, its granted scopes (space separated) and the company
+	// it is limited to.
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	CompanyID string `json:"cid,omitempty"`
+	// TokenUse tells refresh tokens from access tokens (TokenUseAccess, TokenUseRefresh)
+	TokenUse string `json:"use,omitempty"`
+	// Actor is set while a super admin impersonates the user (RFC 8693 "act")
+	Actor *ActorClaim `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
-type sessionTokenPayload struct {
-	Token string `json:"token"`
+const (
+	// TokenUseAccess and TokenUseRefresh are the values of the "use" claim
+	TokenUseAccess  = "access"
+	TokenUseRefresh = "refresh"
+
+	sessionAccessTokenTTL  = time.Hour
+	sessionRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// IsRefreshToken reports whether the token may only be exchanged at a token
+// endpoint. Tokens signed before the "use" claim existed are told apart by
+// their lifetime, which is longer than any access token's.
+func (c *Claims) IsRefreshToken() bool {
+	switch c.TokenUse {
+	case TokenUseRefresh:
+		return true
+	case "":
+		return c.ExpiresAt != nil && c.IssuedAt != nil &&
+			c.ExpiresAt.Sub(c.IssuedAt.Time) > sessionAccessTokenTTL
+	default:
+		return false
+	}
 }
 
 func hashRefreshToken(token string) string {
@@ -40,26 +69,59 @@ func hashRefreshToken(token string) string {
 	return hex.EncodeToString(sum[:])
 }
 
-// HashPassword şifreyi hashler
+// HashPassword şifreyi güncel algoritma ile hashler (PASSWORD_HASH_ALGORITHM)
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	return currentPasswordHasher().Hash(password)
+}
+
+// CheckPassword şifre doğrulama. needsRehash is true when the password matched
+// a hash made with another algorithm or outdated parameters; the caller should
+// then store a fresh hash with RehashPassword.
+func CheckPassword(password, hash string) (ok bool, needsRehash bool) {
+	hasher, err := passwordHasherFor(hash)
+	if err != nil {
+		return false, false
+	}
+	ok, err = hasher.Verify(password, hash)
+	if err != nil || !ok {
+		return false, false
+	}
+
+	current := currentPasswordHasher()
+	return true, !current.Recognizes(hash) || current.Outdated(hash)
 }
 
-// CheckPassword şifre doğrulama
-func CheckPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// RehashPassword replaces a user's outdated password hash. It only writes when
+// the stored hash is still oldHash, so a concurrent password change wins.
+func RehashPassword(userID uuid.UUID, password, oldHash string) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	newHash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return db.Model(&auth.User{}).
+		Where("id = ? AND password_hash = ?", userID, oldHash).
+		Update("password_hash", newHash).Error
 }
 
-// GenerateTokens creates an access token (1 hour) and refresh token (30 days)
-func GenerateTokens(userID uuid.UUID, email, role string) (accessToken string, refreshToken string, err error) {
+// GenerateSessionTokens creates a token pair bound to a user session. Access
+// tokens are only accepted while the session is active and its token version
+// still equals tokenVersion. The refresh token is marked with TokenUseRefresh
+// so it is never accepted as a bearer token.
+func GenerateSessionTokens(userID uuid.UUID, email, role, sessionID string, tokenVersion int) (accessToken string, refreshToken string, err error) {
 	// Access token: 1 hour
-	accessExp := time.Now().Add(1 * time.Hour)
+	accessExp := time.Now().Add(sessionAccessTokenTTL)
 	accessClaims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
+		UserID:       userID,
+		Email:        email,
+		Role:         role,
+		SessionID:    sessionID,
+		TokenVersion: tokenVersion,
+		TokenUse:     TokenUseAccess,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(accessExp),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -69,18 +131,20 @@ func GenerateTokens(userID uuid.UUID, email, role string) (accessToken string, r
 		},
 	}
 
-	accessTok := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessString, err := accessTok.SignedString(jwtSecret)
+	accessString, err := signJWT(accessClaims)
 	if err != nil {
 		return "", "", err
 	}
 
 	// Refresh token: 30 days
-	refreshExp := time.Now().Add(30 * 24 * time.Hour)
+	refreshExp := time.Now().Add(sessionRefreshTokenTTL)
 	refreshClaims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
+		UserID:       userID,
+		Email:        email,
+		Role:         role,
+		SessionID:    sessionID,
+		TokenVersion: tokenVersion,
+		TokenUse:     TokenUseRefresh,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(refreshExp),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -90,8 +154,7 @@ func GenerateTokens(userID uuid.UUID, email, role string) (accessToken string, r
 		},
 	}
 
-	refreshTok := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshString, err := refreshTok.SignedString(jwtSecret)
+	refreshString, err := signJWT(refreshClaims)
 	if err != nil {
 		return "", "", err
 	}
@@ -103,9 +166,8 @@ func GenerateTokens(userID uuid.UUID, email, role string) (accessToken string, r
 func ValidateJWT(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, jwtKeyFunc,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}))
 
 	if err != nil {
 		log.Printf("ValidateJWT: Parse error: %v", err)
@@ -155,85 +217,6 @@ func CreateUser(user *auth.User) error {
 	return nil
 }
 
-// CreateSession saves a session record (we'll store refresh token here)
-func CreateSession(userID uuid.UUID, token string, expiresAt time.Time) error {
-	db, err := config.NewConnection()
-	if err != nil {
-		return err
-	}
-
-	// compute SHA256 hash for token and store both raw token and hash
-	payload := sessionTokenPayload{Token: token}
-	tokenJSON, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-	tokenHash := hashRefreshToken(token)
-
-	session := &auth.Session{
-		UserID:    userID,
-		TokenData: tokenJSON,
-		TokenHash: tokenHash,
-		ExpiresAt: expiresAt,
-	}
-
-	if err := db.Create(session).Error; err != nil {
-		log.Printf("CreateSession failed: %v, userID: %s, token length: %d", err, userID, len(token))
-		return err
-	}
-
-	// Log token head/tail for debugging (do NOT log full token)
-	head := ""
-	tail := ""
-	if len(token) >= 16 {
-		head = token[:8]
-		tail = token[len(token)-8:]
-	} else {
-		head = token
-		tail = token
-	}
-	log.Printf("CreateSession: saved session userID=%s token_len=%d token_head_tail=%s...%s expiresAt=%s hash_prefix=%s", userID, len(token), head, tail, expiresAt.String(), tokenHash[:8])
-
-	return nil
-}
-
-// UpdateSessionToken rotates refresh token within an existing session row
-func UpdateSessionToken(sessionID uuid.UUID, token string, expiresAt time.Time) error {
-	db, err := config.NewConnection()
-	if err != nil {
-		return err
-	}
-
-	payload := sessionTokenPayload{Token: token}
-	tokenJSON, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-	tokenHash := hashRefreshToken(token)
-
-	updates := map[string]interface{}{
-		"token_data": tokenJSON,
-		"token_hash": tokenHash,
-		"expires_at": expiresAt,
-	}
-
-	id := sessionID.String()
-	result := db.Model(&auth.Session{}).Where("id = ?", id).Updates(updates)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return errors.New("session not found")
-	}
-
-	hashPreview := ""
-	if tokenHash != "" && len(tokenHash) >= 8 {
-		hashPreview = tokenHash[:8]
-	}
-	log.Printf("UpdateSessionToken: session_id=%s expiresAt=%s hash_prefix=%s", id, expiresAt.String(), hashPreview)
-	return nil
-}
-
 // CreateAccount creates a local account record (for email/password registrations)
 func CreateAccount(userID uuid.UUID, provider, providerID, accessToken, refreshToken string, expiresAt int64) error {
 	db, err := config.NewConnection()
@@ -243,10 +226,15 @@ func CreateAccount(userID uuid.UUID, provider, providerID, accessToken, refreshT
 
 	var account auth.Account
 	if err := db.Where("provider = ? AND provider_id = ?", provider, providerID).First(&account).Error; err == nil {
-		// update tokens
+		// update tokens; providers like Google only send a refresh token on first consent
 		account.AccessToken = accessToken
-		account.RefreshToken = refreshToken
+		if refreshToken != "" {
+			account.RefreshToken = refreshToken
+		}
 		account.ExpiresAt = expiresAt
+		if err := sealAccountTokens(&account); err != nil {
+			return err
+		}
 		if err := db.Save(&account).Error; err != nil {
 			return err
 		}
@@ -262,221 +250,17 @@ func CreateAccount(userID uuid.UUID, provider, providerID, accessToken, refreshT
 		RefreshToken: refreshToken,
 		ExpiresAt:    expiresAt,
 	}
-
-	if err := db.Create(&account).Error; err != nil {
+	if err := sealAccountTokens(&account); err != nil {
 		return err
 	}
 
-	return nil
-}
-
-// GetSessionByToken returns a session by token
-func GetSessionByToken(token string) (*auth.Session, error) {
-	db, err := config.NewConnection()
-	if err != nil {
-		return nil, err
-	}
-
-	var sess auth.Session
-	// Primary lookup via token hash (matches current sessions table structure)
-	hash := hashRefreshToken(token)
-	hashPreview := hash
-	if len(hashPreview) > 16 {
-		hashPreview = hashPreview[:16]
-	}
-	log.Printf("GetSessionByToken: attempting lookup len=%d hash_prefix=%s", len(token), hashPreview)
-	queryErr := db.Where("token_hash = ?", hash).First(&sess).Error
-	if queryErr != nil {
-		// Fallback to JSON lookup for legacy rows where hash may be missing
-		if err := db.Where("JSON_UNQUOTE(JSON_EXTRACT(token_data, '$.token')) = ?", token).First(&sess).Error; err != nil {
-			log.Printf("GetSessionByToken: lookup failed len=%d hash_err=%v json_err=%v", len(token), queryErr, err)
-			logRecentSessions(db, 5)
-			return nil, err
-		}
-	}
-
-	var payload sessionTokenPayload
-	if len(sess.TokenData) > 0 {
-		if err := json.Unmarshal(sess.TokenData, &payload); err != nil {
-			log.Printf("GetSessionByToken: failed to unmarshal token JSON for session id=%d: %v", sess.ID, err)
-		}
-	}
-
-	// compute hash only for debug (not stored in DB)
-	resultHashPreview := ""
-	if hash != "" && len(hash) >= 8 {
-		resultHashPreview = hash[:8]
-	}
-	log.Printf("GetSessionByToken: found session id=%d userID=%s token_present=%t hash_prefix=%s", sess.ID, sess.UserID, payload.Token != "", resultHashPreview)
-
-	return &sess, nil
-}
-
-func logRecentSessions(db *gorm.DB, limit int) {
-	var sessions []auth.Session
-	if err := db.Order("id DESC").Limit(limit).Find(&sessions).Error; err != nil {
-		log.Printf("logRecentSessions: error listing sessions: %v", err)
-		return
-	}
-
-	log.Printf("logRecentSessions: showing last %d sessions", len(sessions))
-	for _, sess := range sessions {
-		tokenDataPreview := ""
-		if len(sess.TokenData) > 0 {
-			var tokenPayload map[string]any
-			if err := json.Unmarshal(sess.TokenData, &tokenPayload); err != nil {
-				tokenDataPreview = fmt.Sprintf("invalid json len=%d", len(sess.TokenData))
-			} else if token, ok := tokenPayload["token"].(string); ok {
-				head := token
-				tail := token
-				if len(token) > 16 {
-					head = token[:8]
-					tail = token[len(token)-8:]
-				}
-				if len(token) > 16 {
-					tokenDataPreview = fmt.Sprintf("token:%s...%s", head, tail)
-				} else {
-					tokenDataPreview = fmt.Sprintf("token:%s", token)
-				}
-			} else {
-				tokenDataPreview = "token missing"
-			}
-		} else {
-			tokenDataPreview = "no token_data"
-		}
-
-		hashPreview := sess.TokenHash
-		if len(hashPreview) > 18 {
-			hashPreview = fmt.Sprintf("%s...", hashPreview[:18])
-		}
-
-		log.Printf(
-			"logRecentSessions: id=%d user=%s hash=%s %s expires=%s updated=%s",
-			sess.ID,
-			sess.UserID,
-			hashPreview,
-			tokenDataPreview,
-			sess.ExpiresAt.Format(time.RFC3339),
-			sess.UpdatedAt.Format(time.RFC3339),
-		)
-	}
-}
-
-// DeleteSession removes a session by token (the DB stores refresh tokens in `token` column)
-func DeleteSession(refreshToken string) error {
-	db, err := config.NewConnection()
-	if err != nil {
-		return err
-	}
-	// Delete by matching the hashed token (current schema) and fallback to JSON for older rows
-	hash := hashRefreshToken(refreshToken)
-	result := db.Where("token_hash = ?", hash).Delete(&auth.Session{})
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		result = db.Where("JSON_UNQUOTE(JSON_EXTRACT(token_data, '$.token')) = ?", refreshToken).Delete(&auth.Session{})
-		if result.Error != nil {
-			return result.Error
-		}
-	}
-
-	return nil
-}
-
-// DeleteAllSessionsForUser removes all sessions for a user
-func DeleteAllSessionsForUser(userID uuid.UUID) error {
-	db, err := config.NewConnection()
-	if err != nil {
+	if err := db.Create(&account).Error; err != nil {
 		return err
 	}
 
-	result := db.Where("user_id = ?", userID).Delete(&auth.Session{})
-	if result.Error != nil {
-		return result.Error
-	}
-
 	return nil
 }
 
-// RefreshTokens accepts a refresh token, validates it against stored sessions,
-// rotates the refresh token and returns new access + refresh tokens.
-func RefreshTokens(refreshToken string) (newAccess string, newRefresh string, err error) {
-	// Debug: log incoming token head/tail
-	if len(refreshToken) >= 16 {
-		log.Printf("RefreshTokens: incoming token head_tail=%s...%s", refreshToken[:8], refreshToken[len(refreshToken)-8:])
-	} else {
-		log.Printf("RefreshTokens: incoming token (short)=%s", refreshToken)
-	}
-
-	// Validate token structure and claims
-	claims, err := ValidateJWT(refreshToken)
-	if err != nil {
-		// log validation error
-		log.Printf("RefreshTokens: ValidateJWT error: %v", err)
-		return "", "", err
-	}
-
-	// Find session
-	sess, err := GetSessionByToken(refreshToken)
-	if err != nil {
-		log.Printf("RefreshTokens: GetSessionByToken error for token len=%d: %v", len(refreshToken), err)
-		return "", "", err
-	}
-
-	// Debug: log the token parsed from session token_data for correlation
-	if len(sess.TokenData) > 0 {
-		if parsed, pErr := ParseTokenFromJSON(sess.TokenData); pErr == nil {
-			t := parsed.Token
-			if len(t) >= 16 {
-				log.Printf("RefreshTokens: matched session token head_tail=%s...%s", t[:8], t[len(t)-8:])
-			} else {
-				log.Printf("RefreshTokens: matched session token (short)=%s", t)
-			}
-		}
-	}
-
-	// Check expiry
-	if time.Now().After(sess.ExpiresAt) {
-		// session expired
-		_ = DeleteSession(refreshToken)
-		return "", "", errors.New("refresh token expired")
-	}
-
-	// Load user to get email and role
-	user, err := GetUserByID(claims.UserID)
-	if err != nil {
-		return "", "", err
-	}
-
-	// Generate new tokens (rotate)
-	accessTok, refreshTok, err := GenerateTokens(user.ID, user.Email, user.Role)
-	if err != nil {
-		return "", "", err
-	}
-
-	// Rotate token in the existing session row to avoid orphaned records
-	refreshExp := time.Now().Add(30 * 24 * time.Hour)
-	updateErr := UpdateSessionToken(sess.ID, refreshTok, refreshExp)
-	if updateErr != nil {
-		log.Printf("RefreshTokens: UpdateSessionToken failed, falling back to new session creation: %v", updateErr)
-		if createErr := CreateSession(user.ID, refreshTok, refreshExp); createErr != nil {
-			return "", "", createErr
-		}
-		if delErr := DeleteSession(refreshToken); delErr != nil {
-			log.Printf("RefreshTokens: DeleteSession fallback error: %v", delErr)
-		}
-	}
-
-	if postSess, postErr := GetSessionByToken(refreshTok); postErr != nil {
-		log.Printf("RefreshTokens: post-rotation lookup failed len=%d err=%v", len(refreshTok), postErr)
-	} else {
-		log.Printf("RefreshTokens: post-rotation session id=%s expires=%s", postSess.ID, postSess.ExpiresAt.String())
-	}
-
-	return accessTok, refreshTok, nil
-}
-
 // GetUserByEmail email ile kullanıcı bulur
 func GetUserByEmail(email string) (*auth.User, error) {
 	db, err := config.NewConnection()
@@ -551,28 +335,6 @@ func UpdateUserVerifiedStatus(email string, isVerified bool) error {
 	return nil
 }
 
-// GetLastSessions returns the last N sessions for debugging
-func GetLastSessions(limit int) ([]auth.Session, error) {
-	db, err := config.NewConnection()
-	if err != nil {
-		return nil, err
-	}
-
-	var sessions []auth.Session
-	err = db.Order("created_at DESC").Limit(limit).Find(&sessions).Error
-	return sessions, err
-}
-
-// ParseTokenFromJSON parses token from JSON TokenData
-func ParseTokenFromJSON(tokenData []byte) (*sessionTokenPayload, error) {
-	var payload sessionTokenPayload
-	err := json.Unmarshal(tokenData, &payload)
-	if err != nil {
-		return nil, err
-	}
-	return &payload, nil
-}
-
 // syncUserRole senkronize eder User.Role field'ini Role tablosundan
 func syncUserRole(db *gorm.DB, user *auth.User) error {
 	if user.RoleID == nil {
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cookie auth: tokens live in HttpOnly cookies the frontend never reads. State
+// changing requests authenticated by cookie must echo the readable csrf_token
+// cookie in the X-CSRF-Token header (double-submit).
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFCookie         = "csrf_token"
+	CSRFHeader         = "X-CSRF-Token"
+
+	accessCookieMaxAge  = time.Hour
+	refreshCookieMaxAge = 30 * 24 * time.Hour
+)
+
+// AuthCookieSettings are the per-environment attributes of the auth cookies
+type AuthCookieSettings struct {
+	Secure   bool
+	SameSite http.SameSite
+	Domain   string
+	// CookieOnly leaves the tokens out of the login and register response bodies
+	CookieOnly bool
+}
+
+// AuthCookieConfig reads AUTH_COOKIE_SECURE (default true in production),
+// AUTH_COOKIE_SAMESITE (lax, strict or none; default lax), AUTH_COOKIE_DOMAIN
+// and AUTH_COOKIE_ONLY (default false)
+func AuthCookieConfig() AuthCookieSettings {
+	settings := AuthCookieSettings{
+		Secure:   os.Getenv("ENV") == "production" || os.Getenv("NODE_ENV") == "production",
+		SameSite: http.SameSiteLaxMode,
+		Domain:   os.Getenv("AUTH_COOKIE_DOMAIN"),
+	}
+	if v, err := strconv.ParseBool(os.Getenv("AUTH_COOKIE_SECURE")); err == nil {
+		settings.Secure = v
+	}
+	settings.CookieOnly, _ = strconv.ParseBool(os.Getenv("AUTH_COOKIE_ONLY"))
+	switch strings.ToLower(os.Getenv("AUTH_COOKIE_SAMESITE")) {
+	case "strict":
+		settings.SameSite = http.SameSiteStrictMode
+	case "none":
+		// Browsers drop SameSite=None cookies that are not Secure
+		settings.SameSite = http.SameSiteNoneMode
+		settings.Secure = true
+	}
+	return settings
+}
+
+// SetAuthCookies stores the tokens in HttpOnly cookies and issues a fresh CSRF token
+func SetAuthCookies(c *gin.Context, accessToken, refreshToken string) error {
+	csrfToken, err := generateCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	settings := AuthCookieConfig()
+	setAuthCookie(c, settings, AccessTokenCookie, accessToken, accessCookieMaxAge, true)
+	setAuthCookie(c, settings, RefreshTokenCookie, refreshToken, refreshCookieMaxAge, true)
+	// Readable by the frontend so it can send it back in X-CSRF-Token
+	setAuthCookie(c, settings, CSRFCookie, csrfToken, refreshCookieMaxAge, false)
+	return nil
+}
+
+// ClearAuthCookies removes the auth and CSRF cookies
+func ClearAuthCookies(c *gin.Context) {
+	settings := AuthCookieConfig()
+	for _, name := range []string{AccessTokenCookie, RefreshTokenCookie, CSRFCookie} {
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			Domain:   settings.Domain,
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: name != CSRFCookie,
+			Secure:   settings.Secure,
+			SameSite: settings.SameSite,
+		})
+	}
+}
+
+// ValidCSRFToken reports whether the X-CSRF-Token header matches the csrf_token
+// cookie. Safe methods (GET, HEAD, OPTIONS) always pass.
+func ValidCSRFToken(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	cookie, err := c.Cookie(CSRFCookie)
+	if err != nil || cookie == "" {
+		return false
+	}
+	header := c.GetHeader(CSRFHeader)
+	return header != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+func setAuthCookie(c *gin.Context, settings AuthCookieSettings, name, value string, maxAge time.Duration, httpOnly bool) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   settings.Domain,
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: httpOnly,
+		Secure:   settings.Secure,
+		SameSite: settings.SameSite,
+	})
+}
+
+func generateCSRFToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
@@ -452,6 +452,14 @@ func UpdateCompanyModules(companyID uuid.UUID, modules *companymodels.CompanyMod
 	return UpdateCompany(companyID, updates)
 }
 
+// UpdateCompanyPasswordPolicy şirketin şifre politikasını günceller
+func UpdateCompanyPasswordPolicy(companyID uuid.UUID, policy *companymodels.PasswordPolicy) error {
+	updates := map[string]interface{}{
+		"password_policy": policy,
+	}
+	return UpdateCompany(companyID, updates)
+}
+
 // DeleteCompany Company'yi soft delete yapar
 func DeleteCompany(id uuid.UUID, deletedBy uuid.UUID) error {
 	db, err := config.NewConnection()
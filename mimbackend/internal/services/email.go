@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"net/smtp"
 	"os"
+	"time"
 )
 
 // EmailService struct for email operations
@@ -121,6 +122,219 @@ func (s *EmailService) SendPasswordResetEmail(to string, userName *string, reset
 	return s.sendEmail(to, subject, buf.String())
 }
 
+// SendMagicLinkEmail sends a passwordless login link to user
+func (s *EmailService) SendMagicLinkEmail(to string, userName *string, loginURL string) error {
+	subject := "Giriş Bağlantınız - MimReklam"
+
+	// load template from filesystem
+	tmpl, err := template.ParseFiles("templates/magic_link.html")
+	if err != nil {
+		return fmt.Errorf("failed to load magic link template: %w", err)
+	}
+
+	name := "Kullanıcı"
+	if userName != nil && *userName != "" {
+		name = *userName
+	}
+
+	data := struct {
+		UserName string
+		LoginURL string
+		Minutes  int
+	}{
+		UserName: name,
+		LoginURL: loginURL,
+		Minutes:  int(MagicLinkTTL.Minutes()),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render magic link template: %w", err)
+	}
+
+	return s.sendEmail(to, subject, buf.String())
+}
+
+// SendLoginConfirmEmail asks the user to confirm a risky login with a single-use
+// link that completes it
+func (s *EmailService) SendLoginConfirmEmail(to string, userName *string, confirmURL, device, ipAddress, location string, at time.Time) error {
+	subject := "Yeni Girişi Onaylayın - MimReklam"
+
+	// load template from filesystem
+	tmpl, err := template.ParseFiles("templates/login_confirm.html")
+	if err != nil {
+		return fmt.Errorf("failed to load login confirm template: %w", err)
+	}
+
+	name := "Kullanıcı"
+	if userName != nil && *userName != "" {
+		name = *userName
+	}
+
+	data := struct {
+		UserName   string
+		ConfirmURL string
+		Device     string
+		IPAddress  string
+		Location   string
+		Time       string
+		Minutes    int
+	}{
+		UserName:   name,
+		ConfirmURL: confirmURL,
+		Device:     device,
+		IPAddress:  ipAddress,
+		Location:   location,
+		Time:       at.Format("02.01.2006 15:04"),
+		Minutes:    int(MagicLinkTTL.Minutes()),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render login confirm template: %w", err)
+	}
+
+	return s.sendEmail(to, subject, buf.String())
+}
+
+// SendAccountLockedEmail tells a user their account was locked after failed
+// logins and links to an early unlock
+func (s *EmailService) SendAccountLockedEmail(to string, userName *string, unlockURL string) error {
+	subject := "Hesabınız Geçici Olarak Kilitlendi - MimReklam"
+
+	// load template from filesystem
+	tmpl, err := template.ParseFiles("templates/account_locked.html")
+	if err != nil {
+		return fmt.Errorf("failed to load account locked template: %w", err)
+	}
+
+	name := "Kullanıcı"
+	if userName != nil && *userName != "" {
+		name = *userName
+	}
+
+	data := struct {
+		UserName  string
+		UnlockURL string
+		Minutes   int
+	}{
+		UserName:  name,
+		UnlockURL: unlockURL,
+		Minutes:   int(LoginLockoutDuration.Minutes()),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render account locked template: %w", err)
+	}
+
+	return s.sendEmail(to, subject, buf.String())
+}
+
+// SendEmailChangeConfirmEmail sends the confirmation link of an email change
+// to the new address
+func (s *EmailService) SendEmailChangeConfirmEmail(to string, userName *string, confirmURL string) error {
+	subject := "Yeni Email Adresinizi Onaylayın - MimReklam"
+
+	// load template from filesystem
+	tmpl, err := template.ParseFiles("templates/email_change_confirm.html")
+	if err != nil {
+		return fmt.Errorf("failed to load email change confirm template: %w", err)
+	}
+
+	name := "Kullanıcı"
+	if userName != nil && *userName != "" {
+		name = *userName
+	}
+
+	data := struct {
+		UserName   string
+		ConfirmURL string
+		Hours      int
+	}{
+		UserName:   name,
+		ConfirmURL: confirmURL,
+		Hours:      int(EmailChangeTTL.Hours()),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render email change confirm template: %w", err)
+	}
+
+	return s.sendEmail(to, subject, buf.String())
+}
+
+// SendEmailChangeNoticeEmail tells the old address about a requested email
+// change and links to cancelling it
+func (s *EmailService) SendEmailChangeNoticeEmail(to string, userName *string, newEmail, cancelURL string) error {
+	subject := "Email Adresi Değişikliği İstendi - MimReklam"
+
+	// load template from filesystem
+	tmpl, err := template.ParseFiles("templates/email_change_notice.html")
+	if err != nil {
+		return fmt.Errorf("failed to load email change notice template: %w", err)
+	}
+
+	name := "Kullanıcı"
+	if userName != nil && *userName != "" {
+		name = *userName
+	}
+
+	data := struct {
+		UserName  string
+		NewEmail  string
+		CancelURL string
+		Days      int
+	}{
+		UserName:  name,
+		NewEmail:  newEmail,
+		CancelURL: cancelURL,
+		Days:      int(EmailChangeCancelTTL.Hours() / 24),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render email change notice template: %w", err)
+	}
+
+	return s.sendEmail(to, subject, buf.String())
+}
+
+// SendAccountDeletionEmail confirms a scheduled account deletion and tells
+// the user how to cancel it before scheduledFor
+func (s *EmailService) SendAccountDeletionEmail(to string, userName *string, scheduledFor time.Time, loginURL string) error {
+	subject := "Hesap Silme Talebiniz Alındı - MimReklam"
+
+	// load template from filesystem
+	tmpl, err := template.ParseFiles("templates/account_deletion.html")
+	if err != nil {
+		return fmt.Errorf("failed to load account deletion template: %w", err)
+	}
+
+	name := "Kullanıcı"
+	if userName != nil && *userName != "" {
+		name = *userName
+	}
+
+	data := struct {
+		UserName     string
+		ScheduledFor string
+		LoginURL     string
+	}{
+		UserName:     name,
+		ScheduledFor: scheduledFor.Format("02.01.2006 15:04"),
+		LoginURL:     loginURL,
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render account deletion template: %w", err)
+	}
+
+	return s.sendEmail(to, subject, buf.String())
+}
+
 // SendInvitationEmail sends company invitation email to the invited user
 func (s *EmailService) SendInvitationEmail(to, companyName, inviterName, inviterEmail, roleName, token, expiresAt, companyEmail, companyPhone, companyWebsite string) error {
 	subject := companyName + " - Şirket Daveti"
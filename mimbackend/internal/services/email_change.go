@@ -0,0 +1,242 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"mimbackend/config"
+	auth "mimbackend/internal/models/auth"
+	companymodels "mimbackend/internal/models/company"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	EmailChangeTTL = 24 * time.Hour
+	// EmailChangeCancelTTL keeps the link sent to the old address usable after
+	// the change was confirmed, so a hijacked account can be taken back
+	EmailChangeCancelTTL = 7 * 24 * time.Hour
+
+	// emailChangePrefix marks confirmation tokens: email_change:<user ID>:<new email>
+	emailChangePrefix = "email_change:"
+	// emailChangeCancelPrefix marks cancel tokens: email_change_cancel:<user ID>:<old email>
+	emailChangeCancelPrefix = "email_change_cancel:"
+)
+
+var (
+	ErrEmailUnchanged     = errors.New("new email is the current email")
+	ErrEmailInUse         = errors.New("email is already in use")
+	ErrEmailChangeInvalid = errors.New("email change link is invalid or expired")
+)
+
+// RequestEmailChange starts changing the user's email: a confirmation link is
+// sent to the new address and a notice with a cancel link to the current one.
+// The email is only changed once the new address is confirmed; a newer request
+// replaces the pending one.
+func RequestEmailChange(user *auth.User, newEmail string) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	newEmail = normalizeLoginEmail(newEmail)
+	if newEmail == normalizeLoginEmail(user.Email) {
+		return ErrEmailUnchanged
+	}
+	if taken, err := emailTaken(db, newEmail, user.ID); err != nil {
+		return err
+	} else if taken {
+		return ErrEmailInUse
+	}
+
+	confirmToken, err := generateResetToken()
+	if err != nil {
+		return err
+	}
+	cancelToken, err := generateResetToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("identifier LIKE ?", emailChangePrefix+user.ID.String()+":%").
+			Delete(&auth.VerificationToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&auth.VerificationToken{
+			Identifier: emailChangePrefix + user.ID.String() + ":" + newEmail,
+			Token:      hashMagicLinkToken(confirmToken, ""),
+			ExpiresAt:  now.Add(EmailChangeTTL),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&auth.VerificationToken{
+			Identifier: emailChangeCancelPrefix + user.ID.String() + ":" + user.Email,
+			Token:      hashMagicLinkToken(cancelToken, ""),
+			ExpiresAt:  now.Add(EmailChangeCancelTTL),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	emailService := NewEmailService()
+	confirmURL := emailService.frontendURL + "/auth/email-change/confirm?token=" + url.QueryEscape(confirmToken)
+	cancelURL := emailService.frontendURL + "/auth/email-change/cancel?token=" + url.QueryEscape(cancelToken)
+
+	if err := emailService.SendEmailChangeConfirmEmail(newEmail, user.FullName, confirmURL); err != nil {
+		return err
+	}
+	if err := emailService.SendEmailChangeNoticeEmail(user.Email, user.FullName, newEmail, cancelURL); err != nil {
+		// The change itself can go ahead; the notice only adds a way back
+		log.Printf("RequestEmailChange: failed to notify %s: %v", user.Email, err)
+	}
+	return nil
+}
+
+// ConfirmEmailChange consumes the link sent to the new address and moves the
+// user over to it
+func ConfirmEmailChange(token string) (*auth.User, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var user auth.User
+	err = db.Transaction(func(tx *gorm.DB) error {
+		userID, newEmail, err := consumeEmailChangeToken(tx, emailChangePrefix, token)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrEmailChangeInvalid
+			}
+			return err
+		}
+		return applyEmailChange(tx, &user, newEmail)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CancelEmailChange consumes the link sent to the old address. A pending
+// change is dropped; a change that was already confirmed is reverted, in which
+// case reverted is true and the caller should end the user's sessions.
+func CancelEmailChange(token string) (user *auth.User, reverted bool, err error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, false, err
+	}
+
+	user = &auth.User{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		userID, oldEmail, err := consumeEmailChangeToken(tx, emailChangeCancelPrefix, token)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(user, "id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrEmailChangeInvalid
+			}
+			return err
+		}
+		if err := tx.Unscoped().Where("identifier LIKE ?", emailChangePrefix+userID.String()+":%").
+			Delete(&auth.VerificationToken{}).Error; err != nil {
+			return err
+		}
+		if user.Email == oldEmail {
+			return nil
+		}
+		reverted = true
+		return applyEmailChange(tx, user, oldEmail)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return user, reverted, nil
+}
+
+// consumeEmailChangeToken deletes a valid token of the given kind and returns
+// the user and email encoded in its identifier. It runs in the transaction
+// that applies the change, so a failed change leaves the link usable.
+func consumeEmailChangeToken(db *gorm.DB, prefix, token string) (uuid.UUID, string, error) {
+	var vt auth.VerificationToken
+	if err := db.Where("token = ? AND identifier LIKE ? AND expires_at > ?", hashMagicLinkToken(token, ""), prefix+"%", time.Now()).
+		First(&vt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return uuid.Nil, "", ErrEmailChangeInvalid
+		}
+		return uuid.Nil, "", err
+	}
+
+	// Single use: whoever deletes the row first wins
+	result := db.Unscoped().Where("id = ?", vt.ID).Delete(&auth.VerificationToken{})
+	if result.Error != nil {
+		return uuid.Nil, "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return uuid.Nil, "", ErrEmailChangeInvalid
+	}
+
+	idPart, email, ok := strings.Cut(strings.TrimPrefix(vt.Identifier, prefix), ":")
+	userID, err := uuid.Parse(idPart)
+	if !ok || err != nil || email == "" {
+		return uuid.Nil, "", ErrEmailChangeInvalid
+	}
+	return userID, email, nil
+}
+
+// applyEmailChange moves the user and everything addressed by the old email
+// to newEmail: email-keyed provider accounts, pending invitations (accepting
+// one requires the user's email to match) and outstanding links of the old
+// address. Opening a link sent to newEmail proves ownership, so the user
+// counts as verified.
+func applyEmailChange(tx *gorm.DB, user *auth.User, newEmail string) error {
+	if taken, err := emailTaken(tx, newEmail, user.ID); err != nil {
+		return err
+	} else if taken {
+		return ErrEmailInUse
+	}
+
+	oldEmail := user.Email
+	if err := tx.Model(user).Updates(map[string]interface{}{"email": newEmail, "is_verified": true}).Error; err != nil {
+		return err
+	}
+
+	// SAML NameIDs are owned by the company's identity provider and keep matching as they are
+	if err := tx.Model(&auth.Account{}).
+		Where("user_id = ? AND provider_id = ? AND provider NOT LIKE ?", user.ID, oldEmail, "saml:%").
+		Update("provider_id", newEmail).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&companymodels.CompanyInvitation{}).
+		Where("email = ? AND status = ?", oldEmail, companymodels.InvitationPending).
+		Update("email", newEmail).Error; err != nil {
+		return err
+	}
+
+	return tx.Unscoped().
+		Where("identifier IN ?", []string{oldEmail, magicLinkPrefix + oldEmail}).
+		Delete(&auth.VerificationToken{}).Error
+}
+
+// emailTaken reports whether another user, including a deactivated one,
+// already holds email
+func emailTaken(db *gorm.DB, email string, userID uuid.UUID) (bool, error) {
+	var count int64
+	if err := db.Unscoped().Model(&auth.User{}).
+		Where("email = ? AND id <> ?", email, userID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoLocation is where an IP address resolves to in the local GeoIP database
+type GeoLocation struct {
+	CountryCode string
+	Country     string
+	City        string
+	Latitude    float64
+	Longitude   float64
+	HasCoords   bool
+}
+
+// String formats the location as "City, Country" for UserSession.Location
+func (l *GeoLocation) String() string {
+	switch {
+	case l.City != "" && l.Country != "":
+		return l.City + ", " + l.Country
+	case l.Country != "":
+		return l.Country
+	default:
+		return l.CountryCode
+	}
+}
+
+// geoIPRecord is the part of a GeoLite2/GeoIP2 City (or Country) record we use
+type geoIPRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+var (
+	geoIPMu       sync.RWMutex
+	geoIPReader   *maxminddb.Reader
+	geoIPLanguage = "en"
+)
+
+// InitGeoIP opens the MaxMind-format database at GEOIP_DB_PATH. Lookups are
+// made offline from this file; without it sessions get no location and
+// impossible travel is not checked.
+func InitGeoIP() error {
+	path := os.Getenv("GEOIP_DB_PATH")
+	if path == "" {
+		log.Printf("GeoIP: GEOIP_DB_PATH not set, session locations are disabled")
+		return nil
+	}
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database %s: %w", path, err)
+	}
+
+	geoIPMu.Lock()
+	if geoIPReader != nil {
+		geoIPReader.Close()
+	}
+	geoIPReader = reader
+	if lang := os.Getenv("GEOIP_LANGUAGE"); lang != "" {
+		geoIPLanguage = lang
+	}
+	geoIPMu.Unlock()
+
+	log.Printf("GeoIP: loaded %s (%s, built %s)", path, reader.Metadata.DatabaseType,
+		time.Unix(int64(reader.Metadata.BuildEpoch), 0).Format("2006-01-02"))
+	return nil
+}
+
+// LookupGeoIP resolves an IP address in the local GeoIP database. ok is false
+// when no database is loaded or the address is private or unknown.
+func LookupGeoIP(ip string) (*GeoLocation, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsLoopback() || parsed.IsPrivate() || parsed.IsUnspecified() {
+		return nil, false
+	}
+
+	geoIPMu.RLock()
+	defer geoIPMu.RUnlock()
+	if geoIPReader == nil {
+		return nil, false
+	}
+
+	var record geoIPRecord
+	if err := geoIPReader.Lookup(parsed, &record); err != nil {
+		log.Printf("GeoIP: lookup of %s failed: %v", ip, err)
+		return nil, false
+	}
+	if record.Country.ISOCode == "" && record.Location.Latitude == nil {
+		return nil, false
+	}
+
+	location := &GeoLocation{
+		CountryCode: record.Country.ISOCode,
+		Country:     localizedName(record.Country.Names),
+		City:        localizedName(record.City.Names),
+	}
+	if record.Location.Latitude != nil && record.Location.Longitude != nil {
+		location.Latitude = *record.Location.Latitude
+		location.Longitude = *record.Location.Longitude
+		location.HasCoords = true
+	}
+	return location, true
+}
+
+// localizedName picks the GEOIP_LANGUAGE name, falling back to English
+func localizedName(names map[string]string) string {
+	if name, ok := names[geoIPLanguage]; ok {
+		return name
+	}
+	return names["en"]
+}
+
+// geoDistanceKm returns the great-circle distance between two points (haversine)
+func geoDistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// geoIPMaxTravelKmh is the fastest plausible speed between two logins,
+// roughly a commercial flight
+func geoIPMaxTravelKmh() int {
+	return envInt("GEOIP_MAX_TRAVEL_KMH", 900)
+}
+
+// geoIPMinTravelKm is the distance below which two logins are never
+// compared, since city-level GeoIP results can be off by hundreds of km
+func geoIPMinTravelKm() float64 {
+	return float64(envInt("GEOIP_MIN_TRAVEL_KM", 500))
+}
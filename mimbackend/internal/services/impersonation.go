@@ -0,0 +1,209 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"mimbackend/config"
+	auth "mimbackend/internal/models/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// ImpersonationLoginMethod is the login method of sessions a super admin
+	// opened to act as a user
+	ImpersonationLoginMethod = "impersonation"
+
+	DefaultImpersonationDuration = 30 * time.Minute
+	MaxImpersonationDuration     = time.Hour
+)
+
+var (
+	ErrImpersonationNotAllowed    = errors.New("only super admins can impersonate users")
+	ErrImpersonationTargetInvalid = errors.New("user cannot be impersonated")
+	ErrImpersonationTargetMissing = errors.New("user to impersonate not found")
+	ErrImpersonationReason        = errors.New("impersonation reason is required")
+	ErrImpersonationNotFound      = errors.New("impersonation session not found")
+)
+
+// ActorClaim identifies the super admin behind an impersonation token
+type ActorClaim struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// IsImpersonated reports whether the token was issued to a super admin acting as the user
+func (c *Claims) IsImpersonated() bool {
+	return c.Actor != nil
+}
+
+// ImpersonatorID returns the user ID of the super admin behind an impersonation token
+func (c *Claims) ImpersonatorID() (uuid.UUID, bool) {
+	if c.Actor == nil {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(c.Actor.Subject)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// StartImpersonation opens a time-boxed session of the target user for a
+// super admin and returns its access token. The token carries the admin in
+// its "act" claim and there is no refresh token: when the session expires the
+// admin has to start a new one. Super admins, admins, service accounts and
+// the admin themself cannot be impersonated.
+func StartImpersonation(admin *auth.User, targetID uuid.UUID, reason string, duration time.Duration, securityInfo *auth.SessionSecurityInfo) (string, *auth.UserSession, error) {
+	if admin.Role != "super_admin" {
+		return "", nil, ErrImpersonationNotAllowed
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return "", nil, ErrImpersonationReason
+	}
+	if duration <= 0 {
+		duration = DefaultImpersonationDuration
+	}
+	if duration > MaxImpersonationDuration {
+		duration = MaxImpersonationDuration
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var target auth.User
+	if err := db.Where("id = ?", targetID).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil, ErrImpersonationTargetMissing
+		}
+		return "", nil, err
+	}
+	if target.ID == admin.ID || target.IsServiceAccount ||
+		target.Role == "super_admin" || target.Role == "admin" {
+		return "", nil, ErrImpersonationTargetInvalid
+	}
+
+	metadata, err := json.Marshal(map[string]interface{}{
+		"impersonator_email": admin.Email,
+		"reason":             reason,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	session := &auth.UserSession{
+		UserID:         target.ID,
+		SessionID:      uuid.New().String(),
+		IPAddress:      securityInfo.IPAddress,
+		UserAgent:      securityInfo.UserAgent,
+		DeviceType:     securityInfo.DeviceType,
+		OS:             securityInfo.OS,
+		Browser:        securityInfo.Browser,
+		DeviceID:       securityInfo.DeviceID,
+		Location:       securityInfo.Location,
+		Country:        securityInfo.Country,
+		Latitude:       securityInfo.Latitude,
+		Longitude:      securityInfo.Longitude,
+		IsActive:       true,
+		LoginAt:        now,
+		LastActivity:   now,
+		ExpiresAt:      now.Add(duration),
+		LoginMethod:    ImpersonationLoginMethod,
+		ImpersonatorID: &admin.ID,
+		TokenVersion:   initialTokenVersion,
+		TrustScore:     100,
+		Metadata:       datatypes.JSON(metadata),
+	}
+	if err := db.Create(session).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to create impersonation session: %w", err)
+	}
+
+	accessToken, err := signJWT(&Claims{
+		UserID:       target.ID,
+		Email:        target.Email,
+		Role:         target.Role,
+		SessionID:    session.SessionID,
+		TokenVersion: session.TokenVersion,
+		TokenUse:     TokenUseAccess,
+		Actor:        &ActorClaim{Subject: admin.ID.String(), Email: admin.Email},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "camping-clouds",
+			Subject:   target.ID.String(),
+		},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	log.Printf("impersonation: %s (%s) started session %s as user %s until %s: %s",
+		admin.ID, admin.Email, session.SessionID, target.ID, session.ExpiresAt.Format(time.RFC3339), reason)
+	return accessToken, session, nil
+}
+
+// EndImpersonation closes an impersonation session before it expires
+func EndImpersonation(sessionID string) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	result := db.Model(&auth.UserSession{}).
+		Where("session_id = ? AND impersonator_id IS NOT NULL AND is_active = ?", sessionID, true).
+		Updates(map[string]interface{}{
+			"is_active": false,
+			"logout_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrImpersonationNotFound
+	}
+
+	invalidateSessionTokenState(sessionID)
+	return nil
+}
+
+// RecordImpersonationActivity stores one request made during an impersonation session
+func RecordImpersonationActivity(activity *auth.ImpersonationActivity) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+	return db.Create(activity).Error
+}
+
+// ListImpersonationActivity returns the latest requests made while the user
+// was impersonated, optionally limited to one session
+func ListImpersonationActivity(userID uuid.UUID, sessionID string, limit int) ([]auth.ImpersonationActivity, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	query := db.Where("user_id = ?", userID)
+	if sessionID != "" {
+		query = query.Where("session_id = ?", sessionID)
+	}
+
+	var activity []auth.ImpersonationActivity
+	if err := query.Order("created_at DESC").Limit(limit).Find(&activity).Error; err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
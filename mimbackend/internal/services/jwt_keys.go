@@ -0,0 +1,321 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// legacyJWTSecret is the HS256 secret every token was signed with before
+// asymmetric keys were introduced. It is only used when JWT_SECRET is empty.
+const legacyJWTSecret = "your-secret-key-change-this-in-production"
+
+// legacyHS256MaxLifetime is the longest lifetime of a token signed with the
+// HS256 secret (the refresh token). Legacy tokens claiming a longer one are
+// rejected, so no HS256 token is accepted once this long after the cutover.
+const legacyHS256MaxLifetime = 30 * 24 * time.Hour
+
+// jwtKey is a single key known to the server. signKey is nil for keys that are
+// only kept around to verify tokens issued before a rotation.
+type jwtKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	// issuedBefore limits a verify-only key to tokens issued before it (zero: no limit)
+	issuedBefore time.Time
+}
+
+// jwtKeyRing holds the active signing key and every key accepted for verification.
+type jwtKeyRing struct {
+	active *jwtKey
+	keys   map[string]*jwtKey
+}
+
+// JWK is a public key in RFC 7517 format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	jwtKeys     *jwtKeyRing
+	jwtKeysErr  error
+	jwtKeysOnce sync.Once
+)
+
+// InitJWTKeys loads the signing keys from the environment.
+//
+// JWT_KEYS lists the PEM files as "kid=path" pairs separated by commas. Private
+// keys (RSA or Ed25519) can sign and verify, public keys only verify, which lets
+// an old key stay valid while its tokens expire. JWT_ACTIVE_KID selects the
+// signing key and defaults to the first private key in the list.
+//
+// Without JWT_KEYS tokens are signed with HS256 using JWT_SECRET. When JWT_KEYS
+// is set, HS256 tokens are only accepted if JWT_LEGACY_HS256_UNTIL (RFC 3339)
+// names the cutover: tokens issued before it keep working until they expire,
+// so sessions issued before the switch survive it.
+func InitJWTKeys() error {
+	jwtKeysOnce.Do(func() {
+		jwtKeys, jwtKeysErr = loadJWTKeys()
+		if jwtKeysErr == nil {
+			log.Printf("JWT keys loaded: active kid=%q alg=%s verification keys=%d", jwtKeys.active.kid, jwtKeys.active.method.Alg(), len(jwtKeys.keys))
+		}
+	})
+	return jwtKeysErr
+}
+
+func getJWTKeys() (*jwtKeyRing, error) {
+	if err := InitJWTKeys(); err != nil {
+		return nil, err
+	}
+	return jwtKeys, nil
+}
+
+func loadJWTKeys() (*jwtKeyRing, error) {
+	ring := &jwtKeyRing{keys: make(map[string]*jwtKey)}
+
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		secret = legacyJWTSecret
+	}
+	spec := strings.TrimSpace(os.Getenv("JWT_KEYS"))
+
+	if spec == "" {
+		// HS256 tokens never carried a kid, so the shared secret is stored under the empty kid
+		ring.keys[""] = &jwtKey{
+			method:    jwt.SigningMethodHS256,
+			signKey:   []byte(secret),
+			verifyKey: []byte(secret),
+		}
+		ring.active = ring.keys[""]
+		return ring, nil
+	}
+
+	if until := strings.TrimSpace(os.Getenv("JWT_LEGACY_HS256_UNTIL")); until != "" {
+		cutover, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_LEGACY_HS256_UNTIL %q, expected RFC 3339: %w", until, err)
+		}
+		ring.keys[""] = &jwtKey{
+			method:       jwt.SigningMethodHS256,
+			verifyKey:    []byte(secret),
+			issuedBefore: cutover,
+		}
+	}
+
+	var firstSigner *jwtKey
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid JWT_KEYS entry %q, expected kid=path", entry)
+		}
+		kid := strings.TrimSpace(parts[0])
+		if _, exists := ring.keys[kid]; exists {
+			return nil, fmt.Errorf("duplicate JWT key id %q", kid)
+		}
+
+		key, err := loadJWTKeyFile(kid, strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[kid] = key
+		if firstSigner == nil && key.signKey != nil {
+			firstSigner = key
+		}
+	}
+
+	if activeKid := os.Getenv("JWT_ACTIVE_KID"); activeKid != "" {
+		key, ok := ring.keys[activeKid]
+		if !ok {
+			return nil, fmt.Errorf("JWT_ACTIVE_KID %q is not listed in JWT_KEYS", activeKid)
+		}
+		if key.signKey == nil {
+			return nil, fmt.Errorf("JWT_ACTIVE_KID %q refers to a public key; a private key is required for signing", activeKid)
+		}
+		ring.active = key
+	} else {
+		ring.active = firstSigner
+	}
+
+	if ring.active == nil {
+		return nil, errors.New("JWT_KEYS does not contain a private key to sign with")
+	}
+
+	return ring, nil
+}
+
+// loadJWTKeyFile parses a PEM encoded RSA or Ed25519 key
+func loadJWTKeyFile(kid, path string) (*jwtKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT key %q: %w", kid, err)
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("JWT key %q is not PEM encoded", kid)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("JWT key %q has unsupported PEM type %q", kid, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT key %q: %w", kid, err)
+	}
+
+	key := &jwtKey{kid: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+		key.signKey = k
+		key.verifyKey = &k.PublicKey
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+		key.verifyKey = k
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.signKey = k
+		key.verifyKey = k.Public()
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.verifyKey = k
+	default:
+		return nil, fmt.Errorf("JWT key %q must be an RSA or Ed25519 key, got %T", kid, parsed)
+	}
+
+	return key, nil
+}
+
+// signJWT signs claims with the active key and stamps its kid on the header
+func signJWT(claims jwt.Claims) (string, error) {
+	ring, err := getJWTKeys()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(ring.active.method, claims)
+	if ring.active.kid != "" {
+		token.Header["kid"] = ring.active.kid
+	}
+	return token.SignedString(ring.active.signKey)
+}
+
+// jwtKeyFunc resolves the verification key from the token's kid header and
+// rejects tokens whose algorithm does not match the key.
+func jwtKeyFunc(token *jwt.Token) (interface{}, error) {
+	ring, err := getJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ring.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+	if !key.issuedBefore.IsZero() {
+		if err := checkLegacyTokenWindow(token.Claims, key.issuedBefore); err != nil {
+			return nil, err
+		}
+	}
+	return key.verifyKey, nil
+}
+
+// checkLegacyTokenWindow accepts a token of a verify-only legacy key only when
+// it was issued before the cutover with no more than the usual lifetime
+func checkLegacyTokenWindow(claims jwt.Claims, cutover time.Time) error {
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil || !iat.Before(cutover) {
+		return errors.New("legacy token was not issued before the key cutover")
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil || exp.Sub(iat.Time) > legacyHS256MaxLifetime {
+		return errors.New("legacy token lifetime is too long")
+	}
+	return nil
+}
+
+// GetJWKS returns the public half of every asymmetric verification key.
+// HS256 secrets are never published.
+func GetJWKS() (*JWKS, error) {
+	ring, err := getJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	kids := make([]string, 0, len(ring.keys))
+	for kid := range ring.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := &JWKS{Keys: []JWK{}}
+	for _, kid := range kids {
+		key := ring.keys[kid]
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	return set, nil
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"mimbackend/config"
+	auth "mimbackend/internal/models/auth"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// localAccountProvider marks the account row written for password logins; it
+// is not an external provider and cannot be linked or unlinked
+const localAccountProvider = "local"
+
+// ReauthenticationWindow is how recent the sign-in of the current session
+// must be to stand in for re-authentication when the user has neither a
+// password nor two-factor authentication to confirm with
+const ReauthenticationWindow = 10 * time.Minute
+
+var (
+	ErrUnknownLoginProvider     = errors.New("unknown login provider")
+	ErrAccountLinkedToOtherUser = errors.New("provider account is linked to another user")
+	ErrProviderAlreadyLinked    = errors.New("another account of this provider is already linked")
+	ErrLinkedAccountNotFound    = errors.New("linked account not found")
+	ErrLastLoginMethod          = errors.New("account would be left without a login method")
+	ErrOAuthAccountLinkRequired = errors.New("an account with this email already exists; sign in and link the provider")
+	ErrReauthenticationRequired = errors.New("re-authentication required")
+	ErrReauthenticationFailed   = errors.New("re-authentication failed")
+)
+
+// builtinOAuthProviders are the providers with dedicated login handlers.
+// Google is an OpenID Connect provider (see registerGoogleOIDCProvider).
+var builtinOAuthProviders = map[string]bool{"facebook": true, "github": true}
+
+// LinkedAccount is an external login provider attached to a user
+type LinkedAccount struct {
+	ID         uuid.UUID `json:"id"`
+	Provider   string    `json:"provider"`
+	ProviderID string    `json:"provider_id"`
+	LinkedAt   time.Time `json:"linked_at"`
+}
+
+// IsLinkableProvider reports whether provider is a built-in OAuth provider, a
+// configured OpenID Connect provider or the SAML identity provider of a
+// company (saml:<company ID>)
+func IsLinkableProvider(provider string) bool {
+	if builtinOAuthProviders[provider] {
+		return true
+	}
+	if _, ok := SAMLProviderCompany(provider); ok {
+		return true
+	}
+	_, err := GetOIDCProvider(provider)
+	return err == nil
+}
+
+// ListLinkedAccounts returns the external providers linked to the user
+func ListLinkedAccounts(userID uuid.UUID) ([]LinkedAccount, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []auth.Account
+	if err := db.Where("user_id = ? AND provider <> ?", userID, localAccountProvider).
+		Order("created_at ASC").
+		Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+
+	linked := make([]LinkedAccount, 0, len(accounts))
+	for _, account := range accounts {
+		linked = append(linked, LinkedAccount{
+			ID:         account.ID,
+			Provider:   account.Provider,
+			ProviderID: account.ProviderID,
+			LinkedAt:   account.CreatedAt,
+		})
+	}
+	return linked, nil
+}
+
+// LinkAccount attaches a provider identity to a signed-in user. An identity
+// already linked to someone else is refused, as is a second identity of a
+// provider the user has already linked; relinking the same identity only
+// refreshes the stored provider tokens.
+func LinkAccount(userID uuid.UUID, provider, providerID, accessToken, refreshToken string, expiresAt int64) error {
+	if provider == localAccountProvider || providerID == "" {
+		return ErrUnknownLoginProvider
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	var existing auth.Account
+	err = db.Where("provider = ? AND provider_id = ?", provider, providerID).First(&existing).Error
+	switch {
+	case err == nil:
+		if existing.UserID != userID {
+			return ErrAccountLinkedToOtherUser
+		}
+		existing.AccessToken = accessToken
+		if refreshToken != "" {
+			existing.RefreshToken = refreshToken
+		}
+		existing.ExpiresAt = expiresAt
+		if err := sealAccountTokens(&existing); err != nil {
+			return err
+		}
+		return db.Save(&existing).Error
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+
+	var count int64
+	if err := db.Model(&auth.Account{}).
+		Where("user_id = ? AND provider = ?", userID, provider).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrProviderAlreadyLinked
+	}
+
+	account := auth.Account{
+		UserID:       userID,
+		Provider:     provider,
+		ProviderID:   providerID,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+	}
+	if err := sealAccountTokens(&account); err != nil {
+		return err
+	}
+	return db.Create(&account).Error
+}
+
+// UnlinkAccount removes a linked provider, refusing when it is the last way
+// the user can sign in. Magic links are not counted: they depend on the
+// mailbox alone and would make every account look recoverable.
+func UnlinkAccount(userID, accountID uuid.UUID) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	var account auth.Account
+	if err := db.Where("id = ? AND user_id = ? AND provider <> ?", accountID, userID, localAccountProvider).
+		First(&account).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrLinkedAccountNotFound
+		}
+		return err
+	}
+
+	var user auth.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+
+	remaining, err := countLoginMethods(db, &user, account.ID)
+	if err != nil {
+		return err
+	}
+	if remaining == 0 {
+		return ErrLastLoginMethod
+	}
+
+	return db.Delete(&account).Error
+}
+
+// countLoginMethods counts the ways the user can sign in without the linked
+// account excludeAccountID: a password, other linked providers, passkeys and
+// a verified phone number
+func countLoginMethods(db *gorm.DB, user *auth.User, excludeAccountID uuid.UUID) (int64, error) {
+	var methods int64
+	if user.PasswordHash != "" {
+		methods++
+	}
+	if user.Phone != nil && *user.Phone != "" && user.PhoneVerified {
+		methods++
+	}
+
+	var accounts int64
+	if err := db.Model(&auth.Account{}).
+		Where("user_id = ? AND provider <> ? AND id <> ?", user.ID, localAccountProvider, excludeAccountID).
+		Count(&accounts).Error; err != nil {
+		return 0, err
+	}
+
+	var passkeys int64
+	if err := db.Model(&auth.WebAuthnCredential{}).
+		Where("user_id = ?", user.ID).
+		Count(&passkeys).Error; err != nil {
+		return 0, err
+	}
+
+	return methods + accounts + passkeys, nil
+}
+
+// VerifyReauthentication confirms that the person holding the session is the
+// account owner before a sensitive change. A password or a TOTP/recovery code
+// is checked when supplied; users with neither configured fall back to a
+// session signed in within ReauthenticationWindow. Password and code checks
+// share the LoginGuard counters of password login, so they return a
+// *LoginBlockedError while the account is locked or the client is throttled.
+func VerifyReauthentication(user *auth.User, password, code, sessionID, ip, userAgent string) error {
+	if password != "" || code != "" {
+		return verifyReauthenticationSecret(user, password, code, ip, userAgent)
+	}
+
+	mfaService, err := NewMFAService()
+	if err != nil {
+		return err
+	}
+
+	mfaEnabled, err := mfaService.IsEnabled(user.ID)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash != "" || mfaEnabled || sessionID == "" {
+		return ErrReauthenticationRequired
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	var session auth.UserSession
+	if err := db.Where("session_id = ? AND user_id = ? AND is_active = ? AND impersonator_id IS NULL", sessionID, user.ID, true).
+		First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrReauthenticationRequired
+		}
+		return err
+	}
+	if time.Since(session.LoginAt) > ReauthenticationWindow {
+		return ErrReauthenticationRequired
+	}
+	return nil
+}
+
+// verifyReauthenticationSecret checks a re-authentication password or code
+// and counts failures like failed logins
+func verifyReauthenticationSecret(user *auth.User, password, code, ip, userAgent string) error {
+	guard, err := NewLoginGuard()
+	if err != nil {
+		return err
+	}
+	if err := guard.Check(user.Email, ip); err != nil {
+		return err
+	}
+
+	var ok bool
+	reason := auth.LoginFailureInvalidPassword
+	if password != "" {
+		ok = user.PasswordHash != ""
+		if ok {
+			ok, _ = CheckPassword(password, user.PasswordHash)
+		}
+	} else {
+		reason = auth.LoginFailureInvalidCode
+		mfaService, err := NewMFAService()
+		if err != nil {
+			return err
+		}
+		_, err = mfaService.verifySecondFactor(user.ID, code)
+		if err != nil && !errors.Is(err, ErrMFANotEnabled) && !errors.Is(err, ErrInvalidMFACode) {
+			return err
+		}
+		ok = err == nil
+	}
+
+	if !ok {
+		guard.RecordFailure(user, user.Email, ip, userAgent, reason)
+		return ErrReauthenticationFailed
+	}
+	guard.RecordSuccess(user.Email)
+	return nil
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"mimbackend/config"
+	authmodels "mimbackend/internal/models/auth"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	loginAccountWindow      = 15 * time.Minute // failures per account are counted in this window
+	loginAccountMaxFailures = 10               // failures that lock the account
+	loginDelayAfter         = 3                // failures before each attempt has to wait
+	loginMaxDelay           = 30 * time.Second
+	loginIPWindow           = time.Hour
+	loginIPMaxFailures      = 50 // failures that block the client IP
+
+	LoginLockoutDuration = 15 * time.Minute
+
+	// loginUnlockPrefix keeps unlock links apart from the other entries of the
+	// verification_tokens table
+	loginUnlockPrefix = "login_unlock:"
+)
+
+// checkedLoginFailures are the failure reasons of attempts that reached the
+// password or code check. Only these count towards the limits; attempts the
+// guard refused up front are kept in the history only.
+var checkedLoginFailures = []string{
+	authmodels.LoginFailureInvalidPassword,
+	authmodels.LoginFailureUnknownUser,
+	authmodels.LoginFailureInvalidCode,
+}
+
+var (
+	ErrAccountLocked      = errors.New("account temporarily locked")
+	ErrLoginIPBlocked     = errors.New("too many failed logins from this address")
+	ErrLoginThrottled     = errors.New("login attempted too soon after a failure")
+	ErrUnlockTokenInvalid = errors.New("unlock link is invalid or expired")
+)
+
+// LoginBlockedError tells the client why a login was refused before the
+// password was checked and when to try again
+type LoginBlockedError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *LoginBlockedError) Error() string {
+	return fmt.Sprintf("%v (retry after %s)", e.Err, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LoginBlockedError) Unwrap() error {
+	return e.Err
+}
+
+// LockoutStatus describes the login limits currently applied to an account
+type LockoutStatus struct {
+	Locked         bool       `json:"locked"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	DelayedUntil   *time.Time `json:"delayed_until,omitempty"`
+	RecentFailures int64      `json:"recent_failures"`
+}
+
+// LoginGuard protects password login against brute force: failures are counted
+// per account and per client IP, later attempts have to wait progressively
+// longer and too many failures lock the account or block the IP. Counters live
+// in Redis, or in memory when Redis is not available.
+type LoginGuard struct {
+	db    *gorm.DB
+	store loginGuardStore
+}
+
+// NewLoginGuard creates a login guard on the configured store
+func NewLoginGuard() (*LoginGuard, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return &LoginGuard{db: db, store: getLoginGuardStore()}, nil
+}
+
+// Check returns a *LoginBlockedError when a login for email from ip must be
+// refused without checking the password
+func (g *LoginGuard) Check(email, ip string) error {
+	ctx := context.Background()
+	email = normalizeLoginEmail(email)
+
+	checks := []struct {
+		key string
+		err error
+	}{
+		{loginIPBlockKey(ip), ErrLoginIPBlocked},
+		{loginLockKey(email), ErrAccountLocked},
+		{loginDelayKey(email), ErrLoginThrottled},
+	}
+	for _, check := range checks {
+		remaining, err := g.store.Blocked(ctx, check.key)
+		if err != nil {
+			// Fail open: a store outage must not lock everyone out
+			log.Printf("LoginGuard: failed to read %s: %v", check.key, err)
+			continue
+		}
+		if remaining > 0 {
+			return &LoginBlockedError{Err: check.err, RetryAfter: remaining}
+		}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed password login, applies the delay, lockout and
+// IP block thresholds and writes the attempt to the security history. user is
+// nil when the email is not registered; unknown emails are limited the same
+// way so lockouts do not reveal registered addresses.
+func (g *LoginGuard) RecordFailure(user *authmodels.User, email, ip, userAgent, reason string) {
+	ctx := context.Background()
+	email = normalizeLoginEmail(email)
+
+	g.recordAttempt(user, email, ip, userAgent, reason)
+
+	// Refused attempts were never checked against the password
+	if !slices.Contains(checkedLoginFailures, reason) {
+		return
+	}
+
+	if ipFailures, err := g.store.Incr(ctx, loginIPFailKey(ip), loginIPWindow); err != nil {
+		log.Printf("LoginGuard: failed to count failure for ip %s: %v", ip, err)
+	} else if ipFailures >= loginIPMaxFailures {
+		if err := g.store.Block(ctx, loginIPBlockKey(ip), LoginLockoutDuration); err != nil {
+			log.Printf("LoginGuard: failed to block ip %s: %v", ip, err)
+		}
+		_ = g.store.Delete(ctx, loginIPFailKey(ip))
+		log.Printf("🚫 Login blocked for ip %s after %d failures", ip, ipFailures)
+	}
+
+	failures, err := g.store.Incr(ctx, loginFailKey(email), loginAccountWindow)
+	if err != nil {
+		log.Printf("LoginGuard: failed to count failure for %s: %v", email, err)
+		return
+	}
+
+	switch {
+	case failures >= loginAccountMaxFailures:
+		if err := g.store.Block(ctx, loginLockKey(email), LoginLockoutDuration); err != nil {
+			log.Printf("LoginGuard: failed to lock %s: %v", email, err)
+			return
+		}
+		_ = g.store.Delete(ctx, loginFailKey(email), loginDelayKey(email))
+		log.Printf("🔒 Account %s locked after %d failed logins", email, failures)
+		if user != nil {
+			if err := g.sendUnlockEmail(user); err != nil {
+				log.Printf("LoginGuard: failed to send unlock email to %s: %v", email, err)
+			}
+		}
+	case failures >= loginDelayAfter:
+		if err := g.store.Block(ctx, loginDelayKey(email), loginDelay(failures)); err != nil {
+			log.Printf("LoginGuard: failed to delay %s: %v", email, err)
+		}
+	}
+}
+
+// RecordSuccess clears the account counters after a successful password login.
+// IP counters are kept so one valid account cannot reset an attacker's budget.
+func (g *LoginGuard) RecordSuccess(email string) {
+	email = normalizeLoginEmail(email)
+	if err := g.store.Delete(context.Background(), loginFailKey(email), loginDelayKey(email)); err != nil {
+		log.Printf("LoginGuard: failed to reset counters for %s: %v", email, err)
+	}
+}
+
+// Status returns the lockout state of an account. RecentFailures counts the
+// failed attempts of the window that reached the password check.
+func (g *LoginGuard) Status(email string) (*LockoutStatus, error) {
+	ctx := context.Background()
+	email = normalizeLoginEmail(email)
+	status := &LockoutStatus{}
+
+	locked, err := g.store.Blocked(ctx, loginLockKey(email))
+	if err != nil {
+		return nil, err
+	}
+	if locked > 0 {
+		status.Locked = true
+		until := time.Now().Add(locked)
+		status.LockedUntil = &until
+	}
+
+	delayed, err := g.store.Blocked(ctx, loginDelayKey(email))
+	if err != nil {
+		return nil, err
+	}
+	if delayed > 0 {
+		until := time.Now().Add(delayed)
+		status.DelayedUntil = &until
+	}
+
+	var failures int64
+	if err := g.db.Model(&authmodels.LoginAttempt{}).
+		Where("email = ? AND reason IN ? AND created_at > ?", email, checkedLoginFailures, time.Now().Add(-loginAccountWindow)).
+		Count(&failures).Error; err != nil {
+		return nil, err
+	}
+	status.RecentFailures = failures
+
+	return status, nil
+}
+
+// UnlockAccount lifts the lockout and resets the failure counters of an account
+func (g *LoginGuard) UnlockAccount(email string) error {
+	email = normalizeLoginEmail(email)
+	if err := g.store.Delete(context.Background(), loginLockKey(email), loginFailKey(email), loginDelayKey(email)); err != nil {
+		return err
+	}
+	log.Printf("🔓 Account %s unlocked", email)
+	return nil
+}
+
+// UnblockIP lifts the block and resets the failure counter of a client IP
+func (g *LoginGuard) UnblockIP(ip string) error {
+	if err := g.store.Delete(context.Background(), loginIPBlockKey(ip), loginIPFailKey(ip)); err != nil {
+		return err
+	}
+	log.Printf("🔓 Login block for ip %s lifted", ip)
+	return nil
+}
+
+// UnlockWithToken consumes an emailed unlock link and unlocks its account
+func (g *LoginGuard) UnlockWithToken(token string) error {
+	var vt authmodels.VerificationToken
+	if err := g.db.Where("token = ? AND identifier LIKE ? AND expires_at > ?", hashUnlockToken(token), loginUnlockPrefix+"%", time.Now()).
+		First(&vt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUnlockTokenInvalid
+		}
+		return err
+	}
+
+	// Single use: whoever deletes the row first wins
+	result := g.db.Unscoped().Where("id = ?", vt.ID).Delete(&authmodels.VerificationToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUnlockTokenInvalid
+	}
+
+	return g.UnlockAccount(strings.TrimPrefix(vt.Identifier, loginUnlockPrefix))
+}
+
+// FailedAttempts returns the most recent failed logins of a user
+func (g *LoginGuard) FailedAttempts(userID uuid.UUID, limit int) ([]authmodels.LoginAttempt, error) {
+	var attempts []authmodels.LoginAttempt
+	query := g.db.Where("user_id = ?", userID).Order("created_at DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&attempts).Error; err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// Private helper methods
+
+func (g *LoginGuard) recordAttempt(user *authmodels.User, email, ip, userAgent, reason string) {
+	attempt := &authmodels.LoginAttempt{
+		Email:     email,
+		IPAddress: ip,
+		UserAgent: userAgent,
+		Reason:    reason,
+	}
+	if user != nil {
+		attempt.UserID = &user.ID
+	}
+	if err := g.db.Create(attempt).Error; err != nil {
+		log.Printf("LoginGuard: failed to record login attempt for %s: %v", email, err)
+	}
+}
+
+// sendUnlockEmail emails a single-use link that lifts the lockout early
+func (g *LoginGuard) sendUnlockEmail(user *authmodels.User) error {
+	token, err := generateResetToken()
+	if err != nil {
+		return err
+	}
+
+	identifier := loginUnlockPrefix + normalizeLoginEmail(user.Email)
+	if err := g.db.Unscoped().Where("identifier = ?", identifier).Delete(&authmodels.VerificationToken{}).Error; err != nil {
+		return err
+	}
+	if err := g.db.Create(&authmodels.VerificationToken{
+		Identifier: identifier,
+		Token:      hashUnlockToken(token),
+		ExpiresAt:  time.Now().Add(LoginLockoutDuration),
+	}).Error; err != nil {
+		return err
+	}
+
+	emailService := NewEmailService()
+	unlockURL := emailService.frontendURL + "/auth/unlock?token=" + url.QueryEscape(token)
+	return emailService.SendAccountLockedEmail(user.Email, user.FullName, unlockURL)
+}
+
+// loginDelay doubles the wait for every failure past loginDelayAfter
+func loginDelay(failures int64) time.Duration {
+	delay := time.Second << uint(failures-loginDelayAfter)
+	if delay > loginMaxDelay || delay <= 0 {
+		return loginMaxDelay
+	}
+	return delay
+}
+
+func hashUnlockToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginFailKey(email string) string  { return "login:fail:account:" + email }
+func loginDelayKey(email string) string { return "login:delay:account:" + email }
+func loginLockKey(email string) string  { return "login:lock:account:" + email }
+func loginIPFailKey(ip string) string   { return "login:fail:ip:" + ip }
+func loginIPBlockKey(ip string) string  { return "login:block:ip:" + ip }
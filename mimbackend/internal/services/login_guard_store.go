@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"mimbackend/config"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// loginGuardStore keeps the failure counters and blocks of the login guard
+type loginGuardStore interface {
+	// Incr adds a failure to key and returns the count inside the window that
+	// started with the first failure
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Block marks key as blocked for ttl
+	Block(ctx context.Context, key string, ttl time.Duration) error
+	// Blocked returns how long key stays blocked, zero if it is not
+	Blocked(ctx context.Context, key string) (time.Duration, error)
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// memoryGuardStore is used when Redis is not configured. Limits then only
+// apply per process.
+var memoryGuardStore = &memoryLoginGuardStore{entries: map[string]memoryGuardEntry{}}
+
+// getLoginGuardStore returns the Redis store, or the in-memory store without Redis
+func getLoginGuardStore() loginGuardStore {
+	if cli := config.GetRedisClient(); cli != nil {
+		return &redisLoginGuardStore{cli: cli}
+	}
+	return memoryGuardStore
+}
+
+type redisLoginGuardStore struct {
+	cli *redis.Client
+}
+
+func (s *redisLoginGuardStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	count, err := s.cli.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if err := s.cli.Expire(ctx, key, window).Err(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func (s *redisLoginGuardStore) Block(ctx context.Context, key string, ttl time.Duration) error {
+	return s.cli.Set(ctx, key, 1, ttl).Err()
+}
+
+func (s *redisLoginGuardStore) Blocked(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.cli.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// -2: no key, -1: no expiry (never set by the guard)
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (s *redisLoginGuardStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.cli.Del(ctx, keys...).Err()
+}
+
+type memoryGuardEntry struct {
+	count     int64
+	expiresAt time.Time
+}
+
+type memoryLoginGuardStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryGuardEntry
+}
+
+func (s *memoryLoginGuardStore) Incr(_ context.Context, key string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = memoryGuardEntry{expiresAt: time.Now().Add(window)}
+	}
+	entry.count++
+	s.entries[key] = entry
+	return entry.count, nil
+}
+
+func (s *memoryLoginGuardStore) Block(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryGuardEntry{count: 1, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryLoginGuardStore) Blocked(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return 0, nil
+	}
+	remaining := time.Until(entry.expiresAt)
+	if remaining <= 0 {
+		delete(s.entries, key)
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+func (s *memoryLoginGuardStore) Delete(_ context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// sweep drops expired entries; callers hold the lock
+func (s *memoryLoginGuardStore) sweep() {
+	now := time.Now()
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	authmodels "mimbackend/internal/models/auth"
+)
+
+const testLoginIP = "198.51.100.10"
+
+// newTestLoginGuard uses a fresh in-memory store, so tests neither need Redis
+// nor share counters
+func newTestLoginGuard(t *testing.T) *LoginGuard {
+	t.Helper()
+	db := newTestDB(t, &authmodels.User{}, &authmodels.LoginAttempt{}, &authmodels.VerificationToken{})
+	return &LoginGuard{db: db, store: &memoryLoginGuardStore{entries: map[string]memoryGuardEntry{}}}
+}
+
+// failLogins records n wrong passwords for email from ip
+func failLogins(g *LoginGuard, email, ip string, n int) {
+	for i := 0; i < n; i++ {
+		g.RecordFailure(nil, email, ip, "test", authmodels.LoginFailureInvalidPassword)
+	}
+}
+
+// expectBlocked checks that Check refuses the login with want and returns the wait
+func expectBlocked(t *testing.T, g *LoginGuard, email, ip string, want error) time.Duration {
+	t.Helper()
+
+	err := g.Check(email, ip)
+	var blocked *LoginBlockedError
+	if !errors.As(err, &blocked) || !errors.Is(err, want) {
+		t.Fatalf("Check(%s, %s) = %v, want %v", email, ip, err, want)
+	}
+	return blocked.RetryAfter
+}
+
+func TestLoginDelay(t *testing.T) {
+	cases := map[int64]time.Duration{
+		loginDelayAfter:     time.Second,
+		loginDelayAfter + 1: 2 * time.Second,
+		loginDelayAfter + 2: 4 * time.Second,
+		loginDelayAfter + 5: loginMaxDelay,
+		100:                 loginMaxDelay, // the shift overflows
+	}
+	for failures, want := range cases {
+		if got := loginDelay(failures); got != want {
+			t.Errorf("loginDelay(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestLoginGuardProgressiveDelay(t *testing.T) {
+	g := newTestLoginGuard(t)
+	email := "delay@example.com"
+
+	failLogins(g, email, testLoginIP, loginDelayAfter-1)
+	if err := g.Check(email, testLoginIP); err != nil {
+		t.Fatalf("Check after %d failures = %v, want nil", loginDelayAfter-1, err)
+	}
+
+	failLogins(g, email, testLoginIP, 1)
+	if wait := expectBlocked(t, g, email, testLoginIP, ErrLoginThrottled); wait <= 0 || wait > time.Second {
+		t.Fatalf("wait after %d failures = %s, want up to 1s", loginDelayAfter, wait)
+	}
+
+	failLogins(g, email, testLoginIP, 1)
+	if wait := expectBlocked(t, g, email, testLoginIP, ErrLoginThrottled); wait <= time.Second || wait > 2*time.Second {
+		t.Fatalf("wait after %d failures = %s, want up to 2s", loginDelayAfter+1, wait)
+	}
+
+	// The delay belongs to the account, not to the address
+	if err := g.Check("someone-else@example.com", testLoginIP); err != nil {
+		t.Fatalf("Check for another account = %v, want nil", err)
+	}
+}
+
+func TestLoginGuardLockout(t *testing.T) {
+	g := newTestLoginGuard(t)
+	email := "locked@example.com"
+
+	failLogins(g, email, testLoginIP, loginAccountMaxFailures)
+	if wait := expectBlocked(t, g, email, testLoginIP, ErrAccountLocked); wait <= LoginLockoutDuration-time.Minute || wait > LoginLockoutDuration {
+		t.Fatalf("lockout = %s, want about %s", wait, LoginLockoutDuration)
+	}
+
+	// Emails are compared case-insensitively, so the lock cannot be sidestepped
+	expectBlocked(t, g, "  Locked@Example.com", "203.0.113.99", ErrAccountLocked)
+
+	status, err := g.Status(email)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if !status.Locked || status.LockedUntil == nil || status.RecentFailures != loginAccountMaxFailures {
+		t.Fatalf("Status = %+v, want locked with %d recent failures", status, loginAccountMaxFailures)
+	}
+
+	if err := g.UnlockAccount(email); err != nil {
+		t.Fatalf("UnlockAccount: %v", err)
+	}
+	if err := g.Check(email, testLoginIP); err != nil {
+		t.Fatalf("Check after UnlockAccount = %v, want nil", err)
+	}
+	// Unlocking also resets the counters, so the next failure does not lock again
+	failLogins(g, email, testLoginIP, 1)
+	if err := g.Check(email, testLoginIP); err != nil {
+		t.Fatalf("Check after one more failure = %v, want nil", err)
+	}
+}
+
+func TestLoginGuardIPBlock(t *testing.T) {
+	g := newTestLoginGuard(t)
+
+	// Spraying one password over many accounts is caught per address
+	for i := 0; i < loginIPMaxFailures; i++ {
+		failLogins(g, fmt.Sprintf("user%d@example.com", i), testLoginIP, 1)
+	}
+	expectBlocked(t, g, "fresh@example.com", testLoginIP, ErrLoginIPBlocked)
+	if err := g.Check("fresh@example.com", "203.0.113.99"); err != nil {
+		t.Fatalf("Check from another address = %v, want nil", err)
+	}
+
+	if err := g.UnblockIP(testLoginIP); err != nil {
+		t.Fatalf("UnblockIP: %v", err)
+	}
+	if err := g.Check("fresh@example.com", testLoginIP); err != nil {
+		t.Fatalf("Check after UnblockIP = %v, want nil", err)
+	}
+}
+
+func TestLoginGuardRecordSuccess(t *testing.T) {
+	g := newTestLoginGuard(t)
+	email := "success@example.com"
+
+	failLogins(g, email, testLoginIP, loginDelayAfter)
+	expectBlocked(t, g, email, testLoginIP, ErrLoginThrottled)
+
+	g.RecordSuccess(email)
+	if err := g.Check(email, testLoginIP); err != nil {
+		t.Fatalf("Check after RecordSuccess = %v, want nil", err)
+	}
+	failLogins(g, email, testLoginIP, loginDelayAfter-1)
+	if err := g.Check(email, testLoginIP); err != nil {
+		t.Fatalf("account counter was not reset: %v", err)
+	}
+
+	// The address keeps its count so one valid login cannot reset an attacker's budget
+	counted := loginDelayAfter + loginDelayAfter - 1
+	failLogins(g, "other@example.com", testLoginIP, loginIPMaxFailures-counted)
+	expectBlocked(t, g, email, testLoginIP, ErrLoginIPBlocked)
+}
+
+func TestLoginGuardOnlyCountsCheckedAttempts(t *testing.T) {
+	g := newTestLoginGuard(t)
+	email := "refused@example.com"
+
+	// Refused attempts never reached the password check
+	for i := 0; i < loginAccountMaxFailures; i++ {
+		g.RecordFailure(nil, email, testLoginIP, "test", authmodels.LoginFailureThrottled)
+		g.RecordFailure(nil, email, testLoginIP, "test", authmodels.LoginFailureRiskBlocked)
+	}
+	if err := g.Check(email, testLoginIP); err != nil {
+		t.Fatalf("Check after refused attempts = %v, want nil", err)
+	}
+
+	// They are still part of the security history
+	var attempts int64
+	if err := g.db.Model(&authmodels.LoginAttempt{}).Where("email = ?", email).Count(&attempts).Error; err != nil {
+		t.Fatalf("count attempts: %v", err)
+	}
+	if attempts != 2*loginAccountMaxFailures {
+		t.Fatalf("recorded %d attempts, want %d", attempts, 2*loginAccountMaxFailures)
+	}
+
+	// Wrong second-factor codes count like wrong passwords
+	for i := 0; i < loginAccountMaxFailures; i++ {
+		g.RecordFailure(nil, email, testLoginIP, "test", authmodels.LoginFailureInvalidCode)
+	}
+	expectBlocked(t, g, email, testLoginIP, ErrAccountLocked)
+
+	// Attempts refused while locked are not reported as failures either
+	g.RecordFailure(nil, email, testLoginIP, "test", authmodels.LoginFailureLocked)
+	status, err := g.Status(email)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if !status.Locked || status.RecentFailures != loginAccountMaxFailures {
+		t.Fatalf("Status = locked %v, %d recent failures; want locked, %d", status.Locked, status.RecentFailures, loginAccountMaxFailures)
+	}
+}
+
+func TestLoginGuardUnlockWithToken(t *testing.T) {
+	g := newTestLoginGuard(t)
+	email := "unlock@example.com"
+
+	failLogins(g, email, testLoginIP, loginAccountMaxFailures)
+	expectBlocked(t, g, email, testLoginIP, ErrAccountLocked)
+
+	// The link sendUnlockEmail would have emailed
+	if err := g.db.Create(&authmodels.VerificationToken{
+		Identifier: loginUnlockPrefix + email,
+		Token:      hashUnlockToken("unlock-token"),
+		ExpiresAt:  time.Now().Add(LoginLockoutDuration),
+	}).Error; err != nil {
+		t.Fatalf("create unlock token: %v", err)
+	}
+
+	if err := g.UnlockWithToken("wrong-token"); !errors.Is(err, ErrUnlockTokenInvalid) {
+		t.Fatalf("UnlockWithToken(wrong) = %v, want ErrUnlockTokenInvalid", err)
+	}
+	if err := g.UnlockWithToken("unlock-token"); err != nil {
+		t.Fatalf("UnlockWithToken: %v", err)
+	}
+	if err := g.Check(email, testLoginIP); err != nil {
+		t.Fatalf("Check after UnlockWithToken = %v, want nil", err)
+	}
+	if err := g.UnlockWithToken("unlock-token"); !errors.Is(err, ErrUnlockTokenInvalid) {
+		t.Fatalf("UnlockWithToken reused = %v, want ErrUnlockTokenInvalid", err)
+	}
+}
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	authmodels "mimbackend/internal/models/auth"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Actions a login can get from its risk score, from least to most strict
+const (
+	RiskActionAllow             = "allow"
+	RiskActionMFA               = "mfa"
+	RiskActionEmailConfirmation = "email_confirmation"
+	RiskActionBlock             = "block"
+)
+
+// Built-in risk signals
+const (
+	RiskSignalNewDevice    = "new_device"
+	RiskSignalBotUserAgent = "bot_user_agent"
+	RiskSignalRapidLogins  = "rapid_logins"
+	RiskSignalIPReputation = "ip_reputation"
+	RiskSignalUnusualHour  = "unusual_hour"
+	RiskSignalGeoChange    = "geo_change"
+)
+
+const (
+	rapidLoginWindow      = 5 * time.Minute
+	unusualHourMinHistory = 5  // sessions needed before login hours are compared
+	unusualHourHistory    = 50 // latest sessions the login hours are taken from
+)
+
+// LoginRiskContext is what risk signals look at: the user and the request of
+// a login that has not created its session yet
+type LoginRiskContext struct {
+	DB     *gorm.DB
+	UserID uuid.UUID
+	Info   *authmodels.SessionSecurityInfo
+	Now    time.Time
+}
+
+// RiskSignalFunc reports whether a signal fires for a login, with a short
+// detail kept in the score breakdown
+type RiskSignalFunc func(rc *LoginRiskContext) (fired bool, detail string)
+
+// LoginRiskSignal is a fired signal in a score breakdown
+type LoginRiskSignal struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// LoginRiskAssessment is the risk score of a login (0-100) with the signals it
+// is made of and the action its thresholds call for. It is stored in the
+// session metadata under "risk".
+type LoginRiskAssessment struct {
+	Score      int               `json:"score"`
+	Action     string            `json:"action"`
+	Signals    []LoginRiskSignal `json:"signals"`
+	AssessedAt time.Time         `json:"assessed_at"`
+}
+
+// TrustScore is the session trust score the assessment leads to
+func (a *LoginRiskAssessment) TrustScore() int {
+	return 100 - a.Score
+}
+
+// RequiredAction is the action left for a login made with loginMethod. A
+// passkey satisfies every step-up and a magic link already proved the email
+// address. Users without a second factor confirm by email instead of MFA.
+func (a *LoginRiskAssessment) RequiredAction(loginMethod string, hasMFA bool) string {
+	action := a.Action
+	if action == RiskActionBlock {
+		return action
+	}
+	if loginMethod == "webauthn" {
+		return RiskActionAllow
+	}
+	if action == RiskActionMFA && !hasMFA {
+		action = RiskActionEmailConfirmation
+	}
+	if action == RiskActionEmailConfirmation && loginMethod == "magic_link" {
+		return RiskActionAllow
+	}
+	return action
+}
+
+type riskSignal struct {
+	name          string
+	defaultWeight int
+	detect        RiskSignalFunc
+}
+
+var (
+	riskSignalsMu sync.RWMutex
+	riskSignals   = []riskSignal{
+		{RiskSignalNewDevice, 20, detectNewDevice},
+		{RiskSignalBotUserAgent, 40, detectBotUserAgent},
+		{RiskSignalRapidLogins, 30, detectRapidLogins},
+		{RiskSignalIPReputation, 50, detectIPReputation},
+		{RiskSignalUnusualHour, 10, detectUnusualHour},
+		{RiskSignalGeoChange, 20, detectGeoChange},
+	}
+	// riskWeightWarned holds the out-of-range weights already logged
+	riskWeightWarned sync.Map
+)
+
+// RegisterRiskSignal adds a signal to the login risk score, or replaces the
+// one with the same name. Its weight can be overridden with
+// RISK_WEIGHT_<NAME>; a weight of 0 turns the signal off.
+func RegisterRiskSignal(name string, defaultWeight int, detect RiskSignalFunc) {
+	riskSignalsMu.Lock()
+	defer riskSignalsMu.Unlock()
+	for i := range riskSignals {
+		if riskSignals[i].name == name {
+			riskSignals[i] = riskSignal{name, defaultWeight, detect}
+			return
+		}
+	}
+	riskSignals = append(riskSignals, riskSignal{name, defaultWeight, detect})
+}
+
+// LoginRiskConfig is the effective signal weights and action thresholds
+type LoginRiskConfig struct {
+	Weights    map[string]int `json:"weights"`
+	Thresholds map[string]int `json:"thresholds"`
+}
+
+// CurrentLoginRiskConfig returns the weights and thresholds in effect.
+// A threshold of 0 means the action is never taken.
+func CurrentLoginRiskConfig() LoginRiskConfig {
+	config := LoginRiskConfig{
+		Weights: make(map[string]int),
+		Thresholds: map[string]int{
+			RiskActionMFA:               envInt("RISK_THRESHOLD_MFA", 40),
+			RiskActionEmailConfirmation: envInt("RISK_THRESHOLD_EMAIL", 60),
+			RiskActionBlock:             envInt("RISK_THRESHOLD_BLOCK", 90),
+		},
+	}
+	riskSignalsMu.RLock()
+	defer riskSignalsMu.RUnlock()
+	for _, signal := range riskSignals {
+		config.Weights[signal.name] = riskSignalWeight(signal)
+	}
+	return config
+}
+
+// AssessLoginRisk scores a login of the user from the request's security
+// information. Signals that cannot be evaluated (e.g. on a database error)
+// do not fire; scoring never fails a login.
+func (s *SessionService) AssessLoginRisk(userID uuid.UUID, securityInfo *authmodels.SessionSecurityInfo) *LoginRiskAssessment {
+	rc := &LoginRiskContext{DB: s.db, UserID: userID, Info: securityInfo, Now: time.Now()}
+	assessment := &LoginRiskAssessment{Signals: []LoginRiskSignal{}, AssessedAt: rc.Now}
+
+	riskSignalsMu.RLock()
+	signals := append([]riskSignal(nil), riskSignals...)
+	riskSignalsMu.RUnlock()
+
+	for _, signal := range signals {
+		weight := riskSignalWeight(signal)
+		if weight == 0 {
+			continue
+		}
+		if fired, detail := signal.detect(rc); fired {
+			assessment.Score += weight
+			assessment.Signals = append(assessment.Signals, LoginRiskSignal{Name: signal.name, Weight: weight, Detail: detail})
+		}
+	}
+	if assessment.Score > 100 {
+		assessment.Score = 100
+	}
+
+	thresholds := CurrentLoginRiskConfig().Thresholds
+	assessment.Action = RiskActionAllow
+	for _, action := range []string{RiskActionMFA, RiskActionEmailConfirmation, RiskActionBlock} {
+		if threshold := thresholds[action]; threshold > 0 && assessment.Score >= threshold {
+			assessment.Action = action
+		}
+	}
+
+	return assessment
+}
+
+// LoginRiskEntry is the risk breakdown stored on one session
+type LoginRiskEntry struct {
+	SessionID    string               `json:"session_id"`
+	LoginAt      time.Time            `json:"login_at"`
+	LoginMethod  string               `json:"login_method"`
+	IPAddress    string               `json:"ip_address"`
+	Location     string               `json:"location,omitempty"`
+	TrustScore   int                  `json:"trust_score"`
+	IsSuspicious bool                 `json:"is_suspicious"`
+	Risk         *LoginRiskAssessment `json:"risk,omitempty"`
+}
+
+// LoginRiskHistory returns the risk breakdown of the user's latest logins
+func (s *SessionService) LoginRiskHistory(userID uuid.UUID, limit int) ([]LoginRiskEntry, error) {
+	var sessions []authmodels.UserSession
+	if err := s.db.Where("user_id = ? AND impersonator_id IS NULL", userID).
+		Order("login_at DESC").
+		Limit(limit).
+		Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	entries := make([]LoginRiskEntry, 0, len(sessions))
+	for _, session := range sessions {
+		entry := LoginRiskEntry{
+			SessionID:    session.SessionID,
+			LoginAt:      session.LoginAt,
+			LoginMethod:  session.LoginMethod,
+			IPAddress:    session.IPAddress,
+			Location:     session.Location,
+			TrustScore:   session.TrustScore,
+			IsSuspicious: session.IsSuspicious,
+		}
+		var metadata struct {
+			Risk *LoginRiskAssessment `json:"risk"`
+		}
+		if len(session.Metadata) > 0 && json.Unmarshal(session.Metadata, &metadata) == nil {
+			entry.Risk = metadata.Risk
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// RiskBlockedLogins returns the user's latest logins refused by the risk engine
+func (s *SessionService) RiskBlockedLogins(userID uuid.UUID, limit int) ([]authmodels.LoginAttempt, error) {
+	var attempts []authmodels.LoginAttempt
+	if err := s.db.Where("user_id = ? AND reason = ?", userID, authmodels.LoginFailureRiskBlocked).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&attempts).Error; err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// riskSignalWeight is the signal's weight kept within 0..100, so a negative
+// weight cannot lower the score other signals added
+func riskSignalWeight(signal riskSignal) int {
+	key := "RISK_WEIGHT_" + strings.ToUpper(signal.name)
+	weight := signal.defaultWeight
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		weight = v
+	}
+
+	clamped := max(0, min(weight, 100))
+	if clamped != weight {
+		if _, warned := riskWeightWarned.LoadOrStore(fmt.Sprintf("%s=%d", key, weight), true); !warned {
+			log.Printf("⚠️ %s is %d, outside 0..100; using %d", key, weight, clamped)
+		}
+	}
+	return clamped
+}
+
+// Built-in signals
+
+// detectNewDevice fires for a device fingerprint the user never logged in from
+func detectNewDevice(rc *LoginRiskContext) (bool, string) {
+	var count int64
+	if err := rc.DB.Model(&authmodels.UserSession{}).
+		Where("user_id = ? AND device_id = ?", rc.UserID, rc.Info.DeviceID).
+		Count(&count).Error; err != nil {
+		return false, ""
+	}
+	return count == 0, ""
+}
+
+func detectBotUserAgent(rc *LoginRiskContext) (bool, string) {
+	if isBot, _ := rc.Info.Metadata["is_bot"].(bool); isBot {
+		return true, rc.Info.UserAgent
+	}
+	return false, ""
+}
+
+// detectRapidLogins fires when the user already logged in several times
+// within the last few minutes
+func detectRapidLogins(rc *LoginRiskContext) (bool, string) {
+	var count int64
+	if err := rc.DB.Model(&authmodels.UserSession{}).
+		Where("user_id = ? AND created_at > ? AND impersonator_id IS NULL", rc.UserID, rc.Now.Add(-rapidLoginWindow)).
+		Count(&count).Error; err != nil {
+		return false, ""
+	}
+	limit := int64(envInt("RISK_RAPID_LOGIN_COUNT", 3))
+	if count < limit {
+		return false, ""
+	}
+	return true, fmt.Sprintf("%d logins in %s", count, rapidLoginWindow)
+}
+
+// detectIPReputation fires for addresses on the local list in RISK_IP_DENYLIST_FILE
+func detectIPReputation(rc *LoginRiskContext) (bool, string) {
+	ip := net.ParseIP(rc.Info.IPAddress)
+	if ip == nil {
+		return false, ""
+	}
+	riskIPDenyListOnce.Do(loadRiskIPDenyList)
+	for _, network := range riskIPDenyList {
+		if network.Contains(ip) {
+			return true, "listed in " + network.String()
+		}
+	}
+	return false, ""
+}
+
+// detectUnusualHour fires when the user has a login history and never logged
+// in within an hour of this time of day (UTC)
+func detectUnusualHour(rc *LoginRiskContext) (bool, string) {
+	var loginTimes []time.Time
+	if err := rc.DB.Model(&authmodels.UserSession{}).
+		Where("user_id = ? AND impersonator_id IS NULL", rc.UserID).
+		Order("login_at DESC").
+		Limit(unusualHourHistory).
+		Pluck("login_at", &loginTimes).Error; err != nil || len(loginTimes) < unusualHourMinHistory {
+		return false, ""
+	}
+
+	hour := rc.Now.UTC().Hour()
+	for _, t := range loginTimes {
+		diff := (t.UTC().Hour() - hour + 24) % 24
+		if diff <= 1 || diff >= 23 {
+			return false, ""
+		}
+	}
+	return true, fmt.Sprintf("no earlier login around %02d:00 UTC", hour)
+}
+
+// detectGeoChange fires when the GeoIP country differs from the user's
+// previous located login
+func detectGeoChange(rc *LoginRiskContext) (bool, string) {
+	if rc.Info.Country == "" {
+		return false, ""
+	}
+
+	var previous authmodels.UserSession
+	if err := rc.DB.Where("user_id = ? AND country <> ''", rc.UserID).
+		Where("impersonator_id IS NULL AND oauth_client_id IS NULL").
+		Order("login_at DESC").
+		First(&previous).Error; err != nil {
+		return false, ""
+	}
+	if previous.Country == rc.Info.Country {
+		return false, ""
+	}
+	return true, previous.Country + " -> " + rc.Info.Country
+}
+
+var (
+	riskIPDenyList     []*net.IPNet
+	riskIPDenyListOnce sync.Once
+)
+
+// loadRiskIPDenyList reads one IP address or CIDR range per line; lines
+// starting with # are comments
+func loadRiskIPDenyList() {
+	path := os.Getenv("RISK_IP_DENYLIST_FILE")
+	if path == "" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("login risk: failed to open ip deny-list %s: %v", path, err)
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("login risk: invalid ip deny-list entry %q", entry)
+			continue
+		}
+		riskIPDenyList = append(riskIPDenyList, network)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("login risk: failed to read ip deny-list %s: %v", path, err)
+	}
+}
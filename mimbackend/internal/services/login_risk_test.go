@@ -0,0 +1,243 @@
+package services
+
+import (
+	"testing"
+
+	authmodels "mimbackend/internal/models/auth"
+)
+
+// useRiskSignals replaces the registered risk signals for the test
+func useRiskSignals(t *testing.T, signals ...riskSignal) {
+	t.Helper()
+
+	riskSignalsMu.Lock()
+	saved := riskSignals
+	riskSignals = signals
+	riskSignalsMu.Unlock()
+	t.Cleanup(func() {
+		riskSignalsMu.Lock()
+		riskSignals = saved
+		riskSignalsMu.Unlock()
+	})
+}
+
+// fixedRiskSignal always fires with the given weight
+func fixedRiskSignal(name string, weight int) riskSignal {
+	return riskSignal{name, weight, func(*LoginRiskContext) (bool, string) { return true, name + " detail" }}
+}
+
+func TestRiskSignalWeight(t *testing.T) {
+	signal := riskSignal{name: "test_signal", defaultWeight: 25}
+	cases := map[string]int{
+		"":     25, // not set
+		"oops": 25,
+		"0":    0,
+		"60":   60,
+		"250":  100,
+		"-40":  0, // would otherwise lower the score of the other signals
+	}
+	for value, want := range cases {
+		t.Setenv("RISK_WEIGHT_TEST_SIGNAL", value)
+		if got := riskSignalWeight(signal); got != want {
+			t.Errorf("RISK_WEIGHT_TEST_SIGNAL=%q: weight %d, want %d", value, got, want)
+		}
+	}
+
+	signal.defaultWeight = -10
+	t.Setenv("RISK_WEIGHT_TEST_SIGNAL", "")
+	if got := riskSignalWeight(signal); got != 0 {
+		t.Errorf("negative default weight = %d, want 0", got)
+	}
+}
+
+func TestAssessLoginRiskThresholds(t *testing.T) {
+	s := newTestSessionService(t)
+	user := createTestUser(t, s.db, "risk@example.com")
+
+	// Default thresholds: mfa 40, email confirmation 60, block 90
+	cases := []struct {
+		weights []int
+		score   int
+		action  string
+	}{
+		{nil, 0, RiskActionAllow},
+		{[]int{39}, 39, RiskActionAllow},
+		{[]int{40}, 40, RiskActionMFA},
+		{[]int{30, 29}, 59, RiskActionMFA},
+		{[]int{30, 30}, 60, RiskActionEmailConfirmation},
+		{[]int{90}, 90, RiskActionBlock},
+		{[]int{100, 50}, 100, RiskActionBlock}, // the score is capped at 100
+	}
+	for _, tc := range cases {
+		signals := make([]riskSignal, 0, len(tc.weights))
+		for i, weight := range tc.weights {
+			signals = append(signals, fixedRiskSignal(string(rune('a'+i))+"_signal", weight))
+		}
+		useRiskSignals(t, signals...)
+
+		risk := s.AssessLoginRisk(user.ID, testSecurityInfo())
+		if risk.Score != tc.score || risk.Action != tc.action {
+			t.Errorf("weights %v: score %d, action %q; want %d, %q", tc.weights, risk.Score, risk.Action, tc.score, tc.action)
+		}
+		if risk.TrustScore() != 100-tc.score {
+			t.Errorf("weights %v: trust score %d, want %d", tc.weights, risk.TrustScore(), 100-tc.score)
+		}
+		if len(risk.Signals) != len(tc.weights) {
+			t.Fatalf("weights %v: %d signals in the breakdown, want %d", tc.weights, len(risk.Signals), len(tc.weights))
+		}
+		for i, signal := range risk.Signals {
+			if signal.Weight != tc.weights[i] || signal.Detail != signal.Name+" detail" {
+				t.Errorf("weights %v: signal %d = %+v", tc.weights, i, signal)
+			}
+		}
+	}
+}
+
+func TestAssessLoginRiskConfiguredThresholds(t *testing.T) {
+	s := newTestSessionService(t)
+	user := createTestUser(t, s.db, "thresholds@example.com")
+	useRiskSignals(t, fixedRiskSignal("quiet", 0), fixedRiskSignal("loud", 95))
+
+	// A threshold of 0 turns the action off
+	t.Setenv("RISK_THRESHOLD_BLOCK", "0")
+	risk := s.AssessLoginRisk(user.ID, testSecurityInfo())
+	if risk.Action != RiskActionEmailConfirmation {
+		t.Fatalf("action with block disabled = %q, want %q", risk.Action, RiskActionEmailConfirmation)
+	}
+	// Signals weighted 0 are skipped and left out of the breakdown
+	if len(risk.Signals) != 1 || risk.Signals[0].Name != "loud" {
+		t.Fatalf("signals = %+v, want only loud", risk.Signals)
+	}
+
+	t.Setenv("RISK_THRESHOLD_BLOCK", "95")
+	if risk := s.AssessLoginRisk(user.ID, testSecurityInfo()); risk.Action != RiskActionBlock {
+		t.Fatalf("action at RISK_THRESHOLD_BLOCK=95 = %q, want %q", risk.Action, RiskActionBlock)
+	}
+
+	// Weights are configured per signal too
+	t.Setenv("RISK_WEIGHT_LOUD", "10")
+	if risk := s.AssessLoginRisk(user.ID, testSecurityInfo()); risk.Score != 10 || risk.Action != RiskActionAllow {
+		t.Fatalf("RISK_WEIGHT_LOUD=10: score %d, action %q; want 10, allow", risk.Score, risk.Action)
+	}
+}
+
+func TestAssessLoginRiskBuiltinSignals(t *testing.T) {
+	s := newTestSessionService(t)
+	user := createTestUser(t, s.db, "builtin@example.com")
+	useRiskSignals(t,
+		riskSignal{RiskSignalNewDevice, 20, detectNewDevice},
+		riskSignal{RiskSignalBotUserAgent, 40, detectBotUserAgent},
+	)
+
+	info := testSecurityInfo()
+	info.DeviceID = "device-1"
+	risk := s.AssessLoginRisk(user.ID, info)
+	if risk.Score != 20 || risk.Action != RiskActionAllow || len(risk.Signals) != 1 || risk.Signals[0].Name != RiskSignalNewDevice {
+		t.Fatalf("first login = %+v, want new_device only", risk)
+	}
+
+	// Once the device has a session it no longer counts as new
+	if _, _, _, err := s.IssueSessionTokens(user, info, risk, testSessionTTL); err != nil {
+		t.Fatalf("IssueSessionTokens: %v", err)
+	}
+	if risk := s.AssessLoginRisk(user.ID, info); risk.Score != 0 {
+		t.Fatalf("login from a known device scored %d, want 0", risk.Score)
+	}
+
+	// A bot user agent on a new device needs email confirmation
+	bot := testSecurityInfo()
+	bot.DeviceID = "device-2"
+	bot.Metadata = map[string]interface{}{"is_bot": true}
+	if risk := s.AssessLoginRisk(user.ID, bot); risk.Score != 60 || risk.Action != RiskActionEmailConfirmation {
+		t.Fatalf("bot on a new device: score %d, action %q; want 60, %q", risk.Score, risk.Action, RiskActionEmailConfirmation)
+	}
+}
+
+func TestLoginRiskRequiredAction(t *testing.T) {
+	cases := []struct {
+		action, method string
+		hasMFA         bool
+		want           string
+	}{
+		{RiskActionAllow, "password", false, RiskActionAllow},
+		{RiskActionMFA, "password", true, RiskActionMFA},
+		// Users without a second factor confirm by email instead
+		{RiskActionMFA, "password", false, RiskActionEmailConfirmation},
+		{RiskActionEmailConfirmation, "password", true, RiskActionEmailConfirmation},
+		{RiskActionBlock, "password", true, RiskActionBlock},
+		// A passkey satisfies every step-up but not a block
+		{RiskActionMFA, "webauthn", false, RiskActionAllow},
+		{RiskActionEmailConfirmation, "webauthn", false, RiskActionAllow},
+		{RiskActionBlock, "webauthn", true, RiskActionBlock},
+		// A magic link already proved the email address
+		{RiskActionEmailConfirmation, "magic_link", false, RiskActionAllow},
+		{RiskActionMFA, "magic_link", false, RiskActionAllow},
+		{RiskActionMFA, "magic_link", true, RiskActionMFA},
+		{RiskActionBlock, "magic_link", false, RiskActionBlock},
+		// External logins step up the same way
+		{RiskActionMFA, "oauth_google", false, RiskActionEmailConfirmation},
+	}
+	for _, tc := range cases {
+		risk := &LoginRiskAssessment{Action: tc.action}
+		if got := risk.RequiredAction(tc.method, tc.hasMFA); got != tc.want {
+			t.Errorf("RequiredAction(%q, %q, mfa=%v) = %q, want %q", tc.action, tc.method, tc.hasMFA, got, tc.want)
+		}
+	}
+}
+
+func TestLoginRiskRecordedOnSession(t *testing.T) {
+	s := newTestSessionService(t)
+	user := createTestUser(t, s.db, "breakdown@example.com")
+	useRiskSignals(t, fixedRiskSignal(RiskSignalNewDevice, 20), fixedRiskSignal(RiskSignalGeoChange, 25))
+
+	risk := s.AssessLoginRisk(user.ID, testSecurityInfo())
+	_, _, session, err := s.IssueSessionTokens(user, testSecurityInfo(), risk, testSessionTTL)
+	if err != nil {
+		t.Fatalf("IssueSessionTokens: %v", err)
+	}
+
+	// The step-up was passed before the session was created
+	stored := reloadSession(t, s, session.SessionID)
+	if stored.TrustScore != 55 || stored.IsSuspicious {
+		t.Fatalf("session trust score %d, suspicious %v; want 55, false", stored.TrustScore, stored.IsSuspicious)
+	}
+
+	entries, err := s.LoginRiskHistory(user.ID, 10)
+	if err != nil {
+		t.Fatalf("LoginRiskHistory: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Risk == nil {
+		t.Fatalf("LoginRiskHistory = %+v, want one entry with a risk breakdown", entries)
+	}
+	got := entries[0].Risk
+	if got.Score != 45 || got.Action != RiskActionMFA || len(got.Signals) != 2 {
+		t.Fatalf("stored risk = %+v, want score 45, action mfa and two signals", got)
+	}
+	for i, name := range []string{RiskSignalNewDevice, RiskSignalGeoChange} {
+		if got.Signals[i].Name != name || got.Signals[i].Weight != risk.Signals[i].Weight || got.Signals[i].Detail != name+" detail" {
+			t.Errorf("stored signal %d = %+v, want %s", i, got.Signals[i], name)
+		}
+	}
+}
+
+func TestRiskBlockedLogins(t *testing.T) {
+	s := newTestSessionService(t)
+	if err := s.db.AutoMigrate(&authmodels.LoginAttempt{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	user := createTestUser(t, s.db, "blocked@example.com")
+
+	for _, reason := range []string{authmodels.LoginFailureRiskBlocked, authmodels.LoginFailureInvalidPassword, authmodels.LoginFailureRiskBlocked} {
+		if err := s.db.Create(&authmodels.LoginAttempt{UserID: &user.ID, Email: user.Email, Reason: reason}).Error; err != nil {
+			t.Fatalf("create attempt: %v", err)
+		}
+	}
+
+	attempts, err := s.RiskBlockedLogins(user.ID, 10)
+	if err != nil {
+		t.Fatalf("RiskBlockedLogins: %v", err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("RiskBlockedLogins returned %d attempts, want 2", len(attempts))
+	}
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mimbackend/config"
+	auth "mimbackend/internal/models/auth"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	MagicLinkTTL = 15 * time.Minute
+
+	magicLinkCooldown = time.Minute
+	// magicLinkPrefix keeps magic links apart from the 6-digit email codes that
+	// share the verification_tokens table
+	magicLinkPrefix = "magic_link:"
+)
+
+var (
+	ErrMagicLinkInvalid = errors.New("magic link is invalid or expired")
+	// ErrLoginConfirmationPending is returned while the previous confirmation
+	// link is cooling down; that link is still valid and no new email is sent
+	ErrLoginConfirmationPending = errors.New("a login confirmation link was sent moments ago")
+)
+
+// MagicLinkBindBrowser reports whether links only work in the browser that
+// requested them (MAGIC_LINK_BIND_BROWSER=true)
+func MagicLinkBindBrowser() bool {
+	return os.Getenv("MAGIC_LINK_BIND_BROWSER") == "true"
+}
+
+// SendMagicLink emails a single-use login link. Unknown emails are ignored
+// silently so the endpoint does not reveal registered addresses. binding is an
+// optional browser nonce; when set the link only works together with it.
+func SendMagicLink(email, binding string) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	email = strings.TrimSpace(email)
+	var user auth.User
+	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := issueMagicLinkToken(db, &user, binding)
+	if err != nil || token == "" {
+		return err
+	}
+
+	emailService := NewEmailService()
+	loginURL := emailService.frontendURL + "/auth/magic-link?token=" + url.QueryEscape(token)
+	return emailService.SendMagicLinkEmail(user.Email, user.FullName, loginURL)
+}
+
+// SendLoginConfirmation emails a link that completes a login held back by the
+// risk engine. It is a magic link: the frontend consumes it at
+// /auth/magic-link/consume, which proves ownership of the address. Within the
+// cooldown of the previous link it returns ErrLoginConfirmationPending.
+func SendLoginConfirmation(user *auth.User, binding string, securityInfo *auth.SessionSecurityInfo) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	token, err := issueMagicLinkToken(db, user, binding)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return ErrLoginConfirmationPending
+	}
+
+	device := securityInfo.DeviceType
+	if securityInfo.Browser != "" || securityInfo.OS != "" {
+		device = strings.Trim(securityInfo.Browser+" / "+securityInfo.OS, " /")
+	}
+	emailService := NewEmailService()
+	confirmURL := emailService.frontendURL + "/auth/magic-link?token=" + url.QueryEscape(token)
+	return emailService.SendLoginConfirmEmail(user.Email, user.FullName, confirmURL, device,
+		securityInfo.IPAddress, securityInfo.Location, time.Now())
+}
+
+// issueMagicLinkToken stores a new link token for the user, replacing older
+// ones. It returns an empty token while the previous link is still cooling down.
+func issueMagicLinkToken(db *gorm.DB, user *auth.User, binding string) (string, error) {
+	identifier := magicLinkPrefix + user.Email
+
+	// Throttle repeated requests for the same address
+	var recent int64
+	if err := db.Model(&auth.VerificationToken{}).
+		Where("identifier = ? AND created_at > ?", identifier, time.Now().Add(-magicLinkCooldown)).
+		Count(&recent).Error; err != nil {
+		return "", err
+	}
+	if recent > 0 {
+		return "", nil
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		return "", err
+	}
+
+	// A new link replaces the older ones
+	if err := db.Unscoped().Where("identifier = ?", identifier).Delete(&auth.VerificationToken{}).Error; err != nil {
+		return "", err
+	}
+	if err := db.Create(&auth.VerificationToken{
+		Identifier: identifier,
+		Token:      hashMagicLinkToken(token, binding),
+		ExpiresAt:  time.Now().Add(MagicLinkTTL),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeMagicLink validates and deletes the link token and returns its user.
+// binding must be the browser nonce the link was requested with, if any.
+func ConsumeMagicLink(token, binding string) (*auth.User, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var vt auth.VerificationToken
+	hashes := []string{hashMagicLinkToken(token, "")}
+	if binding != "" {
+		hashes = append(hashes, hashMagicLinkToken(token, binding))
+	}
+	if err := db.Where("token IN ? AND identifier LIKE ? AND expires_at > ?", hashes, magicLinkPrefix+"%", time.Now()).
+		First(&vt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMagicLinkInvalid
+		}
+		return nil, err
+	}
+
+	// Single use: whoever deletes the row first wins
+	result := db.Unscoped().Where("id = ?", vt.ID).Delete(&auth.VerificationToken{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrMagicLinkInvalid
+	}
+
+	var user auth.User
+	if err := db.Where("email = ?", strings.TrimPrefix(vt.Identifier, magicLinkPrefix)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMagicLinkInvalid
+		}
+		return nil, err
+	}
+
+	// Opening the link proves ownership of the address
+	if !user.IsVerified {
+		if err := db.Model(&user).Update("is_verified", true).Error; err != nil {
+			log.Printf("ConsumeMagicLink: failed to mark %s verified: %v", user.Email, err)
+		}
+	}
+
+	return &user, nil
+}
+
+// hashMagicLinkToken stores links hashed; a bound link also hashes the browser nonce
+func hashMagicLinkToken(token, binding string) string {
+	value := token
+	if binding != "" {
+		value = fmt.Sprintf("%s:%s", token, binding)
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
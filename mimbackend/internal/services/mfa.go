@@ -0,0 +1,552 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"mimbackend/config"
+	authmodels "mimbackend/internal/models/auth"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// TOTP parameters (RFC 6238 defaults, understood by every authenticator app)
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // accept one step before/after to tolerate clock drift
+
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+	mfaMaxOpenChallenges    = 3 // pending challenges per user; each allows mfaChallengeMaxAttempts codes
+	recoveryCodeCount       = 10
+)
+
+var (
+	ErrMFAAlreadyEnabled       = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled           = errors.New("two-factor authentication is not enabled")
+	ErrMFAEnrollmentNotStarted = errors.New("two-factor enrollment has not been started")
+	ErrInvalidMFACode          = errors.New("invalid two-factor code")
+	ErrMFAChallengeInvalid     = errors.New("mfa challenge is invalid or expired")
+	ErrMFAChallengeLimit       = errors.New("too many pending mfa challenges")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment is returned when enrollment starts. The client renders
+// ProvisioningURI as a QR code; Secret is shown for manual entry.
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// MFAService handles TOTP enrollment, recovery codes and login challenges
+type MFAService struct {
+	db *gorm.DB
+}
+
+// NewMFAService creates a new MFA service
+func NewMFAService() (*MFAService, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return &MFAService{db: db}, nil
+}
+
+// IsEnabled reports whether the user has a confirmed TOTP authenticator
+func (s *MFAService) IsEnabled(userID uuid.UUID) (bool, error) {
+	var count int64
+	if err := s.db.Model(&authmodels.UserTOTP{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL", userID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// BeginTOTPEnrollment creates a new secret for the user. Starting again before
+// confirming replaces the pending secret.
+func (s *MFAService) BeginTOTPEnrollment(user *authmodels.User) (*TOTPEnrollment, error) {
+	var totp authmodels.UserTOTP
+	findErr := s.db.Where("user_id = ?", user.ID).First(&totp).Error
+	if findErr == nil && totp.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if findErr != nil && !errors.Is(findErr, gorm.ErrRecordNotFound) {
+		return nil, findErr
+	}
+
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	secret := totpEncoding.EncodeToString(raw)
+	sealed, err := sealToken(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if findErr == nil {
+		if err := s.db.Model(&totp).Updates(map[string]interface{}{
+			"secret":         sealed,
+			"last_used_step": 0,
+		}).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		if err := s.db.Create(&authmodels.UserTOTP{UserID: user.ID, Secret: sealed}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment activates the pending authenticator once the user proves
+// it works and returns a fresh set of recovery codes. The codes are only
+// returned here; the database keeps their hashes.
+func (s *MFAService) ConfirmTOTPEnrollment(userID uuid.UUID, code string) ([]string, error) {
+	var totp authmodels.UserTOTP
+	if err := s.db.Where("user_id = ?", userID).First(&totp).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMFAEnrollmentNotStarted
+		}
+		return nil, err
+	}
+	if totp.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	if err := s.verifyTOTP(&totp, code); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(&totp).Update("confirmed_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+
+	return s.replaceRecoveryCodes(userID)
+}
+
+// DisableTOTP removes the authenticator and all recovery codes. code may be a
+// TOTP code or an unused recovery code.
+func (s *MFAService) DisableTOTP(userID uuid.UUID, code string) error {
+	if _, err := s.verifySecondFactor(userID, code); err != nil {
+		return err
+	}
+
+	// Hard delete: user_totps.user_id is unique and the user may enroll again
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&authmodels.UserTOTP{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", userID).Delete(&authmodels.MFARecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes invalidates the remaining recovery codes and returns a new set
+func (s *MFAService) RegenerateRecoveryCodes(userID uuid.UUID, code string) ([]string, error) {
+	if _, err := s.verifySecondFactor(userID, code); err != nil {
+		return nil, err
+	}
+	return s.replaceRecoveryCodes(userID)
+}
+
+// RemainingRecoveryCodes returns how many recovery codes are still unused
+func (s *MFAService) RemainingRecoveryCodes(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := s.db.Model(&authmodels.MFARecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	return count, err
+}
+
+// CreateChallenge starts the second login step for a user whose first factor
+// (loginMethod) was verified. risk is the assessment of that first step, if it
+// was scored. A user has at most mfaMaxOpenChallenges pending challenges, so
+// logging in again does not hand out fresh code attempts without limit.
+func (s *MFAService) CreateChallenge(userID uuid.UUID, loginMethod string, risk *LoginRiskAssessment) (string, time.Time, error) {
+	var open int64
+	if err := s.db.Model(&authmodels.MFAChallenge{}).
+		Where("user_id = ? AND consumed_at IS NULL AND expires_at > ? AND attempts < ?", userID, time.Now(), mfaChallengeMaxAttempts).
+		Count(&open).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	if open >= mfaMaxOpenChallenges {
+		return "", time.Time{}, ErrMFAChallengeLimit
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(raw)
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+
+	challenge := &authmodels.MFAChallenge{
+		UserID:      userID,
+		TokenHash:   hashMFAToken(token),
+		ExpiresAt:   expiresAt,
+		LoginMethod: loginMethod,
+	}
+	if risk != nil {
+		data, err := json.Marshal(risk)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		challenge.LoginRisk = datatypes.JSON(data)
+	}
+	if err := s.db.Create(challenge).Error; err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// ChallengeLogin returns the first factor and the risk assessment stored with
+// a login challenge, also after it was completed. risk is nil when the first
+// step was not scored.
+func (s *MFAService) ChallengeLogin(token string) (loginMethod string, risk *LoginRiskAssessment) {
+	var challenge authmodels.MFAChallenge
+	if err := s.db.Select("login_method", "login_risk").Where("token_hash = ?", hashMFAToken(token)).
+		First(&challenge).Error; err != nil {
+		return "", nil
+	}
+	if len(challenge.LoginRisk) > 0 {
+		risk = &LoginRiskAssessment{}
+		if err := json.Unmarshal(challenge.LoginRisk, risk); err != nil {
+			risk = nil
+		}
+	}
+	return challenge.LoginMethod, risk
+}
+
+// VerifyChallenge completes a login challenge. It returns the user and the
+// factor that was used ("totp" or "recovery_code"). Each challenge can be
+// completed once and allows a limited number of wrong codes.
+func (s *MFAService) VerifyChallenge(token, code string) (uuid.UUID, string, error) {
+	var method string
+	userID, err := s.CompleteChallenge(token, func(userID uuid.UUID) error {
+		var err error
+		method, err = s.verifySecondFactor(userID, code)
+		return err
+	})
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return userID, method, nil
+}
+
+// ChallengeUser returns the user of a pending challenge without completing it
+func (s *MFAService) ChallengeUser(token string) (uuid.UUID, error) {
+	challenge, err := s.activeChallenge(token)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return challenge.UserID, nil
+}
+
+// CompleteChallenge runs verify for the challenge's user and consumes the
+// challenge when it succeeds. Every verification counts against the
+// challenge's attempt limit. Factors other than TOTP (e.g. WebAuthn) use this
+// with their own verification.
+func (s *MFAService) CompleteChallenge(token string, verify func(userID uuid.UUID) error) (uuid.UUID, error) {
+	challenge, err := s.activeChallenge(token)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// Take an attempt before verifying, in one conditional update, so
+	// concurrent submissions cannot all pass the limit check
+	attempt := s.db.Model(&authmodels.MFAChallenge{}).
+		Where("id = ? AND attempts < ?", challenge.ID, mfaChallengeMaxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if attempt.Error != nil {
+		return uuid.Nil, attempt.Error
+	}
+	if attempt.RowsAffected == 0 {
+		return uuid.Nil, ErrMFAChallengeInvalid
+	}
+
+	if err := verify(challenge.UserID); err != nil {
+		return uuid.Nil, err
+	}
+
+	result := s.db.Model(&authmodels.MFAChallenge{}).
+		Where("id = ? AND consumed_at IS NULL", challenge.ID).
+		Update("consumed_at", time.Now())
+	if result.Error != nil {
+		return uuid.Nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return uuid.Nil, ErrMFAChallengeInvalid
+	}
+
+	return challenge.UserID, nil
+}
+
+// Methods lists the second factors the user can complete a login challenge
+// with. An empty list means the user has no second factor.
+func (s *MFAService) Methods(userID uuid.UUID) ([]string, error) {
+	methods := []string{}
+
+	totpEnabled, err := s.IsEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totpEnabled {
+		methods = append(methods, "totp", "recovery_code")
+	}
+
+	var passkeys int64
+	if err := s.db.Model(&authmodels.WebAuthnCredential{}).
+		Where("user_id = ?", userID).
+		Count(&passkeys).Error; err != nil {
+		return nil, err
+	}
+	if passkeys > 0 {
+		methods = append(methods, "webauthn")
+	}
+
+	return methods, nil
+}
+
+// Private helper methods
+
+func (s *MFAService) activeChallenge(token string) (*authmodels.MFAChallenge, error) {
+	var challenge authmodels.MFAChallenge
+	if err := s.db.Where("token_hash = ? AND consumed_at IS NULL AND expires_at > ? AND attempts < ?",
+		hashMFAToken(token), time.Now(), mfaChallengeMaxAttempts).
+		First(&challenge).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMFAChallengeInvalid
+		}
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// verifySecondFactor accepts a 6-digit TOTP code or a recovery code
+func (s *MFAService) verifySecondFactor(userID uuid.UUID, code string) (string, error) {
+	var totp authmodels.UserTOTP
+	if err := s.db.Where("user_id = ? AND confirmed_at IS NOT NULL", userID).First(&totp).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrMFANotEnabled
+		}
+		return "", err
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if isTOTPCode(code) {
+		if err := s.verifyTOTP(&totp, code); err != nil {
+			return "", err
+		}
+		return "totp", nil
+	}
+
+	if err := s.useRecoveryCode(userID, code); err != nil {
+		return "", err
+	}
+	return "recovery_code", nil
+}
+
+// verifyTOTP checks the code against the current time step (± skew). A step is
+// accepted once, so an observed code cannot be replayed within its window.
+func (s *MFAService) verifyTOTP(totp *authmodels.UserTOTP, code string) error {
+	secret, err := openToken(totp.Secret)
+	if err != nil {
+		return err
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return fmt.Errorf("invalid totp secret: %w", err)
+	}
+
+	current := time.Now().Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + int64(i)
+		if step <= totp.LastUsedStep {
+			continue
+		}
+		if !hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			continue
+		}
+
+		result := s.db.Model(&authmodels.UserTOTP{}).
+			Where("id = ? AND last_used_step < ?", totp.ID, step).
+			Update("last_used_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidMFACode
+		}
+		totp.LastUsedStep = step
+		return nil
+	}
+
+	return ErrInvalidMFACode
+}
+
+func (s *MFAService) useRecoveryCode(userID uuid.UUID, code string) error {
+	normalized := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	if normalized == "" {
+		return ErrInvalidMFACode
+	}
+
+	result := s.db.Model(&authmodels.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashMFAToken(normalized)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores a new set
+func (s *MFAService) replaceRecoveryCodes(userID uuid.UUID) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]authmodels.MFARecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		// 8 base32 characters shown as xxxx-xxxx
+		normalized := strings.ToLower(totpEncoding.EncodeToString(raw))
+		codes = append(codes, normalized[:4]+"-"+normalized[4:])
+		records = append(records, authmodels.MFARecoveryCode{
+			UserID:   userID,
+			CodeHash: hashMFAToken(normalized),
+		})
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&authmodels.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// totpCode computes the HOTP value (RFC 4226) for a time step
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// totpProvisioningURI builds the otpauth:// URI authenticator apps import from a QR code
+func totpProvisioningURI(account, secret string) string {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "MIM"
+	}
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", totpPeriod))
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + params.Encode()
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func hashMFAToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReencryptTOTPSecrets rewraps TOTP secrets with the active token encryption
+// key, like ReencryptAccountTokens does for provider tokens, and returns the
+// number of updated authenticators
+func ReencryptTOTPSecrets() (int, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return 0, err
+	}
+	return reencryptTOTPSecrets(db)
+}
+
+func reencryptTOTPSecrets(db *gorm.DB) (int, error) {
+	ring, err := getTokenKeys()
+	if err != nil || ring == nil {
+		return 0, err
+	}
+
+	updated := 0
+	var totps []authmodels.UserTOTP
+	result := db.Select("id", "secret").
+		Where("secret <> ''").
+		FindInBatches(&totps, 200, func(tx *gorm.DB, _ int) error {
+			for i := range totps {
+				secret, changed, err := rewrapToken(totps[i].Secret)
+				if err != nil {
+					log.Printf("ReencryptTOTPSecrets: totp %s: %v", totps[i].ID, err)
+					continue
+				}
+				if !changed {
+					continue
+				}
+				if err := db.Model(&authmodels.UserTOTP{}).Where("id = ?", totps[i].ID).
+					Update("secret", secret).Error; err != nil {
+					return err
+				}
+				updated++
+			}
+			return nil
+		})
+	return updated, result.Error
+}
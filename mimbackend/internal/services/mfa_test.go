@@ -0,0 +1,219 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	authmodels "mimbackend/internal/models/auth"
+)
+
+func newTestMFAService(t *testing.T) *MFAService {
+	t.Helper()
+	return &MFAService{db: newTestDB(t, &authmodels.User{}, &authmodels.UserTOTP{}, &authmodels.MFARecoveryCode{},
+		&authmodels.MFAChallenge{}, &authmodels.WebAuthnCredential{})}
+}
+
+// enrollTOTP enables TOTP for user and returns the shared secret
+func enrollTOTP(t *testing.T, s *MFAService, user *authmodels.User) []byte {
+	t.Helper()
+
+	enrollment, err := s.BeginTOTPEnrollment(user)
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment: %v", err)
+	}
+	key, err := totpEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	if _, err := s.ConfirmTOTPEnrollment(user.ID, totpCode(key, time.Now().Unix()/totpPeriod)); err != nil {
+		t.Fatalf("ConfirmTOTPEnrollment: %v", err)
+	}
+	return key
+}
+
+func TestMFAChallengeTOTP(t *testing.T) {
+	s := newTestMFAService(t)
+	user := createTestUser(t, s.db, "totp@example.com")
+	key := enrollTOTP(t, s, user)
+
+	challenge, _, err := s.CreateChallenge(user.ID, "password", nil)
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	if _, _, err := s.VerifyChallenge(challenge, "000000x"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("VerifyChallenge(wrong code) = %v, want ErrInvalidMFACode", err)
+	}
+
+	// The confirmation used the current step; the next one is still inside the skew
+	userID, method, err := s.VerifyChallenge(challenge, totpCode(key, time.Now().Unix()/totpPeriod+1))
+	if err != nil {
+		t.Fatalf("VerifyChallenge: %v", err)
+	}
+	if userID != user.ID || method != "totp" {
+		t.Fatalf("VerifyChallenge = %s, %q; want %s, totp", userID, method, user.ID)
+	}
+	if _, err := s.ChallengeUser(challenge); !errors.Is(err, ErrMFAChallengeInvalid) {
+		t.Fatalf("ChallengeUser after completion = %v, want ErrMFAChallengeInvalid", err)
+	}
+}
+
+func TestMFAChallengeAttemptLimit(t *testing.T) {
+	s := newTestMFAService(t)
+	user := createTestUser(t, s.db, "attempts@example.com")
+	key := enrollTOTP(t, s, user)
+
+	challenge, _, err := s.CreateChallenge(user.ID, "password", nil)
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	for i := 0; i < mfaChallengeMaxAttempts; i++ {
+		if _, _, err := s.VerifyChallenge(challenge, "wrong-code"); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("attempt %d = %v, want ErrInvalidMFACode", i+1, err)
+		}
+	}
+
+	// Once the attempts are used up not even the right code completes it
+	if _, _, err := s.VerifyChallenge(challenge, totpCode(key, time.Now().Unix()/totpPeriod+1)); !errors.Is(err, ErrMFAChallengeInvalid) {
+		t.Fatalf("VerifyChallenge after %d wrong codes = %v, want ErrMFAChallengeInvalid", mfaChallengeMaxAttempts, err)
+	}
+
+	// Parallel submissions on one challenge share the same limit
+	challenge, _, err = s.CreateChallenge(user.ID, "password", nil)
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	var verified atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4*mfaChallengeMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.CompleteChallenge(challenge, func(uuid.UUID) error {
+				verified.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				return ErrInvalidMFACode
+			})
+			if !errors.Is(err, ErrInvalidMFACode) && !errors.Is(err, ErrMFAChallengeInvalid) {
+				t.Errorf("concurrent CompleteChallenge = %v, want ErrInvalidMFACode or ErrMFAChallengeInvalid", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := verified.Load(); got != mfaChallengeMaxAttempts {
+		t.Fatalf("concurrent submissions verified %d codes, want %d", got, mfaChallengeMaxAttempts)
+	}
+}
+
+func TestMFAChallengeOpenLimit(t *testing.T) {
+	s := newTestMFAService(t)
+	user := createTestUser(t, s.db, "open@example.com")
+	other := createTestUser(t, s.db, "other@example.com")
+	enrollTOTP(t, s, user)
+
+	challenges := make([]string, 0, mfaMaxOpenChallenges)
+	for i := 0; i < mfaMaxOpenChallenges; i++ {
+		challenge, _, err := s.CreateChallenge(user.ID, "password", nil)
+		if err != nil {
+			t.Fatalf("CreateChallenge %d: %v", i+1, err)
+		}
+		challenges = append(challenges, challenge)
+	}
+	if _, _, err := s.CreateChallenge(user.ID, "password", nil); !errors.Is(err, ErrMFAChallengeLimit) {
+		t.Fatalf("CreateChallenge past the limit = %v, want ErrMFAChallengeLimit", err)
+	}
+
+	// The limit is per user
+	if _, _, err := s.CreateChallenge(other.ID, "password", nil); err != nil {
+		t.Fatalf("CreateChallenge for another user: %v", err)
+	}
+
+	// A challenge whose attempts are used up no longer counts as open
+	for i := 0; i < mfaChallengeMaxAttempts; i++ {
+		_, _, _ = s.VerifyChallenge(challenges[0], "wrong-code")
+	}
+	if _, _, err := s.CreateChallenge(user.ID, "password", nil); err != nil {
+		t.Fatalf("CreateChallenge after one was exhausted: %v", err)
+	}
+
+	// Neither does an expired one
+	if err := s.db.Model(&authmodels.MFAChallenge{}).
+		Where("token_hash = ?", hashMFAToken(challenges[1])).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("expire challenge: %v", err)
+	}
+	if _, _, err := s.CreateChallenge(user.ID, "password", nil); err != nil {
+		t.Fatalf("CreateChallenge after one expired: %v", err)
+	}
+	if _, _, err := s.CreateChallenge(user.ID, "password", nil); !errors.Is(err, ErrMFAChallengeLimit) {
+		t.Fatalf("CreateChallenge past the limit = %v, want ErrMFAChallengeLimit", err)
+	}
+}
+
+// useTestTokenKeys turns on token encryption with a throwaway key for the test
+func useTestTokenKeys(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("TOKEN_ENCRYPTION_KEYS", "test="+base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	ring, err := loadTokenKeys()
+	if err != nil {
+		t.Fatalf("load token keys: %v", err)
+	}
+	tokenKeysOnce.Do(func() {})
+	saved := tokenKeys
+	tokenKeys = ring
+	t.Cleanup(func() { tokenKeys = saved })
+}
+
+func TestTOTPSecretEncryptedAtRest(t *testing.T) {
+	s := newTestMFAService(t)
+	user := createTestUser(t, s.db, "sealed@example.com")
+
+	// A secret stored before encryption was turned on
+	legacy := createTestUser(t, s.db, "legacy@example.com")
+	legacyKey := enrollTOTP(t, s, legacy)
+
+	useTestTokenKeys(t)
+	key := enrollTOTP(t, s, user)
+
+	var stored authmodels.UserTOTP
+	if err := s.db.Where("user_id = ?", user.ID).First(&stored).Error; err != nil {
+		t.Fatalf("load totp: %v", err)
+	}
+	if !strings.HasPrefix(stored.Secret, encryptedTokenPrefix) || strings.Contains(stored.Secret, totpEncoding.EncodeToString(key)) {
+		t.Fatalf("stored secret %q is not sealed", stored.Secret)
+	}
+
+	challenge, _, err := s.CreateChallenge(user.ID, "password", nil)
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	if _, _, err := s.VerifyChallenge(challenge, totpCode(key, time.Now().Unix()/totpPeriod+1)); err != nil {
+		t.Fatalf("VerifyChallenge with a sealed secret: %v", err)
+	}
+
+	// The plaintext secret still verifies and is sealed by the startup pass
+	if n, err := reencryptTOTPSecrets(s.db); err != nil || n != 1 {
+		t.Fatalf("reencryptTOTPSecrets = %d, %v; want 1", n, err)
+	}
+	var legacyStored authmodels.UserTOTP
+	if err := s.db.Where("user_id = ?", legacy.ID).First(&legacyStored).Error; err != nil {
+		t.Fatalf("load legacy totp: %v", err)
+	}
+	if !strings.HasPrefix(legacyStored.Secret, encryptedTokenPrefix) {
+		t.Fatalf("legacy secret %q was not sealed", legacyStored.Secret)
+	}
+	challenge, _, err = s.CreateChallenge(legacy.ID, "password", nil)
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	if _, _, err := s.VerifyChallenge(challenge, totpCode(legacyKey, time.Now().Unix()/totpPeriod+1)); err != nil {
+		t.Fatalf("VerifyChallenge after sealing the legacy secret: %v", err)
+	}
+}
@@ -15,16 +15,11 @@ import (
 )
 
 type OAuthToken struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	ExpiresIn   int    `json:"expires_in"`
-}
-
-type GoogleUserInfo struct {
-	ID      string `json:"id"`
-	Email   string `json:"email"`
-	Name    string `json:"name"`
-	Picture string `json:"picture"`
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"` // OpenID Connect providers
 }
 
 type FacebookUserInfo struct {
@@ -46,90 +41,8 @@ type GithubUserInfo struct {
 	AvatarURL string `json:"avatar_url"`
 }
 
-// ExchangeGoogleCode Google authorization code'u access token'a çevirir
-func ExchangeGoogleCode(code string) (*OAuthToken, error) {
-	data := url.Values{}
-	data.Set("client_id", os.Getenv("GOOGLE_CLIENT_ID"))
-	data.Set("client_secret", os.Getenv("GOOGLE_CLIENT_SECRET"))
-	data.Set("code", code)
-	data.Set("grant_type", "authorization_code")
-	redirect := os.Getenv("GOOGLE_REDIRECT_URI")
-	if redirect == "" {
-		base := os.Getenv("BASE_URL")
-		if base == "" {
-			base = "http://localhost:3333"
-		}
-		apiPrefix := os.Getenv("API_PREFIX")
-		if apiPrefix == "" {
-			apiPrefix = "/api/v1"
-		}
-		redirect = strings.TrimRight(base, "/") + apiPrefix + "/auth/google/callback"
-	}
-	data.Set("redirect_uri", redirect)
-
-	req, err := http.NewRequest("POST", "https://oauth2.googleapis.com/token", strings.NewReader(data.Encode()))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("failed to exchange code")
-	}
-
-	var token OAuthToken
-	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
-		return nil, err
-	}
-
-	return &token, nil
-}
-
-// GetGoogleUserInfo Google access token ile user bilgilerini alır
-func GetGoogleUserInfo(accessToken string) (*GoogleUserInfo, error) {
-	req, err := http.NewRequest("GET", "https://www.googleapis.com/oauth2/v2/userinfo", nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("failed to get user info")
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	log.Printf("Google userinfo response: %s", string(body))
-
-	var userInfo GoogleUserInfo
-	if err := json.Unmarshal(body, &userInfo); err != nil {
-		return nil, err
-	}
-
-	return &userInfo, nil
-}
-
 // ExchangeFacebookCode Facebook authorization code'u access token'a çevirir
-func ExchangeFacebookCode(code string) (*OAuthToken, error) {
+func ExchangeFacebookCode(code, codeVerifier string) (*OAuthToken, error) {
 	params := url.Values{}
 	params.Set("client_id", os.Getenv("FACEBOOK_CLIENT_ID"))
 	params.Set("client_secret", os.Getenv("FACEBOOK_CLIENT_SECRET"))
@@ -147,6 +60,9 @@ func ExchangeFacebookCode(code string) (*OAuthToken, error) {
 		fbRedirect = strings.TrimRight(base, "/") + apiPrefix + "/auth/facebook/callback"
 	}
 	params.Set("redirect_uri", fbRedirect)
+	if codeVerifier != "" {
+		params.Set("code_verifier", codeVerifier)
+	}
 
 	resp, err := http.Get("https://graph.facebook.com/v18.0/oauth/access_token?" + params.Encode())
 	if err != nil {
@@ -194,7 +110,7 @@ func GetFacebookUserInfo(accessToken string) (*FacebookUserInfo, error) {
 }
 
 // ExchangeGithubCode Github authorization code'u access token'a çevirir
-func ExchangeGithubCode(code string) (*OAuthToken, error) {
+func ExchangeGithubCode(code, codeVerifier string) (*OAuthToken, error) {
 	data := url.Values{}
 	data.Set("client_id", os.Getenv("GITHUB_CLIENT_ID"))
 	data.Set("client_secret", os.Getenv("GITHUB_CLIENT_SECRET"))
@@ -212,6 +128,9 @@ func ExchangeGithubCode(code string) (*OAuthToken, error) {
 		ghRedirect = strings.TrimRight(base, "/") + apiPrefix + "/auth/github/callback"
 	}
 	data.Set("redirect_uri", ghRedirect)
+	if codeVerifier != "" {
+		data.Set("code_verifier", codeVerifier)
+	}
 
 	req, err := http.NewRequest("POST", "https://github.com/login/oauth/access_token", strings.NewReader(data.Encode()))
 	if err != nil {
@@ -347,36 +266,13 @@ func FindOrCreateOAuthUser(provider, providerID, email, name, imageURL string) (
 		return &user, nil
 	}
 
-	// Account bulunamadı, email ile user var mı kontrol et
+	// Account bulunamadı, aynı email ile kayıtlı bir user varsa otomatik
+	// birleştirme yapılmaz: provider email'i doğrulamamış olabilir. Kullanıcı
+	// önce kendi hesabıyla giriş yapıp provider'ı /user/accounts üzerinden bağlamalı.
 	var existingUser auth.User
 	if err := db.Where("email = ?", email).First(&existingUser).Error; err == nil {
-		// User var, account oluştur
-		account = auth.Account{
-			UserID:     existingUser.ID,
-			Provider:   provider,
-			ProviderID: providerID,
-		}
-		if err := db.Create(&account).Error; err != nil {
-			return nil, err
-		}
-		// IsVerified'i true yap
-		if !existingUser.IsVerified {
-			existingUser.IsVerified = true
-			if err := db.Save(&existingUser).Error; err != nil {
-				return nil, err
-			}
-		}
-		// ImageURL ekle
-		if imageURL != "" && (existingUser.ImageURL == nil || *existingUser.ImageURL == "" || *existingUser.ImageURL != imageURL) {
-			log.Printf("Updating existing email user imageURL from %v to %s", existingUser.ImageURL, imageURL)
-			existingUser.ImageURL = &imageURL
-			if err := db.Save(&existingUser).Error; err != nil {
-				log.Printf("Failed to update user imageURL: %v", err)
-				return nil, err
-			}
-			log.Printf("Updated user imageURL successfully")
-		}
-		return &existingUser, nil
+		log.Printf("FindOrCreateOAuthUser: %s account %s matches existing user %s by email; link required", provider, providerID, existingUser.ID)
+		return nil, ErrOAuthAccountLinkRequired
 	}
 
 	// Yeni user oluştur
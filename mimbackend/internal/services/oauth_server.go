@@ -0,0 +1,971 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"mimbackend/config"
+	auth "mimbackend/internal/models/auth"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// OAuthClientIDPrefix and OAuthClientSecretPrefix mark the credentials of
+	// third-party apps registered with the authorization server
+	OAuthClientIDPrefix     = "mim_client_"
+	OAuthClientSecretPrefix = "mim_cs_"
+
+	// OAuthTokenUseRefresh is the TokenUse of delegated refresh tokens
+	OAuthTokenUseRefresh = TokenUseRefresh
+	// OAuthLoginMethod is the login method of sessions created for OAuth clients
+	OAuthLoginMethod = "oauth_client"
+	// PKCEMethodS256 is the only accepted code_challenge_method
+	PKCEMethodS256 = "S256"
+
+	oauthCodeTTL        = 5 * time.Minute
+	oauthAccessTokenTTL = time.Hour
+	oauthSessionTTL     = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrDelegatedToken is returned when a third-party app token is used where
+	// only first-party tokens are accepted
+	ErrDelegatedToken        = errors.New("token was issued to a third-party application")
+	ErrOAuthClientNotFound   = errors.New("oauth client not found")
+	ErrOAuthClientPublic     = errors.New("public clients have no secret")
+	ErrOAuthConsentNotFound  = errors.New("oauth authorization not found")
+	ErrInvalidOAuthClientURI = errors.New("invalid redirect uri")
+)
+
+// OAuthError is a protocol error of the authorization server. Code is one of
+// the RFC 6749 error codes (invalid_request, invalid_client, invalid_grant,
+// invalid_scope, access_denied, ...); handlers return it as is.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// IsDelegated reports whether the token was issued to a third-party app
+func (c *Claims) IsDelegated() bool {
+	return c.ClientID != ""
+}
+
+// OAuthClientInput describes a client registration
+type OAuthClientInput struct {
+	Name         string
+	Description  string
+	HomepageURL  string
+	RedirectURIs []string
+	Scopes       []string
+	IsPublic     bool
+}
+
+// CreateOAuthClient registers a third-party app for companyID. For
+// confidential clients the plain secret is returned only here.
+func CreateOAuthClient(companyID, createdBy uuid.UUID, input OAuthClientInput) (string, *auth.OAuthClient, error) {
+	redirectURIs, err := validateRedirectURIs(input.RedirectURIs)
+	if err != nil {
+		return "", nil, err
+	}
+	scopes, err := NormalizeTokenScopes(input.Scopes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, err := generateResetToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	client := &auth.OAuthClient{
+		CompanyID:    companyID,
+		ClientID:     OAuthClientIDPrefix + id[:32],
+		Name:         strings.TrimSpace(input.Name),
+		Description:  strings.TrimSpace(input.Description),
+		HomepageURL:  strings.TrimSpace(input.HomepageURL),
+		RedirectURIs: datatypes.JSONSlice[string](redirectURIs),
+		Scopes:       datatypes.JSONSlice[string](scopes),
+		IsPublic:     input.IsPublic,
+		CreatedBy:    createdBy,
+	}
+
+	var secret string
+	if !input.IsPublic {
+		if secret, err = newOAuthClientSecret(); err != nil {
+			return "", nil, err
+		}
+		client.ClientSecretHash = hashAPIToken(secret)
+	}
+
+	if err := db.Create(client).Error; err != nil {
+		return "", nil, err
+	}
+	return secret, client, nil
+}
+
+// ListOAuthClients returns the active clients of a company, newest first
+func ListOAuthClients(companyID uuid.UUID) ([]auth.OAuthClient, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []auth.OAuthClient
+	if err := db.Where("company_id = ? AND revoked_at IS NULL", companyID).
+		Order("created_at DESC").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// UpdateOAuthClient replaces the registration details of a client. Narrowed
+// scopes apply to existing grants on their next refresh.
+func UpdateOAuthClient(companyID, id uuid.UUID, input OAuthClientInput) (*auth.OAuthClient, error) {
+	client, err := findOAuthClient(companyID, id)
+	if err != nil {
+		return nil, err
+	}
+	redirectURIs, err := validateRedirectURIs(input.RedirectURIs)
+	if err != nil {
+		return nil, err
+	}
+	scopes, err := NormalizeTokenScopes(input.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	client.Name = strings.TrimSpace(input.Name)
+	client.Description = strings.TrimSpace(input.Description)
+	client.HomepageURL = strings.TrimSpace(input.HomepageURL)
+	client.RedirectURIs = datatypes.JSONSlice[string](redirectURIs)
+	client.Scopes = datatypes.JSONSlice[string](scopes)
+	if err := db.Model(client).Updates(map[string]interface{}{
+		"name":          client.Name,
+		"description":   client.Description,
+		"homepage_url":  client.HomepageURL,
+		"redirect_uris": client.RedirectURIs,
+		"scopes":        client.Scopes,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// RotateOAuthClientSecret replaces the secret of a confidential client. The
+// old secret stops working immediately; issued tokens stay valid.
+func RotateOAuthClientSecret(companyID, id uuid.UUID) (string, *auth.OAuthClient, error) {
+	client, err := findOAuthClient(companyID, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if client.IsPublic {
+		return "", nil, ErrOAuthClientPublic
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := newOAuthClientSecret()
+	if err != nil {
+		return "", nil, err
+	}
+	client.ClientSecretHash = hashAPIToken(secret)
+	if err := db.Model(client).Update("client_secret_hash", client.ClientSecretHash).Error; err != nil {
+		return "", nil, err
+	}
+	return secret, client, nil
+}
+
+// DeleteOAuthClient revokes a client together with every consent and session
+// granted to it
+func DeleteOAuthClient(companyID, id uuid.UUID) error {
+	client, err := findOAuthClient(companyID, id)
+	if err != nil {
+		return err
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := db.Model(client).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&auth.OAuthConsent{}).
+		Where("client_id = ? AND revoked_at IS NULL", client.ID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return revokeOAuthSessions(db.Where("oauth_client_id = ?", client.ID))
+}
+
+// OAuthAuthorizeRequest holds the query parameters of an authorization request
+type OAuthAuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// OAuthAuthorization is a validated authorization request waiting for the
+// user's decision
+type OAuthAuthorization struct {
+	Client        *auth.OAuthClient
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+}
+
+// ValidateAuthorizeRequest checks an authorization request made on behalf of
+// userID. When the returned error is an OAuthError and the authorization is
+// not nil, the client and redirect URI were verified and the error may be
+// sent back to the client's redirect URI; otherwise it must only be shown to the user.
+func ValidateAuthorizeRequest(userID uuid.UUID, req OAuthAuthorizeRequest) (*OAuthAuthorization, error) {
+	client, err := findOAuthClientByClientID(req.ClientID)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, oauthError("invalid_client", "unknown client_id")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		return nil, oauthError("invalid_request", "redirect_uri is not registered for this client")
+	}
+
+	authorization := &OAuthAuthorization{
+		Client:      client,
+		RedirectURI: req.RedirectURI,
+		State:       req.State,
+	}
+
+	if req.ResponseType != "code" {
+		return authorization, oauthError("unsupported_response_type", "only response_type=code is supported")
+	}
+	if req.CodeChallengeMethod != PKCEMethodS256 || !validPKCEValue(req.CodeChallenge) {
+		return authorization, oauthError("invalid_request", "PKCE with code_challenge_method=S256 is required")
+	}
+	authorization.CodeChallenge = req.CodeChallenge
+
+	scopes, err := requestedOAuthScopes(client, req.Scope)
+	if err != nil {
+		return authorization, err
+	}
+	authorization.Scopes = scopes
+
+	// Clients act inside the company that registered them
+	member, err := GetUserCompanyMembership(userID, client.CompanyID)
+	if err != nil || !member.IsActive {
+		return authorization, oauthError("access_denied", "user is not an active member of the client's company")
+	}
+
+	return authorization, nil
+}
+
+// HasOAuthConsent reports whether userID already granted every scope of the authorization
+func HasOAuthConsent(userID uuid.UUID, authorization *OAuthAuthorization) (bool, error) {
+	consent, err := findOAuthConsent(userID, authorization.Client.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, scope := range authorization.Scopes {
+		if !oauthScopeCovered(consent.Scopes, scope) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ApproveOAuthAuthorization records the user's consent and returns the
+// authorization code for the client
+func ApproveOAuthAuthorization(userID uuid.UUID, authorization *OAuthAuthorization) (string, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return "", err
+	}
+
+	consent, err := findOAuthConsent(userID, authorization.Client.ID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		consent = &auth.OAuthConsent{
+			UserID:   userID,
+			ClientID: authorization.Client.ID,
+			Scopes:   datatypes.JSONSlice[string](authorization.Scopes),
+		}
+		if err := db.Create(consent).Error; err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	default:
+		scopes := append([]string{}, consent.Scopes...)
+		for _, scope := range authorization.Scopes {
+			if !containsString(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+		if err := db.Model(consent).Update("scopes", datatypes.JSONSlice[string](scopes)).Error; err != nil {
+			return "", err
+		}
+	}
+
+	code, err := generateResetToken()
+	if err != nil {
+		return "", err
+	}
+	if err := db.Create(&auth.OAuthAuthorizationCode{
+		CodeHash:            hashAPIToken(code),
+		ClientID:            authorization.Client.ID,
+		UserID:              userID,
+		RedirectURI:         authorization.RedirectURI,
+		Scopes:              datatypes.JSONSlice[string](authorization.Scopes),
+		CodeChallenge:       authorization.CodeChallenge,
+		CodeChallengeMethod: PKCEMethodS256,
+		ExpiresAt:           time.Now().Add(oauthCodeTTL),
+	}).Error; err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// OAuthRedirectURL appends params to a verified redirect URI
+func OAuthRedirectURL(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// AuthenticateOAuthClient checks the credentials a client sent to the token,
+// introspection or revocation endpoint. Public clients send no secret.
+func AuthenticateOAuthClient(clientID, clientSecret string) (*auth.OAuthClient, error) {
+	client, err := findOAuthClientByClientID(clientID)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if client.IsPublic {
+		if clientSecret != "" {
+			return nil, oauthError("invalid_client", "client authentication failed")
+		}
+		return client, nil
+	}
+	if clientSecret == "" ||
+		subtle.ConstantTimeCompare([]byte(hashAPIToken(clientSecret)), []byte(client.ClientSecretHash)) != 1 {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	return client, nil
+}
+
+// OAuthTokenResponse is the RFC 6749 token endpoint response
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// ExchangeAuthorizationCode redeems an authorization code. The tokens are
+// bound to a new user session that belongs to the client.
+func ExchangeAuthorizationCode(client *auth.OAuthClient, code, redirectURI, codeVerifier string, securityInfo *auth.SessionSecurityInfo) (*OAuthTokenResponse, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var record auth.OAuthAuthorizationCode
+	if err := db.Where("code_hash = ?", hashAPIToken(code)).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, oauthError("invalid_grant", "invalid authorization code")
+		}
+		return nil, err
+	}
+	if record.ClientID != client.ID {
+		return nil, oauthError("invalid_grant", "invalid authorization code")
+	}
+
+	// Mark the code used first so two concurrent exchanges cannot both win
+	now := time.Now()
+	result := db.Model(&auth.OAuthAuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", record.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// A replayed code means it leaked: revoke what it was exchanged for (RFC 6749 4.1.2)
+		var used auth.OAuthAuthorizationCode
+		if err := db.Where("id = ?", record.ID).First(&used).Error; err == nil && used.SessionID != "" {
+			if err := revokeOAuthSessions(db.Where("session_id = ?", used.SessionID)); err != nil {
+				log.Printf("oauth: failed to revoke session of replayed code %s: %v", record.ID, err)
+			}
+		}
+		return nil, oauthError("invalid_grant", "authorization code was already used")
+	}
+
+	if now.After(record.ExpiresAt) {
+		return nil, oauthError("invalid_grant", "authorization code expired")
+	}
+	if record.RedirectURI != redirectURI {
+		return nil, oauthError("invalid_grant", "redirect_uri does not match the authorization request")
+	}
+	if !verifyPKCE(record.CodeChallenge, codeVerifier) {
+		return nil, oauthError("invalid_grant", "code_verifier does not match the code_challenge")
+	}
+
+	user, err := GetUserByID(record.UserID)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "user not found")
+	}
+	if member, err := GetUserCompanyMembership(user.ID, client.CompanyID); err != nil || !member.IsActive {
+		return nil, oauthError("invalid_grant", "user is no longer an active member of the client's company")
+	}
+
+	sessions, err := NewSessionService()
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string(record.Scopes)
+	sessionID := uuid.New().String()
+	accessToken, refreshToken, err := generateDelegatedTokens(user, sessionID, initialTokenVersion, client, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	securityInfo.LoginMethod = OAuthLoginMethod
+	if _, err := sessions.createSession(sessionID, user.ID, refreshToken, securityInfo, nil, oauthSessionTTL, &client.ID); err != nil {
+		return nil, err
+	}
+	if err := db.Model(&auth.OAuthAuthorizationCode{}).Where("id = ?", record.ID).
+		Update("session_id", sessionID).Error; err != nil {
+		log.Printf("oauth: failed to link code %s to session %s: %v", record.ID, sessionID, err)
+	}
+
+	return &OAuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// RefreshOAuthToken rotates a delegated refresh token. scope may narrow the
+// original grant; scopes the client no longer has are dropped.
+func RefreshOAuthToken(client *auth.OAuthClient, refreshToken, scope string) (*OAuthTokenResponse, error) {
+	claims, err := ValidateJWT(refreshToken)
+	if err != nil || claims.TokenUse != OAuthTokenUseRefresh || claims.ClientID != client.ClientID {
+		return nil, oauthError("invalid_grant", "invalid refresh token")
+	}
+
+	sessions, err := NewSessionService()
+	if err != nil {
+		return nil, err
+	}
+
+	// Reuse of a rotated token revokes the whole family here
+	session, err := sessions.GetSessionByToken(refreshToken)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "refresh token is invalid, expired or revoked")
+	}
+	if session.OAuthClientID == nil || *session.OAuthClientID != client.ID {
+		return nil, oauthError("invalid_grant", "invalid refresh token")
+	}
+
+	user, err := GetUserByID(session.UserID)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "user not found")
+	}
+	if member, err := GetUserCompanyMembership(user.ID, client.CompanyID); err != nil || !member.IsActive {
+		return nil, oauthError("invalid_grant", "user is no longer an active member of the client's company")
+	}
+
+	granted := strings.Fields(claims.Scope)
+	scopes := make([]string, 0, len(granted))
+	if scope != "" {
+		for _, requested := range strings.Fields(scope) {
+			if !containsString(granted, requested) {
+				return nil, oauthError("invalid_scope", "requested scope exceeds the original grant")
+			}
+		}
+		granted = strings.Fields(scope)
+	}
+	for _, s := range granted {
+		if oauthScopeCovered(client.Scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, oauthError("invalid_scope", "none of the granted scopes is allowed for this client anymore")
+	}
+
+	accessToken, newRefreshToken, err := generateDelegatedTokens(user, session.SessionID, session.TokenVersion, client, scopes)
+	if err != nil {
+		return nil, err
+	}
+	if err := sessions.RotateRefreshToken(session, refreshToken, newRefreshToken, oauthSessionTTL); err != nil {
+		return nil, oauthError("invalid_grant", "refresh token is invalid, expired or revoked")
+	}
+
+	return &OAuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: newRefreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// OAuthIntrospection is the RFC 7662 introspection response
+type OAuthIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	CompanyID string `json:"company_id,omitempty"`
+}
+
+// IntrospectOAuthToken reports whether a token issued to client is still
+// active. Tokens of other clients and first-party tokens are reported as inactive.
+func IntrospectOAuthToken(client *auth.OAuthClient, token string) (*OAuthIntrospection, error) {
+	inactive := &OAuthIntrospection{Active: false}
+
+	claims, err := ValidateJWT(token)
+	if err != nil || claims.ClientID != client.ClientID {
+		return inactive, nil
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	tokenType := "Bearer"
+	if claims.TokenUse == OAuthTokenUseRefresh {
+		tokenType = "refresh_token"
+		active, err := delegatedRefreshTokenActive(token, claims)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return inactive, nil
+		}
+	} else if err := checkSessionTokenVersion(db, claims); errors.Is(err, ErrSessionRevoked) {
+		return inactive, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	resp := &OAuthIntrospection{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Email,
+		TokenType: tokenType,
+		Sub:       claims.Subject,
+		Iss:       claims.Issuer,
+		CompanyID: claims.CompanyID,
+	}
+	if claims.ExpiresAt != nil {
+		resp.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		resp.Iat = claims.IssuedAt.Unix()
+	}
+	return resp, nil
+}
+
+// RevokeOAuthToken implements RFC 7009. Revoking either token of a grant ends
+// its session, which invalidates the other one too. Unknown tokens and tokens
+// of other clients are ignored, as the RFC requires.
+func RevokeOAuthToken(client *auth.OAuthClient, token string) error {
+	claims, err := ValidateJWT(token)
+	if err != nil || claims.ClientID != client.ClientID || claims.SessionID == "" {
+		return nil
+	}
+
+	sessions, err := NewSessionService()
+	if err != nil {
+		return err
+	}
+	if claims.TokenUse == OAuthTokenUseRefresh {
+		return sessions.LogoutSession(token)
+	}
+	return sessions.RevokeSession(claims.SessionID, claims.UserID)
+}
+
+// ListOAuthConsents returns the apps a user has authorized
+func ListOAuthConsents(userID uuid.UUID) ([]auth.OAuthConsent, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var consents []auth.OAuthConsent
+	if err := db.Preload("Client").
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("updated_at DESC").Find(&consents).Error; err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+// RevokeOAuthConsent withdraws a user's authorization of an app and ends
+// every session the app holds for the user
+func RevokeOAuthConsent(userID, consentID uuid.UUID) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	var consent auth.OAuthConsent
+	if err := db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", consentID, userID).
+		First(&consent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrOAuthConsentNotFound
+		}
+		return err
+	}
+
+	if err := db.Model(&consent).Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+	return revokeOAuthSessions(db.Where("user_id = ? AND oauth_client_id = ?", userID, consent.ClientID))
+}
+
+// DelegatedTokenAllows reports whether a third-party app token may perform
+// action on resource: a granted scope must cover it and the user must still
+// hold the permission in the client's company.
+func DelegatedTokenAllows(claims *Claims, resource, action, clientIP string) (bool, error) {
+	if !TokenScopesAllow(strings.Fields(claims.Scope), resource, action) {
+		return false, nil
+	}
+	companyID, err := uuid.Parse(claims.CompanyID)
+	if err != nil {
+		return false, nil
+	}
+	return CheckUserCompanyPermissionWithContext(claims.UserID, resource, action, &companyID, clientIP, time.Now())
+}
+
+// generateDelegatedTokens signs the token pair of a third-party app. Both are
+// bound to the app's session; the refresh token is marked so it cannot be
+// used as an access token.
+func generateDelegatedTokens(user *auth.User, sessionID string, tokenVersion int, client *auth.OAuthClient, scopes []string) (string, string, error) {
+	now := time.Now()
+	claims := func(use string, ttl time.Duration) *Claims {
+		return &Claims{
+			UserID:       user.ID,
+			Email:        user.Email,
+			Role:         user.Role,
+			SessionID:    sessionID,
+			TokenVersion: tokenVersion,
+			ClientID:     client.ClientID,
+			Scope:        strings.Join(scopes, " "),
+			CompanyID:    client.CompanyID.String(),
+			TokenUse:     use,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+				IssuedAt:  jwt.NewNumericDate(now),
+				NotBefore: jwt.NewNumericDate(now),
+				Issuer:    "camping-clouds",
+				Subject:   user.ID.String(),
+				Audience:  jwt.ClaimStrings{client.ClientID},
+			},
+		}
+	}
+
+	accessToken, err := signJWT(claims(TokenUseAccess, oauthAccessTokenTTL))
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := signJWT(claims(OAuthTokenUseRefresh, oauthSessionTTL))
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// delegatedRefreshTokenActive checks the refresh token's family record and session
+func delegatedRefreshTokenActive(token string, claims *Claims) (bool, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return false, err
+	}
+
+	var record auth.RefreshToken
+	if err := db.Where("token_hash = ?", hashRefreshToken(token)).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if record.UsedAt != nil || record.RevokedAt != nil || time.Now().After(record.ExpiresAt) {
+		return false, nil
+	}
+
+	state, err := loadSessionTokenState(db, claims.SessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return state.Active && time.Now().Before(state.ExpiresAt) && state.UserID == claims.UserID, nil
+}
+
+// revokeOAuthSessions ends the active sessions matched by scope and revokes
+// their refresh token families
+func revokeOAuthSessions(scope *gorm.DB) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	var sessionIDs []string
+	if err := scope.Model(&auth.UserSession{}).
+		Where("is_active = ?", true).
+		Pluck("session_id", &sessionIDs).Error; err != nil {
+		return err
+	}
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if err := db.Model(&auth.RefreshToken{}).
+		Where("family_id IN ? AND revoked_at IS NULL", sessionIDs).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&auth.UserSession{}).
+		Where("session_id IN ?", sessionIDs).
+		Updates(map[string]interface{}{
+			"is_active": false,
+			"logout_at": now,
+		}).Error; err != nil {
+		return err
+	}
+	invalidateSessionTokenState(sessionIDs...)
+	return nil
+}
+
+func findOAuthClient(companyID, id uuid.UUID) (*auth.OAuthClient, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var client auth.OAuthClient
+	if err := db.Where("id = ? AND company_id = ? AND revoked_at IS NULL", id, companyID).
+		First(&client).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, err
+	}
+	return &client, nil
+}
+
+func findOAuthClientByClientID(clientID string) (*auth.OAuthClient, error) {
+	if !strings.HasPrefix(clientID, OAuthClientIDPrefix) {
+		return nil, ErrOAuthClientNotFound
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var client auth.OAuthClient
+	if err := db.Where("client_id = ? AND revoked_at IS NULL", clientID).First(&client).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, err
+	}
+	return &client, nil
+}
+
+func findOAuthConsent(userID, clientID uuid.UUID) (*auth.OAuthConsent, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var consent auth.OAuthConsent
+	if err := db.Where("user_id = ? AND client_id = ? AND revoked_at IS NULL", userID, clientID).
+		First(&consent).Error; err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+func newOAuthClientSecret() (string, error) {
+	secret, err := generateResetToken()
+	if err != nil {
+		return "", err
+	}
+	return OAuthClientSecretPrefix + secret, nil
+}
+
+// requestedOAuthScopes parses the space separated scope parameter. An empty
+// parameter requests every scope the client is registered for.
+func requestedOAuthScopes(client *auth.OAuthClient, scope string) ([]string, error) {
+	if strings.TrimSpace(scope) == "" {
+		return append([]string{}, client.Scopes...), nil
+	}
+
+	scopes, err := NormalizeTokenScopes(strings.Fields(scope))
+	if err != nil {
+		return nil, oauthError("invalid_scope", err.Error())
+	}
+	for _, s := range scopes {
+		if !oauthScopeCovered(client.Scopes, s) {
+			return nil, oauthError("invalid_scope", fmt.Sprintf("scope %q is not allowed for this client", s))
+		}
+	}
+	return scopes, nil
+}
+
+// oauthScopeCovered reports whether allowed covers every action scope stands for
+func oauthScopeCovered(allowed []string, scope string) bool {
+	resource, action, _ := strings.Cut(scope, ":")
+	actions := []string{action}
+	switch action {
+	case ScopeWrite:
+		actions = []string{ScopeCreate, ScopeUpdate, ScopeDelete}
+	case ScopeAll:
+		actions = []string{ScopeRead, ScopeCreate, ScopeUpdate, ScopeDelete}
+	}
+	for _, a := range actions {
+		if !TokenScopesAllow(allowed, resource, a) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateRedirectURIs accepts absolute URIs without fragment: https, http on
+// a loopback host for local development, or a private scheme for native apps (RFC 8252)
+func validateRedirectURIs(uris []string) ([]string, error) {
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("%w: at least one redirect uri is required", ErrInvalidOAuthClientURI)
+	}
+
+	result := make([]string, 0, len(uris))
+	for _, raw := range uris {
+		raw = strings.TrimSpace(raw)
+		u, err := url.Parse(raw)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidOAuthClientURI, raw)
+		}
+		switch u.Scheme {
+		case "https":
+			if u.Host == "" {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidOAuthClientURI, raw)
+			}
+		case "http":
+			if host := u.Hostname(); host != "localhost" && host != "127.0.0.1" && host != "::1" {
+				return nil, fmt.Errorf("%w: %q must use https", ErrInvalidOAuthClientURI, raw)
+			}
+		}
+		if !containsString(result, raw) {
+			result = append(result, raw)
+		}
+	}
+	return result, nil
+}
+
+// validPKCEValue checks the length and alphabet of a code challenge or verifier (RFC 7636)
+func validPKCEValue(value string) bool {
+	if len(value) < 43 || len(value) > 128 {
+		return false
+	}
+	for _, r := range value {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9',
+			r == '-', r == '.', r == '_', r == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// verifyPKCE checks an S256 code verifier against the stored challenge
+func verifyPKCE(challenge, verifier string) bool {
+	if !validPKCEValue(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"mimbackend/config"
+
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// OAuthStateTTL bounds how long a provider redirect may take
+const OAuthStateTTL = 10 * time.Minute
+
+var (
+	ErrOAuthStateInvalid = errors.New("oauth state is invalid, expired or already used")
+	ErrInvalidRedirectTo = errors.New("redirect_to must be a path or a frontend URL")
+)
+
+// OAuthState is what the server remembers about a login (or link) started with
+// an external provider. It is stored under the state parameter, which the
+// browser also keeps in the oauth_state cookie, and deleted on first use.
+type OAuthState struct {
+	Provider     string     `json:"provider"`               // google, github, facebook, oidc:<name> or saml:<company ID>
+	CodeVerifier string     `json:"code_verifier"`          // PKCE verifier sent with the code exchange
+	Nonce        string     `json:"nonce"`                  // Echoed back in OpenID Connect ID tokens; SAML uses it in the AuthnRequest ID
+	RedirectTo   string     `json:"redirect_to,omitempty"`  // Frontend path to return to
+	LinkUserID   *uuid.UUID `json:"link_user_id,omitempty"` // Set when a signed-in user links the provider
+}
+
+// CodeChallenge returns the S256 PKCE challenge of the state's verifier
+func (s *OAuthState) CodeChallenge() string {
+	sum := sha256.Sum256([]byte(s.CodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewOAuthState creates and stores the state of a provider redirect and
+// returns the opaque value to send as the state parameter
+func NewOAuthState(provider, redirectTo string, linkUserID *uuid.UUID) (string, *OAuthState, error) {
+	redirectTo, err := NormalizeRedirectTo(redirectTo)
+	if err != nil {
+		return "", nil, err
+	}
+
+	state, err := randomURLToken()
+	if err != nil {
+		return "", nil, err
+	}
+	verifier, err := randomURLToken()
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := randomURLToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	entry := &OAuthState{
+		Provider:     provider,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		RedirectTo:   redirectTo,
+		LinkUserID:   linkUserID,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := getOAuthStateStore().Save(context.Background(), oauthStateKey(state), data, OAuthStateTTL); err != nil {
+		return "", nil, err
+	}
+	return state, entry, nil
+}
+
+// ConsumeOAuthState returns and deletes the state of a provider callback.
+// Unknown, expired, reused or other-provider states are rejected.
+func ConsumeOAuthState(state, provider string) (*OAuthState, error) {
+	if state == "" {
+		return nil, ErrOAuthStateInvalid
+	}
+
+	data, err := getOAuthStateStore().Take(context.Background(), oauthStateKey(state))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrOAuthStateInvalid
+	}
+
+	var entry OAuthState
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if entry.Provider != provider {
+		return nil, ErrOAuthStateInvalid
+	}
+	return &entry, nil
+}
+
+// NormalizeRedirectTo accepts a frontend path ("/dashboard?tab=1") or an
+// absolute URL on FRONTEND_URL and returns it as a path. Anything that could
+// send the browser to another site is rejected.
+func NormalizeRedirectTo(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(raw, "\\\r\n\t") {
+		return "", ErrInvalidRedirectTo
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", ErrInvalidRedirectTo
+	}
+	if u.Scheme != "" || u.Host != "" {
+		frontend, err := url.Parse(os.Getenv("FRONTEND_URL"))
+		if err != nil || frontend.Host == "" || u.Scheme != frontend.Scheme || u.Host != frontend.Host || u.User != nil {
+			return "", ErrInvalidRedirectTo
+		}
+	} else if !strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "//") {
+		return "", ErrInvalidRedirectTo
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if strings.HasPrefix(path, "//") {
+		return "", ErrInvalidRedirectTo
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if u.Fragment != "" {
+		path += "#" + u.EscapedFragment()
+	}
+	return path, nil
+}
+
+func oauthStateKey(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return "oauth:state:" + hex.EncodeToString(sum[:])
+}
+
+// randomURLToken returns 32 random bytes as unpadded base64url (43 characters,
+// also a valid PKCE verifier)
+func randomURLToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// oauthStateStore keeps pending provider redirects until their callback
+type oauthStateStore interface {
+	Save(ctx context.Context, key string, data []byte, ttl time.Duration) error
+	// Take returns and deletes the value of key, nil if there is none
+	Take(ctx context.Context, key string) ([]byte, error)
+}
+
+// memoryStateStore is used when Redis is not configured. Callbacks must then
+// reach the instance that started the login.
+var memoryStateStore = &memoryOAuthStateStore{entries: map[string]memoryStateEntry{}}
+
+// getOAuthStateStore returns the Redis store, or the in-memory store without Redis
+func getOAuthStateStore() oauthStateStore {
+	if cli := config.GetRedisClient(); cli != nil {
+		return &redisOAuthStateStore{cli: cli}
+	}
+	return memoryStateStore
+}
+
+type redisOAuthStateStore struct {
+	cli *redis.Client
+}
+
+func (s *redisOAuthStateStore) Save(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	return s.cli.Set(ctx, key, data, ttl).Err()
+}
+
+func (s *redisOAuthStateStore) Take(ctx context.Context, key string) ([]byte, error) {
+	// GETDEL makes the read and delete atomic, so a state is used at most once across instances
+	data, err := s.cli.GetDel(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+type memoryStateEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+type memoryOAuthStateStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryStateEntry
+}
+
+func (s *memoryOAuthStateStore) Save(_ context.Context, key string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = memoryStateEntry{data: data, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (s *memoryOAuthStateStore) Take(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.entries, key)
+	if time.Now().After(entry.expiresAt) {
+		return nil, nil
+	}
+	return entry.data, nil
+}
@@ -0,0 +1,674 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	oidcDiscoveryTTL = time.Hour
+	oidcJWKSTTL      = time.Hour
+	// An unknown kid triggers a JWKS refetch (key rotation) at most this often
+	oidcJWKSMinRefresh = time.Minute
+	oidcClockSkew      = time.Minute
+
+	// googleOIDCIssuer is the issuer of the built-in Google provider
+	googleOIDCIssuer = "https://accounts.google.com"
+)
+
+var (
+	ErrOIDCProviderNotFound = errors.New("oidc provider not found")
+	ErrOIDCInvalidIDToken   = errors.New("invalid id token")
+	ErrOIDCEmailMissing     = errors.New("oidc provider returned no email")
+	ErrOIDCEmailNotVerified = errors.New("oidc provider reports the email as not verified")
+
+	oidcProviderNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+	oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// OIDCClaimMapping names the ID token / userinfo claims a provider uses for
+// the fields FindOrCreateOAuthUser needs. Empty fields use the standard claims.
+type OIDCClaimMapping struct {
+	Subject       string // default sub
+	Email         string // default email
+	EmailVerified string // default email_verified
+	Name          string // default name
+	Picture       string // default picture
+}
+
+// OIDCProviderConfig configures a generic OpenID Connect login provider. The
+// endpoints and signing keys are discovered from Issuer.
+type OIDCProviderConfig struct {
+	Name         string // URL segment and Account.Provider, e.g. "microsoft"
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // default: openid email profile
+	RedirectURI  string   // default: BASE_URL + API_PREFIX + /auth/oidc/<name>/callback
+	Claims       OIDCClaimMapping
+	// AuthParams are added to the authorization request, e.g. Google's access_type
+	AuthParams map[string]string
+}
+
+// OIDCIdentity is the user an OIDC provider authenticated
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified *bool // nil when the provider does not say
+	Name          string
+	Picture       string
+	Claims        map[string]interface{}
+}
+
+// OIDCProvider is a configured provider with its cached discovery document and keys
+type OIDCProvider struct {
+	config     OIDCProviderConfig
+	httpClient *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var (
+	oidcProvidersMu   sync.RWMutex
+	oidcProviders     = map[string]*OIDCProvider{}
+	oidcProvidersOnce sync.Once
+)
+
+// RegisterOIDCProvider adds a provider to the registry, replacing one with the same name
+func RegisterOIDCProvider(cfg OIDCProviderConfig) (*OIDCProvider, error) {
+	cfg.Name = strings.ToLower(strings.TrimSpace(cfg.Name))
+	cfg.Issuer = strings.TrimSpace(cfg.Issuer)
+	if !oidcProviderNamePattern.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("invalid oidc provider name %q", cfg.Name)
+	}
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("oidc provider %s: issuer and client id are required", cfg.Name)
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	} else if !containsString(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = cfg.Name
+	}
+	if cfg.RedirectURI == "" {
+		cfg.RedirectURI = oauthCallbackURL("/auth/oidc/" + cfg.Name + "/callback")
+	}
+
+	provider := &OIDCProvider{
+		config:     cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	oidcProvidersMu.Lock()
+	oidcProviders[cfg.Name] = provider
+	oidcProvidersMu.Unlock()
+	return provider, nil
+}
+
+// GetOIDCProvider returns a registered provider by name
+func GetOIDCProvider(name string) (*OIDCProvider, error) {
+	loadOIDCProvidersFromEnv()
+
+	oidcProvidersMu.RLock()
+	provider, ok := oidcProviders[strings.ToLower(name)]
+	oidcProvidersMu.RUnlock()
+	if !ok {
+		return nil, ErrOIDCProviderNotFound
+	}
+	return provider, nil
+}
+
+// OIDCProviderInfo is the public description of a provider for login buttons
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// ListOIDCProviders returns the registered providers sorted by name
+func ListOIDCProviders() []OIDCProviderInfo {
+	loadOIDCProvidersFromEnv()
+
+	oidcProvidersMu.RLock()
+	defer oidcProvidersMu.RUnlock()
+	infos := make([]OIDCProviderInfo, 0, len(oidcProviders))
+	for _, p := range oidcProviders {
+		infos = append(infos, OIDCProviderInfo{Name: p.config.Name, DisplayName: p.config.DisplayName})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// loadOIDCProvidersFromEnv registers the providers listed in OIDC_PROVIDERS.
+// For a provider named microsoft the settings are OIDC_MICROSOFT_ISSUER,
+// _CLIENT_ID, _CLIENT_SECRET, _SCOPES, _REDIRECT_URI, _DISPLAY_NAME and the
+// optional claim overrides _SUBJECT_CLAIM, _EMAIL_CLAIM, _EMAIL_VERIFIED_CLAIM,
+// _NAME_CLAIM and _PICTURE_CLAIM.
+func loadOIDCProvidersFromEnv() {
+	oidcProvidersOnce.Do(func() {
+		for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+			env := func(key string) string { return strings.TrimSpace(os.Getenv(prefix + key)) }
+
+			_, err := RegisterOIDCProvider(OIDCProviderConfig{
+				Name:         name,
+				DisplayName:  env("DISPLAY_NAME"),
+				Issuer:       env("ISSUER"),
+				ClientID:     env("CLIENT_ID"),
+				ClientSecret: env("CLIENT_SECRET"),
+				Scopes:       strings.FieldsFunc(env("SCOPES"), func(r rune) bool { return r == ' ' || r == ',' }),
+				RedirectURI:  env("REDIRECT_URI"),
+				Claims: OIDCClaimMapping{
+					Subject:       env("SUBJECT_CLAIM"),
+					Email:         env("EMAIL_CLAIM"),
+					EmailVerified: env("EMAIL_VERIFIED_CLAIM"),
+					Name:          env("NAME_CLAIM"),
+					Picture:       env("PICTURE_CLAIM"),
+				},
+			})
+			if err != nil {
+				log.Printf("OIDC: skipping provider %s: %v", name, err)
+			}
+		}
+		registerGoogleOIDCProvider()
+	})
+}
+
+// registerGoogleOIDCProvider registers Google from GOOGLE_CLIENT_ID,
+// _CLIENT_SECRET and _REDIRECT_URI unless OIDC_PROVIDERS configures a
+// provider named google. Its callback stays /auth/google/callback so the
+// redirect URI registered at Google keeps working.
+func registerGoogleOIDCProvider() {
+	clientID := strings.TrimSpace(os.Getenv("GOOGLE_CLIENT_ID"))
+	if clientID == "" {
+		return
+	}
+
+	oidcProvidersMu.RLock()
+	_, configured := oidcProviders["google"]
+	oidcProvidersMu.RUnlock()
+	if configured {
+		return
+	}
+
+	redirectURI := strings.TrimSpace(os.Getenv("GOOGLE_REDIRECT_URI"))
+	if redirectURI == "" {
+		redirectURI = oauthCallbackURL("/auth/google/callback")
+	}
+	_, err := RegisterOIDCProvider(OIDCProviderConfig{
+		Name:         "google",
+		DisplayName:  "Google",
+		Issuer:       googleOIDCIssuer,
+		ClientID:     clientID,
+		ClientSecret: strings.TrimSpace(os.Getenv("GOOGLE_CLIENT_SECRET")),
+		RedirectURI:  redirectURI,
+		// Issue a refresh token for ProviderTokenService
+		AuthParams: map[string]string{"access_type": "offline"},
+	})
+	if err != nil {
+		log.Printf("OIDC: skipping provider google: %v", err)
+	}
+}
+
+// Name returns the provider name used in URLs and Account.Provider
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL builds the provider's authorization URL. codeChallenge is the
+// S256 PKCE challenge of the verifier later passed to Authenticate.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURI)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	if nonce != "" {
+		params.Set("nonce", nonce)
+	}
+	if codeChallenge != "" {
+		params.Set("code_challenge", codeChallenge)
+		params.Set("code_challenge_method", PKCEMethodS256)
+	}
+	for key, value := range p.config.AuthParams {
+		params.Set(key, value)
+	}
+
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return discovery.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Authenticate exchanges an authorization code, validates the ID token and
+// maps its claims. The userinfo endpoint fills in claims missing from the ID token.
+func (p *OIDCProvider) Authenticate(code, nonce, codeVerifier string) (*OIDCIdentity, *OAuthToken, error) {
+	token, err := p.exchange(code, codeVerifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token.IDToken == "" {
+		return nil, nil, fmt.Errorf("%w: token response has no id_token", ErrOIDCInvalidIDToken)
+	}
+
+	claims, err := p.VerifyIDToken(token.IDToken, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	identity := p.mapClaims(claims)
+	if identity.Email == "" && token.AccessToken != "" {
+		if userinfo, err := p.userinfo(token.AccessToken); err != nil {
+			log.Printf("OIDC %s: userinfo request failed: %v", p.config.Name, err)
+		} else if sub, _ := userinfo["sub"].(string); sub != "" && sub == claimString(claims, "", "sub") {
+			// Userinfo may only be merged when it describes the same subject (OIDC Core 5.3.2)
+			for key, value := range userinfo {
+				if _, ok := claims[key]; !ok {
+					claims[key] = value
+				}
+			}
+			identity = p.mapClaims(claims)
+		}
+	}
+
+	if identity.Subject == "" {
+		return nil, nil, fmt.Errorf("%w: missing subject", ErrOIDCInvalidIDToken)
+	}
+	if identity.Email == "" {
+		return nil, nil, ErrOIDCEmailMissing
+	}
+	// Accounts are matched by email, so an unverified address could take over an existing user
+	if identity.EmailVerified != nil && !*identity.EmailVerified {
+		return nil, nil, ErrOIDCEmailNotVerified
+	}
+	return identity, token, nil
+}
+
+// VerifyIDToken checks the signature against the provider JWKS and the
+// issuer, audience, expiry and nonce of an ID token
+func (p *OIDCProvider) VerifyIDToken(rawIDToken, nonce string) (jwt.MapClaims, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, p.keyFunc,
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcClockSkew))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCInvalidIDToken, err)
+	}
+
+	// With several audiences the token must name us as the authorized party
+	if aud, _ := claims.GetAudience(); len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.config.ClientID {
+			return nil, fmt.Errorf("%w: azp does not match client id", ErrOIDCInvalidIDToken)
+		}
+	}
+	if tokenNonce, _ := claims["nonce"].(string); nonce != "" && tokenNonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCInvalidIDToken)
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) mapClaims(claims map[string]interface{}) *OIDCIdentity {
+	m := p.config.Claims
+	identity := &OIDCIdentity{
+		Subject: claimString(claims, m.Subject, "sub"),
+		Email:   strings.ToLower(claimString(claims, m.Email, "email")),
+		Name:    claimString(claims, m.Name, "name"),
+		Picture: claimString(claims, m.Picture, "picture"),
+		Claims:  claims,
+	}
+
+	key := m.EmailVerified
+	if key == "" {
+		key = "email_verified"
+	}
+	switch v := claims[key].(type) {
+	case bool:
+		identity.EmailVerified = &v
+	case string: // Some providers send "true" / "false"
+		verified := strings.EqualFold(v, "true")
+		identity.EmailVerified = &verified
+	}
+	return identity
+}
+
+func (p *OIDCProvider) exchange(code, codeVerifier string) (*OAuthToken, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", p.config.RedirectURI)
+	data.Set("client_id", p.config.ClientID)
+	if codeVerifier != "" {
+		data.Set("code_verifier", codeVerifier)
+	}
+	if p.config.ClientSecret != "" {
+		data.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("failed to exchange code: %s", string(body))
+	}
+
+	var token OAuthToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RefreshToken runs the refresh_token grant against the provider's token endpoint
+func (p *OIDCProvider) RefreshToken(refreshToken string) (*OAuthToken, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refreshToken)
+	data.Set("client_id", p.config.ClientID)
+	if p.config.ClientSecret != "" {
+		data.Set("client_secret", p.config.ClientSecret)
+	}
+	return postTokenRequest(p.httpClient, discovery.TokenEndpoint, data)
+}
+
+func (p *OIDCProvider) userinfo(accessToken string) (map[string]interface{}, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	if discovery.UserinfoEndpoint == "" {
+		return nil, errors.New("provider has no userinfo endpoint")
+	}
+
+	req, err := http.NewRequest("GET", discovery.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	var info map[string]interface{}
+	if err := p.getJSON(req, &info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// getDiscovery returns the cached discovery document, fetching it when stale
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimRight(p.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var discovery oidcDiscovery
+	if err := p.getJSON(req, &discovery); err != nil {
+		if p.discovery != nil {
+			log.Printf("OIDC %s: discovery refresh failed, keeping cached document: %v", p.config.Name, err)
+			return p.discovery, nil
+		}
+		return nil, fmt.Errorf("oidc discovery for %s failed: %w", p.config.Name, err)
+	}
+
+	// The document must be about the configured issuer (OIDC Discovery 4.3)
+	if strings.TrimRight(discovery.Issuer, "/") != strings.TrimRight(p.config.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery for %s: issuer %q does not match %q", p.config.Name, discovery.Issuer, p.config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery for %s: incomplete document", p.config.Name)
+	}
+
+	p.discovery = &discovery
+	p.discoveredAt = time.Now()
+	return p.discovery, nil
+}
+
+// keyFunc resolves the ID token signing key by kid, refetching the JWKS once
+// when the kid is unknown so provider key rotation is picked up
+func (p *OIDCProvider) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	keys, err := p.getKeys(false)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := lookupOIDCKey(keys, kid)
+	if !ok {
+		if keys, err = p.getKeys(true); err != nil {
+			return nil, err
+		}
+		if key, ok = lookupOIDCKey(keys, kid); !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+	return key, nil
+}
+
+func lookupOIDCKey(keys map[string]interface{}, kid string) (interface{}, bool) {
+	if key, ok := keys[kid]; ok {
+		return key, true
+	}
+	// Tokens without kid are accepted only when the provider has a single key
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (p *OIDCProvider) getKeys(forceRefresh bool) (map[string]interface{}, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	age := time.Since(p.keysFetchedAt)
+	if p.keys != nil && age < oidcJWKSTTL && (!forceRefresh || age < oidcJWKSMinRefresh) {
+		return p.keys, nil
+	}
+
+	req, err := http.NewRequest("GET", discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set JWKS
+	if err := p.getJSON(req, &set); err != nil {
+		if p.keys != nil {
+			return p.keys, nil
+		}
+		return nil, fmt.Errorf("failed to fetch jwks for %s: %w", p.config.Name, err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("OIDC %s: skipping key %q: %v", p.config.Name, jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	return p.keys, nil
+}
+
+func (p *OIDCProvider) getJSON(req *http.Request, v interface{}) error {
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", req.URL.Redacted(), resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// publicKey converts an RSA, EC or Ed25519 JWK into a verification key
+func (k JWK) publicKey() (interface{}, error) {
+	decode := func(s string) ([]byte, error) { return base64.RawURLEncoding.DecodeString(s) }
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// claimString reads a string claim under key, or under fallback when key is empty
+func claimString(claims map[string]interface{}, key, fallback string) string {
+	if key == "" {
+		key = fallback
+	}
+	s, _ := claims[key].(string)
+	return s
+}
+
+// oauthCallbackURL builds an absolute callback URL from BASE_URL and API_PREFIX
+func oauthCallbackURL(path string) string {
+	base := os.Getenv("BASE_URL")
+	if base == "" {
+		base = "http://localhost:3333"
+	}
+	apiPrefix := os.Getenv("API_PREFIX")
+	if apiPrefix == "" {
+		apiPrefix = "/api/v1"
+	}
+	return strings.TrimRight(base, "/") + apiPrefix + path
+}
@@ -0,0 +1,432 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testOIDCClientID = "test-client"
+	testOIDCCode     = "test-code"
+	testOIDCVerifier = "test-verifier"
+)
+
+// stubIssuer is an OpenID Connect provider serving discovery, JWKS, token and
+// userinfo endpoints. idToken decides the claims of the next ID token.
+type stubIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu              sync.Mutex
+	key             *rsa.PrivateKey
+	kid             string
+	discoveryIssuer string // issuer announced in discovery, defaults to the server URL
+	idToken         func(claims jwt.MapClaims)
+	userinfo        map[string]interface{}
+	tokenForm       url.Values
+	jwksRequests    int
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	t.Helper()
+
+	s := &stubIssuer{t: t, kid: "key-1"}
+	s.rotateKey("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		issuer := s.discoveryIssuer
+		s.mu.Unlock()
+		if issuer == "" {
+			issuer = s.server.URL
+		}
+		writeTestJSON(w, map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": s.server.URL + "/authorize",
+			"token_endpoint":         s.server.URL + "/token",
+			"userinfo_endpoint":      s.server.URL + "/userinfo",
+			"jwks_uri":               s.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.jwksRequests++
+		writeTestJSON(w, JWKS{Keys: []JWK{{
+			Kty: "RSA",
+			Kid: s.kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(s.key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.PublicKey.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.tokenForm = r.PostForm
+		s.mu.Unlock()
+		if r.PostForm.Get("code") != testOIDCCode || r.PostForm.Get("code_verifier") != testOIDCVerifier {
+			w.WriteHeader(http.StatusBadRequest)
+			writeTestJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeTestJSON(w, map[string]interface{}{
+			"access_token":  "provider-access-token",
+			"refresh_token": "provider-refresh-token",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"id_token":      s.signIDToken(),
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer provider-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		writeTestJSON(w, s.userinfo)
+	})
+
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *stubIssuer) rotateKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		s.t.Fatalf("generate key: %v", err)
+	}
+	s.mu.Lock()
+	s.key, s.kid = key, kid
+	s.mu.Unlock()
+}
+
+// signIDToken signs an ID token for testOIDCClientID, adjusted by s.idToken
+func (s *stubIssuer) signIDToken() string {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            s.server.URL,
+		"aud":            testOIDCClientID,
+		"sub":            "subject-1",
+		"email":          "Jane@Example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+		"picture":        "https://example.com/jane.png",
+		"nonce":          "nonce-1",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.idToken != nil {
+		s.idToken(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		s.t.Fatalf("sign id token: %v", err)
+	}
+	return signed
+}
+
+func (s *stubIssuer) setIDToken(adjust func(claims jwt.MapClaims)) {
+	s.mu.Lock()
+	s.idToken = adjust
+	s.mu.Unlock()
+}
+
+// register adds a provider for the stub issuer and removes it after the test
+func (s *stubIssuer) register(name string, claims OIDCClaimMapping) *OIDCProvider {
+	s.t.Helper()
+
+	provider, err := RegisterOIDCProvider(OIDCProviderConfig{
+		Name:         name,
+		Issuer:       s.server.URL,
+		ClientID:     testOIDCClientID,
+		ClientSecret: "test-secret",
+		RedirectURI:  "http://localhost:3333/api/v1/auth/oidc/" + name + "/callback",
+		Claims:       claims,
+	})
+	if err != nil {
+		s.t.Fatalf("RegisterOIDCProvider: %v", err)
+	}
+	s.t.Cleanup(func() { unregisterOIDCProvider(name) })
+	return provider
+}
+
+func unregisterOIDCProvider(name string) {
+	oidcProvidersMu.Lock()
+	delete(oidcProviders, name)
+	oidcProvidersMu.Unlock()
+}
+
+func writeTestJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestOIDCAuthCodeURLUsesDiscovery(t *testing.T) {
+	issuer := newStubIssuer(t)
+	provider := issuer.register("stub", OIDCClaimMapping{})
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", "challenge-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse %s: %v", authURL, err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != issuer.server.URL+"/authorize" {
+		t.Fatalf("authorization endpoint = %s", got)
+	}
+
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testOIDCClientID,
+		"scope":                 "openid email profile",
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        "challenge-1",
+		"code_challenge_method": PKCEMethodS256,
+	}
+	for key, value := range want {
+		if q.Get(key) != value {
+			t.Errorf("%s = %q, want %q", key, q.Get(key), value)
+		}
+	}
+}
+
+func TestOIDCAuthenticateMapsClaims(t *testing.T) {
+	issuer := newStubIssuer(t)
+	provider := issuer.register("stub", OIDCClaimMapping{})
+
+	identity, token, err := provider.Authenticate(testOIDCCode, "nonce-1", testOIDCVerifier)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	if identity.Subject != "subject-1" || identity.Email != "jane@example.com" ||
+		identity.Name != "Jane Doe" || identity.Picture != "https://example.com/jane.png" {
+		t.Fatalf("identity = %+v", identity)
+	}
+	if identity.EmailVerified == nil || !*identity.EmailVerified {
+		t.Fatalf("EmailVerified = %v, want true", identity.EmailVerified)
+	}
+	if token.AccessToken != "provider-access-token" || token.RefreshToken != "provider-refresh-token" {
+		t.Fatalf("token = %+v", token)
+	}
+
+	form := issuer.tokenForm
+	if form.Get("grant_type") != "authorization_code" || form.Get("client_secret") != "test-secret" ||
+		form.Get("redirect_uri") != "http://localhost:3333/api/v1/auth/oidc/stub/callback" {
+		t.Fatalf("token request = %v", form)
+	}
+}
+
+func TestOIDCAuthenticateCustomClaimMapping(t *testing.T) {
+	issuer := newStubIssuer(t)
+	issuer.setIDToken(func(claims jwt.MapClaims) {
+		delete(claims, "email")
+		delete(claims, "email_verified")
+		claims["oid"] = "object-1"
+		claims["upn"] = "jane@corp.example"
+		claims["verified"] = "true"
+	})
+	provider := issuer.register("stub", OIDCClaimMapping{Subject: "oid", Email: "upn", EmailVerified: "verified"})
+
+	identity, _, err := provider.Authenticate(testOIDCCode, "nonce-1", testOIDCVerifier)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Subject != "object-1" || identity.Email != "jane@corp.example" {
+		t.Fatalf("identity = %+v", identity)
+	}
+	if identity.EmailVerified == nil || !*identity.EmailVerified {
+		t.Fatalf("EmailVerified = %v, want true", identity.EmailVerified)
+	}
+}
+
+func TestOIDCAuthenticateUsesUserinfoOfSameSubject(t *testing.T) {
+	issuer := newStubIssuer(t)
+	issuer.setIDToken(func(claims jwt.MapClaims) { delete(claims, "email") })
+	provider := issuer.register("stub", OIDCClaimMapping{})
+
+	// Userinfo about another subject is ignored
+	issuer.userinfo = map[string]interface{}{"sub": "subject-2", "email": "mallory@example.com"}
+	if _, _, err := provider.Authenticate(testOIDCCode, "nonce-1", testOIDCVerifier); !errors.Is(err, ErrOIDCEmailMissing) {
+		t.Fatalf("Authenticate with foreign userinfo = %v, want ErrOIDCEmailMissing", err)
+	}
+
+	issuer.userinfo = map[string]interface{}{"sub": "subject-1", "email": "jane@example.com"}
+	identity, _, err := provider.Authenticate(testOIDCCode, "nonce-1", testOIDCVerifier)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Email != "jane@example.com" {
+		t.Fatalf("Email = %q", identity.Email)
+	}
+}
+
+func TestOIDCAuthenticateRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		adjust func(claims jwt.MapClaims)
+		nonce  string
+	}{
+		{"bad nonce", nil, "nonce-2"},
+		{"missing nonce", func(claims jwt.MapClaims) { delete(claims, "nonce") }, "nonce-1"},
+		{"wrong audience", func(claims jwt.MapClaims) { claims["aud"] = "other-client" }, "nonce-1"},
+		{"wrong issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example" }, "nonce-1"},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }, "nonce-1"},
+		{"other authorized party", func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testOIDCClientID, "other-client"}
+			claims["azp"] = "other-client"
+		}, "nonce-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newStubIssuer(t)
+			issuer.setIDToken(tt.adjust)
+			provider := issuer.register("stub", OIDCClaimMapping{})
+
+			_, _, err := provider.Authenticate(testOIDCCode, tt.nonce, testOIDCVerifier)
+			if !errors.Is(err, ErrOIDCInvalidIDToken) {
+				t.Fatalf("Authenticate = %v, want ErrOIDCInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestOIDCAuthenticateRejectsForeignSignature(t *testing.T) {
+	issuer := newStubIssuer(t)
+	provider := issuer.register("stub", OIDCClaimMapping{})
+
+	// Signed with a key the JWKS does not publish under this kid
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": issuer.server.URL, "aud": testOIDCClientID, "sub": "subject-1",
+		"nonce": "nonce-1", "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = issuer.kid
+	signed, err := token.SignedString(other)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	if _, err := provider.VerifyIDToken(signed, "nonce-1"); !errors.Is(err, ErrOIDCInvalidIDToken) {
+		t.Fatalf("VerifyIDToken = %v, want ErrOIDCInvalidIDToken", err)
+	}
+}
+
+func TestOIDCAuthenticateRejectsUnverifiedEmail(t *testing.T) {
+	issuer := newStubIssuer(t)
+	issuer.setIDToken(func(claims jwt.MapClaims) { claims["email_verified"] = false })
+	provider := issuer.register("stub", OIDCClaimMapping{})
+
+	if _, _, err := provider.Authenticate(testOIDCCode, "nonce-1", testOIDCVerifier); !errors.Is(err, ErrOIDCEmailNotVerified) {
+		t.Fatalf("Authenticate = %v, want ErrOIDCEmailNotVerified", err)
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newStubIssuer(t)
+	issuer.discoveryIssuer = "https://evil.example"
+	provider := issuer.register("stub", OIDCClaimMapping{})
+
+	if _, err := provider.AuthCodeURL("state", "nonce", ""); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("AuthCodeURL = %v, want issuer mismatch", err)
+	}
+}
+
+func TestOIDCRefetchesJWKSOnKeyRotation(t *testing.T) {
+	issuer := newStubIssuer(t)
+	provider := issuer.register("stub", OIDCClaimMapping{})
+
+	if _, _, err := provider.Authenticate(testOIDCCode, "nonce-1", testOIDCVerifier); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	// The cached keys are too fresh to be refetched for an unknown kid
+	issuer.rotateKey("key-2")
+	if _, _, err := provider.Authenticate(testOIDCCode, "nonce-1", testOIDCVerifier); !errors.Is(err, ErrOIDCInvalidIDToken) {
+		t.Fatalf("Authenticate right after rotation = %v, want ErrOIDCInvalidIDToken", err)
+	}
+
+	provider.mu.Lock()
+	provider.keysFetchedAt = time.Now().Add(-oidcJWKSMinRefresh)
+	provider.mu.Unlock()
+	if _, _, err := provider.Authenticate(testOIDCCode, "nonce-1", testOIDCVerifier); err != nil {
+		t.Fatalf("Authenticate after rotation: %v", err)
+	}
+	if issuer.jwksRequests != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", issuer.jwksRequests)
+	}
+}
+
+func TestRegisterGoogleOIDCProvider(t *testing.T) {
+	t.Setenv("GOOGLE_CLIENT_ID", "google-client")
+	t.Setenv("GOOGLE_CLIENT_SECRET", "google-secret")
+	t.Setenv("GOOGLE_REDIRECT_URI", "")
+	t.Setenv("BASE_URL", "https://api.example.com")
+	t.Setenv("API_PREFIX", "")
+	t.Cleanup(func() { unregisterOIDCProvider("google") })
+
+	registerGoogleOIDCProvider()
+	oidcProvidersMu.RLock()
+	provider := oidcProviders["google"]
+	oidcProvidersMu.RUnlock()
+	if provider == nil {
+		t.Fatal("google provider was not registered")
+	}
+
+	cfg := provider.config
+	if cfg.Issuer != googleOIDCIssuer || cfg.ClientID != "google-client" || cfg.ClientSecret != "google-secret" {
+		t.Fatalf("config = %+v", cfg)
+	}
+	// The callback registered at Google stays the same
+	if cfg.RedirectURI != "https://api.example.com/api/v1/auth/google/callback" {
+		t.Fatalf("RedirectURI = %s", cfg.RedirectURI)
+	}
+	if cfg.AuthParams["access_type"] != "offline" {
+		t.Fatalf("AuthParams = %v", cfg.AuthParams)
+	}
+
+	// A provider named google in OIDC_PROVIDERS takes precedence
+	unregisterOIDCProvider("google")
+	issuer := newStubIssuer(t)
+	configured := issuer.register("google", OIDCClaimMapping{})
+	registerGoogleOIDCProvider()
+	oidcProvidersMu.RLock()
+	defer oidcProvidersMu.RUnlock()
+	if oidcProviders["google"] != configured {
+		t.Fatal("built-in google provider replaced the configured one")
+	}
+}
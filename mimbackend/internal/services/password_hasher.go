@@ -0,0 +1,180 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms, selected with PASSWORD_HASH_ALGORITHM
+const (
+	HashAlgorithmArgon2id = "argon2id"
+	HashAlgorithmBcrypt   = "bcrypt"
+)
+
+var errUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords with one algorithm. Hashes are
+// self-describing, so the hasher of a stored hash is found from its prefix.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded
+	Verify(password, encoded string) (bool, error)
+	// Outdated reports whether encoded was made by this algorithm with
+	// different parameters than the current ones
+	Outdated(encoded string) bool
+	// Recognizes reports whether encoded was made by this algorithm
+	Recognizes(encoded string) bool
+}
+
+// currentPasswordHasher returns the hasher new hashes are made with
+func currentPasswordHasher() PasswordHasher {
+	switch strings.ToLower(os.Getenv("PASSWORD_HASH_ALGORITHM")) {
+	case HashAlgorithmBcrypt:
+		return newBcryptHasher()
+	case "", HashAlgorithmArgon2id:
+		return newArgon2idHasher()
+	default:
+		log.Printf("password hasher: unknown PASSWORD_HASH_ALGORITHM %q, using argon2id", os.Getenv("PASSWORD_HASH_ALGORITHM"))
+		return newArgon2idHasher()
+	}
+}
+
+// passwordHasherFor returns the hasher that made encoded
+func passwordHasherFor(encoded string) (PasswordHasher, error) {
+	for _, hasher := range []PasswordHasher{newArgon2idHasher(), newBcryptHasher()} {
+		if hasher.Recognizes(encoded) {
+			return hasher, nil
+		}
+	}
+	return nil, errUnknownPasswordHash
+}
+
+// argon2idHasher produces PHC strings: $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+type argon2idHasher struct {
+	memory      uint32 // KiB
+	iterations  uint32
+	parallelism uint8
+	saltLength  int
+	keyLength   uint32
+}
+
+func newArgon2idHasher() *argon2idHasher {
+	return &argon2idHasher{
+		memory:      uint32(envInt("ARGON2_MEMORY_KIB", 64*1024)),
+		iterations:  uint32(envInt("ARGON2_ITERATIONS", 3)),
+		parallelism: uint8(envInt("ARGON2_PARALLELISM", 2)),
+		saltLength:  16,
+		keyLength:   32,
+	}
+}
+
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.iterations, h.memory, h.parallelism, h.keyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.iterations, h.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *argon2idHasher) Outdated(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.memory != h.memory || params.iterations != h.iterations ||
+		params.parallelism != h.parallelism || len(salt) != h.saltLength || uint32(len(key)) != h.keyLength
+}
+
+func (h *argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func decodeArgon2id(encoded string) (*argon2idHasher, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != HashAlgorithmArgon2id {
+		return nil, nil, nil, errUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, err
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	params := &argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return nil, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return params, salt, key, nil
+}
+
+// bcryptHasher is the original algorithm; existing hashes keep verifying and
+// are upgraded on the next successful login
+type bcryptHasher struct {
+	cost int
+}
+
+func newBcryptHasher() *bcryptHasher {
+	cost := envInt("BCRYPT_COST", bcrypt.DefaultCost)
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	return &bcryptHasher{cost: cost}
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(bytes), err
+}
+
+func (h *bcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *bcryptHasher) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
+
+func (h *bcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"mimbackend/config"
+	auth "mimbackend/internal/models/auth"
+	companymodels "mimbackend/internal/models/company"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Password policy violation codes
+const (
+	PasswordTooShort      = "too_short"
+	PasswordMissingUpper  = "missing_upper"
+	PasswordMissingLower  = "missing_lower"
+	PasswordMissingDigit  = "missing_digit"
+	PasswordMissingSymbol = "missing_symbol"
+	PasswordContainsEmail = "contains_email"
+	PasswordContainsName  = "contains_name"
+	PasswordTooCommon     = "too_common"
+	PasswordReused        = "reused"
+)
+
+// PasswordPolicy is the set of rules a new password has to satisfy
+type PasswordPolicy struct {
+	MinLength          int  `json:"min_length"`
+	RequireUpper       bool `json:"require_upper"`
+	RequireLower       bool `json:"require_lower"`
+	RequireDigit       bool `json:"require_digit"`
+	RequireSymbol      bool `json:"require_symbol"`
+	ForbidPersonalInfo bool `json:"forbid_personal_info"`
+	HistorySize        int  `json:"history_size"` // last N passwords that cannot be reused
+}
+
+// PasswordViolation is a single rule a password failed
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password failed
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// commonPasswords is the built-in deny-list; PASSWORD_DENYLIST_FILE adds more
+// entries, one per line
+var commonPasswords = []string{
+	"123456", "1234567", "12345678", "123456789", "1234567890", "111111", "000000",
+	"123123", "654321", "password", "password1", "password123", "passw0rd",
+	"qwerty", "qwerty123", "qwertyuiop", "asdfgh", "zxcvbnm", "abc123", "iloveyou",
+	"admin", "admin123", "welcome", "welcome1", "letmein", "monkey", "dragon",
+	"football", "baseball", "sunshine", "princess", "superman", "trustno1",
+	"sifre", "sifre123", "şifre", "şifre123", "parola", "parola123", "galatasaray",
+	"fenerbahce", "besiktas", "trabzonspor", "istanbul", "ankara", "türkiye", "turkiye",
+}
+
+var (
+	passwordDenyList     map[string]struct{}
+	passwordDenyListOnce sync.Once
+)
+
+// DefaultPasswordPolicy returns the global policy configured through the
+// PASSWORD_* environment variables
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:          envInt("PASSWORD_MIN_LENGTH", 8),
+		RequireUpper:       envBool("PASSWORD_REQUIRE_UPPER", true),
+		RequireLower:       envBool("PASSWORD_REQUIRE_LOWER", true),
+		RequireDigit:       envBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol:      envBool("PASSWORD_REQUIRE_SYMBOL", true),
+		ForbidPersonalInfo: envBool("PASSWORD_FORBID_PERSONAL_INFO", true),
+		HistorySize:        envInt("PASSWORD_HISTORY_SIZE", 5),
+	}
+}
+
+// Tighten applies a company's stricter rules on top of p
+func (p PasswordPolicy) Tighten(company *companymodels.PasswordPolicy) PasswordPolicy {
+	if company == nil {
+		return p
+	}
+	if company.MinLength > p.MinLength {
+		p.MinLength = company.MinLength
+	}
+	if company.HistorySize > p.HistorySize {
+		p.HistorySize = company.HistorySize
+	}
+	p.RequireUpper = p.RequireUpper || company.RequireUpper
+	p.RequireLower = p.RequireLower || company.RequireLower
+	p.RequireDigit = p.RequireDigit || company.RequireDigit
+	p.RequireSymbol = p.RequireSymbol || company.RequireSymbol
+	return p
+}
+
+// Validate checks password against the policy. email and fullName are the
+// account's personal data the password must not contain.
+func (p PasswordPolicy) Validate(password, email, fullName string) error {
+	var violations []PasswordViolation
+	add := func(code, message string) {
+		violations = append(violations, PasswordViolation{Code: code, Message: message})
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		add(PasswordTooShort, fmt.Sprintf("şifre en az %d karakter olmalıdır", p.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		add(PasswordMissingUpper, "şifre en az bir büyük harf içermelidir")
+	}
+	if p.RequireLower && !hasLower {
+		add(PasswordMissingLower, "şifre en az bir küçük harf içermelidir")
+	}
+	if p.RequireDigit && !hasDigit {
+		add(PasswordMissingDigit, "şifre en az bir rakam içermelidir")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		add(PasswordMissingSymbol, "şifre en az bir özel karakter (örn. !@#$%) içermelidir")
+	}
+
+	lower := strings.ToLower(password)
+	if p.ForbidPersonalInfo {
+		if local, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@"); len(local) >= 3 && strings.Contains(lower, local) {
+			add(PasswordContainsEmail, "şifre email adresinizi içeremez")
+		}
+		for _, part := range strings.Fields(strings.ToLower(fullName)) {
+			if utf8.RuneCountInString(part) >= 3 && strings.Contains(lower, part) {
+				add(PasswordContainsName, "şifre adınızı veya soyadınızı içeremez")
+				break
+			}
+		}
+	}
+
+	if isCommonPassword(lower) {
+		add(PasswordTooCommon, "şifre çok yaygın kullanılan şifrelerden biri")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// EffectivePasswordPolicy returns the global policy tightened by every active
+// company the user belongs to
+func EffectivePasswordPolicy(db *gorm.DB, userID uuid.UUID) (PasswordPolicy, error) {
+	policy := DefaultPasswordPolicy()
+
+	var companies []companymodels.Company
+	if err := db.Select("companies.id", "companies.password_policy").
+		Joins("JOIN company_members ON company_members.company_id = companies.id").
+		Where("company_members.user_id = ? AND company_members.is_active = ? AND company_members.deleted_at IS NULL", userID, true).
+		Find(&companies).Error; err != nil {
+		return policy, err
+	}
+	for _, company := range companies {
+		policy = policy.Tighten(company.PasswordPolicy)
+	}
+	return policy, nil
+}
+
+// SetUserPassword validates newPassword against the user's effective policy and
+// password history, then stores its hash and records it in the history
+func SetUserPassword(db *gorm.DB, user *auth.User, newPassword string) error {
+	policy, err := EffectivePasswordPolicy(db, user.ID)
+	if err != nil {
+		return err
+	}
+
+	fullName := ""
+	if user.FullName != nil {
+		fullName = *user.FullName
+	}
+	if err := policy.Validate(newPassword, user.Email, fullName); err != nil {
+		return err
+	}
+
+	reused, err := isRecentPassword(db, user, newPassword, policy.HistorySize)
+	if err != nil {
+		return err
+	}
+	if reused {
+		return &PasswordPolicyError{Violations: []PasswordViolation{{
+			Code:    PasswordReused,
+			Message: fmt.Sprintf("yeni şifre son %d şifrenizden biriyle aynı olamaz", max(policy.HistorySize, 1)),
+		}}}
+	}
+
+	hashedPassword, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&auth.User{}).Where("id = ?", user.ID).Update("password_hash", hashedPassword).Error; err != nil {
+			return err
+		}
+		return addPasswordHistory(tx, user.ID, hashedPassword, policy.HistorySize)
+	})
+	if err != nil {
+		return err
+	}
+
+	user.PasswordHash = hashedPassword
+	return nil
+}
+
+// RecordPasswordHistory stores the initial password hash of a new account
+func RecordPasswordHistory(userID uuid.UUID, passwordHash string) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+	return addPasswordHistory(db, userID, passwordHash, DefaultPasswordPolicy().HistorySize)
+}
+
+// Private helper methods
+
+// isRecentPassword compares password with the current hash and the last
+// historySize entries of the history
+func isRecentPassword(db *gorm.DB, user *auth.User, password string, historySize int) (bool, error) {
+	if ok, _ := CheckPassword(password, user.PasswordHash); ok {
+		return true, nil
+	}
+	if historySize <= 0 {
+		return false, nil
+	}
+
+	var history []auth.PasswordHistory
+	if err := db.Where("user_id = ?", user.ID).
+		Order("created_at DESC").
+		Limit(historySize).
+		Find(&history).Error; err != nil {
+		return false, err
+	}
+	for _, entry := range history {
+		if ok, _ := CheckPassword(password, entry.PasswordHash); ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addPasswordHistory records a hash and drops entries beyond historySize
+func addPasswordHistory(db *gorm.DB, userID uuid.UUID, passwordHash string, historySize int) error {
+	if historySize <= 0 {
+		return nil
+	}
+	if err := db.Create(&auth.PasswordHistory{UserID: userID, PasswordHash: passwordHash}).Error; err != nil {
+		return err
+	}
+
+	var ids []uuid.UUID
+	if err := db.Model(&auth.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) <= historySize {
+		return nil
+	}
+	return db.Unscoped().Where("id IN ?", ids[historySize:]).Delete(&auth.PasswordHistory{}).Error
+}
+
+func isCommonPassword(lower string) bool {
+	passwordDenyListOnce.Do(loadPasswordDenyList)
+	_, found := passwordDenyList[lower]
+	return found
+}
+
+func loadPasswordDenyList() {
+	passwordDenyList = make(map[string]struct{}, len(commonPasswords))
+	for _, pw := range commonPasswords {
+		passwordDenyList[pw] = struct{}{}
+	}
+
+	path := os.Getenv("PASSWORD_DENYLIST_FILE")
+	if path == "" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("password policy: failed to open deny-list %s: %v", path, err)
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if pw := strings.ToLower(strings.TrimSpace(scanner.Text())); pw != "" && !strings.HasPrefix(pw, "#") {
+			passwordDenyList[pw] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("password policy: failed to read deny-list %s: %v", path, err)
+	}
+}
+
+func envInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return fallback
+}
+
+func envBool(key string, fallback bool) bool {
+	if v, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return v
+	}
+	return fallback
+}
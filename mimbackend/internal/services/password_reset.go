@@ -4,14 +4,16 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"log"
 	"mimbackend/config"
 	auth "mimbackend/internal/models/auth"
 	"time"
-	"unicode"
 
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+var errResetTokenInvalid = errors.New("invalid or expired reset token")
+
 // generateResetToken generates a secure random token for password reset
 func generateResetToken() (string, error) {
 	bytes := make([]byte, 32)
@@ -64,79 +66,39 @@ func ResetPassword(token, newPassword string) error {
 		return err
 	}
 
-	// Find user by reset token
 	var user auth.User
-	if err := db.Where("reset_token = ? AND reset_token_expires > ?", token, time.Now()).First(&user).Error; err != nil {
-		return errors.New("invalid or expired reset token")
-	}
-	// Prevent reuse: check if newPassword equals the current password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(newPassword)); err == nil {
-		return errors.New("yeni şifre önceki şifreyle aynı olamaz")
-	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Find user by reset token
+		if err := tx.Where("reset_token = ? AND reset_token_expires > ?", token, time.Now()).First(&user).Error; err != nil {
+			return errResetTokenInvalid
+		}
 
-	// Enforce password strength
-	if err := validatePasswordStrength(newPassword); err != nil {
-		return err
-	}
+		// The reset token is single use: whoever clears it first wins
+		result := tx.Model(&auth.User{}).
+			Where("id = ? AND reset_token = ? AND reset_token_expires > ?", user.ID, token, time.Now()).
+			Updates(map[string]interface{}{
+				"reset_token":         nil,
+				"reset_token_expires": nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errResetTokenInvalid
+		}
 
-	// Hash new password
-	hashedPassword, err := HashPassword(newPassword)
+		// Enforce the password policy and history, then store the new hash. A
+		// rejected password rolls back and leaves the token usable.
+		return SetUserPassword(tx, &user, newPassword)
+	})
 	if err != nil {
 		return err
 	}
 
-	// Update password and clear reset token
-	user.PasswordHash = hashedPassword
-	user.ResetToken = nil
-	user.ResetTokenExpires = nil
-
-	// Save to database
-	if err := db.Save(&user).Error; err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// validatePasswordStrength ensures a password meets minimal complexity requirements
-func validatePasswordStrength(pw string) error {
-	var hasMinLen bool
-	var hasUpper bool
-	var hasLower bool
-	var hasNumber bool
-	var hasSpecial bool
-
-	if len(pw) >= 6 {
-		hasMinLen = true
-	}
-
-	for _, r := range pw {
-		switch {
-		case unicode.IsUpper(r):
-			hasUpper = true
-		case unicode.IsLower(r):
-			hasLower = true
-		case unicode.IsDigit(r):
-			hasNumber = true
-		case unicode.IsPunct(r) || unicode.IsSymbol(r):
-			hasSpecial = true
-		}
-	}
-
-	if !hasMinLen {
-		return errors.New("şifre en az 8 karakter olmalıdır")
-	}
-	if !hasUpper {
-		return errors.New("şifre en az bir büyük harf içermelidir")
-	}
-	if !hasLower {
-		return errors.New("şifre en az bir küçük harf içermelidir")
-	}
-	if !hasNumber {
-		return errors.New("şifre en az bir rakam içermelidir")
-	}
-	if !hasSpecial {
-		return errors.New("şifre en az bir özel karakter (örn. !@#$%) içermelidir")
+	// Sessions opened with the old password must not outlive it
+	sessionService := &SessionService{db: db}
+	if err := sessionService.LogoutAllUserSessions(user.ID); err != nil {
+		log.Printf("ResetPassword: failed to logout sessions for user %s: %v", user.ID, err)
 	}
 
 	return nil
@@ -151,7 +113,7 @@ func ValidateResetToken(token string) (*auth.User, error) {
 
 	var user auth.User
 	if err := db.Where("reset_token = ? AND reset_token_expires > ?", token, time.Now()).First(&user).Error; err != nil {
-		return nil, errors.New("invalid or expired reset token")
+		return nil, errResetTokenInvalid
 	}
 
 	return &user, nil
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"mimbackend/config"
+	auth "mimbackend/internal/models/auth"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// PersonalAccessTokenPrefix marks personal access tokens so JWTMiddleware
+	// can tell them apart from JWTs and secret scanners can find leaked ones
+	PersonalAccessTokenPrefix = "mim_pat_"
+
+	DefaultPersonalAccessTokenTTL = 30 * 24 * time.Hour
+	MaxPersonalAccessTokenTTL     = 365 * 24 * time.Hour
+
+	// last_used_at is refreshed at most this often to avoid a write per request
+	patLastUsedInterval = time.Minute
+)
+
+// Scope actions. ScopeWrite covers create, update and delete.
+const (
+	ScopeRead   = "read"
+	ScopeCreate = "create"
+	ScopeUpdate = "update"
+	ScopeDelete = "delete"
+	ScopeWrite  = "write"
+	ScopeAll    = "*"
+)
+
+var (
+	ErrPersonalAccessTokenInvalid  = errors.New("personal access token is invalid, expired or revoked")
+	ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+	ErrInvalidTokenScope           = errors.New("invalid token scope")
+	ErrTokenCompanyNotAllowed      = errors.New("user is not an active member of this company")
+
+	scopeResourcePattern = regexp.MustCompile(`^([a-z][a-z0-9_-]*|\*)$`)
+)
+
+// CreatePersonalAccessTokenInput describes a new personal access token
+type CreatePersonalAccessTokenInput struct {
+	Name      string
+	Scopes    []string
+	CompanyID *uuid.UUID
+	ExpiresIn time.Duration // Zero means DefaultPersonalAccessTokenTTL
+}
+
+// CreatePersonalAccessToken creates a token for userID. The plain token is
+// returned only here; afterwards only its hash is known.
+func CreatePersonalAccessToken(userID uuid.UUID, input CreatePersonalAccessTokenInput) (string, *auth.PersonalAccessToken, error) {
+	scopes, err := NormalizeTokenScopes(input.Scopes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ttl := input.ExpiresIn
+	if ttl <= 0 {
+		ttl = DefaultPersonalAccessTokenTTL
+	}
+	if ttl > MaxPersonalAccessTokenTTL {
+		return "", nil, fmt.Errorf("token lifetime cannot exceed %d days", int(MaxPersonalAccessTokenTTL.Hours()/24))
+	}
+
+	if input.CompanyID != nil {
+		member, err := GetUserCompanyMembership(userID, *input.CompanyID)
+		if err != nil || !member.IsActive {
+			return "", nil, ErrTokenCompanyNotAllowed
+		}
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := generateResetToken()
+	if err != nil {
+		return "", nil, err
+	}
+	raw := PersonalAccessTokenPrefix + secret
+	expiresAt := time.Now().Add(ttl)
+
+	token := &auth.PersonalAccessToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(input.Name),
+		TokenHash: hashAPIToken(raw),
+		Prefix:    raw[:len(PersonalAccessTokenPrefix)+6],
+		Scopes:    datatypes.JSONSlice[string](scopes),
+		CompanyID: input.CompanyID,
+		ExpiresAt: &expiresAt,
+	}
+	if err := db.Create(token).Error; err != nil {
+		return "", nil, err
+	}
+	return raw, token, nil
+}
+
+// ListPersonalAccessTokens returns the tokens of a user, newest first
+func ListPersonalAccessTokens(userID uuid.UUID) ([]auth.PersonalAccessToken, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []auth.PersonalAccessToken
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokePersonalAccessToken revokes a token owned by userID
+func RevokePersonalAccessToken(userID, tokenID uuid.UUID) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	result := db.Model(&auth.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+	return nil
+}
+
+// IsPersonalAccessToken reports whether a bearer credential is a personal
+// access token rather than a JWT
+func IsPersonalAccessToken(raw string) bool {
+	return strings.HasPrefix(raw, PersonalAccessTokenPrefix)
+}
+
+// AuthenticatePersonalAccessToken resolves a plain token to its record and owner
+func AuthenticatePersonalAccessToken(raw, clientIP string) (*auth.PersonalAccessToken, *auth.User, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var token auth.PersonalAccessToken
+	if err := db.Preload("User").Where("token_hash = ?", hashAPIToken(raw)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrPersonalAccessTokenInvalid
+		}
+		return nil, nil, err
+	}
+	if !token.IsActive() || token.User == nil {
+		return nil, nil, ErrPersonalAccessTokenInvalid
+	}
+
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > patLastUsedInterval {
+		now := time.Now()
+		if err := db.Model(&token).Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": clientIP}).Error; err != nil {
+			log.Printf("personal access token: failed to record usage of %s: %v", token.ID, err)
+		}
+	}
+	return &token, token.User, nil
+}
+
+// PersonalAccessTokenAllows reports whether a request authenticated by token
+// may perform action on resource: the token scopes must cover it and the
+// owner must still hold the permission in Casbin.
+func PersonalAccessTokenAllows(token *auth.PersonalAccessToken, resource, action, clientIP string) (bool, error) {
+	if !TokenScopesAllow(token.Scopes, resource, action) {
+		return false, nil
+	}
+	return CheckUserCompanyPermissionWithContext(token.UserID, resource, action, token.CompanyID, clientIP, time.Now())
+}
+
+// TokenScopesAllow reports whether any scope covers resource:action
+func TokenScopesAllow(scopes []string, resource, action string) bool {
+	for _, scope := range scopes {
+		scopeResource, scopeAction, _ := strings.Cut(scope, ":")
+		if scopeResource != ScopeAll && scopeResource != resource {
+			continue
+		}
+		switch scopeAction {
+		case ScopeAll, action:
+			return true
+		case ScopeWrite:
+			if action == ScopeCreate || action == ScopeUpdate || action == ScopeDelete {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NormalizeTokenScopes validates resource:action scopes and removes duplicates
+func NormalizeTokenScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidTokenScope)
+	}
+
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		resource, action, found := strings.Cut(scope, ":")
+		if !found || !scopeResourcePattern.MatchString(resource) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTokenScope, scope)
+		}
+		switch action {
+		case ScopeRead, ScopeCreate, ScopeUpdate, ScopeDelete, ScopeWrite, ScopeAll:
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTokenScope, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, nil
+}
+
+// hashAPIToken is the lookup hash of personal access tokens and service account secrets
+func hashAPIToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"mimbackend/config"
+	authmodels "mimbackend/internal/models/auth"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	phoneOTPTTL         = 5 * time.Minute
+	phoneOTPMaxAttempts = 5
+	phoneOTPCooldown    = time.Minute // minimum time between two codes to the same number
+	phoneOTPPerHour     = 5           // codes per number per hour
+	phoneOTPPerIPHour   = 20          // codes per client IP per hour
+)
+
+var (
+	ErrInvalidPhoneNumber = errors.New("invalid phone number")
+	ErrOTPRateLimited     = errors.New("too many code requests")
+	ErrOTPInvalid         = errors.New("invalid or expired code")
+	ErrPhoneInUse         = errors.New("phone number is already in use")
+	ErrOTPHashKeyMissing  = errors.New("OTP_HASH_KEY is not set")
+)
+
+// PhoneOTPService sends and verifies SMS one-time codes for phone
+// verification and phone login
+type PhoneOTPService struct {
+	db      *gorm.DB
+	sms     SMSProvider
+	hashKey []byte
+}
+
+// NewPhoneOTPService creates a new phone OTP service using the configured SMS
+// provider. Codes are neither sent nor checked without OTP_HASH_KEY.
+func NewPhoneOTPService() (*PhoneOTPService, error) {
+	hashKey := os.Getenv("OTP_HASH_KEY")
+	if hashKey == "" {
+		return nil, ErrOTPHashKeyMissing
+	}
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	sms, err := NewSMSProvider()
+	if err != nil {
+		return nil, err
+	}
+	return &PhoneOTPService{db: db, sms: sms, hashKey: []byte(hashKey)}, nil
+}
+
+// SendVerificationCode sends a code that proves the user owns phone
+func (s *PhoneOTPService) SendVerificationCode(userID uuid.UUID, phone, ip string) (time.Time, error) {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var owners int64
+	if err := s.db.Model(&authmodels.User{}).
+		Where("phone = ? AND id <> ?", phone, userID).
+		Count(&owners).Error; err != nil {
+		return time.Time{}, err
+	}
+	if owners > 0 {
+		return time.Time{}, ErrPhoneInUse
+	}
+
+	return s.sendCode(phone, authmodels.OTPPurposeVerifyPhone, &userID, ip, true)
+}
+
+// VerifyPhone checks the code and stores phone as the user's verified number
+func (s *PhoneOTPService) VerifyPhone(userID uuid.UUID, phone, code string) error {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.verifyCode(phone, authmodels.OTPPurposeVerifyPhone, &userID, code); err != nil {
+		return err
+	}
+
+	err = s.db.Model(&authmodels.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"phone":          phone,
+		"phone_verified": true,
+	}).Error
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "duplicate") {
+		return ErrPhoneInUse
+	}
+	return err
+}
+
+// SendLoginCode sends a login code to a verified phone number. Unknown numbers
+// get the same response without an SMS so the endpoint does not reveal which
+// numbers are registered.
+func (s *PhoneOTPService) SendLoginCode(phone, ip string) (time.Time, error) {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var user authmodels.User
+	err = s.db.Where("phone = ? AND phone_verified = ?", phone, true).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, err
+	}
+
+	return s.sendCode(phone, authmodels.OTPPurposeLogin, nil, ip, err == nil)
+}
+
+// VerifyLoginCode checks a login code and returns the user of the phone number
+func (s *PhoneOTPService) VerifyLoginCode(phone, code string) (*authmodels.User, error) {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.verifyCode(phone, authmodels.OTPPurposeLogin, nil, code); err != nil {
+		return nil, err
+	}
+
+	var user authmodels.User
+	if err := s.db.Where("phone = ? AND phone_verified = ?", phone, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOTPInvalid
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// NormalizePhone converts a phone number to E.164. Turkish numbers written in
+// national format (0532 123 45 67, 532 123 45 67) get the +90 prefix.
+func NormalizePhone(phone string) (string, error) {
+	var digits strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", ErrInvalidPhoneNumber
+		}
+	}
+	number := digits.String()
+	international := strings.HasPrefix(strings.TrimSpace(phone), "+")
+
+	switch {
+	case international:
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case len(number) == 11 && strings.HasPrefix(number, "0"):
+		number = "90" + number[1:]
+	case len(number) == 10 && strings.HasPrefix(number, "5"):
+		number = "90" + number
+	}
+
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", ErrInvalidPhoneNumber
+	}
+	return "+" + number, nil
+}
+
+// Private helper methods
+
+// sendCode enforces the rate limits, replaces earlier codes and sends a new
+// one. When deliver is false the code is recorded but no SMS is sent.
+func (s *PhoneOTPService) sendCode(phone, purpose string, userID *uuid.UUID, ip string, deliver bool) (time.Time, error) {
+	if err := s.checkRateLimit(phone, ip); err != nil {
+		return time.Time{}, err
+	}
+
+	code, err := randomDigits(6)
+	if err != nil {
+		return time.Time{}, err
+	}
+	expiresAt := time.Now().Add(phoneOTPTTL)
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Only the newest code of a number and purpose is valid
+		if err := tx.Model(&authmodels.OTP{}).
+			Where("phone = ? AND purpose = ? AND is_used = ?", phone, purpose, false).
+			Update("is_used", true).Error; err != nil {
+			return err
+		}
+		return tx.Create(&authmodels.OTP{
+			Phone:     phone,
+			Code:      s.hashCode(phone, code),
+			Purpose:   purpose,
+			UserID:    userID,
+			IPAddress: ip,
+			ExpiresAt: expiresAt,
+		}).Error
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if deliver {
+		message := fmt.Sprintf("MIM doğrulama kodunuz: %s. Kod %d dakika geçerlidir, kimseyle paylaşmayın.", code, int(phoneOTPTTL.Minutes()))
+		if err := s.sms.Send(phone, message); err != nil {
+			return time.Time{}, fmt.Errorf("failed to send sms: %w", err)
+		}
+	}
+
+	return expiresAt, nil
+}
+
+func (s *PhoneOTPService) checkRateLimit(phone, ip string) error {
+	now := time.Now()
+
+	var recent int64
+	if err := s.db.Model(&authmodels.OTP{}).
+		Where("phone = ? AND created_at > ?", phone, now.Add(-phoneOTPCooldown)).
+		Count(&recent).Error; err != nil {
+		return err
+	}
+	if recent > 0 {
+		return ErrOTPRateLimited
+	}
+
+	var hourly int64
+	if err := s.db.Model(&authmodels.OTP{}).
+		Where("phone = ? AND created_at > ?", phone, now.Add(-time.Hour)).
+		Count(&hourly).Error; err != nil {
+		return err
+	}
+	if hourly >= phoneOTPPerHour {
+		return ErrOTPRateLimited
+	}
+
+	if ip != "" {
+		var perIP int64
+		if err := s.db.Model(&authmodels.OTP{}).
+			Where("ip_address = ? AND created_at > ?", ip, now.Add(-time.Hour)).
+			Count(&perIP).Error; err != nil {
+			return err
+		}
+		if perIP >= phoneOTPPerIPHour {
+			return ErrOTPRateLimited
+		}
+	}
+
+	return nil
+}
+
+// verifyCode checks code against the newest pending code. Every guess counts
+// against the code; after phoneOTPMaxAttempts it can no longer be used.
+func (s *PhoneOTPService) verifyCode(phone, purpose string, userID *uuid.UUID, code string) (*authmodels.OTP, error) {
+	var otp authmodels.OTP
+	query := s.db.Where("phone = ? AND purpose = ? AND is_used = ? AND expires_at > ?", phone, purpose, false, time.Now())
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+	if err := query.Order("created_at DESC").First(&otp).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOTPInvalid
+		}
+		return nil, err
+	}
+
+	// Count the attempt before comparing, in one conditional update, so
+	// parallel guesses cannot all pass the limit check
+	attempt := s.db.Model(&authmodels.OTP{}).
+		Where("id = ? AND attempts < ?", otp.ID, phoneOTPMaxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if attempt.Error != nil {
+		return nil, attempt.Error
+	}
+	if attempt.RowsAffected == 0 {
+		return nil, ErrOTPInvalid
+	}
+
+	code = strings.TrimSpace(code)
+	if !hmac.Equal([]byte(otp.Code), []byte(s.hashCode(phone, code))) {
+		return nil, ErrOTPInvalid
+	}
+
+	result := s.db.Model(&authmodels.OTP{}).
+		Where("id = ? AND is_used = ?", otp.ID, false).
+		Update("is_used", true)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrOTPInvalid
+	}
+
+	return &otp, nil
+}
+
+// hashCode keys the hash with OTP_HASH_KEY so a leaked table cannot be brute
+// forced offline (a 6-digit code has only a million values)
+func (s *PhoneOTPService) hashCode(phone, code string) string {
+	mac := hmac.New(sha256.New, s.hashKey)
+	mac.Write([]byte(phone + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomDigits returns n cryptographically random decimal digits
+func randomDigits(n int) (string, error) {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(byte('0' + d.Int64()))
+	}
+	return b.String(), nil
+}
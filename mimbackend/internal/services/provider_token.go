@@ -0,0 +1,189 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"mimbackend/config"
+	auth "mimbackend/internal/models/auth"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// providerTokenRefreshSkew refreshes access tokens shortly before they expire,
+// so a token handed out is still valid when the caller uses it
+const providerTokenRefreshSkew = time.Minute
+
+var (
+	ErrProviderNotLinked = errors.New("provider is not linked to this user")
+	// ErrProviderTokenExpired means the access token expired and could not be
+	// renewed; the user has to link the provider again
+	ErrProviderTokenExpired = errors.New("provider token expired and cannot be refreshed")
+)
+
+// providerTokenLocks serializes refreshes of the same account within this
+// process, so concurrent callers do not spend a rotating refresh token twice
+var providerTokenLocks sync.Map // account ID -> *sync.Mutex
+
+// ProviderTokenService hands out the tokens of linked providers (Google,
+// GitHub, OpenID Connect, ...) for calling their APIs on the user's behalf
+type ProviderTokenService struct {
+	db         *gorm.DB
+	httpClient *http.Client
+}
+
+// NewProviderTokenService creates a new provider token service
+func NewProviderTokenService() (*ProviderTokenService, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return &ProviderTokenService{db: db, httpClient: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// AccessToken returns a usable access token of the user's account at provider.
+// An expired token is refreshed with the stored refresh token and the new
+// tokens are saved; tokens without an expiry are returned as they are.
+func (s *ProviderTokenService) AccessToken(userID uuid.UUID, provider string) (string, error) {
+	var account auth.Account
+	if err := s.db.Where("user_id = ? AND provider = ? AND provider <> ?", userID, provider, localAccountProvider).
+		First(&account).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrProviderNotLinked
+		}
+		return "", err
+	}
+
+	lock, _ := providerTokenLocks.LoadOrStore(account.ID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Reload under the lock: another caller may have refreshed meanwhile
+	if err := s.db.First(&account, "id = ?", account.ID).Error; err != nil {
+		return "", err
+	}
+	if err := openAccountTokens(&account); err != nil {
+		return "", err
+	}
+
+	if account.AccessToken != "" && !providerTokenExpired(account.ExpiresAt) {
+		return account.AccessToken, nil
+	}
+	if account.RefreshToken == "" {
+		return "", ErrProviderTokenExpired
+	}
+
+	token, err := s.refresh(provider, account.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+
+	account.AccessToken = token.AccessToken
+	if token.RefreshToken != "" {
+		// Providers that rotate refresh tokens invalidate the old one
+		account.RefreshToken = token.RefreshToken
+	}
+	account.ExpiresAt = 0
+	if token.ExpiresIn > 0 {
+		account.ExpiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second).Unix()
+	}
+	accessToken := account.AccessToken
+	if err := sealAccountTokens(&account); err != nil {
+		return "", err
+	}
+	if err := s.db.Model(&auth.Account{}).Where("id = ?", account.ID).Updates(map[string]interface{}{
+		"access_token":  account.AccessToken,
+		"refresh_token": account.RefreshToken,
+		"expires_at":    account.ExpiresAt,
+	}).Error; err != nil {
+		return "", err
+	}
+	return accessToken, nil
+}
+
+// providerTokenExpired reports whether a token expiring at expiresAt (Unix
+// seconds, zero when the provider gave no expiry) needs to be refreshed
+func providerTokenExpired(expiresAt int64) bool {
+	if expiresAt == 0 {
+		return false
+	}
+	return time.Now().Add(providerTokenRefreshSkew).Unix() >= expiresAt
+}
+
+// refresh runs the refresh_token grant against the provider's token endpoint
+func (s *ProviderTokenService) refresh(provider, refreshToken string) (*OAuthToken, error) {
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refreshToken)
+
+	var endpoint string
+	switch provider {
+	case "github":
+		// Only GitHub Apps with expiring user tokens hand out refresh tokens
+		endpoint = "https://github.com/login/oauth/access_token"
+		data.Set("client_id", os.Getenv("GITHUB_CLIENT_ID"))
+		data.Set("client_secret", os.Getenv("GITHUB_CLIENT_SECRET"))
+	case "facebook":
+		// Facebook has no refresh tokens; long-lived tokens must be renewed by logging in again
+		return nil, ErrProviderTokenExpired
+	default:
+		oidcProvider, err := GetOIDCProvider(provider)
+		if err != nil {
+			return nil, ErrProviderTokenExpired
+		}
+		return oidcProvider.RefreshToken(refreshToken)
+	}
+
+	return postTokenRequest(s.httpClient, endpoint, data)
+}
+
+// postTokenRequest posts a form to an OAuth2 token endpoint. A rejected grant
+// (invalid_grant and the like) is reported as ErrProviderTokenExpired.
+func postTokenRequest(client *http.Client, endpoint string, data url.Values) (*OAuthToken, error) {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	// GitHub answers errors with 200 and an "error" field
+	var token struct {
+		OAuthToken
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized || token.Error != "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrProviderTokenExpired, token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("token response has no access_token")
+	}
+	return &token.OAuthToken, nil
+}
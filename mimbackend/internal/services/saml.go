@@ -0,0 +1,737 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"mimbackend/config"
+	"mimbackend/internal/cache"
+	auth "mimbackend/internal/models/auth"
+	basemodels "mimbackend/internal/models/basemodels"
+	companymodels "mimbackend/internal/models/company"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	"gorm.io/gorm"
+)
+
+const (
+	// samlDefaultRole is given to JIT-created members when no mapping matches
+	samlDefaultRole = "company_employee"
+	// samlPlanType is the plan that includes SAML single sign-on
+	samlPlanType = "enterprise"
+)
+
+var (
+	ErrSAMLNotConfigured      = errors.New("saml single sign-on is not configured for this company")
+	ErrSAMLPlanRequired       = errors.New("saml single sign-on requires the enterprise plan")
+	ErrSAMLInvalidMetadata    = errors.New("invalid identity provider metadata")
+	ErrSAMLDomainsRequired    = errors.New("at least one allowed email domain is required to enable saml")
+	ErrSAMLInvalidRole        = errors.New("role mapping refers to an unknown or owner role")
+	ErrSAMLInvalidResponse    = errors.New("invalid saml response")
+	ErrSAMLEmailMissing       = errors.New("saml assertion has no email address")
+	ErrSAMLDomainNotAllowed   = errors.New("email domain is not allowed for this company")
+	ErrSAMLAccountExists      = errors.New("an account with this email already exists; sign in and link the company's single sign-on")
+	ErrSAMLServiceAccountUser = errors.New("service accounts cannot sign in with saml")
+)
+
+// SAMLConfigUpdate carries the fields an owner may change; nil fields are left as they are
+type SAMLConfigUpdate struct {
+	IDPMetadata    *string
+	Enabled        *bool
+	EnforceSSO     *bool
+	EmailAttribute *string
+	NameAttribute  *string
+	RoleAttribute  *string
+	RoleMapping    map[string]string
+	DefaultRole    *string
+	AllowedDomains []string
+}
+
+// SAMLIdentity is the user described by a verified assertion
+type SAMLIdentity struct {
+	NameID string
+	Email  string
+	Name   string
+	Roles  []string // Values of the configured role attribute
+}
+
+// SAMLProvider returns the account provider name of a company's identity provider
+func SAMLProvider(companyID uuid.UUID) string {
+	return "saml:" + companyID.String()
+}
+
+// SAMLProviderCompany returns the company of a SAML account provider name.
+// Sessions started with SAML record the same name as their login method.
+func SAMLProviderCompany(provider string) (uuid.UUID, bool) {
+	id, ok := strings.CutPrefix(provider, "saml:")
+	if !ok {
+		return uuid.Nil, false
+	}
+	companyID, err := uuid.Parse(id)
+	return companyID, err == nil
+}
+
+// SAMLMetadataURL is the service provider entity ID and metadata location of a company
+func SAMLMetadataURL(companyID uuid.UUID) string {
+	return oauthCallbackURL("/auth/saml/" + companyID.String() + "/metadata")
+}
+
+// SAMLACSURL is where identity providers post their responses
+func SAMLACSURL(companyID uuid.UUID) string {
+	return oauthCallbackURL("/auth/saml/" + companyID.String() + "/acs")
+}
+
+// SAMLLoginURL starts single sign-on for a company
+func SAMLLoginURL(companyID uuid.UUID) string {
+	return oauthCallbackURL("/auth/saml/" + companyID.String() + "/login")
+}
+
+// GetSAMLConfig returns the SAML configuration of a company
+func GetSAMLConfig(companyID uuid.UUID) (*companymodels.CompanySAMLConfig, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+	return getSAMLConfig(db, companyID)
+}
+
+func getSAMLConfig(db *gorm.DB, companyID uuid.UUID) (*companymodels.CompanySAMLConfig, error) {
+	var cfg companymodels.CompanySAMLConfig
+	if err := db.Where("company_id = ?", companyID).First(&cfg).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSAMLNotConfigured
+		}
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// SaveSAMLConfig creates or updates the SAML configuration of an enterprise
+// company. The service provider key pair is generated on first save.
+func SaveSAMLConfig(companyID uuid.UUID, update SAMLConfigUpdate) (*companymodels.CompanySAMLConfig, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+	return saveSAMLConfig(db, companyID, update)
+}
+
+func saveSAMLConfig(db *gorm.DB, companyID uuid.UUID, update SAMLConfigUpdate) (*companymodels.CompanySAMLConfig, error) {
+	var company companymodels.Company
+	if err := db.First(&company, "id = ?", companyID).Error; err != nil {
+		return nil, err
+	}
+	if !samlPlanIncluded(&company) {
+		return nil, ErrSAMLPlanRequired
+	}
+
+	cfg, err := getSAMLConfig(db, companyID)
+	if errors.Is(err, ErrSAMLNotConfigured) {
+		cfg = &companymodels.CompanySAMLConfig{CompanyID: companyID, DefaultRole: samlDefaultRole}
+		if err := generateSAMLKeyPair(cfg); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if update.IDPMetadata != nil {
+		entity, err := parseIDPMetadata([]byte(*update.IDPMetadata))
+		if err != nil {
+			return nil, err
+		}
+		ssoURL := idpSSOLocation(entity)
+		if ssoURL == "" {
+			return nil, fmt.Errorf("%w: no HTTP-Redirect single sign-on service", ErrSAMLInvalidMetadata)
+		}
+		cfg.IDPMetadata = *update.IDPMetadata
+		cfg.IDPEntityID = entity.EntityID
+		cfg.IDPSSOURL = ssoURL
+	}
+	if update.Enabled != nil {
+		cfg.Enabled = *update.Enabled
+	}
+	if update.EnforceSSO != nil {
+		cfg.EnforceSSO = *update.EnforceSSO
+	}
+	if update.EmailAttribute != nil {
+		cfg.EmailAttribute = strings.TrimSpace(*update.EmailAttribute)
+	}
+	if update.NameAttribute != nil {
+		cfg.NameAttribute = strings.TrimSpace(*update.NameAttribute)
+	}
+	if update.RoleAttribute != nil {
+		cfg.RoleAttribute = strings.TrimSpace(*update.RoleAttribute)
+	}
+	if update.RoleMapping != nil {
+		cfg.RoleMapping = update.RoleMapping
+	}
+	if update.DefaultRole != nil {
+		cfg.DefaultRole = strings.TrimSpace(*update.DefaultRole)
+	}
+	if update.AllowedDomains != nil {
+		domains := make(companymodels.SAMLDomainList, 0, len(update.AllowedDomains))
+		for _, domain := range update.AllowedDomains {
+			if domain = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(domain, "@"))); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		cfg.AllowedDomains = domains
+	}
+
+	if cfg.Enabled {
+		if cfg.IDPMetadata == "" {
+			return nil, fmt.Errorf("%w: metadata is required to enable saml", ErrSAMLInvalidMetadata)
+		}
+		if len(cfg.AllowedDomains) == 0 {
+			return nil, ErrSAMLDomainsRequired
+		}
+	}
+	// Enforcing without a working login would lock members out
+	if !cfg.Enabled {
+		cfg.EnforceSSO = false
+	}
+
+	for _, roleName := range append(mappedRoleNames(cfg.RoleMapping), cfg.DefaultRole) {
+		if _, err := findSAMLRole(db, companyID, roleName); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.Save(cfg).Error; err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// DeleteSAMLConfig removes the SAML configuration; linked SAML logins stop working
+func DeleteSAMLConfig(companyID uuid.UUID) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	// Hard delete so the unique company_id can be configured again
+	result := db.Unscoped().Where("company_id = ?", companyID).Delete(&companymodels.CompanySAMLConfig{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSAMLNotConfigured
+	}
+	return nil
+}
+
+// SAMLMetadata returns the service provider metadata of a company for the identity provider
+func SAMLMetadata(companyID uuid.UUID) ([]byte, error) {
+	cfg, err := GetSAMLConfig(companyID)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := samlServiceProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return xml.MarshalIndent(sp.Metadata(), "", "  ")
+}
+
+// SAMLAuthnRequestURL returns the identity provider URL that starts a login.
+// requestID becomes the AuthnRequest ID and must be passed to
+// AuthenticateSAMLResponse; relayState is echoed back to the ACS.
+func SAMLAuthnRequestURL(companyID uuid.UUID, requestID, relayState string) (string, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return "", err
+	}
+	return samlAuthnRequestURL(db, companyID, requestID, relayState)
+}
+
+func samlAuthnRequestURL(db *gorm.DB, companyID uuid.UUID, requestID, relayState string) (string, error) {
+	cfg, err := activeSAMLConfig(db, companyID)
+	if err != nil {
+		return "", err
+	}
+	sp, err := samlServiceProvider(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := sp.MakeAuthenticationRequest(cfg.IDPSSOURL, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", err
+	}
+	req.ID = requestID
+	redirect, err := req.Redirect(url.QueryEscape(relayState), sp)
+	if err != nil {
+		return "", err
+	}
+	return redirect.String(), nil
+}
+
+// AuthenticateSAMLResponse verifies the SAMLResponse posted to the ACS: the
+// signature against the IdP certificate, the audience, the recipient, the
+// validity window and that it answers requestID. IdP-initiated logins are not
+// accepted.
+func AuthenticateSAMLResponse(companyID uuid.UUID, r *http.Request, requestID string) (*companymodels.CompanySAMLConfig, *SAMLIdentity, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, nil, err
+	}
+	return authenticateSAMLResponse(db, companyID, r, requestID)
+}
+
+func authenticateSAMLResponse(db *gorm.DB, companyID uuid.UUID, r *http.Request, requestID string) (*companymodels.CompanySAMLConfig, *SAMLIdentity, error) {
+	cfg, err := activeSAMLConfig(db, companyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	sp, err := samlServiceProvider(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrSAMLInvalidResponse, err)
+	}
+	assertion, err := sp.ParseResponse(r, []string{requestID})
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			return nil, nil, fmt.Errorf("%w: %v", ErrSAMLInvalidResponse, invalid.PrivateErr)
+		}
+		return nil, nil, fmt.Errorf("%w: %v", ErrSAMLInvalidResponse, err)
+	}
+
+	identity := &SAMLIdentity{}
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		identity.NameID = strings.TrimSpace(assertion.Subject.NameID.Value)
+	}
+	if identity.NameID == "" {
+		return nil, nil, fmt.Errorf("%w: missing NameID", ErrSAMLInvalidResponse)
+	}
+
+	if cfg.EmailAttribute != "" {
+		identity.Email = firstAttributeValue(assertion, cfg.EmailAttribute)
+	} else {
+		identity.Email = identity.NameID
+	}
+	identity.Email = strings.ToLower(strings.TrimSpace(identity.Email))
+	if identity.Email == "" || !strings.Contains(identity.Email, "@") {
+		return nil, nil, ErrSAMLEmailMissing
+	}
+	if !samlDomainAllowed(cfg, identity.Email) {
+		return nil, nil, ErrSAMLDomainNotAllowed
+	}
+	if cfg.NameAttribute != "" {
+		identity.Name = firstAttributeValue(assertion, cfg.NameAttribute)
+	}
+	if cfg.RoleAttribute != "" {
+		identity.Roles = attributeValues(assertion, cfg.RoleAttribute)
+	}
+	return cfg, identity, nil
+}
+
+// FindOrCreateSAMLUser signs in the user behind a verified assertion. Users
+// are found by their SAML account only: an existing user with the same email
+// is never linked automatically, since the account may belong to other
+// companies too; they link the identity provider from /user/accounts while
+// signed in. Unknown users are created just in time together with their
+// CompanyMember, unverified because the allowed domains are only declared by
+// the owner. The member role follows the role mapping on every login.
+func FindOrCreateSAMLUser(cfg *companymodels.CompanySAMLConfig, identity *SAMLIdentity) (*auth.User, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+	return findOrCreateSAMLUser(db, cfg, identity)
+}
+
+func findOrCreateSAMLUser(db *gorm.DB, cfg *companymodels.CompanySAMLConfig, identity *SAMLIdentity) (*auth.User, error) {
+	provider := SAMLProvider(cfg.CompanyID)
+	roleName := samlRoleName(cfg, identity.Roles)
+
+	var user auth.User
+	var account auth.Account
+	err := db.Where("provider = ? AND provider_id = ?", provider, identity.NameID).First(&account).Error
+	switch {
+	case err == nil:
+		if err := db.First(&user, "id = ?", account.UserID).Error; err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err = db.Where("email = ?", identity.Email).First(&user).Error
+		switch {
+		case err == nil:
+			log.Printf("SAML %s: NameID %s matches user %s by email; the account has to be linked", provider, identity.NameID, user.ID)
+			return nil, ErrSAMLAccountExists
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user = auth.User{
+				Email:           identity.Email,
+				Role:            "user",
+				IsVerified:      false, // Nothing proves the company owns the allowed domains
+				ActiveCompanyID: &cfg.CompanyID,
+			}
+			if identity.Name != "" {
+				user.FullName = &identity.Name
+			}
+			if err := db.Create(&user).Error; err != nil {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+
+		account = auth.Account{UserID: user.ID, Provider: provider, ProviderID: identity.NameID}
+		if err := db.Create(&account).Error; err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if user.IsServiceAccount {
+		return nil, ErrSAMLServiceAccountUser
+	}
+	if err := syncSAMLMembership(db, cfg.CompanyID, user.ID, roleName); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SSORequiredCompany returns a company that requires the user to sign in
+// with its SAML identity provider, or uuid.Nil. samlCompanyID is the company
+// whose identity provider the user signed in with (uuid.Nil for other logins);
+// it only satisfies that company's policy. Owners are exempt so the company
+// keeps a way in when its identity provider is down.
+func SSORequiredCompany(userID, samlCompanyID uuid.UUID) (uuid.UUID, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return ssoRequiredCompany(db, userID, samlCompanyID)
+}
+
+func ssoRequiredCompany(db *gorm.DB, userID, samlCompanyID uuid.UUID) (uuid.UUID, error) {
+	var cfg companymodels.CompanySAMLConfig
+	err := db.Model(&companymodels.CompanySAMLConfig{}).
+		Joins("JOIN company_members ON company_members.company_id = company_saml_configs.company_id AND company_members.deleted_at IS NULL").
+		Joins("JOIN companies ON companies.id = company_saml_configs.company_id AND companies.deleted_at IS NULL").
+		Where("company_members.user_id = ? AND company_members.is_owner = ? AND company_members.is_active = ?", userID, false, true).
+		Where("company_saml_configs.enabled = ? AND company_saml_configs.enforce_sso = ?", true, true).
+		Where("companies.plan_type = ? AND companies.is_active = ?", samlPlanType, true).
+		Where("company_saml_configs.company_id <> ?", samlCompanyID).
+		First(&cfg).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return cfg.CompanyID, nil
+}
+
+// syncSAMLMembership adds the user to the company with roleName, or moves an
+// existing non-owner member to it when the mapping changed
+func syncSAMLMembership(db *gorm.DB, companyID, userID uuid.UUID, roleName string) error {
+	role, err := findSAMLRole(db, companyID, roleName)
+	if err != nil {
+		return err
+	}
+
+	domain := BuildDomainID(&companyID)
+	userSubject := fmt.Sprintf("user:%s", userID.String())
+
+	var member companymodels.CompanyMember
+	err = db.Where("company_id = ? AND user_id = ?", companyID, userID).First(&member).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		now := time.Now()
+		member = companymodels.CompanyMember{
+			CompanyID: companyID,
+			UserID:    userID,
+			RoleID:    role.ID,
+			IsActive:  true,
+			Status:    "active",
+			JoinedAt:  &now,
+		}
+		if err := db.Create(&member).Error; err != nil {
+			return fmt.Errorf("failed to add member: %w", err)
+		}
+		if _, err := AddRoleForUser(userSubject, fmt.Sprintf("role:%s", role.ID.String()), domain); err != nil {
+			log.Printf("Warning: failed to assign role for user %s in company %s: %v", userSubject, companyID.String(), err)
+		}
+	case err != nil:
+		return err
+	case member.IsOwner || member.RoleID == role.ID:
+		return nil
+	default:
+		oldRoleID := member.RoleID
+		if err := db.Model(&member).Update("role_id", role.ID).Error; err != nil {
+			return fmt.Errorf("failed to update member role: %w", err)
+		}
+		if _, err := DeleteRoleForUser(userSubject, fmt.Sprintf("role:%s", oldRoleID.String()), domain); err != nil {
+			log.Printf("warning: failed to remove old role assignment for user %s: %v", userSubject, err)
+		}
+		if _, err := AddRoleForUser(userSubject, fmt.Sprintf("role:%s", role.ID.String()), domain); err != nil {
+			log.Printf("warning: failed to add new role assignment for user %s: %v", userSubject, err)
+		}
+	}
+
+	_ = cache.InvalidateCompanyMembersCache(context.Background(), companyID)
+	return nil
+}
+
+// findSAMLRole finds a role by name - company-scoped first, then global.
+// The owner role cannot be handed out by an identity provider.
+func findSAMLRole(db *gorm.DB, companyID uuid.UUID, roleName string) (*basemodels.Role, error) {
+	if roleName == "" || roleName == "company_owner" {
+		return nil, fmt.Errorf("%w: %q", ErrSAMLInvalidRole, roleName)
+	}
+	var role basemodels.Role
+	if err := db.Where("name = ? AND company_id = ?", roleName, companyID).First(&role).Error; err != nil {
+		if err := db.Where("name = ? AND company_id IS NULL", roleName).First(&role).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: %q", ErrSAMLInvalidRole, roleName)
+			}
+			return nil, err
+		}
+	}
+	return &role, nil
+}
+
+// samlRoleName picks the role of the first asserted value with a mapping
+func samlRoleName(cfg *companymodels.CompanySAMLConfig, values []string) string {
+	for _, value := range values {
+		if roleName, ok := cfg.RoleMapping[value]; ok {
+			return roleName
+		}
+	}
+	if cfg.DefaultRole != "" {
+		return cfg.DefaultRole
+	}
+	return samlDefaultRole
+}
+
+func mappedRoleNames(mapping companymodels.SAMLRoleMapping) []string {
+	names := make([]string, 0, len(mapping))
+	for _, name := range mapping {
+		names = append(names, name)
+	}
+	return names
+}
+
+// activeSAMLConfig returns the configuration of a company that can sign users in
+func activeSAMLConfig(db *gorm.DB, companyID uuid.UUID) (*companymodels.CompanySAMLConfig, error) {
+	cfg, err := getSAMLConfig(db, companyID)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled || cfg.IDPMetadata == "" {
+		return nil, ErrSAMLNotConfigured
+	}
+
+	var company companymodels.Company
+	if err := db.First(&company, "id = ?", companyID).Error; err != nil {
+		return nil, err
+	}
+	if !company.IsActive {
+		return nil, ErrSAMLNotConfigured
+	}
+	if !samlPlanIncluded(&company) {
+		return nil, ErrSAMLPlanRequired
+	}
+	return cfg, nil
+}
+
+func samlPlanIncluded(company *companymodels.Company) bool {
+	return company.PlanType != nil && *company.PlanType == samlPlanType
+}
+
+func samlDomainAllowed(cfg *companymodels.CompanySAMLConfig, email string) bool {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+	for _, allowed := range cfg.AllowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// samlServiceProvider builds the service provider of a company from its configuration
+func samlServiceProvider(cfg *companymodels.CompanySAMLConfig) (*saml.ServiceProvider, error) {
+	keyPEM, err := openToken(cfg.SPPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	keyBlock, _ := pem.Decode([]byte(keyPEM))
+	if keyBlock == nil {
+		return nil, errors.New("invalid saml service provider key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("saml service provider key is not an RSA key")
+	}
+
+	certBlock, _ := pem.Decode([]byte(cfg.SPCertificate))
+	if certBlock == nil {
+		return nil, errors.New("invalid saml service provider certificate")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataURL, err := url.Parse(SAMLMetadataURL(cfg.CompanyID))
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(SAMLACSURL(cfg.CompanyID))
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               rsaKey,
+		Certificate:       cert,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		AllowIDPInitiated: false,
+	}
+	if cfg.IDPMetadata != "" {
+		if sp.IDPMetadata, err = parseIDPMetadata([]byte(cfg.IDPMetadata)); err != nil {
+			return nil, err
+		}
+	} else {
+		sp.IDPMetadata = &saml.EntityDescriptor{}
+	}
+	return sp, nil
+}
+
+// generateSAMLKeyPair creates the signing key and a self-signed certificate of
+// a company's service provider
+func generateSAMLKeyPair(cfg *companymodels.CompanySAMLConfig) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "saml-sp-" + cfg.CompanyID.String()},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := sealToken(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})))
+	if err != nil {
+		return err
+	}
+	cfg.SPPrivateKey = sealed
+	cfg.SPCertificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return nil
+}
+
+// parseIDPMetadata reads identity provider metadata, which is either an
+// EntityDescriptor or an EntitiesDescriptor wrapping one
+func parseIDPMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	if err := xrv.Validate(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSAMLInvalidMetadata, err)
+	}
+
+	entity := &saml.EntityDescriptor{}
+	if err := xml.Unmarshal(data, entity); err != nil {
+		entities := &saml.EntitiesDescriptor{}
+		if xml.Unmarshal(data, entities) != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSAMLInvalidMetadata, err)
+		}
+		entity = nil
+		for i := range entities.EntityDescriptors {
+			if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+				entity = &entities.EntityDescriptors[i]
+				break
+			}
+		}
+		if entity == nil {
+			return nil, fmt.Errorf("%w: no identity provider entity", ErrSAMLInvalidMetadata)
+		}
+	}
+	if entity.EntityID == "" || len(entity.IDPSSODescriptors) == 0 {
+		return nil, fmt.Errorf("%w: no identity provider entity", ErrSAMLInvalidMetadata)
+	}
+	return entity, nil
+}
+
+func idpSSOLocation(entity *saml.EntityDescriptor) string {
+	for _, descriptor := range entity.IDPSSODescriptors {
+		for _, sso := range descriptor.SingleSignOnServices {
+			if sso.Binding == saml.HTTPRedirectBinding {
+				return sso.Location
+			}
+		}
+	}
+	return ""
+}
+
+// attributeValues returns the values of the attribute with the given Name or FriendlyName
+func attributeValues(assertion *saml.Assertion, name string) []string {
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if attr.Name != name && attr.FriendlyName != name {
+				continue
+			}
+			for _, value := range attr.Values {
+				if v := strings.TrimSpace(value.Value); v != "" {
+					values = append(values, v)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func firstAttributeValue(assertion *saml.Assertion, name string) string {
+	if values := attributeValues(assertion, name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,560 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	authmodels "mimbackend/internal/models/auth"
+	basemodels "mimbackend/internal/models/basemodels"
+	companymodels "mimbackend/internal/models/company"
+)
+
+// testIdP is an identity provider answering AuthnRequests of one company's
+// service provider for a fixed user
+type testIdP struct {
+	t   *testing.T
+	idp *saml.IdentityProvider
+	sp  *saml.EntityDescriptor
+
+	// user is signed in by the next response
+	user *saml.Session
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate idp key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create idp certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse idp certificate: %v", err)
+	}
+
+	p := &testIdP{t: t}
+	p.idp = &saml.IdentityProvider{
+		Key:                     key,
+		Signer:                  key,
+		Certificate:             cert,
+		MetadataURL:             url.URL{Scheme: "https", Host: "idp.example.test", Path: "/metadata"},
+		SSOURL:                  url.URL{Scheme: "https", Host: "idp.example.test", Path: "/sso"},
+		ServiceProviderProvider: p,
+	}
+	return p
+}
+
+// GetServiceProvider implements saml.ServiceProviderProvider
+func (p *testIdP) GetServiceProvider(_ *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	if p.sp == nil || p.sp.EntityID != serviceProviderID {
+		return nil, errors.New("unknown service provider")
+	}
+	return p.sp, nil
+}
+
+// metadata returns the IdP metadata a company owner would upload
+func (p *testIdP) metadata() string {
+	p.t.Helper()
+
+	data, err := xml.Marshal(p.idp.Metadata())
+	if err != nil {
+		p.t.Fatalf("marshal idp metadata: %v", err)
+	}
+	return string(data)
+}
+
+// trust registers the service provider of a saved configuration
+func (p *testIdP) trust(cfg *companymodels.CompanySAMLConfig) {
+	p.t.Helper()
+
+	sp, err := samlServiceProvider(cfg)
+	if err != nil {
+		p.t.Fatalf("service provider: %v", err)
+	}
+	p.sp = sp.Metadata()
+}
+
+// respond answers the AuthnRequest in authURL like the IdP's SSO endpoint
+// would and returns the base64 SAMLResponse. edit may change the assertion
+// before it is signed.
+func (p *testIdP) respond(authURL string, edit func(*saml.Assertion)) string {
+	p.t.Helper()
+
+	req, err := saml.NewIdpAuthnRequest(p.idp, httptest.NewRequest(http.MethodGet, authURL, nil))
+	if err != nil {
+		p.t.Fatalf("parse authn request: %v", err)
+	}
+	if err := req.Validate(); err != nil {
+		p.t.Fatalf("validate authn request: %v", err)
+	}
+
+	session := *p.user
+	session.ID = uuid.NewString()
+	session.CreateTime = time.Now()
+	session.ExpireTime = time.Now().Add(time.Hour)
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, &session); err != nil {
+		p.t.Fatalf("make assertion: %v", err)
+	}
+	if edit != nil {
+		edit(req.Assertion)
+	}
+
+	form, err := req.PostBinding()
+	if err != nil {
+		p.t.Fatalf("post binding: %v", err)
+	}
+	return form.SAMLResponse
+}
+
+func samlUser(email string, groups ...string) *saml.Session {
+	attr := func(name string, values ...string) saml.Attribute {
+		a := saml.Attribute{Name: name, NameFormat: "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"}
+		for _, v := range values {
+			a.Values = append(a.Values, saml.AttributeValue{Type: "xs:string", Value: v})
+		}
+		return a
+	}
+	return &saml.Session{
+		NameID:       "idp-" + email,
+		NameIDFormat: "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent",
+		CustomAttributes: []saml.Attribute{
+			attr("email", email),
+			attr("name", "Ayşe Yılmaz"),
+			attr("groups", groups...),
+		},
+	}
+}
+
+// samlFixture is an enterprise company with SAML enabled against a testIdP
+type samlFixture struct {
+	db      *gorm.DB
+	idp     *testIdP
+	company *companymodels.Company
+	cfg     *companymodels.CompanySAMLConfig
+	roles   map[string]*basemodels.Role
+}
+
+func newSAMLFixture(t *testing.T) *samlFixture {
+	t.Helper()
+
+	t.Setenv("BASE_URL", "https://api.example.test")
+	t.Setenv("API_PREFIX", "/api/v1")
+
+	db := newTestDB(t,
+		&authmodels.User{}, &authmodels.Account{}, &basemodels.Role{},
+		&companymodels.Company{}, &companymodels.CompanyMember{}, &companymodels.CompanySAMLConfig{},
+	)
+
+	f := &samlFixture{db: db, idp: newTestIdP(t), roles: map[string]*basemodels.Role{}}
+	for _, name := range []string{"company_owner", "company_employee", "company_admin"} {
+		name := name
+		role := &basemodels.Role{Name: &name, IsActive: true}
+		if err := db.Create(role).Error; err != nil {
+			t.Fatalf("create role: %v", err)
+		}
+		f.roles[name] = role
+	}
+
+	slug, plan := "acme", samlPlanType
+	f.company = &companymodels.Company{Slug: &slug, PlanType: &plan, IsActive: true}
+	if err := db.Create(f.company).Error; err != nil {
+		t.Fatalf("create company: %v", err)
+	}
+
+	metadata, enabled := f.idp.metadata(), true
+	attrEmail, attrName, attrRole := "email", "name", "groups"
+	cfg, err := saveSAMLConfig(db, f.company.ID, SAMLConfigUpdate{
+		IDPMetadata:    &metadata,
+		Enabled:        &enabled,
+		EmailAttribute: &attrEmail,
+		NameAttribute:  &attrName,
+		RoleAttribute:  &attrRole,
+		RoleMapping:    map[string]string{"admins": "company_admin"},
+		AllowedDomains: []string{"@Acme.test"},
+	})
+	if err != nil {
+		t.Fatalf("save saml config: %v", err)
+	}
+	f.cfg = cfg
+	f.idp.trust(cfg)
+	return f
+}
+
+// login runs an SP-initiated login for requestID and posts the response to the ACS
+func (f *samlFixture) login(t *testing.T, requestID string, edit func(*saml.Assertion)) (*companymodels.CompanySAMLConfig, *SAMLIdentity, error) {
+	t.Helper()
+
+	authURL, err := samlAuthnRequestURL(f.db, f.company.ID, requestID, "relay")
+	if err != nil {
+		t.Fatalf("authn request url: %v", err)
+	}
+	return f.post(requestID, f.idp.respond(authURL, edit))
+}
+
+// post delivers a SAMLResponse to the ACS of the login that used requestID
+func (f *samlFixture) post(requestID, samlResponse string) (*companymodels.CompanySAMLConfig, *SAMLIdentity, error) {
+	form := url.Values{"SAMLResponse": {samlResponse}, "RelayState": {"relay"}}
+	r := httptest.NewRequest(http.MethodPost, SAMLACSURL(f.company.ID), strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return authenticateSAMLResponse(f.db, f.company.ID, r, requestID)
+}
+
+func (f *samlFixture) member(t *testing.T, userID uuid.UUID) *companymodels.CompanyMember {
+	t.Helper()
+
+	var member companymodels.CompanyMember
+	if err := f.db.Where("company_id = ? AND user_id = ?", f.company.ID, userID).First(&member).Error; err != nil {
+		t.Fatalf("find member: %v", err)
+	}
+	return &member
+}
+
+func TestSAMLSaveConfigRequiresEnterprisePlan(t *testing.T) {
+	f := newSAMLFixture(t)
+
+	slug, plan := "small", "basic"
+	company := &companymodels.Company{Slug: &slug, PlanType: &plan, IsActive: true}
+	if err := f.db.Create(company).Error; err != nil {
+		t.Fatalf("create company: %v", err)
+	}
+	enabled := true
+	if _, err := saveSAMLConfig(f.db, company.ID, SAMLConfigUpdate{Enabled: &enabled}); !errors.Is(err, ErrSAMLPlanRequired) {
+		t.Fatalf("expected ErrSAMLPlanRequired, got %v", err)
+	}
+}
+
+func TestSAMLAuthenticateValidAssertion(t *testing.T) {
+	f := newSAMLFixture(t)
+	f.idp.user = samlUser("ayse@acme.test", "admins")
+
+	cfg, identity, err := f.login(t, "id-valid", nil)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if cfg.CompanyID != f.company.ID {
+		t.Fatalf("config of company %s, want %s", cfg.CompanyID, f.company.ID)
+	}
+	if identity.NameID != "idp-ayse@acme.test" || identity.Email != "ayse@acme.test" || identity.Name != "Ayşe Yılmaz" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+	if len(identity.Roles) != 1 || identity.Roles[0] != "admins" {
+		t.Fatalf("unexpected roles %v", identity.Roles)
+	}
+}
+
+func TestSAMLAuthenticateRejectsInvalidResponses(t *testing.T) {
+	f := newSAMLFixture(t)
+
+	tests := []struct {
+		name string
+		user *saml.Session
+		edit func(*saml.Assertion)
+		want error
+	}{
+		{
+			name: "wrong audience",
+			user: samlUser("ayse@acme.test"),
+			edit: func(a *saml.Assertion) {
+				a.Conditions.AudienceRestrictions = []saml.AudienceRestriction{{
+					Audience: saml.Audience{Value: "https://other.example.test/metadata"},
+				}}
+			},
+			want: ErrSAMLInvalidResponse,
+		},
+		{
+			name: "expired",
+			user: samlUser("ayse@acme.test"),
+			edit: func(a *saml.Assertion) {
+				a.Conditions.NotOnOrAfter = time.Now().Add(-time.Hour)
+			},
+			want: ErrSAMLInvalidResponse,
+		},
+		{
+			name: "domain not allowed",
+			user: samlUser("ayse@other.test"),
+			want: ErrSAMLDomainNotAllowed,
+		},
+		{
+			name: "no email",
+			user: samlUser(""),
+			want: ErrSAMLEmailMissing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.idp.user = tt.user
+			if _, _, err := f.login(t, "id-"+uuid.NewString(), tt.edit); !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestSAMLAuthenticateRejectsForeignSignature(t *testing.T) {
+	f := newSAMLFixture(t)
+
+	// Another IdP answers with a valid-looking response for our service provider
+	other := newTestIdP(t)
+	other.sp = f.idp.sp
+	other.user = samlUser("ayse@acme.test")
+
+	authURL, err := samlAuthnRequestURL(f.db, f.company.ID, "id-foreign", "relay")
+	if err != nil {
+		t.Fatalf("authn request url: %v", err)
+	}
+	other.idp.MetadataURL = f.idp.idp.MetadataURL // Same issuer, different key
+	if _, _, err := f.post("id-foreign", other.respond(authURL, nil)); !errors.Is(err, ErrSAMLInvalidResponse) {
+		t.Fatalf("expected ErrSAMLInvalidResponse, got %v", err)
+	}
+}
+
+func TestSAMLAuthenticateRejectsReplayedAssertion(t *testing.T) {
+	f := newSAMLFixture(t)
+	f.idp.user = samlUser("ayse@acme.test")
+
+	authURL, err := samlAuthnRequestURL(f.db, f.company.ID, "id-first", "relay")
+	if err != nil {
+		t.Fatalf("authn request url: %v", err)
+	}
+	response := f.idp.respond(authURL, nil)
+	if _, _, err := f.post("id-first", response); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	// The ACS consumes the state of a login once, so a captured response can
+	// only be posted again within a new login, whose request ID it does not answer
+	if _, _, err := f.post("id-second", response); !errors.Is(err, ErrSAMLInvalidResponse) {
+		t.Fatalf("expected ErrSAMLInvalidResponse on replay, got %v", err)
+	}
+	// Nor is it accepted unsolicited
+	if _, _, err := f.post("", response); !errors.Is(err, ErrSAMLInvalidResponse) {
+		t.Fatalf("expected ErrSAMLInvalidResponse for IdP-initiated use, got %v", err)
+	}
+}
+
+func TestSAMLAuthenticateRequiresActiveConfig(t *testing.T) {
+	f := newSAMLFixture(t)
+	f.idp.user = samlUser("ayse@acme.test")
+
+	authURL, err := samlAuthnRequestURL(f.db, f.company.ID, "id-disabled", "relay")
+	if err != nil {
+		t.Fatalf("authn request url: %v", err)
+	}
+	response := f.idp.respond(authURL, nil)
+
+	disabled := false
+	if _, err := saveSAMLConfig(f.db, f.company.ID, SAMLConfigUpdate{Enabled: &disabled}); err != nil {
+		t.Fatalf("disable saml: %v", err)
+	}
+	if _, _, err := f.post("id-disabled", response); !errors.Is(err, ErrSAMLNotConfigured) {
+		t.Fatalf("expected ErrSAMLNotConfigured, got %v", err)
+	}
+}
+
+func TestSAMLFindOrCreateUserJustInTime(t *testing.T) {
+	f := newSAMLFixture(t)
+	f.idp.user = samlUser("ayse@acme.test")
+
+	cfg, identity, err := f.login(t, "id-jit", nil)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	user, err := findOrCreateSAMLUser(f.db, cfg, identity)
+	if err != nil {
+		t.Fatalf("find or create: %v", err)
+	}
+	// Nothing proves the company owns acme.test, so the address stays unverified
+	if user.Email != "ayse@acme.test" || user.IsVerified || user.FullName == nil || *user.FullName != "Ayşe Yılmaz" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	if user.ActiveCompanyID == nil || *user.ActiveCompanyID != f.company.ID {
+		t.Fatalf("active company %v, want %s", user.ActiveCompanyID, f.company.ID)
+	}
+
+	var account authmodels.Account
+	if err := f.db.Where("provider = ? AND provider_id = ?", SAMLProvider(f.company.ID), "idp-ayse@acme.test").First(&account).Error; err != nil {
+		t.Fatalf("saml account: %v", err)
+	}
+	if account.UserID != user.ID {
+		t.Fatalf("account of user %s, want %s", account.UserID, user.ID)
+	}
+
+	member := f.member(t, user.ID)
+	if member.RoleID != f.roles["company_employee"].ID || !member.IsActive || member.IsOwner {
+		t.Fatalf("unexpected membership %+v", member)
+	}
+
+	// The next login finds the same user and moves the member to the mapped role
+	f.idp.user = samlUser("ayse@acme.test", "admins")
+	cfg, identity, err = f.login(t, "id-jit-2", nil)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	again, err := findOrCreateSAMLUser(f.db, cfg, identity)
+	if err != nil {
+		t.Fatalf("find or create: %v", err)
+	}
+	if again.ID != user.ID {
+		t.Fatalf("second login created user %s, want %s", again.ID, user.ID)
+	}
+	if member := f.member(t, user.ID); member.RoleID != f.roles["company_admin"].ID {
+		t.Fatalf("member role %s, want company_admin", member.RoleID)
+	}
+
+	var users int64
+	f.db.Model(&authmodels.User{}).Count(&users)
+	if users != 1 {
+		t.Fatalf("%d users, want 1", users)
+	}
+}
+
+func TestSAMLFindOrCreateUserKeepsOwnerRole(t *testing.T) {
+	f := newSAMLFixture(t)
+	owner := createTestUser(t, f.db, "owner@acme.test")
+	if err := f.db.Create(&companymodels.CompanyMember{
+		CompanyID: f.company.ID, UserID: owner.ID, RoleID: f.roles["company_owner"].ID, IsOwner: true, IsActive: true,
+	}).Error; err != nil {
+		t.Fatalf("create owner: %v", err)
+	}
+	// The owner linked the identity provider from /user/accounts
+	if err := f.db.Create(&authmodels.Account{
+		UserID: owner.ID, Provider: SAMLProvider(f.company.ID), ProviderID: "idp-owner@acme.test",
+	}).Error; err != nil {
+		t.Fatalf("link owner: %v", err)
+	}
+
+	f.idp.user = samlUser("owner@acme.test", "admins")
+	cfg, identity, err := f.login(t, "id-owner", nil)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	user, err := findOrCreateSAMLUser(f.db, cfg, identity)
+	if err != nil {
+		t.Fatalf("find or create: %v", err)
+	}
+	if user.ID != owner.ID {
+		t.Fatalf("linked user %s, want owner %s", user.ID, owner.ID)
+	}
+	if member := f.member(t, owner.ID); !member.IsOwner || member.RoleID != f.roles["company_owner"].ID {
+		t.Fatalf("owner membership changed: %+v", member)
+	}
+}
+
+func TestSAMLFindOrCreateUserRefusesUnlinkedUserWithSameEmail(t *testing.T) {
+	f := newSAMLFixture(t)
+	createTestUser(t, f.db, "ayse@acme.test")
+	member := createTestUser(t, f.db, "mehmet@acme.test")
+	if err := f.db.Create(&companymodels.CompanyMember{
+		CompanyID: f.company.ID, UserID: member.ID, RoleID: f.roles["company_employee"].ID, IsActive: true,
+	}).Error; err != nil {
+		t.Fatalf("create member: %v", err)
+	}
+
+	// Neither a stranger nor a member of the company is linked by email
+	for i, email := range []string{"ayse@acme.test", "mehmet@acme.test"} {
+		f.idp.user = samlUser(email)
+		cfg, identity, err := f.login(t, fmt.Sprintf("id-existing-%d", i), nil)
+		if err != nil {
+			t.Fatalf("authenticate %s: %v", email, err)
+		}
+		if _, err := findOrCreateSAMLUser(f.db, cfg, identity); !errors.Is(err, ErrSAMLAccountExists) {
+			t.Fatalf("%s: expected ErrSAMLAccountExists, got %v", email, err)
+		}
+	}
+
+	var accounts int64
+	f.db.Model(&authmodels.Account{}).Count(&accounts)
+	if accounts != 0 {
+		t.Fatalf("%d accounts linked, want 0", accounts)
+	}
+}
+
+func TestSSORequiredCompany(t *testing.T) {
+	f := newSAMLFixture(t)
+
+	employee := createTestUser(t, f.db, "employee@acme.test")
+	owner := createTestUser(t, f.db, "owner@acme.test")
+	outsider := createTestUser(t, f.db, "outsider@example.test")
+	for _, m := range []companymodels.CompanyMember{
+		{CompanyID: f.company.ID, UserID: employee.ID, RoleID: f.roles["company_employee"].ID, IsActive: true},
+		{CompanyID: f.company.ID, UserID: owner.ID, RoleID: f.roles["company_owner"].ID, IsOwner: true, IsActive: true},
+	} {
+		if err := f.db.Create(&m).Error; err != nil {
+			t.Fatalf("create member: %v", err)
+		}
+	}
+
+	requiredAfterSAML := func(user *authmodels.User, samlCompanyID uuid.UUID) uuid.UUID {
+		t.Helper()
+		companyID, err := ssoRequiredCompany(f.db, user.ID, samlCompanyID)
+		if err != nil {
+			t.Fatalf("sso required company: %v", err)
+		}
+		return companyID
+	}
+	required := func(user *authmodels.User) uuid.UUID {
+		t.Helper()
+		return requiredAfterSAML(user, uuid.Nil)
+	}
+
+	if got := required(employee); got != uuid.Nil {
+		t.Fatalf("sso required without enforce_sso: %s", got)
+	}
+
+	enforce := true
+	if _, err := saveSAMLConfig(f.db, f.company.ID, SAMLConfigUpdate{EnforceSSO: &enforce}); err != nil {
+		t.Fatalf("enforce sso: %v", err)
+	}
+	if got := required(employee); got != f.company.ID {
+		t.Fatalf("sso required company %s, want %s", got, f.company.ID)
+	}
+	if got := required(owner); got != uuid.Nil {
+		t.Fatalf("owner must keep password login, got %s", got)
+	}
+
+	// Only the company's own identity provider satisfies it
+	if got := requiredAfterSAML(employee, f.company.ID); got != uuid.Nil {
+		t.Fatalf("sso required after signing in with the company's IdP: %s", got)
+	}
+	if got := requiredAfterSAML(employee, uuid.New()); got != f.company.ID {
+		t.Fatalf("sso required company after another company's IdP %s, want %s", got, f.company.ID)
+	}
+	if got := required(outsider); got != uuid.Nil {
+		t.Fatalf("non-member must not be affected, got %s", got)
+	}
+
+	// Disabling SAML also lifts the enforcement
+	disabled := false
+	if _, err := saveSAMLConfig(f.db, f.company.ID, SAMLConfigUpdate{Enabled: &disabled}); err != nil {
+		t.Fatalf("disable saml: %v", err)
+	}
+	if got := required(employee); got != uuid.Nil {
+		t.Fatalf("sso required with saml disabled: %s", got)
+	}
+}
@@ -0,0 +1,430 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"mimbackend/config"
+	"mimbackend/internal/cache"
+	authmodels "mimbackend/internal/models/auth"
+	basemodels "mimbackend/internal/models/basemodels"
+	companymodels "mimbackend/internal/models/company"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// ServiceAccountSecretPrefix marks service account secrets in the
+	// Authorization header, next to JWTs and personal access tokens
+	ServiceAccountSecretPrefix = "mim_sa_"
+
+	// ServiceAccountRole is the global role of service account users
+	ServiceAccountRole = "service_account"
+
+	// DefaultSecretRotationGrace keeps the old secrets working after a rotation
+	// so integrations can switch over
+	DefaultSecretRotationGrace = 24 * time.Hour
+
+	serviceAccountEmailDomain = "service-accounts.invalid"
+	saLastUsedInterval        = time.Minute
+)
+
+var (
+	ErrServiceAccountNotFound        = errors.New("service account not found")
+	ErrServiceAccountSecretNotFound  = errors.New("service account secret not found")
+	ErrServiceAccountSecretInvalid   = errors.New("service account secret is invalid, expired or revoked")
+	ErrServiceAccountRoleNotFound    = errors.New("role not found or inactive")
+	ErrServiceAccountLoginNotAllowed = errors.New("service accounts cannot log in")
+)
+
+// ServiceAccountUpdate holds the optional changes of UpdateServiceAccount
+type ServiceAccountUpdate struct {
+	Name     *string
+	RoleID   *uuid.UUID
+	IsActive *bool
+}
+
+// CreateServiceAccount adds a non-human member to a company. It is backed by
+// a user row without credentials so permission checks work as for people.
+func CreateServiceAccount(companyID, createdBy uuid.UUID, name string, roleID uuid.UUID) (*companymodels.CompanyMember, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := findCompanyRole(db, companyID, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	userID := uuid.New()
+	now := time.Now()
+	user := &authmodels.User{
+		Email:            fmt.Sprintf("sa-%s@%s", userID, serviceAccountEmailDomain),
+		FullName:         &name,
+		Role:             ServiceAccountRole,
+		IsServiceAccount: true,
+	}
+	user.ID = userID
+
+	member := &companymodels.CompanyMember{
+		UserID:           userID,
+		CompanyID:        companyID,
+		RoleID:           role.ID,
+		IsActive:         true,
+		Status:           "active",
+		JoinedAt:         &now,
+		IsServiceAccount: true,
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return tx.Create(member).Error
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create service account: %w", err)
+	}
+
+	assignServiceAccountRole(userID, companyID, nil, role.ID)
+	log.Printf("✅ Service account created: member_id=%s, company_id=%s, created_by=%s", member.ID, companyID, createdBy)
+	_ = cache.InvalidateCompanyMembersCache(context.Background(), companyID)
+
+	member.User = user
+	member.Role = role
+	return member, nil
+}
+
+// ListServiceAccounts returns the service accounts of a company
+func ListServiceAccounts(companyID uuid.UUID) ([]companymodels.CompanyMember, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var members []companymodels.CompanyMember
+	if err := db.Where("company_id = ? AND is_service_account = ?", companyID, true).
+		Preload("User").
+		Preload("Role").
+		Order("created_at ASC").
+		Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// GetServiceAccount returns one service account of a company
+func GetServiceAccount(companyID, serviceAccountID uuid.UUID) (*companymodels.CompanyMember, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+	return findServiceAccount(db, companyID, serviceAccountID)
+}
+
+// UpdateServiceAccount renames, re-roles or (de)activates a service account
+func UpdateServiceAccount(companyID, serviceAccountID uuid.UUID, update ServiceAccountUpdate) (*companymodels.CompanyMember, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := findServiceAccount(db, companyID, serviceAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var newRole *basemodels.Role
+	if update.RoleID != nil && *update.RoleID != member.RoleID {
+		if newRole, err = findCompanyRole(db, companyID, *update.RoleID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if update.Name != nil {
+			name := strings.TrimSpace(*update.Name)
+			if err := tx.Model(&authmodels.User{}).Where("id = ?", member.UserID).Update("full_name", name).Error; err != nil {
+				return err
+			}
+		}
+		memberUpdates := map[string]interface{}{}
+		if newRole != nil {
+			memberUpdates["role_id"] = newRole.ID
+		}
+		if update.IsActive != nil {
+			memberUpdates["is_active"] = *update.IsActive
+			memberUpdates["status"] = "active"
+			if !*update.IsActive {
+				memberUpdates["status"] = "suspended"
+			}
+		}
+		if len(memberUpdates) == 0 {
+			return nil
+		}
+		return tx.Model(member).Updates(memberUpdates).Error
+	}); err != nil {
+		return nil, fmt.Errorf("failed to update service account: %w", err)
+	}
+
+	if newRole != nil {
+		oldRoleID := member.RoleID
+		assignServiceAccountRole(member.UserID, companyID, &oldRoleID, newRole.ID)
+	}
+	_ = cache.InvalidateCompanyMembersCache(context.Background(), companyID)
+
+	return findServiceAccount(db, companyID, serviceAccountID)
+}
+
+// DeleteServiceAccount removes a service account, its role assignment and
+// revokes all of its secrets
+func DeleteServiceAccount(companyID, serviceAccountID, deletedBy uuid.UUID) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	member, err := findServiceAccount(db, companyID, serviceAccountID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&companymodels.ServiceAccountSecret{}).
+			Where("member_id = ? AND revoked_at IS NULL", member.ID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(member).Updates(map[string]interface{}{"deleted_at": now, "deleted_by": deletedBy}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&authmodels.User{}).Where("id = ?", member.UserID).
+			Updates(map[string]interface{}{"deleted_at": now, "deleted_by": deletedBy}).Error
+	}); err != nil {
+		return fmt.Errorf("failed to delete service account: %w", err)
+	}
+
+	domain := BuildDomainID(&companyID)
+	if _, err := DeleteRoleForUser("user:"+member.UserID.String(), "role:"+member.RoleID.String(), domain); err != nil {
+		log.Printf("warning: failed to remove role of service account %s: %v", member.ID, err)
+	}
+	_ = cache.InvalidateCompanyMembersCache(context.Background(), companyID)
+	return nil
+}
+
+// CreateServiceAccountSecret issues a new secret. The plain secret is only
+// returned here.
+func CreateServiceAccountSecret(companyID, serviceAccountID, createdBy uuid.UUID, name string) (string, *companymodels.ServiceAccountSecret, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return "", nil, err
+	}
+
+	member, err := findServiceAccount(db, companyID, serviceAccountID)
+	if err != nil {
+		return "", nil, err
+	}
+	return createServiceAccountSecret(db, member, createdBy, name)
+}
+
+// RotateServiceAccountSecret issues a new secret and lets every other active
+// secret expire after grace
+func RotateServiceAccountSecret(companyID, serviceAccountID, createdBy uuid.UUID, name string, grace time.Duration) (string, *companymodels.ServiceAccountSecret, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return "", nil, err
+	}
+
+	member, err := findServiceAccount(db, companyID, serviceAccountID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	raw, secret, err := createServiceAccountSecret(db, member, createdBy, name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	cutoff := time.Now().Add(grace)
+	if err := db.Model(&companymodels.ServiceAccountSecret{}).
+		Where("member_id = ? AND id <> ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", member.ID, secret.ID, cutoff).
+		Update("expires_at", cutoff).Error; err != nil {
+		return "", nil, err
+	}
+	return raw, secret, nil
+}
+
+// ListServiceAccountSecrets returns the secrets of a service account, newest first
+func ListServiceAccountSecrets(companyID, serviceAccountID uuid.UUID) ([]companymodels.ServiceAccountSecret, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []companymodels.ServiceAccountSecret
+	if err := db.Where("member_id = ? AND company_id = ?", serviceAccountID, companyID).
+		Order("created_at DESC").
+		Find(&secrets).Error; err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// RevokeServiceAccountSecret revokes one secret immediately
+func RevokeServiceAccountSecret(companyID, serviceAccountID, secretID uuid.UUID) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+
+	result := db.Model(&companymodels.ServiceAccountSecret{}).
+		Where("id = ? AND member_id = ? AND company_id = ? AND revoked_at IS NULL", secretID, serviceAccountID, companyID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrServiceAccountSecretNotFound
+	}
+	return nil
+}
+
+// IsServiceAccountSecret reports whether a bearer credential is a service account secret
+func IsServiceAccountSecret(raw string) bool {
+	return strings.HasPrefix(raw, ServiceAccountSecretPrefix)
+}
+
+// AuthenticateServiceAccount resolves a plain secret to the secret record and
+// the active service account (with its user) it belongs to
+func AuthenticateServiceAccount(raw, clientIP string) (*companymodels.ServiceAccountSecret, *companymodels.CompanyMember, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var secret companymodels.ServiceAccountSecret
+	if err := db.Where("secret_hash = ?", hashAPIToken(raw)).First(&secret).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrServiceAccountSecretInvalid
+		}
+		return nil, nil, err
+	}
+	if !secret.IsActive() {
+		return nil, nil, ErrServiceAccountSecretInvalid
+	}
+
+	member, err := findServiceAccount(db, secret.CompanyID, secret.MemberID)
+	if err != nil {
+		if errors.Is(err, ErrServiceAccountNotFound) {
+			return nil, nil, ErrServiceAccountSecretInvalid
+		}
+		return nil, nil, err
+	}
+	if !member.IsActive || member.User == nil {
+		return nil, nil, ErrServiceAccountSecretInvalid
+	}
+
+	if secret.LastUsedAt == nil || time.Since(*secret.LastUsedAt) > saLastUsedInterval {
+		if err := db.Model(&secret).Updates(map[string]interface{}{"last_used_at": time.Now(), "last_used_ip": clientIP}).Error; err != nil {
+			log.Printf("service account: failed to record usage of secret %s: %v", secret.ID, err)
+		}
+	}
+	return &secret, member, nil
+}
+
+// RecordServiceAccountActivity stores one API call of a service account
+func RecordServiceAccountActivity(activity *companymodels.ServiceAccountActivity) error {
+	db, err := config.NewConnection()
+	if err != nil {
+		return err
+	}
+	return db.Create(activity).Error
+}
+
+// ListServiceAccountActivity returns the latest calls of a service account
+func ListServiceAccountActivity(companyID, serviceAccountID uuid.UUID, limit int) ([]companymodels.ServiceAccountActivity, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var activity []companymodels.ServiceAccountActivity
+	if err := db.Where("member_id = ? AND company_id = ?", serviceAccountID, companyID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&activity).Error; err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+// Private helper methods
+
+func findServiceAccount(db *gorm.DB, companyID, serviceAccountID uuid.UUID) (*companymodels.CompanyMember, error) {
+	var member companymodels.CompanyMember
+	if err := db.Where("id = ? AND company_id = ? AND is_service_account = ?", serviceAccountID, companyID, true).
+		Preload("User").
+		Preload("Role").
+		First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrServiceAccountNotFound
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+func findCompanyRole(db *gorm.DB, companyID, roleID uuid.UUID) (*basemodels.Role, error) {
+	var role basemodels.Role
+	if err := db.Where("id = ? AND company_id = ? AND is_active = ?", roleID, companyID, true).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrServiceAccountRoleNotFound
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+func createServiceAccountSecret(db *gorm.DB, member *companymodels.CompanyMember, createdBy uuid.UUID, name string) (string, *companymodels.ServiceAccountSecret, error) {
+	random, err := generateResetToken()
+	if err != nil {
+		return "", nil, err
+	}
+	raw := ServiceAccountSecretPrefix + random
+
+	secret := &companymodels.ServiceAccountSecret{
+		MemberID:   member.ID,
+		CompanyID:  member.CompanyID,
+		Name:       strings.TrimSpace(name),
+		SecretHash: hashAPIToken(raw),
+		Prefix:     raw[:len(ServiceAccountSecretPrefix)+6],
+		CreatedBy:  createdBy,
+	}
+	if err := db.Create(secret).Error; err != nil {
+		return "", nil, err
+	}
+	return raw, secret, nil
+}
+
+// assignServiceAccountRole moves the Casbin grouping of a service account user
+// from oldRoleID (if any) to newRoleID in the company domain
+func assignServiceAccountRole(userID, companyID uuid.UUID, oldRoleID *uuid.UUID, newRoleID uuid.UUID) {
+	domain := BuildDomainID(&companyID)
+	userSubject := "user:" + userID.String()
+
+	if oldRoleID != nil {
+		if _, err := DeleteRoleForUser(userSubject, "role:"+oldRoleID.String(), domain); err != nil {
+			log.Printf("warning: failed to remove old role of service account user %s: %v", userSubject, err)
+		}
+	}
+	if _, err := AddRoleForUser(userSubject, "role:"+newRoleID.String(), domain); err != nil {
+		log.Printf("warning: failed to assign role to service account user %s: %v", userSubject, err)
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
-	"strings"
+	"log"
+	"math"
 	"time"
 
 	"mimbackend/config"
@@ -19,6 +20,13 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	// ErrRefreshTokenRevoked is returned for tokens whose family was revoked
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+)
+
 // SessionService handles user session operations
 type SessionService struct {
 	db *gorm.DB
@@ -44,7 +52,7 @@ func (s *SessionService) ExtractSecurityInfo(c *gin.Context) *authmodels.Session
 	ua := useragent.Parse(userAgent)
 
 	info := &authmodels.SessionSecurityInfo{
-		IPAddress:   s.getClientIP(c),
+		IPAddress:   c.ClientIP(), // Forwarded headers only count from TRUSTED_PROXIES
 		UserAgent:   userAgent,
 		DeviceType:  s.getDeviceType(ua),
 		OS:          s.getOS(ua),
@@ -61,64 +69,154 @@ func (s *SessionService) ExtractSecurityInfo(c *gin.Context) *authmodels.Session
 	info.Metadata["is_mobile"] = ua.Mobile
 	info.Metadata["is_bot"] = ua.Bot
 
+	// Resolve the location offline from the local GeoIP database, if one is loaded
+	if location, ok := LookupGeoIP(info.IPAddress); ok {
+		info.Location = location.String()
+		info.Country = location.CountryCode
+		if location.HasCoords {
+			info.Latitude = &location.Latitude
+			info.Longitude = &location.Longitude
+		}
+	}
+
 	return info
 }
 
+// initialTokenVersion is the token version of a newly created session
+const initialTokenVersion = 1
+
 // CreateSession creates a new user session with security tracking
 func (s *SessionService) CreateSession(userID uuid.UUID, refreshToken string, securityInfo *authmodels.SessionSecurityInfo, expirationDuration time.Duration) (*authmodels.UserSession, error) {
+	return s.createSession(uuid.New().String(), userID, refreshToken, securityInfo, nil, expirationDuration, nil)
+}
+
+// IssueSessionTokens creates a new user session and the token pair bound to it.
+// The access token carries the session ID and token version, so revoking the
+// session invalidates it immediately. risk is the assessment the login was
+// checked with before its step-ups, nil for logins that were not scored.
+func (s *SessionService) IssueSessionTokens(user *authmodels.User, securityInfo *authmodels.SessionSecurityInfo, risk *LoginRiskAssessment, expirationDuration time.Duration) (accessToken string, refreshToken string, session *authmodels.UserSession, err error) {
+	sessionID := uuid.New().String()
+
+	accessToken, refreshToken, err = GenerateSessionTokens(user.ID, user.Email, user.Role, sessionID, initialTokenVersion)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	session, err = s.createSession(sessionID, user.ID, refreshToken, securityInfo, risk, expirationDuration, nil)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return accessToken, refreshToken, session, nil
+}
+
+func (s *SessionService) createSession(sessionID string, userID uuid.UUID, refreshToken string, securityInfo *authmodels.SessionSecurityInfo, risk *LoginRiskAssessment, expirationDuration time.Duration, oauthClientID *uuid.UUID) (*authmodels.UserSession, error) {
 	session := &authmodels.UserSession{
-		UserID:       userID,
-		SessionID:    uuid.New().String(),
-		IPAddress:    securityInfo.IPAddress,
-		UserAgent:    securityInfo.UserAgent,
-		DeviceType:   securityInfo.DeviceType,
-		OS:           securityInfo.OS,
-		Browser:      securityInfo.Browser,
-		DeviceID:     securityInfo.DeviceID,
-		Location:     securityInfo.Location,
-		IsActive:     true,
-		LoginAt:      time.Now(),
-		LastActivity: time.Now(),
-		ExpiresAt:    time.Now().Add(expirationDuration),
-		LoginMethod:  securityInfo.LoginMethod,
-		RefreshToken: s.hashToken(refreshToken),
-		TokenVersion: 1,
-		TrustScore:   100,
-		IsSuspicious: false,
-	}
-
-	// Check for suspicious activity
-	if err := s.checkSuspiciousActivity(session); err != nil {
-		fmt.Printf("⚠️ Suspicious activity detected: %v\n", err)
-		session.IsSuspicious = true
-		session.TrustScore = 50
-		metadata := make(map[string]interface{})
-		metadata["warning"] = err.Error()
-		if metadataJSON, err := json.Marshal(metadata); err == nil {
-			session.Metadata = datatypes.JSON(metadataJSON)
+		UserID:        userID,
+		SessionID:     sessionID,
+		IPAddress:     securityInfo.IPAddress,
+		UserAgent:     securityInfo.UserAgent,
+		DeviceType:    securityInfo.DeviceType,
+		OS:            securityInfo.OS,
+		Browser:       securityInfo.Browser,
+		DeviceID:      securityInfo.DeviceID,
+		Location:      securityInfo.Location,
+		Country:       securityInfo.Country,
+		Latitude:      securityInfo.Latitude,
+		Longitude:     securityInfo.Longitude,
+		IsActive:      true,
+		LoginAt:       time.Now(),
+		LastActivity:  time.Now(),
+		ExpiresAt:     time.Now().Add(expirationDuration),
+		LoginMethod:   securityInfo.LoginMethod,
+		RefreshToken:  s.hashToken(refreshToken),
+		OAuthClientID: oauthClientID,
+		TokenVersion:  initialTokenVersion,
+		TrustScore:    100,
+		IsSuspicious:  false,
+	}
+
+	metadata := make(map[string]interface{})
+	for k, v := range securityInfo.Metadata {
+		metadata[k] = v
+	}
+
+	// Keep the score the login was checked with for admins. Its step-up
+	// (second factor, email confirmation) was passed before the session got
+	// created, so a risky score alone does not make the session suspicious.
+	if risk != nil {
+		session.TrustScore = risk.TrustScore()
+		metadata["risk"] = risk
+		for _, signal := range risk.Signals {
+			if signal.Name == RiskSignalNewDevice {
+				metadata["new_device"] = true
+			}
 		}
-	} else if securityInfo.Metadata != nil {
-		// Convert map to JSON
-		if metadataJSON, err := json.Marshal(securityInfo.Metadata); err == nil {
-			session.Metadata = datatypes.JSON(metadataJSON)
-			fmt.Printf("📦 Metadata set: %s\n", string(metadataJSON))
+		if risk.Action != RiskActionAllow {
+			log.Printf("Risky login of user %s: score %d, action %s", userID, risk.Score, risk.Action)
+			metadata["warning"] = fmt.Sprintf("login risk score %d", risk.Score)
 		}
 	}
+	if metadataJSON, err := json.Marshal(metadata); err == nil {
+		session.Metadata = datatypes.JSON(metadataJSON)
+	}
 
-	fmt.Printf("💾 Attempting to save session to DB...\n")
-	if err := s.db.Debug().Create(session).Error; err != nil {
-		fmt.Printf("❌ Failed to create session: %v\n", err)
-		return nil, fmt.Errorf("failed to create session: %w", err)
+	// The session and the first refresh token of its family are written
+	// together, so a failed insert leaves no session without a usable token
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+		if err := s.createRefreshToken(tx, userID, session.SessionID, refreshToken, session.ExpiresAt); err != nil {
+			return fmt.Errorf("failed to create refresh token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return nil, err
+	}
+
+	// Third-party app sessions are created from the app's servers, not the user's device
+	if oauthClientID == nil {
+		s.checkImpossibleTravel(session)
 	}
 
 	fmt.Printf("✅ Session saved successfully! ID: %s, SessionID: %s\n", session.ID, session.SessionID)
 	return session, nil
 }
 
-// GetSessionByToken retrieves a session by refresh token
+// GetSessionByToken retrieves a session by refresh token.
+// A token that was already rotated revokes its whole family and returns
+// ErrRefreshTokenReused; a token from a revoked family returns ErrRefreshTokenRevoked.
 func (s *SessionService) GetSessionByToken(refreshToken string) (*authmodels.UserSession, error) {
 	hashedToken := s.hashToken(refreshToken)
 
+	var record authmodels.RefreshToken
+	err := s.db.Where("token_hash = ?", hashedToken).First(&record).Error
+	if err == nil {
+		if record.UsedAt != nil {
+			if revokeErr := s.revokeTokenFamily(record.FamilyID, "refresh_token_reuse"); revokeErr != nil {
+				fmt.Printf("❌ Failed to revoke token family %s: %v\n", record.FamilyID, revokeErr)
+			}
+			return nil, ErrRefreshTokenReused
+		}
+		if record.RevokedAt != nil {
+			return nil, ErrRefreshTokenRevoked
+		}
+
+		var session authmodels.UserSession
+		if err := s.db.Where("session_id = ? AND is_active = ? AND expires_at > ?",
+			record.FamilyID, true, time.Now()).First(&session).Error; err != nil {
+			return nil, err
+		}
+		return &session, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Sessions created before token families existed only know their current token
 	var session authmodels.UserSession
 	if err := s.db.Where("refresh_token = ? AND is_active = ? AND expires_at > ?",
 		hashedToken, true, time.Now()).First(&session).Error; err != nil {
@@ -128,6 +226,119 @@ func (s *SessionService) GetSessionByToken(refreshToken string) (*authmodels.Use
 	return &session, nil
 }
 
+// RefreshSession exchanges a refresh token for a new token pair of the same
+// session. The presented token is rotated within its family, so presenting it
+// again revokes the session (see GetSessionByToken).
+func (s *SessionService) RefreshSession(refreshToken string, expirationDuration time.Duration) (accessToken string, newRefreshToken string, session *authmodels.UserSession, err error) {
+	claims, err := ValidateJWT(refreshToken)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if !claims.IsRefreshToken() {
+		return "", "", nil, ErrRefreshTokenRevoked
+	}
+	// Tokens of third-party apps are refreshed through /oauth/token only
+	if claims.IsDelegated() {
+		return "", "", nil, ErrDelegatedToken
+	}
+
+	session, err = s.GetSessionByToken(refreshToken)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if session.UserID != claims.UserID {
+		return "", "", nil, ErrRefreshTokenRevoked
+	}
+
+	user, err := GetUserByID(session.UserID)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	accessToken, newRefreshToken, err = GenerateSessionTokens(user.ID, user.Email, user.Role, session.SessionID, session.TokenVersion)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if err := s.RotateRefreshToken(session, refreshToken, newRefreshToken, expirationDuration); err != nil {
+		return "", "", nil, err
+	}
+
+	return accessToken, newRefreshToken, session, nil
+}
+
+// RotateRefreshToken marks the presented refresh token as used and stores its
+// successor in the same family. The session keeps its identity; only the token,
+// activity and expiry change.
+func (s *SessionService) RotateRefreshToken(session *authmodels.UserSession, oldToken, newToken string, expirationDuration time.Duration) error {
+	now := time.Now()
+	expiresAt := now.Add(expirationDuration)
+	oldHash := s.hashToken(oldToken)
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var record authmodels.RefreshToken
+		findErr := tx.Where("token_hash = ?", oldHash).First(&record).Error
+		switch {
+		case findErr == nil:
+			// Conditional update so two concurrent refreshes cannot both win
+			result := tx.Model(&authmodels.RefreshToken{}).
+				Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", record.ID).
+				Update("used_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrRefreshTokenReused
+			}
+		case errors.Is(findErr, gorm.ErrRecordNotFound):
+			// Pre-family session: record the old token as used so replaying it is detected too
+			if err := tx.Create(&authmodels.RefreshToken{
+				UserID:    session.UserID,
+				FamilyID:  session.SessionID,
+				TokenHash: oldHash,
+				ExpiresAt: session.ExpiresAt,
+				UsedAt:    &now,
+			}).Error; err != nil {
+				return err
+			}
+		default:
+			return findErr
+		}
+
+		if err := tx.Create(&authmodels.RefreshToken{
+			UserID:    session.UserID,
+			FamilyID:  session.SessionID,
+			TokenHash: s.hashToken(newToken),
+			ExpiresAt: expiresAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&authmodels.UserSession{}).
+			Where("id = ?", session.ID).
+			Updates(map[string]interface{}{
+				"refresh_token": s.hashToken(newToken),
+				"last_activity": now,
+				"expires_at":    expiresAt,
+			}).Error
+	})
+
+	if errors.Is(err, ErrRefreshTokenReused) {
+		if revokeErr := s.revokeTokenFamily(session.SessionID, "refresh_token_reuse"); revokeErr != nil {
+			fmt.Printf("❌ Failed to revoke token family %s: %v\n", session.SessionID, revokeErr)
+		}
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	session.RefreshToken = s.hashToken(newToken)
+	session.LastActivity = now
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
 // UpdateSessionActivity updates the last activity timestamp
 func (s *SessionService) UpdateSessionActivity(sessionID string) error {
 	return s.db.Model(&authmodels.UserSession{}).
@@ -135,29 +346,101 @@ func (s *SessionService) UpdateSessionActivity(sessionID string) error {
 		Update("last_activity", time.Now()).Error
 }
 
-// LogoutSession marks a session as logged out
+// LogoutSession marks a session as logged out and revokes its refresh token family
 func (s *SessionService) LogoutSession(refreshToken string) error {
 	hashedToken := s.hashToken(refreshToken)
 	now := time.Now()
 
-	return s.db.Model(&authmodels.UserSession{}).
-		Where("refresh_token = ?", hashedToken).
+	// Resolve the family from any of its tokens, not only the current one
+	var record authmodels.RefreshToken
+	if err := s.db.Where("token_hash = ?", hashedToken).First(&record).Error; err == nil {
+		if err := s.revokeRefreshTokens(s.db.Where("family_id = ?", record.FamilyID)); err != nil {
+			return err
+		}
+		if err := s.db.Model(&authmodels.UserSession{}).
+			Where("session_id = ?", record.FamilyID).
+			Updates(map[string]interface{}{
+				"is_active": false,
+				"logout_at": now,
+			}).Error; err != nil {
+			return err
+		}
+		invalidateSessionTokenState(record.FamilyID)
+		return nil
+	}
+
+	var session authmodels.UserSession
+	if err := s.db.Where("refresh_token = ?", hashedToken).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := s.db.Model(&authmodels.UserSession{}).
+		Where("id = ?", session.ID).
 		Updates(map[string]interface{}{
 			"is_active": false,
 			"logout_at": now,
-		}).Error
+		}).Error; err != nil {
+		return err
+	}
+	invalidateSessionTokenState(session.SessionID)
+	return nil
 }
 
 // LogoutAllUserSessions logs out all sessions for a user
 func (s *SessionService) LogoutAllUserSessions(userID uuid.UUID) error {
 	now := time.Now()
 
-	return s.db.Model(&authmodels.UserSession{}).
+	if err := s.revokeRefreshTokens(s.db.Where("user_id = ?", userID)); err != nil {
+		return err
+	}
+
+	var sessionIDs []string
+	if err := s.db.Model(&authmodels.UserSession{}).
 		Where("user_id = ? AND is_active = ?", userID, true).
+		Pluck("session_id", &sessionIDs).Error; err != nil {
+		return err
+	}
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+
+	if err := s.db.Model(&authmodels.UserSession{}).
+		Where("session_id IN ?", sessionIDs).
 		Updates(map[string]interface{}{
 			"is_active": false,
 			"logout_at": now,
-		}).Error
+		}).Error; err != nil {
+		return err
+	}
+
+	invalidateSessionTokenState(sessionIDs...)
+	return nil
+}
+
+// BumpTokenVersion invalidates the access tokens of every active session of a
+// user without logging the sessions out. Clients pick up the change (e.g. a new
+// role) on their next refresh.
+func (s *SessionService) BumpTokenVersion(userID uuid.UUID) error {
+	var sessionIDs []string
+	if err := s.db.Model(&authmodels.UserSession{}).
+		Where("user_id = ? AND is_active = ?", userID, true).
+		Pluck("session_id", &sessionIDs).Error; err != nil {
+		return err
+	}
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+
+	if err := s.db.Model(&authmodels.UserSession{}).
+		Where("session_id IN ?", sessionIDs).
+		Update("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+		return err
+	}
+
+	invalidateSessionTokenState(sessionIDs...)
+	return nil
 }
 
 // GetUserActiveSessions retrieves all active sessions for a user
@@ -203,12 +486,21 @@ func (s *SessionService) CleanupExpiredSessions() error {
 func (s *SessionService) RevokeSession(sessionID string, userID uuid.UUID) error {
 	now := time.Now()
 
-	return s.db.Model(&authmodels.UserSession{}).
+	if err := s.revokeRefreshTokens(s.db.Where("family_id = ? AND user_id = ?", sessionID, userID)); err != nil {
+		return err
+	}
+
+	if err := s.db.Model(&authmodels.UserSession{}).
 		Where("session_id = ? AND user_id = ?", sessionID, userID).
 		Updates(map[string]interface{}{
 			"is_active": false,
 			"logout_at": now,
-		}).Error
+		}).Error; err != nil {
+		return err
+	}
+
+	invalidateSessionTokenState(sessionID)
+	return nil
 }
 
 // MarkSessionSuspicious marks a session as suspicious
@@ -223,34 +515,40 @@ func (s *SessionService) MarkSessionSuspicious(sessionID string, reason string)
 
 // Private helper methods
 
-func (s *SessionService) getClientIP(c *gin.Context) string {
-	// Try to get real IP from headers (for proxied requests)
-	ip := c.GetHeader("X-Real-IP")
-	if ip == "" {
-		ip = c.GetHeader("X-Forwarded-For")
-		if ip != "" {
-			// X-Forwarded-For can contain multiple IPs, take the first one
-			ips := strings.Split(ip, ",")
-			ip = strings.TrimSpace(ips[0])
-		}
-	}
+func (s *SessionService) createRefreshToken(db *gorm.DB, userID uuid.UUID, familyID, refreshToken string, expiresAt time.Time) error {
+	return db.Create(&authmodels.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: s.hashToken(refreshToken),
+		ExpiresAt: expiresAt,
+	}).Error
+}
 
-	// Fallback to RemoteAddr
-	if ip == "" {
-		ip = c.ClientIP()
-	}
+// revokeRefreshTokens revokes every not yet revoked token matched by scope
+func (s *SessionService) revokeRefreshTokens(scope *gorm.DB) error {
+	return scope.Model(&authmodels.RefreshToken{}).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", time.Now()).Error
+}
 
-	// Validate and clean IP
-	if parsedIP := net.ParseIP(ip); parsedIP != nil {
-		return parsedIP.String()
+// revokeTokenFamily is the response to refresh token reuse: every token of the
+// family is revoked, the owning session is logged out and flagged as suspicious.
+func (s *SessionService) revokeTokenFamily(familyID, reason string) error {
+	if err := s.revokeRefreshTokens(s.db.Where("family_id = ?", familyID)); err != nil {
+		return err
 	}
 
-	// If still invalid, use RemoteAddr
-	if host, _, err := net.SplitHostPort(c.Request.RemoteAddr); err == nil {
-		return host
+	var session authmodels.UserSession
+	if err := s.db.Where("session_id = ?", familyID).First(&session).Error; err != nil {
+		return err
 	}
+	if err := session.MarkAsLogout(s.db); err != nil {
+		return err
+	}
+	invalidateSessionTokenState(familyID)
 
-	return c.Request.RemoteAddr
+	fmt.Printf("🚨 Refresh token family %s revoked (user %s): %s\n", familyID, session.UserID, reason)
+	return session.MarkAsSuspicious(s.db, reason)
 }
 
 func (s *SessionService) getDeviceType(ua useragent.UserAgent) string {
@@ -288,7 +586,7 @@ func (s *SessionService) getBrowser(ua useragent.UserAgent) string {
 func (s *SessionService) generateDeviceFingerprint(c *gin.Context, ua useragent.UserAgent) string {
 	// Create a unique fingerprint based on multiple factors
 	fingerprint := fmt.Sprintf("%s|%s|%s|%s|%s",
-		s.getClientIP(c),
+		c.ClientIP(),
 		ua.OS,
 		ua.Name,
 		c.GetHeader("Accept-Language"),
@@ -305,41 +603,40 @@ func (s *SessionService) hashToken(token string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func (s *SessionService) checkSuspiciousActivity(session *authmodels.UserSession) error {
-	// Check 1: Multiple rapid logins from different IPs
-	var recentSessions []authmodels.UserSession
-	if err := s.db.Where("user_id = ? AND created_at > ?",
-		session.UserID, time.Now().Add(-5*time.Minute)).
-		Find(&recentSessions).Error; err != nil {
-		return nil // Don't fail on check error
+// checkImpossibleTravel compares the new session's GeoIP coordinates with the
+// user's previous located session and marks it suspicious when nobody could
+// have covered the distance in the time between the two logins
+func (s *SessionService) checkImpossibleTravel(session *authmodels.UserSession) {
+	if session.Latitude == nil || session.Longitude == nil {
+		return
 	}
 
-	if len(recentSessions) > 3 {
-		return fmt.Errorf("multiple rapid login attempts detected")
+	var previous authmodels.UserSession
+	err := s.db.Where("user_id = ? AND id <> ? AND latitude IS NOT NULL AND longitude IS NOT NULL", session.UserID, session.ID).
+		Where("impersonator_id IS NULL AND oauth_client_id IS NULL").
+		Order("login_at DESC").
+		First(&previous).Error
+	if err != nil {
+		return // First located session or lookup error - nothing to compare
 	}
 
-	// Check 2: Login from new device/location
-	var previousSessions []authmodels.UserSession
-	if err := s.db.Where("user_id = ? AND device_id = ?",
-		session.UserID, session.DeviceID).
-		Limit(1).
-		Find(&previousSessions).Error; err != nil {
-		return nil
+	distance := geoDistanceKm(*previous.Latitude, *previous.Longitude, *session.Latitude, *session.Longitude)
+	if distance < geoIPMinTravelKm() {
+		return // GeoIP coordinates are approximate, nearby cities are never suspicious
 	}
 
-	if len(previousSessions) == 0 {
-		// First time from this device - not necessarily suspicious but worth noting
-		metadata := make(map[string]interface{})
-		metadata["new_device"] = true
-		if metadataJSON, err := json.Marshal(metadata); err == nil {
-			session.Metadata = datatypes.JSON(metadataJSON)
-		}
+	elapsed := session.LoginAt.Sub(previous.LoginAt)
+	speed := distance / math.Max(elapsed.Hours(), 1.0/60)
+	if speed <= float64(geoIPMaxTravelKmh()) {
+		return
 	}
 
-	// Check 3: Login from drastically different IP location (if we had geolocation)
-	// This would require a geolocation service integration
-
-	return nil
+	reason := fmt.Sprintf("impossible travel: %.0f km from %s in %s (%.0f km/h)",
+		distance, previous.Location, elapsed.Round(time.Minute), speed)
+	log.Printf("session %s of user %s: %s", session.SessionID, session.UserID, reason)
+	if err := session.MarkAsSuspicious(s.db, reason); err != nil {
+		log.Printf("failed to mark session %s suspicious: %v", session.SessionID, err)
+	}
 }
 
 // GetSessionStats returns statistics about user sessions
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"mimbackend/config"
+	"mimbackend/internal/cache"
+	authmodels "mimbackend/internal/models/auth"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrSessionRevoked is returned for access tokens whose session was logged out,
+// revoked, or moved to a newer token version (password reset, role change)
+var ErrSessionRevoked = errors.New("session revoked")
+
+// sessionStateCacheTTL bounds how long a cached session state is trusted.
+// Every revocation path invalidates the entry, so the TTL only limits staleness
+// for changes made outside this service.
+const sessionStateCacheTTL = 5 * time.Minute
+
+// sessionTokenState is the part of a user session needed to validate an access token
+type sessionTokenState struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Active    bool      `json:"active"`
+	Version   int       `json:"version"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ErrNotAccessToken is returned when a refresh token is presented as a bearer token
+var ErrNotAccessToken = errors.New("token is not an access token")
+
+// ValidateAccessToken validates an access token and checks that the session it
+// was issued for is still active and on the same token version. Refresh tokens
+// are rejected.
+func ValidateAccessToken(tokenString string) (*Claims, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+	return validateAccessToken(db, tokenString)
+}
+
+func validateAccessToken(db *gorm.DB, tokenString string) (*Claims, error) {
+	claims, err := ValidateJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.IsRefreshToken() {
+		return nil, ErrNotAccessToken
+	}
+
+	if err := checkSessionTokenVersion(db, claims); err != nil {
+		log.Printf("ValidateAccessToken: rejected token for session %s: %v", claims.SessionID, err)
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func checkSessionTokenVersion(db *gorm.DB, claims *Claims) error {
+	// Every token is bound to a session; one without sid cannot be revoked
+	if claims.SessionID == "" {
+		return ErrSessionRevoked
+	}
+
+	state, err := loadSessionTokenState(db, claims.SessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+
+	if !state.Active || time.Now().After(state.ExpiresAt) ||
+		state.UserID != claims.UserID || state.Version != claims.TokenVersion {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+// loadSessionTokenState reads the session state from Redis, falling back to user_sessions
+func loadSessionTokenState(db *gorm.DB, sessionID string) (*sessionTokenState, error) {
+	ctx := context.Background()
+
+	if data, err := cache.GetSessionStateCache(ctx, sessionID); err == nil && len(data) > 0 {
+		var state sessionTokenState
+		if err := json.Unmarshal(data, &state); err == nil {
+			return &state, nil
+		}
+	}
+
+	var session authmodels.UserSession
+	if err := db.Select("user_id", "is_active", "token_version", "expires_at").
+		Where("session_id = ?", sessionID).
+		First(&session).Error; err != nil {
+		return nil, err
+	}
+
+	state := &sessionTokenState{
+		UserID:    session.UserID,
+		Active:    session.IsActive,
+		Version:   session.TokenVersion,
+		ExpiresAt: session.ExpiresAt,
+	}
+	if data, err := json.Marshal(state); err == nil {
+		_ = cache.SetSessionStateCache(ctx, sessionID, data, sessionStateCacheTTL)
+	}
+
+	return state, nil
+}
+
+// invalidateSessionTokenState drops cached session state so the next request
+// re-reads it from the database. Call it after the database change is committed.
+func invalidateSessionTokenState(sessionIDs ...string) {
+	if err := cache.InvalidateSessionStateCache(context.Background(), sessionIDs...); err != nil {
+		log.Printf("invalidateSessionTokenState: failed to invalidate %d sessions: %v", len(sessionIDs), err)
+	}
+}
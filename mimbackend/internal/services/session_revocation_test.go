@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	authmodels "mimbackend/internal/models/auth"
+)
+
+// issueTestSession starts a session for a new user and returns its token pair
+func issueTestSession(t *testing.T, s *SessionService, email string) (*authmodels.User, *authmodels.UserSession, string, string) {
+	t.Helper()
+
+	user := createTestUser(t, s.db, email)
+	access, refresh, session, err := s.IssueSessionTokens(user, testSecurityInfo(), nil, testSessionTTL)
+	if err != nil {
+		t.Fatalf("IssueSessionTokens: %v", err)
+	}
+	return user, session, access, refresh
+}
+
+func TestValidateAccessToken(t *testing.T) {
+	s := newTestSessionService(t)
+	user, session, access, refresh := issueTestSession(t, s, "valid@example.com")
+
+	claims, err := validateAccessToken(s.db, access)
+	if err != nil {
+		t.Fatalf("validateAccessToken: %v", err)
+	}
+	if claims.UserID != user.ID || claims.SessionID != session.SessionID || claims.TokenVersion != initialTokenVersion {
+		t.Fatalf("claims = %+v, want user %s, session %s, version %d", claims, user.ID, session.SessionID, initialTokenVersion)
+	}
+
+	if _, err := validateAccessToken(s.db, refresh); !errors.Is(err, ErrNotAccessToken) {
+		t.Fatalf("validateAccessToken(refresh token) = %v, want ErrNotAccessToken", err)
+	}
+}
+
+func TestValidateAccessTokenRevokedSession(t *testing.T) {
+	s := newTestSessionService(t)
+	user, session, access, refresh := issueTestSession(t, s, "revoked@example.com")
+
+	if err := s.RevokeSession(session.SessionID, user.ID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if _, err := validateAccessToken(s.db, access); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("validateAccessToken after RevokeSession = %v, want ErrSessionRevoked", err)
+	}
+	if _, err := s.GetSessionByToken(refresh); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Fatalf("GetSessionByToken after RevokeSession = %v, want ErrRefreshTokenRevoked", err)
+	}
+}
+
+func TestValidateAccessTokenLoggedOut(t *testing.T) {
+	s := newTestSessionService(t)
+	_, _, access, refresh := issueTestSession(t, s, "logout@example.com")
+
+	if err := s.LogoutSession(refresh); err != nil {
+		t.Fatalf("LogoutSession: %v", err)
+	}
+	if _, err := validateAccessToken(s.db, access); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("validateAccessToken after logout = %v, want ErrSessionRevoked", err)
+	}
+}
+
+func TestValidateAccessTokenBumpedVersion(t *testing.T) {
+	s := newTestSessionService(t)
+	user, session, access, _ := issueTestSession(t, s, "bump@example.com")
+
+	if err := s.BumpTokenVersion(user.ID); err != nil {
+		t.Fatalf("BumpTokenVersion: %v", err)
+	}
+	if _, err := validateAccessToken(s.db, access); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("validateAccessToken on the old version = %v, want ErrSessionRevoked", err)
+	}
+	if !reloadSession(t, s, session.SessionID).IsActive {
+		t.Fatal("BumpTokenVersion logged the session out")
+	}
+
+	// Tokens minted on refresh carry the new version and are accepted
+	renewed, _, err := GenerateSessionTokens(user.ID, user.Email, user.Role, session.SessionID, initialTokenVersion+1)
+	if err != nil {
+		t.Fatalf("GenerateSessionTokens: %v", err)
+	}
+	if _, err := validateAccessToken(s.db, renewed); err != nil {
+		t.Fatalf("validateAccessToken on the new version = %v", err)
+	}
+}
+
+func TestValidateAccessTokenSessionBinding(t *testing.T) {
+	s := newTestSessionService(t)
+	_, session, _, _ := issueTestSession(t, s, "owner@example.com")
+	other := createTestUser(t, s.db, "other@example.com")
+
+	// Without sid the token could never be revoked
+	unbound, _, err := GenerateSessionTokens(other.ID, other.Email, other.Role, "", initialTokenVersion)
+	if err != nil {
+		t.Fatalf("GenerateSessionTokens: %v", err)
+	}
+	if _, err := validateAccessToken(s.db, unbound); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("validateAccessToken without sid = %v, want ErrSessionRevoked", err)
+	}
+
+	// A session ID only validates tokens of the session's own user
+	foreign, _, err := GenerateSessionTokens(other.ID, other.Email, other.Role, session.SessionID, initialTokenVersion)
+	if err != nil {
+		t.Fatalf("GenerateSessionTokens: %v", err)
+	}
+	if _, err := validateAccessToken(s.db, foreign); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("validateAccessToken with another user's sid = %v, want ErrSessionRevoked", err)
+	}
+
+	unknown, _, err := GenerateSessionTokens(other.ID, other.Email, other.Role, "no-such-session", initialTokenVersion)
+	if err != nil {
+		t.Fatalf("GenerateSessionTokens: %v", err)
+	}
+	if _, err := validateAccessToken(s.db, unknown); !errors.Is(err, ErrSessionRevoked) {
+		t.Fatalf("validateAccessToken with an unknown sid = %v, want ErrSessionRevoked", err)
+	}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	authmodels "mimbackend/internal/models/auth"
+)
+
+const testSessionTTL = 24 * time.Hour
+
+func newTestSessionService(t *testing.T) *SessionService {
+	t.Helper()
+	return &SessionService{db: newTestDB(t, &authmodels.User{}, &authmodels.UserSession{}, &authmodels.RefreshToken{})}
+}
+
+func testSecurityInfo() *authmodels.SessionSecurityInfo {
+	return &authmodels.SessionSecurityInfo{IPAddress: "203.0.113.7", UserAgent: "test", LoginMethod: "password"}
+}
+
+// reloadSession reads the session back from the database
+func reloadSession(t *testing.T, s *SessionService, sessionID string) *authmodels.UserSession {
+	t.Helper()
+
+	var session authmodels.UserSession
+	if err := s.db.Where("session_id = ?", sessionID).First(&session).Error; err != nil {
+		t.Fatalf("load session %s: %v", sessionID, err)
+	}
+	return &session
+}
+
+func TestIssueSessionTokensRollsBackWithoutRefreshToken(t *testing.T) {
+	s := newTestSessionService(t)
+	user := createTestUser(t, s.db, "rollback@example.com")
+
+	// The refresh token insert fails after the session row was written
+	if err := s.db.Migrator().DropTable(&authmodels.RefreshToken{}); err != nil {
+		t.Fatalf("drop refresh_tokens: %v", err)
+	}
+	if _, _, _, err := s.IssueSessionTokens(user, testSecurityInfo(), nil, testSessionTTL); err == nil {
+		t.Fatal("IssueSessionTokens succeeded without a refresh_tokens table")
+	}
+
+	var sessions int64
+	if err := s.db.Model(&authmodels.UserSession{}).Where("user_id = ?", user.ID).Count(&sessions).Error; err != nil {
+		t.Fatalf("count sessions: %v", err)
+	}
+	if sessions != 0 {
+		t.Fatalf("%d sessions left without a refresh token family, want 0", sessions)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestSessionService(t)
+	user := createTestUser(t, s.db, "rotate@example.com")
+
+	session, err := s.CreateSession(user.ID, "refresh-1", testSecurityInfo(), testSessionTTL)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	found, err := s.GetSessionByToken("refresh-1")
+	if err != nil {
+		t.Fatalf("GetSessionByToken: %v", err)
+	}
+	if err := s.RotateRefreshToken(found, "refresh-1", "refresh-2", testSessionTTL); err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+
+	found, err = s.GetSessionByToken("refresh-2")
+	if err != nil {
+		t.Fatalf("GetSessionByToken after rotation: %v", err)
+	}
+	if found.SessionID != session.SessionID {
+		t.Fatalf("rotated token belongs to session %s, want %s", found.SessionID, session.SessionID)
+	}
+
+	// Both tokens stay in the family; only the successor is unused
+	var family []authmodels.RefreshToken
+	if err := s.db.Where("family_id = ?", session.SessionID).Order("created_at").Find(&family).Error; err != nil {
+		t.Fatalf("load family: %v", err)
+	}
+	if len(family) != 2 || family[0].UsedAt == nil || family[1].UsedAt != nil {
+		t.Fatalf("family = %+v, want a used token followed by its unused successor", family)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	s := newTestSessionService(t)
+	user := createTestUser(t, s.db, "reuse@example.com")
+
+	session, err := s.CreateSession(user.ID, "refresh-1", testSecurityInfo(), testSessionTTL)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	other, err := s.CreateSession(user.ID, "other-1", testSecurityInfo(), testSessionTTL)
+	if err != nil {
+		t.Fatalf("CreateSession (other): %v", err)
+	}
+	if err := s.RotateRefreshToken(session, "refresh-1", "refresh-2", testSessionTTL); err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+
+	// A copied token is replayed after the legitimate client rotated it
+	if _, err := s.GetSessionByToken("refresh-1"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("GetSessionByToken(rotated) = %v, want ErrRefreshTokenReused", err)
+	}
+
+	// The successor held by the legitimate client dies with the family
+	if _, err := s.GetSessionByToken("refresh-2"); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Fatalf("GetSessionByToken(successor) = %v, want ErrRefreshTokenRevoked", err)
+	}
+	var live int64
+	if err := s.db.Model(&authmodels.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", session.SessionID).
+		Count(&live).Error; err != nil {
+		t.Fatalf("count tokens: %v", err)
+	}
+	if live != 0 {
+		t.Fatalf("%d tokens of the reused family are not revoked", live)
+	}
+
+	revoked := reloadSession(t, s, session.SessionID)
+	if revoked.IsActive || revoked.LogoutAt == nil {
+		t.Fatalf("session active = %v, logout_at = %v; want logged out", revoked.IsActive, revoked.LogoutAt)
+	}
+	if !revoked.IsSuspicious {
+		t.Fatal("session of the reused family is not marked suspicious")
+	}
+
+	// Other sessions of the user are left alone
+	if _, err := s.GetSessionByToken("other-1"); err != nil {
+		t.Fatalf("GetSessionByToken(other session) = %v", err)
+	}
+	if untouched := reloadSession(t, s, other.SessionID); !untouched.IsActive || untouched.IsSuspicious {
+		t.Fatalf("other session active = %v, suspicious = %v", untouched.IsActive, untouched.IsSuspicious)
+	}
+}
+
+func TestRotateRefreshTokenTwiceRevokesFamily(t *testing.T) {
+	s := newTestSessionService(t)
+	user := createTestUser(t, s.db, "race@example.com")
+
+	session, err := s.CreateSession(user.ID, "refresh-1", testSecurityInfo(), testSessionTTL)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := s.RotateRefreshToken(session, "refresh-1", "refresh-2", testSessionTTL); err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+
+	// A second refresh with the same token, e.g. one that passed the lookup concurrently
+	if err := s.RotateRefreshToken(session, "refresh-1", "refresh-3", testSessionTTL); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("second RotateRefreshToken = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := s.GetSessionByToken("refresh-2"); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Fatalf("GetSessionByToken(successor) = %v, want ErrRefreshTokenRevoked", err)
+	}
+	if reloadSession(t, s, session.SessionID).IsActive {
+		t.Fatal("session is still active after the token was rotated twice")
+	}
+}
+
+func TestRefreshTokenPreFamilySession(t *testing.T) {
+	s := newTestSessionService(t)
+	user := createTestUser(t, s.db, "legacy@example.com")
+
+	// Sessions from before token families only carry the hash of their current token
+	legacy := &authmodels.UserSession{
+		UserID:       user.ID,
+		IPAddress:    "203.0.113.7",
+		IsActive:     true,
+		ExpiresAt:    time.Now().Add(testSessionTTL),
+		RefreshToken: s.hashToken("legacy-1"),
+		TokenVersion: initialTokenVersion,
+	}
+	if err := s.db.Create(legacy).Error; err != nil {
+		t.Fatalf("create legacy session: %v", err)
+	}
+
+	found, err := s.GetSessionByToken("legacy-1")
+	if err != nil {
+		t.Fatalf("GetSessionByToken(legacy) = %v", err)
+	}
+	if err := s.RotateRefreshToken(found, "legacy-1", "legacy-2", testSessionTTL); err != nil {
+		t.Fatalf("RotateRefreshToken(legacy): %v", err)
+	}
+
+	// The legacy token was recorded as used, so its replay is detected as well
+	if _, err := s.GetSessionByToken("legacy-1"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("GetSessionByToken(replayed legacy) = %v, want ErrRefreshTokenReused", err)
+	}
+	if reloadSession(t, s, legacy.SessionID).IsActive {
+		t.Fatal("legacy session is still active after its token was replayed")
+	}
+}
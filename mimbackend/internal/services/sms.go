@@ -0,0 +1,87 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMSProvider sends text messages. Real gateways (Netgsm, İleti Merkezi,
+// Twilio, ...) implement this interface and register themselves with
+// RegisterSMSProvider.
+type SMSProvider interface {
+	Send(to, message string) error
+}
+
+var (
+	smsProvidersMu sync.RWMutex
+	smsProviders   = map[string]func() (SMSProvider, error){
+		"console": func() (SMSProvider, error) { return &ConsoleSMSProvider{}, nil },
+		"file": func() (SMSProvider, error) {
+			path := os.Getenv("SMS_FILE_PATH")
+			if path == "" {
+				path = "sms.log"
+			}
+			return &FileSMSProvider{Path: path}, nil
+		},
+	}
+)
+
+// RegisterSMSProvider makes a provider selectable with SMS_PROVIDER=<name>
+func RegisterSMSProvider(name string, factory func() (SMSProvider, error)) {
+	smsProvidersMu.Lock()
+	defer smsProvidersMu.Unlock()
+	smsProviders[strings.ToLower(name)] = factory
+}
+
+// NewSMSProvider returns the provider selected by SMS_PROVIDER. Without a
+// setting the console provider is used in development; other environments
+// must configure a provider explicitly.
+func NewSMSProvider() (SMSProvider, error) {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("SMS_PROVIDER")))
+	if name == "" {
+		if os.Getenv("ENV") != "development" {
+			return nil, fmt.Errorf("sms provider not configured")
+		}
+		name = "console"
+	}
+
+	smsProvidersMu.RLock()
+	factory, ok := smsProviders[name]
+	smsProvidersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sms provider: %s", name)
+	}
+	return factory()
+}
+
+// ConsoleSMSProvider prints messages to stdout (development)
+type ConsoleSMSProvider struct{}
+
+func (p *ConsoleSMSProvider) Send(to, message string) error {
+	fmt.Printf("[DEV] SMS to %s: %s\n", to, message)
+	return nil
+}
+
+// FileSMSProvider appends messages to a file, one line per message (development and tests)
+type FileSMSProvider struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (p *FileSMSProvider) Send(to, message string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := os.OpenFile(p.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open sms file: %w", err)
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), to, message)
+	return err
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"mimbackend/config"
+	auth "mimbackend/internal/models/auth"
+
+	"gorm.io/gorm"
+)
+
+// encryptedTokenPrefix marks values written by sealToken. Values without it
+// were stored before encryption was enabled and are returned unchanged.
+const encryptedTokenPrefix = "enc:v1:"
+
+var (
+	ErrTokenEncryptionKeyUnknown = errors.New("token encrypted with an unknown key")
+	ErrTokenCiphertextInvalid    = errors.New("invalid encrypted token")
+)
+
+// tokenKeyRing holds the key encryption keys. New values are sealed with the
+// active key; the others are kept so older values can still be opened.
+type tokenKeyRing struct {
+	activeKid string
+	keys      map[string]cipher.AEAD
+}
+
+var (
+	tokenKeys     *tokenKeyRing
+	tokenKeysErr  error
+	tokenKeysOnce sync.Once
+)
+
+// InitTokenEncryption loads the keys used to encrypt provider tokens at rest.
+//
+// TOKEN_ENCRYPTION_KEYS lists base64 encoded 32 byte AES keys as "kid=key"
+// pairs separated by commas; TOKEN_ENCRYPTION_ACTIVE_KID selects the key for
+// new values and defaults to the first one. To rotate, add a new key, make it
+// active and keep the old one until ReencryptAccountTokens has run.
+//
+// Without keys tokens are stored in plaintext, which is refused when ENV is
+// production.
+func InitTokenEncryption() error {
+	tokenKeysOnce.Do(func() {
+		tokenKeys, tokenKeysErr = loadTokenKeys()
+		if tokenKeysErr != nil {
+			return
+		}
+		if tokenKeys == nil {
+			if os.Getenv("ENV") == "production" {
+				tokenKeysErr = errors.New("TOKEN_ENCRYPTION_KEYS is required in production")
+				return
+			}
+			log.Printf("⚠️  TOKEN_ENCRYPTION_KEYS is not set — provider tokens are stored unencrypted")
+			return
+		}
+		log.Printf("Token encryption keys loaded: active kid=%q keys=%d", tokenKeys.activeKid, len(tokenKeys.keys))
+	})
+	return tokenKeysErr
+}
+
+func loadTokenKeys() (*tokenKeyRing, error) {
+	spec := strings.TrimSpace(os.Getenv("TOKEN_ENCRYPTION_KEYS"))
+	if spec == "" {
+		return nil, nil
+	}
+
+	ring := &tokenKeyRing{keys: map[string]cipher.AEAD{}}
+	var first string
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, encoded, ok := strings.Cut(entry, "=")
+		kid = strings.TrimSpace(kid)
+		if !ok || kid == "" || strings.Contains(kid, ":") {
+			return nil, fmt.Errorf("invalid TOKEN_ENCRYPTION_KEYS entry %q, expected kid=base64key", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("token encryption key %q must be 32 bytes of base64", kid)
+		}
+		aead, err := newTokenAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := ring.keys[kid]; dup {
+			return nil, fmt.Errorf("duplicate token encryption key %q", kid)
+		}
+		ring.keys[kid] = aead
+		if first == "" {
+			first = kid
+		}
+	}
+	if first == "" {
+		return nil, nil
+	}
+
+	ring.activeKid = first
+	if active := strings.TrimSpace(os.Getenv("TOKEN_ENCRYPTION_ACTIVE_KID")); active != "" {
+		if _, ok := ring.keys[active]; !ok {
+			return nil, fmt.Errorf("TOKEN_ENCRYPTION_ACTIVE_KID %q is not in TOKEN_ENCRYPTION_KEYS", active)
+		}
+		ring.activeKid = active
+	}
+	return ring, nil
+}
+
+func getTokenKeys() (*tokenKeyRing, error) {
+	if err := InitTokenEncryption(); err != nil {
+		return nil, err
+	}
+	return tokenKeys, nil
+}
+
+func newTokenAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealToken encrypts a token with a fresh data key and wraps that key with the
+// active key encryption key:
+//
+//	enc:v1:<kid>:<base64 wrapped data key>:<base64 nonce+ciphertext>
+//
+// Rotation only needs to rewrap the data key, see ReencryptAccountTokens.
+func sealToken(plaintext string) (string, error) {
+	ring, err := getTokenKeys()
+	if err != nil {
+		return "", err
+	}
+	// Values that are already sealed (e.g. a kept refresh token) are left alone
+	if ring == nil || plaintext == "" || strings.HasPrefix(plaintext, encryptedTokenPrefix) {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := aeadSeal(ring.keys[ring.activeKid], dataKey, []byte(ring.activeKid))
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newTokenAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := aeadSeal(dataAEAD, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedTokenPrefix + ring.activeKid + ":" +
+		base64.RawURLEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// openToken decrypts a value written by sealToken; plaintext values from
+// before encryption was enabled are returned as they are
+func openToken(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedTokenPrefix) {
+		return value, nil
+	}
+	_, dataKey, sealed, err := unwrapTokenKey(value)
+	if err != nil {
+		return "", err
+	}
+
+	dataAEAD, err := newTokenAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := aeadOpen(dataAEAD, sealed, nil)
+	if err != nil {
+		return "", ErrTokenCiphertextInvalid
+	}
+	return string(plaintext), nil
+}
+
+// rewrapToken returns value sealed under the active key. Encrypted values only
+// get their data key rewrapped; plaintext values are encrypted. changed is
+// false when nothing had to be done.
+func rewrapToken(value string) (result string, changed bool, err error) {
+	ring, err := getTokenKeys()
+	if err != nil || ring == nil || value == "" {
+		return value, false, err
+	}
+	if !strings.HasPrefix(value, encryptedTokenPrefix) {
+		sealed, err := sealToken(value)
+		return sealed, err == nil, err
+	}
+
+	kid, dataKey, sealed, err := unwrapTokenKey(value)
+	if err != nil {
+		return value, false, err
+	}
+	if kid == ring.activeKid {
+		return value, false, nil
+	}
+	wrapped, err := aeadSeal(ring.keys[ring.activeKid], dataKey, []byte(ring.activeKid))
+	if err != nil {
+		return value, false, err
+	}
+	return encryptedTokenPrefix + ring.activeKid + ":" +
+		base64.RawURLEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawURLEncoding.EncodeToString(sealed), true, nil
+}
+
+// unwrapTokenKey parses a sealed value and decrypts its data key
+func unwrapTokenKey(value string) (kid string, dataKey, sealed []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, encryptedTokenPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrTokenCiphertextInvalid
+	}
+	kid = parts[0]
+
+	ring, err := getTokenKeys()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if ring == nil {
+		return "", nil, nil, ErrTokenEncryptionKeyUnknown
+	}
+	kek, ok := ring.keys[kid]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("%w: %s", ErrTokenEncryptionKeyUnknown, kid)
+	}
+
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, ErrTokenCiphertextInvalid
+	}
+	sealed, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrTokenCiphertextInvalid
+	}
+	// The kid is authenticated as additional data so a wrapped key cannot be relabelled
+	dataKey, err = aeadOpen(kek, wrapped, []byte(kid))
+	if err != nil || len(dataKey) != 32 {
+		return "", nil, nil, ErrTokenCiphertextInvalid
+	}
+	return kid, dataKey, sealed, nil
+}
+
+// aeadSeal returns nonce || ciphertext
+func aeadSeal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func aeadOpen(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrTokenCiphertextInvalid
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// sealAccountTokens encrypts the provider tokens of an account before it is saved
+func sealAccountTokens(account *auth.Account) error {
+	var err error
+	if account.AccessToken, err = sealToken(account.AccessToken); err != nil {
+		return err
+	}
+	account.RefreshToken, err = sealToken(account.RefreshToken)
+	return err
+}
+
+// openAccountTokens decrypts the provider tokens of a loaded account
+func openAccountTokens(account *auth.Account) error {
+	var err error
+	if account.AccessToken, err = openToken(account.AccessToken); err != nil {
+		return err
+	}
+	account.RefreshToken, err = openToken(account.RefreshToken)
+	return err
+}
+
+// ReencryptAccountTokens encrypts tokens stored before encryption was enabled
+// and rewraps the data keys of tokens sealed with a retired key. It is safe to
+// run repeatedly and returns the number of updated accounts.
+func ReencryptAccountTokens() (int, error) {
+	ring, err := getTokenKeys()
+	if err != nil || ring == nil {
+		return 0, err
+	}
+
+	db, err := config.NewConnection()
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	var accounts []auth.Account
+	result := db.Select("id", "access_token", "refresh_token").
+		Where("access_token <> '' OR refresh_token <> ''").
+		FindInBatches(&accounts, 200, func(tx *gorm.DB, _ int) error {
+			for i := range accounts {
+				accessToken, accessChanged, err := rewrapToken(accounts[i].AccessToken)
+				if err != nil {
+					log.Printf("ReencryptAccountTokens: account %s: %v", accounts[i].ID, err)
+					continue
+				}
+				refreshToken, refreshChanged, err := rewrapToken(accounts[i].RefreshToken)
+				if err != nil {
+					log.Printf("ReencryptAccountTokens: account %s: %v", accounts[i].ID, err)
+					continue
+				}
+				if !accessChanged && !refreshChanged {
+					continue
+				}
+				if err := db.Model(&auth.Account{}).Where("id = ?", accounts[i].ID).
+					Updates(map[string]interface{}{"access_token": accessToken, "refresh_token": refreshToken}).Error; err != nil {
+					return err
+				}
+				updated++
+			}
+			return nil
+		})
+	return updated, result.Error
+}
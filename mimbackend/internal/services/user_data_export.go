@@ -0,0 +1,227 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"mimbackend/config"
+	auth "mimbackend/internal/models/auth"
+	companymodels "mimbackend/internal/models/company"
+
+	"github.com/google/uuid"
+)
+
+// UserDataExportTTL is how long the download link of a personal data export works
+const UserDataExportTTL = 24 * time.Hour
+
+// StartUserDataExport builds the personal data archive of a user, stores it
+// in the export store and emails the download link to the user's address.
+// Exports of users carry no company ID.
+func StartUserDataExport(userID uuid.UUID) (string, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	archive, err := BuildUserDataExport(user)
+	if err != nil {
+		return "", err
+	}
+
+	token := SaveExport(ExportRecord{
+		UserID:    userID,
+		Filename:  fmt.Sprintf("mimreklam-data-%s-%s.zip", userID.String(), time.Now().Format("20060102")),
+		Data:      archive,
+		ExpiresAt: time.Now().Add(UserDataExportTTL),
+	})
+
+	emailSvc := NewEmailService()
+	link := fmt.Sprintf("%s/settings/privacy/export/download?token=%s", emailSvc.frontendURL, token)
+	subject := "Kişisel veri dışa aktarımınız hazır - MimReklam"
+	bodyHTML := fmt.Sprintf("<p>Merhaba,</p><p>Hesabınıza ait kişisel verilerin dışa aktarımı hazır. Bağlantı %d saat geçerlidir ve yalnızca hesabınızla giriş yapıldığında çalışır: <a href=\"%s\">İndir</a></p>", int(UserDataExportTTL.Hours()), link)
+	if err := emailSvc.sendEmail(user.Email, subject, bodyHTML); err != nil {
+		log.Printf("StartUserDataExport: failed to email %s: %v", user.Email, err)
+	}
+
+	return token, nil
+}
+
+// BuildUserDataExport assembles everything tied to the user into a zip of
+// JSON files: profile, linked accounts, session history, failed logins,
+// company memberships, invitations, permissions and security settings.
+// Secrets (password hash, tokens, key material) are left out.
+func BuildUserDataExport(user *auth.User) ([]byte, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]interface{}{}
+
+	profile := map[string]interface{}{
+		"id":                user.ID,
+		"email":             user.Email,
+		"phone":             user.Phone,
+		"phone_verified":    user.PhoneVerified,
+		"full_name":         user.FullName,
+		"image_url":         user.ImageURL,
+		"is_verified":       user.IsVerified,
+		"role":              user.Role,
+		"active_company_id": user.ActiveCompanyID,
+		"has_password":      user.PasswordHash != "",
+		"created_at":        user.CreatedAt,
+		"updated_at":        user.UpdatedAt,
+		"exported_at":       time.Now(),
+	}
+	if request, err := GetAccountDeletion(user.ID); err == nil {
+		profile["account_deletion"] = request
+	}
+	files["profile.json"] = profile
+
+	var accounts []auth.Account
+	if err := db.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	accountRows := make([]map[string]interface{}, 0, len(accounts))
+	for _, account := range accounts {
+		accountRows = append(accountRows, map[string]interface{}{
+			"id":          account.ID,
+			"provider":    account.Provider,
+			"provider_id": account.ProviderID,
+			"created_at":  account.CreatedAt,
+			"updated_at":  account.UpdatedAt,
+		})
+	}
+	files["accounts.json"] = accountRows
+
+	var sessions []auth.UserSession
+	if err := db.Unscoped().Where("user_id = ?", user.ID).Order("login_at DESC").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	files["sessions.json"] = sessions
+
+	var attempts []auth.LoginAttempt
+	if err := db.Where("user_id = ? OR email = ?", user.ID, user.Email).Order("created_at DESC").Find(&attempts).Error; err != nil {
+		return nil, err
+	}
+	files["login_attempts.json"] = attempts
+
+	var members []companymodels.CompanyMember
+	if err := db.Where("user_id = ?", user.ID).Preload("Company").Preload("Role").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	memberships := make([]map[string]interface{}, 0, len(members))
+	for _, member := range members {
+		row := map[string]interface{}{
+			"company_id": member.CompanyID,
+			"is_owner":   member.IsOwner,
+			"is_active":  member.IsActive,
+			"status":     member.Status,
+			"joined_at":  member.JoinedAt,
+		}
+		if member.Company != nil {
+			row["company_name"] = member.Company.Name
+		}
+		if member.Role != nil {
+			row["role"] = member.Role.Name
+		}
+		memberships = append(memberships, row)
+	}
+	files["memberships.json"] = memberships
+
+	var received []companymodels.CompanyInvitation
+	if err := db.Where("email = ?", user.Email).Order("created_at DESC").Find(&received).Error; err != nil {
+		return nil, err
+	}
+	var sent []companymodels.CompanyInvitation
+	if err := db.Where("invited_by = ?", user.ID).Order("created_at DESC").Find(&sent).Error; err != nil {
+		return nil, err
+	}
+	files["invitations.json"] = map[string]interface{}{
+		"received": invitationExportRows(received),
+		"sent":     invitationExportRows(sent),
+	}
+
+	var permissions []auth.UserPermission
+	if err := db.Where("user_id = ?", user.ID).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	roles := map[string][]string{}
+	domains := []string{BuildDomainID(nil)}
+	for _, member := range members {
+		domains = append(domains, BuildDomainID(&member.CompanyID))
+	}
+	for _, domain := range domains {
+		if assigned, err := GetRolesForUser(fmt.Sprintf("user:%s", user.ID.String()), domain); err == nil && len(assigned) > 0 {
+			roles[domain] = assigned
+		}
+	}
+	files["permissions.json"] = map[string]interface{}{
+		"user_permissions": permissions,
+		"role_assignments": roles,
+	}
+
+	security := map[string]interface{}{}
+	var totp []auth.UserTOTP
+	if err := db.Where("user_id = ?", user.ID).Find(&totp).Error; err != nil {
+		return nil, err
+	}
+	security["totp_enabled"] = len(totp) > 0 && totp[0].ConfirmedAt != nil
+	var passkeys []auth.WebAuthnCredential
+	if err := db.Where("user_id = ?", user.ID).Find(&passkeys).Error; err != nil {
+		return nil, err
+	}
+	security["passkeys"] = passkeys
+	var tokens []auth.PersonalAccessToken
+	if err := db.Where("user_id = ?", user.ID).Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	security["personal_access_tokens"] = tokens
+	var consents []auth.OAuthConsent
+	if err := db.Where("user_id = ?", user.ID).Preload("Client").Find(&consents).Error; err != nil {
+		return nil, err
+	}
+	security["oauth_consents"] = consents
+	files["security.json"] = security
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"profile.json", "accounts.json", "sessions.json", "login_attempts.json", "memberships.json", "invitations.json", "permissions.json", "security.json"} {
+		body, err := json.MarshalIndent(files[name], "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s: %w", name, err)
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func invitationExportRows(invitations []companymodels.CompanyInvitation) []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(invitations))
+	for _, inv := range invitations {
+		rows = append(rows, map[string]interface{}{
+			"id":         inv.ID,
+			"company_id": inv.CompanyID,
+			"email":      inv.Email,
+			"role_name":  inv.RoleName,
+			"status":     inv.Status,
+			"invited_by": inv.InvitedBy,
+			"expires_at": inv.ExpiresAt,
+			"created_at": inv.CreatedAt,
+		})
+	}
+	return rows
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"mimbackend/config"
+	authmodels "mimbackend/internal/models/auth"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const webauthnCeremonyTTL = 5 * time.Minute
+
+// WebAuthn ceremony kinds
+const (
+	webauthnCeremonyRegistration = "registration"
+	webauthnCeremonyLogin        = "login"
+	webauthnCeremonyMFA          = "mfa"
+)
+
+var (
+	ErrWebAuthnCeremonyInvalid    = errors.New("webauthn ceremony is invalid or expired")
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	ErrWebAuthnVerificationFailed = errors.New("webauthn verification failed")
+)
+
+// WebAuthnService handles passkey registration and assertion ceremonies
+type WebAuthnService struct {
+	db *gorm.DB
+	wa *webauthn.WebAuthn
+}
+
+// NewWebAuthnService creates a new WebAuthn service.
+//
+// WEBAUTHN_RP_ID is the relying party ID (the site's domain) and defaults to
+// the host of FRONTEND_URL. WEBAUTHN_RP_ORIGINS lists the allowed origins
+// separated by commas and defaults to FRONTEND_URL.
+func NewWebAuthnService() (*WebAuthnService, error) {
+	db, err := config.NewConnection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return newWebAuthnService(db)
+}
+
+func newWebAuthnService(db *gorm.DB) (*WebAuthnService, error) {
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "http://localhost:3000"
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		if u, err := url.Parse(frontendURL); err == nil && u.Hostname() != "" {
+			rpID = u.Hostname()
+		} else {
+			rpID = "localhost"
+		}
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{frontendURL}
+	}
+
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "MIM"
+	}
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid webauthn configuration: %w", err)
+	}
+
+	return &WebAuthnService{db: db, wa: wa}, nil
+}
+
+// webauthnUser adapts auth.User to the webauthn.User interface. The user
+// handle is the 16 byte user UUID.
+type webauthnUser struct {
+	user        *authmodels.User
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	id := u.user.ID
+	return id[:]
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	if u.user.FullName != nil && *u.user.FullName != "" {
+		return *u.user.FullName
+	}
+	return u.user.Email
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// BeginRegistration starts registering a new passkey for the user
+func (s *WebAuthnService) BeginRegistration(user *authmodels.User) (string, *protocol.CredentialCreation, error) {
+	wu, err := s.loadUser(user)
+	if err != nil {
+		return "", nil, err
+	}
+
+	creation, session, err := s.wa.BeginRegistration(wu,
+		webauthn.WithExclusions(webauthn.Credentials(wu.credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ceremonyID, err := s.saveCeremony(&user.ID, webauthnCeremonyRegistration, session)
+	if err != nil {
+		return "", nil, err
+	}
+	return ceremonyID, creation, nil
+}
+
+// FinishRegistration verifies the attestation response and stores the credential
+func (s *WebAuthnService) FinishRegistration(user *authmodels.User, ceremonyID, name string, response []byte) (*authmodels.WebAuthnCredential, error) {
+	session, err := s.takeCeremony(ceremonyID, webauthnCeremonyRegistration, &user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+
+	wu, err := s.loadUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.wa.CreateCredential(wu, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	record := &authmodels.WebAuthnCredential{
+		UserID:       user.ID,
+		CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID),
+		Name:         name,
+		Credential:   datatypes.JSON(data),
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to store webauthn credential: %w", err)
+	}
+
+	return record, nil
+}
+
+// BeginLogin starts a passwordless login. No user is known yet, the
+// authenticator offers its discoverable credentials (passkeys).
+func (s *WebAuthnService) BeginLogin() (string, *protocol.CredentialAssertion, error) {
+	assertion, session, err := s.wa.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ceremonyID, err := s.saveCeremony(nil, webauthnCeremonyLogin, session)
+	if err != nil {
+		return "", nil, err
+	}
+	return ceremonyID, assertion, nil
+}
+
+// FinishLogin verifies a passwordless assertion and returns the user it belongs to.
+// User verification is required, so the passkey alone counts as multi-factor.
+func (s *WebAuthnService) FinishLogin(ceremonyID string, response []byte) (*authmodels.User, error) {
+	session, err := s.takeCeremony(ceremonyID, webauthnCeremonyLogin, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+
+	var owner *webauthnUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, ErrWebAuthnCredentialNotFound
+		}
+		var user authmodels.User
+		if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+			return nil, ErrWebAuthnCredentialNotFound
+		}
+		owner, err = s.loadUser(&user)
+		if err != nil {
+			return nil, err
+		}
+		return owner, nil
+	}
+
+	credential, err := s.wa.ValidateDiscoverableLogin(handler, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+	if err := s.recordAssertion(owner.user.ID, credential); err != nil {
+		return nil, err
+	}
+
+	return owner.user, nil
+}
+
+// BeginSecondFactor starts an assertion with the user's registered credentials
+// after the password step of a login
+func (s *WebAuthnService) BeginSecondFactor(userID uuid.UUID) (string, *protocol.CredentialAssertion, error) {
+	var user authmodels.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		return "", nil, err
+	}
+	wu, err := s.loadUser(&user)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(wu.credentials) == 0 {
+		return "", nil, ErrWebAuthnCredentialNotFound
+	}
+
+	assertion, session, err := s.wa.BeginLogin(wu)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ceremonyID, err := s.saveCeremony(&userID, webauthnCeremonyMFA, session)
+	if err != nil {
+		return "", nil, err
+	}
+	return ceremonyID, assertion, nil
+}
+
+// FinishSecondFactor verifies the assertion started by BeginSecondFactor
+func (s *WebAuthnService) FinishSecondFactor(userID uuid.UUID, ceremonyID string, response []byte) error {
+	session, err := s.takeCeremony(ceremonyID, webauthnCeremonyMFA, &userID)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+
+	var user authmodels.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		return err
+	}
+	wu, err := s.loadUser(&user)
+	if err != nil {
+		return err
+	}
+
+	credential, err := s.wa.ValidateLogin(wu, *session, parsed)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+	return s.recordAssertion(userID, credential)
+}
+
+// ListCredentials returns the user's registered passkeys
+func (s *WebAuthnService) ListCredentials(userID uuid.UUID) ([]authmodels.WebAuthnCredential, error) {
+	var credentials []authmodels.WebAuthnCredential
+	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// DeleteCredential removes one of the user's passkeys
+func (s *WebAuthnService) DeleteCredential(userID, id uuid.UUID) error {
+	// Hard delete so the same authenticator can be registered again
+	result := s.db.Unscoped().Where("id = ? AND user_id = ?", id, userID).Delete(&authmodels.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+// Private helper methods
+
+func (s *WebAuthnService) loadUser(user *authmodels.User) (*webauthnUser, error) {
+	var records []authmodels.WebAuthnCredential
+	if err := s.db.Where("user_id = ?", user.ID).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(records))
+	for _, record := range records {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(record.Credential, &credential); err != nil {
+			log.Printf("WebAuthn: skipping unreadable credential %s: %v", record.ID, err)
+			continue
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return &webauthnUser{user: user, credentials: credentials}, nil
+}
+
+// recordAssertion stores the new sign counter. A counter that went backwards
+// means the authenticator may have been cloned and the login is refused.
+func (s *WebAuthnService) recordAssertion(userID uuid.UUID, credential *webauthn.Credential) error {
+	credentialID := base64.RawURLEncoding.EncodeToString(credential.ID)
+
+	if credential.Authenticator.CloneWarning {
+		log.Printf("🚨 WebAuthn clone warning for credential %s (user %s)", credentialID, userID)
+		return fmt.Errorf("%w: authenticator sign counter went backwards", ErrWebAuthnVerificationFailed)
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Model(&authmodels.WebAuthnCredential{}).
+		Where("credential_id = ? AND user_id = ?", credentialID, userID).
+		Updates(map[string]interface{}{
+			"credential":   datatypes.JSON(data),
+			"last_used_at": time.Now(),
+		}).Error
+}
+
+func (s *WebAuthnService) saveCeremony(userID *uuid.UUID, kind string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	ceremony := &authmodels.WebAuthnCeremony{
+		UserID:    userID,
+		Kind:      kind,
+		Data:      datatypes.JSON(data),
+		ExpiresAt: time.Now().Add(webauthnCeremonyTTL),
+	}
+	if err := s.db.Create(ceremony).Error; err != nil {
+		return "", err
+	}
+	return ceremony.ID.String(), nil
+}
+
+// takeCeremony loads and deletes a ceremony so every challenge is answered at most once
+func (s *WebAuthnService) takeCeremony(ceremonyID, kind string, userID *uuid.UUID) (*webauthn.SessionData, error) {
+	id, err := uuid.Parse(ceremonyID)
+	if err != nil {
+		return nil, ErrWebAuthnCeremonyInvalid
+	}
+
+	var ceremony authmodels.WebAuthnCeremony
+	if err := s.db.Where("id = ? AND kind = ? AND expires_at > ?", id, kind, time.Now()).First(&ceremony).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebAuthnCeremonyInvalid
+		}
+		return nil, err
+	}
+	if userID != nil && (ceremony.UserID == nil || *ceremony.UserID != *userID) {
+		return nil, ErrWebAuthnCeremonyInvalid
+	}
+
+	result := s.db.Unscoped().Where("id = ?", ceremony.ID).Delete(&authmodels.WebAuthnCeremony{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrWebAuthnCeremonyInvalid
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(ceremony.Data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
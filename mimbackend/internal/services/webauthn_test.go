@@ -0,0 +1,366 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	authmodels "mimbackend/internal/models/auth"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/google/uuid"
+)
+
+const testWebAuthnOrigin = "http://localhost:3000"
+
+// softAuthenticator is a software passkey: a P-256 key with "none"
+// attestation that answers ceremonies the way a browser and platform
+// authenticator would
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	rpID         string
+	origin       string
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatalf("credential id: %v", err)
+	}
+	return &softAuthenticator{t: t, key: key, credentialID: credentialID, rpID: "localhost", origin: testWebAuthnOrigin}
+}
+
+func (a *softAuthenticator) clientData(ceremony protocol.CeremonyType, challenge protocol.URLEncodedBase64) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      string(ceremony),
+		"challenge": challenge.String(),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		a.t.Fatalf("client data: %v", err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) authenticatorData(flags protocol.AuthenticatorFlags, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// create answers navigator.credentials.create()
+func (a *softAuthenticator) create(creation *protocol.CredentialCreation) []byte {
+	a.userHandle = creation.Response.User.ID.(protocol.URLEncodedBase64)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatalf("cose key: %v", err)
+	}
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagAttestedCredentialData
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(flags, attested),
+	})
+	if err != nil {
+		a.t.Fatalf("attestation object: %v", err)
+	}
+
+	return a.marshalResponse(map[string]string{
+		"clientDataJSON":    b64(a.clientData(protocol.CreateCeremony, creation.Response.Challenge)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// get answers navigator.credentials.get()
+func (a *softAuthenticator) get(assertion *protocol.CredentialAssertion) []byte {
+	a.signCount++
+	authData := a.authenticatorData(protocol.FlagUserPresent|protocol.FlagUserVerified, nil)
+	clientData := a.clientData(protocol.AssertCeremony, assertion.Response.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatalf("sign: %v", err)
+	}
+
+	return a.marshalResponse(map[string]string{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) marshalResponse(response map[string]string) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		a.t.Fatalf("marshal response: %v", err)
+	}
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func newTestWebAuthnService(t *testing.T) *WebAuthnService {
+	t.Helper()
+
+	t.Setenv("FRONTEND_URL", testWebAuthnOrigin)
+	t.Setenv("WEBAUTHN_RP_ID", "")
+	t.Setenv("WEBAUTHN_RP_ORIGINS", "")
+
+	db := newTestDB(t, &authmodels.User{}, &authmodels.WebAuthnCredential{}, &authmodels.WebAuthnCeremony{},
+		&authmodels.UserTOTP{}, &authmodels.MFAChallenge{})
+	s, err := newWebAuthnService(db)
+	if err != nil {
+		t.Fatalf("newWebAuthnService: %v", err)
+	}
+	return s
+}
+
+// registerPasskey runs a full registration ceremony for user
+func registerPasskey(t *testing.T, s *WebAuthnService, user *authmodels.User) *softAuthenticator {
+	t.Helper()
+
+	ceremonyID, creation, err := s.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+
+	authenticator := newSoftAuthenticator(t)
+	record, err := s.FinishRegistration(user, ceremonyID, "Test key", authenticator.create(creation))
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if record.CredentialID != b64(authenticator.credentialID) || record.Name != "Test key" {
+		t.Fatalf("stored credential = %s %q", record.CredentialID, record.Name)
+	}
+	return authenticator
+}
+
+func TestWebAuthnRegisterThenPasskeyLogin(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	user := createTestUser(t, s.db, "passkey@example.com")
+	authenticator := registerPasskey(t, s, user)
+
+	ceremonyID, assertion, err := s.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	if assertion.Response.UserVerification != protocol.VerificationRequired {
+		t.Fatalf("passkey login must require user verification, got %q", assertion.Response.UserVerification)
+	}
+
+	loggedIn, err := s.FinishLogin(ceremonyID, authenticator.get(assertion))
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if loggedIn.ID != user.ID {
+		t.Fatalf("logged in as %s, want %s", loggedIn.ID, user.ID)
+	}
+
+	var record authmodels.WebAuthnCredential
+	if err := s.db.Where("user_id = ?", user.ID).First(&record).Error; err != nil {
+		t.Fatalf("load credential: %v", err)
+	}
+	if record.LastUsedAt == nil {
+		t.Fatal("LastUsedAt was not recorded")
+	}
+}
+
+func TestWebAuthnRegistrationRejectsWrongOrigin(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	user := createTestUser(t, s.db, "origin@example.com")
+
+	ceremonyID, creation, err := s.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	authenticator := newSoftAuthenticator(t)
+	authenticator.origin = "https://evil.example"
+
+	_, err = s.FinishRegistration(user, ceremonyID, "", authenticator.create(creation))
+	if !errors.Is(err, ErrWebAuthnVerificationFailed) {
+		t.Fatalf("FinishRegistration error = %v, want ErrWebAuthnVerificationFailed", err)
+	}
+}
+
+func TestWebAuthnSecondFactor(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	mfa := &MFAService{db: s.db}
+	user := createTestUser(t, s.db, "mfa@example.com")
+
+	methods, err := mfa.Methods(user.ID)
+	if err != nil || len(methods) != 0 {
+		t.Fatalf("Methods before registration = %v, %v", methods, err)
+	}
+	authenticator := registerPasskey(t, s, user)
+	methods, err = mfa.Methods(user.ID)
+	if err != nil || len(methods) != 1 || methods[0] != "webauthn" {
+		t.Fatalf("Methods after registration = %v, %v", methods, err)
+	}
+
+	risk := &LoginRiskAssessment{Score: 40, Action: RiskActionMFA, Signals: []LoginRiskSignal{{Name: RiskSignalNewDevice, Weight: 40}}}
+	challenge, _, err := mfa.CreateChallenge(user.ID, "password", risk)
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	ceremonyID, assertion, err := s.BeginSecondFactor(user.ID)
+	if err != nil {
+		t.Fatalf("BeginSecondFactor: %v", err)
+	}
+	if len(assertion.Response.AllowedCredentials) != 1 {
+		t.Fatalf("allowed credentials = %d, want 1", len(assertion.Response.AllowedCredentials))
+	}
+
+	response := authenticator.get(assertion)
+	userID, err := mfa.CompleteChallenge(challenge, func(userID uuid.UUID) error {
+		return s.FinishSecondFactor(userID, ceremonyID, response)
+	})
+	if err != nil {
+		t.Fatalf("CompleteChallenge: %v", err)
+	}
+	if userID != user.ID {
+		t.Fatalf("challenge completed for %s, want %s", userID, user.ID)
+	}
+
+	// The challenge is consumed with the assertion
+	if _, err := mfa.ChallengeUser(challenge); !errors.Is(err, ErrMFAChallengeInvalid) {
+		t.Fatalf("ChallengeUser after completion = %v, want ErrMFAChallengeInvalid", err)
+	}
+	// The first step's method and risk assessment are handed on to the session
+	method, got := mfa.ChallengeLogin(challenge)
+	if method != "password" {
+		t.Fatalf("ChallengeLogin method = %q, want password", method)
+	}
+	if got == nil || got.Score != risk.Score || got.Action != risk.Action || len(got.Signals) != 1 {
+		t.Fatalf("ChallengeLogin risk = %+v, want %+v", got, risk)
+	}
+}
+
+func TestWebAuthnSecondFactorRejectsOtherUsersCeremony(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	user := createTestUser(t, s.db, "owner@example.com")
+	other := createTestUser(t, s.db, "other@example.com")
+	authenticator := registerPasskey(t, s, user)
+
+	ceremonyID, assertion, err := s.BeginSecondFactor(user.ID)
+	if err != nil {
+		t.Fatalf("BeginSecondFactor: %v", err)
+	}
+	err = s.FinishSecondFactor(other.ID, ceremonyID, authenticator.get(assertion))
+	if !errors.Is(err, ErrWebAuthnCeremonyInvalid) {
+		t.Fatalf("FinishSecondFactor for another user = %v, want ErrWebAuthnCeremonyInvalid", err)
+	}
+}
+
+func TestWebAuthnRejectsReplayedAssertion(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	user := createTestUser(t, s.db, "replay@example.com")
+	authenticator := registerPasskey(t, s, user)
+
+	ceremonyID, assertion, err := s.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	response := authenticator.get(assertion)
+	if _, err := s.FinishLogin(ceremonyID, response); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+
+	// The same ceremony cannot be answered twice
+	if _, err := s.FinishLogin(ceremonyID, response); !errors.Is(err, ErrWebAuthnCeremonyInvalid) {
+		t.Fatalf("replay on the same ceremony = %v, want ErrWebAuthnCeremonyInvalid", err)
+	}
+
+	// Nor does the captured assertion answer a new challenge
+	ceremonyID, _, err = s.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	if _, err := s.FinishLogin(ceremonyID, response); !errors.Is(err, ErrWebAuthnVerificationFailed) {
+		t.Fatalf("replay on a new ceremony = %v, want ErrWebAuthnVerificationFailed", err)
+	}
+}
+
+func TestWebAuthnRejectsWrongOriginAssertion(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	user := createTestUser(t, s.db, "phish@example.com")
+	authenticator := registerPasskey(t, s, user)
+
+	ceremonyID, assertion, err := s.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	authenticator.origin = "https://login.evil.example"
+	if _, err := s.FinishLogin(ceremonyID, authenticator.get(assertion)); !errors.Is(err, ErrWebAuthnVerificationFailed) {
+		t.Fatalf("FinishLogin from another origin = %v, want ErrWebAuthnVerificationFailed", err)
+	}
+}
+
+func TestWebAuthnRejectsClonedAuthenticator(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	user := createTestUser(t, s.db, "clone@example.com")
+	authenticator := registerPasskey(t, s, user)
+
+	for i := 0; i < 2; i++ {
+		ceremonyID, assertion, err := s.BeginLogin()
+		if err != nil {
+			t.Fatalf("BeginLogin: %v", err)
+		}
+		if _, err := s.FinishLogin(ceremonyID, authenticator.get(assertion)); err != nil {
+			t.Fatalf("FinishLogin %d: %v", i, err)
+		}
+	}
+
+	// A copy of the key whose counter lags behind the stored one
+	authenticator.signCount = 0
+	ceremonyID, assertion, err := s.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	if _, err := s.FinishLogin(ceremonyID, authenticator.get(assertion)); !errors.Is(err, ErrWebAuthnVerificationFailed) {
+		t.Fatalf("FinishLogin with a lagging counter = %v, want ErrWebAuthnVerificationFailed", err)
+	}
+}